@@ -4,6 +4,11 @@ go 1.15
 
 require (
 	github.com/aws/aws-lambda-go v1.20.0
-	github.com/aws/aws-sdk-go v1.35.34
+	github.com/aws/aws-sdk-go v1.36.4
+	github.com/ciaranRoche/lambda-image-processor/pkg/greyscale v0.0.0
 	github.com/sirupsen/logrus v1.7.0
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+replace github.com/ciaranRoche/lambda-image-processor/pkg/greyscale => ../pkg/greyscale
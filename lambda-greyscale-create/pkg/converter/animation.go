@@ -0,0 +1,36 @@
+package converter
+
+import "github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+
+const (
+	// maxAnimationFramesEnv and maxAnimationPixelsEnv optionally override
+	// the limits of imageprocessing.DefaultAnimationLimits.
+	maxAnimationFramesEnv = "MAX_ANIMATION_FRAMES"
+	maxAnimationPixelsEnv = "MAX_ANIMATION_PIXELS"
+)
+
+// readAnimationLimits returns the animation limits, overridden by any that
+// are set in the environment.
+func readAnimationLimits() (imageprocessing.AnimationLimits, error) {
+	limits := imageprocessing.DefaultAnimationLimits
+	frames, err := positiveEnv(maxAnimationFramesEnv, int64(limits.MaxFrames))
+	if err != nil {
+		return limits, err
+	}
+	if limits.MaxPixels, err = positiveEnv(maxAnimationPixelsEnv, limits.MaxPixels); err != nil {
+		return limits, err
+	}
+	limits.MaxFrames = int(frames)
+	return limits, nil
+}
+
+// animatedEncoder returns the encoder of a rendition as an AnimationEncoder
+// when the source is animated and the rendition is written in a format that
+// keeps the animation.
+func animatedEncoder(animation *imageprocessing.Animation, encoder imageprocessing.Encoder) (imageprocessing.AnimationEncoder, bool) {
+	if animation == nil {
+		return nil, false
+	}
+	animationEncoder, ok := encoder.(imageprocessing.AnimationEncoder)
+	return animationEncoder, ok
+}
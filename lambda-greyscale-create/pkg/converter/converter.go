@@ -0,0 +1,335 @@
+// Package converter is the create lambda, it converts uploads to the
+// greyscale bucket into their renditions.
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	image2 "image"
+	_ "image/jpeg"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler converts uploads to the greyscale bucket into their renditions,
+// stores them in the convert bucket and publishes an image converted message.
+type Handler struct {
+	store      greyscale.ObjectStore
+	publisher  greyscale.Publisher
+	imageTopic string
+	cfg        processingConfig
+}
+
+// NewHandler returns a Handler publishing image converted messages to
+// imageTopic. The rendition list and profiles are loaded here, once per
+// container, so an invalid one fails the cold start instead of every upload.
+func NewHandler(ctx context.Context, store greyscale.ObjectStore, publisher greyscale.Publisher, imageTopic string) (*Handler, error) {
+	cfg, err := loadProcessingConfig(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		store:      store,
+		publisher:  publisher,
+		imageTopic: imageTopic,
+		cfg:        cfg,
+	}, nil
+}
+
+// Handle processes every object created in an s3 event.
+func (h *Handler) Handle(ctx context.Context, event events.S3Event) {
+	logger := logrus.WithFields(logrus.Fields{"action": "converter"})
+
+	// processing stops short of the lambda deadline, the time left over is
+	// for publishing the error
+	workCtx, cancel := workContext(ctx, h.cfg.deadlineMargin)
+	defer cancel()
+	if h.cfg.trackAllocations {
+		workCtx = imageprocessing.WithAllocationTracking(workCtx)
+	}
+
+	for _, e := range event.Records {
+		if err := h.handleNewObject(workCtx, e, h.cfg, logger); err != nil {
+			var rejected *rejectedError
+			if errors.As(err, &rejected) {
+				greyscale.HandleRejected(ctx, rejected.event(e.S3.Bucket.Name, e.S3.Object.Key), h.publisher)
+				continue
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				logger.Errorf("processing %s ran out of time : %v", e.S3.Object.Key, err)
+			}
+			greyscale.HandleError(ctx, err, h.publisher)
+			continue
+		}
+	}
+
+	logger.Infof("lambda function finished, processed '%d' events", len(event.Records))
+}
+
+func (h *Handler) handleNewObject(ctx context.Context, object events.S3EventRecord, cfg processingConfig, logger *logrus.Entry) error {
+	imageSourceBucket := object.S3.Bucket.Name
+	imageSourceKey := object.S3.Object.Key
+
+	imageDestinationBucket := greyscale.ConvertBucket(imageSourceBucket)
+
+	// read processing instructions set by the uploader
+	head, err := h.store.Head(ctx, imageSourceBucket, imageSourceKey)
+	if err != nil {
+		logger.Errorf("error getting image metadata from bucket : %v", err)
+		return err
+	}
+	if head.ContentLength > cfg.maxObjectSize {
+		return objectTooLarge(head.ContentLength, cfg.maxObjectSize)
+	}
+	in, err := readInstructions(ctx, h.store, imageSourceBucket, imageSourceKey, head.Metadata, cfg.profiles)
+	if err != nil {
+		logger.Errorf("error reading processing instructions : %v", err)
+		return err
+	}
+	renditions := in.renditions(cfg)
+	logger.Infof("processing image '%s' with instructions %+v", imageSourceKey, in)
+
+	// get uploaded image
+	logger.Infof("getting image '%s' from bucket '%s'", imageSourceKey, imageSourceBucket)
+	img, info, err := h.store.Get(ctx, imageSourceBucket, imageSourceKey)
+	if err != nil {
+		logger.Errorf("error getting image from bucket : %v", err)
+		return err
+	}
+	defer img.Close()
+
+	imgType := info.ContentType
+
+	// convert image to buffer, reading no more than the size limit in case
+	// the object was replaced since it was checked
+	imageBufferCopy := &bytes.Buffer{}
+	n, err := io.Copy(imageBufferCopy, io.LimitReader(img, cfg.maxObjectSize+1))
+	if err != nil {
+		logger.Errorf("error creating buffer copy : %v", err)
+		return err
+	}
+	if n > cfg.maxObjectSize {
+		size := info.ContentLength
+		if size < n {
+			size = n
+		}
+		return objectTooLarge(size, cfg.maxObjectSize)
+	}
+
+	// check the size the image claims before decoding any of it
+	imageData := imageBufferCopy.Bytes()
+	if _, _, err := imageprocessing.CheckDecodeConfig(imageData, cfg.decodeLimits); err != nil {
+		logger.Errorf("error checking image header : %v", err)
+		return rejection(err)
+	}
+
+	// decode buffer to image type
+	logger.Infof("decoding buffer of size %d", len(imageData))
+	decodedImage, sourceFormat, err := image2.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		logger.Errorf("error decoding buffer : %v", err)
+		return err
+	}
+
+	// decoding keeps only the first frame of a gif, so animated gifs are
+	// decoded again frame by frame
+	var animation *imageprocessing.Animation
+	if sourceFormat == "gif" && imageprocessing.IsAnimatedGIF(imageData) {
+		animation, err = imageprocessing.DecodeAnimation(imageData, cfg.animationLimits)
+		if err != nil {
+			logger.Errorf("error decoding animation : %v", err)
+			return rejection(err)
+		}
+		logger.Infof("decoded %d frames of animated image %s", len(animation.Frames), imageSourceKey)
+	}
+
+	// decoding keeps only the first page of a tiff, so the remaining pages
+	// are decoded when any rendition asks for every page
+	var pages []image2.Image
+	if sourceFormat == "tiff" && anyRenditionPages(renditions, pagesAll) {
+		count, err := imageprocessing.TIFFPageCount(imageData)
+		if err != nil {
+			logger.Warnf("error counting pages of %s, rendering the first page only : %v", imageSourceKey, err)
+		} else if count > 1 {
+			if pages, err = imageprocessing.DecodeTIFFPages(imageData, cfg.decodeLimits); err != nil {
+				logger.Errorf("error decoding tiff pages : %v", err)
+				return rejection(err)
+			}
+			logger.Infof("decoded %d pages of image %s", len(pages), imageSourceKey)
+		}
+	}
+
+	// decoding drops the metadata, so read it from the source, a broken
+	// exif segment only loses the metadata
+	metadata, err := imageprocessing.ReadMetadata(imageData)
+	if err != nil {
+		logger.Warnf("error reading metadata of %s : %v", imageSourceKey, err)
+	}
+
+	// turn photos taken sideways upright before any rendition sees them,
+	// the later pages of a tiff were turned upright as they were decoded
+	orientation := metadata.Orientation()
+	if sourceFormat == "tiff" {
+		if orientation, err = imageprocessing.TIFFOrientation(imageData); err != nil {
+			logger.Warnf("error reading orientation of %s : %v", imageSourceKey, err)
+		}
+	}
+	if orientation != imageprocessing.OrientationNormal {
+		logger.Infof("applying exif orientation %d to image %s", orientation, imageSourceKey)
+		decodedImage, err = imageprocessing.AutoOrientContext(ctx, decodedImage, orientation)
+		if err != nil {
+			return err
+		}
+	}
+
+	// pick the encoder of every rendition first, animations are only kept
+	// by formats that can hold them and any other format gets the first frame
+	encoders := make([]imageprocessing.Encoder, len(renditions))
+	var stills []string
+	for i, r := range renditions {
+		if encoders[i], err = r.encoderFor(sourceFormat, in); err != nil {
+			logger.Errorf("error creating encoder : %v", err)
+			return err
+		}
+		if _, ok := animatedEncoder(animation, encoders[i]); !ok {
+			stills = append(stills, r.Name)
+		}
+	}
+
+	// run the still renditions from the one decoded image together, so the
+	// actions their pipelines start with run once, every action is logged and
+	// summed up for the message
+	observers := newOutputObservers(renditions, logger)
+	var processed map[string]image2.Image
+	if len(stills) > 0 {
+		logger.Infof("imageprocessor starting renditions %v for image %s ", stills, imageSourceKey)
+		processed, err = in.graph(cfg).TransformOutputs(imageprocessing.WithPipelineObserver(ctx, observers), decodedImage, stills)
+		if err != nil {
+			logger.Errorf("error processing image %v", err)
+			return err
+		}
+	}
+
+	var renditionImages []greyscale.Rendition
+	for i, r := range renditions {
+		renditionImage, err := h.handleRendition(ctx, processed[r.Name], animation, encoders[i], observers.observers[r.Name], metadata, imageDestinationBucket, imageSourceKey, r, i == 0, logger)
+		if err != nil {
+			return err
+		}
+		renditionImages = append(renditionImages, renditionImage)
+
+		if r.Pages != pagesAll {
+			continue
+		}
+		for page := 1; page < len(pages); page++ {
+			pageRendition := r.pageRendition(page + 1)
+			observer := newRenditionObserver(logger.WithField("rendition", pageRendition.Name))
+			logger.Infof("imageprocessor starting rendition %s for image %s ", pageRendition.Name, imageSourceKey)
+			processedPage, err := imageprocessing.PipelineWithContext(r.processorPipeline).TransformContext(imageprocessing.WithPipelineObserver(ctx, observer), pages[page])
+			if err != nil {
+				logger.Errorf("error processing image %v", err)
+				return err
+			}
+			renditionImage, err := h.handleRendition(ctx, processedPage, nil, encoders[i], observer, metadata, imageDestinationBucket, imageSourceKey, pageRendition, false, logger)
+			if err != nil {
+				return err
+			}
+			renditionImages = append(renditionImages, renditionImage)
+		}
+	}
+
+	// create sns topic for successful image conversion
+	snsMessage, err := greyscale.EncodeImageMessage(greyscale.GreyImage{
+		SourceBucket:  imageSourceBucket,
+		SourceKey:     imageSourceKey,
+		SourceURL:     greyscale.ImageURL(imageSourceBucket, greyscale.Region, imageSourceKey),
+		ConvertBucket: imageDestinationBucket,
+		ConvertKey:    renditionImages[0].ConvertKey,
+		ConvertURL:    renditionImages[0].ConvertURL,
+		ImageType:     imgType,
+		Renditions:    renditionImages,
+		Metadata:      renditions[0].metadataPolicy().Apply(metadata).Fields(),
+	})
+	if err != nil {
+		logger.Errorf("error building image message : %v", err)
+		return err
+	}
+
+	// public sns message
+	logger.Infof("sending message : %s", snsMessage.Body)
+	err = h.publisher.Publish(ctx, h.imageTopic, snsMessage)
+	if err != nil {
+		logger.Errorf("error publishing sns : %v", err)
+		return err
+	}
+	return nil
+}
+
+// handleRendition encodes and stores one rendition of an upload. processed
+// is the still image the rendition's pipeline produced, animations taking
+// the animated path are run through the pipeline here, frame by frame.
+func (h *Handler) handleRendition(ctx context.Context, processed image2.Image, animation *imageprocessing.Animation, encoder imageprocessing.Encoder, observer *renditionObserver, metadata *imageprocessing.Metadata, bucket, sourceKey string, r rendition, primary bool, logger *logrus.Entry) (greyscale.Rendition, error) {
+	renditionLogger := logger.WithField("rendition", r.Name)
+
+	var b bytes.Buffer
+	var size image2.Point
+	if animationEncoder, ok := animatedEncoder(animation, encoder); ok {
+		logger.Infof("imageprocessor starting rendition %s for %d frames of image %s ", r.Name, len(animation.Frames), sourceKey)
+		processedAnimation, err := animation.TransformContext(imageprocessing.WithPipelineObserver(ctx, observer), r.processorPipeline)
+		if err != nil {
+			logger.Errorf("error processing animation %v", err)
+			return greyscale.Rendition{}, err
+		}
+		renditionLogger.WithFields(observer.fields()).Infof("imageprocessor ended rendition %s for image %s ", r.Name, sourceKey)
+
+		logger.Infof("encoding animated rendition %s of image %s as %s", r.Name, sourceKey, encoder.ContentType())
+		if err := animationEncoder.EncodeAnimation(&b, processedAnimation); err != nil {
+			logger.Errorf("error encoding animation: %v ", err)
+			return greyscale.Rendition{}, err
+		}
+		size = processedAnimation.Bounds().Size()
+	} else {
+		renditionLogger.WithFields(observer.fields()).Infof("imageprocessor ended rendition %s for image %s ", r.Name, sourceKey)
+
+		// encode converted image
+		logger.Infof("encoding rendition %s of image %s as %s", r.Name, sourceKey, encoder.ContentType())
+		if err := encoder.Encode(&b, processed); err != nil {
+			logger.Errorf("error encoding image: %v ", err)
+			return greyscale.Rendition{}, err
+		}
+		size = processed.Bounds().Size()
+	}
+
+	// write back the source metadata the rendition keeps
+	encoded, err := imageprocessing.EmbedMetadata(b.Bytes(), encoder.ContentType(), r.metadataPolicy().Apply(metadata), size)
+	if err != nil {
+		logger.Errorf("error embedding metadata : %v", err)
+		return greyscale.Rendition{}, err
+	}
+
+	key := renditionKey(sourceKey, r.Name, encoder.Extension(), primary)
+
+	// upload converted image to converted image bucket
+	logger.Infof("uploading image %s to bucket %s", key, bucket)
+	err = h.store.Put(ctx, bucket, key, bytes.NewReader(encoded), encoder.ContentType())
+	if err != nil {
+		logger.Errorf("error putting image in bucket : %v", err)
+		return greyscale.Rendition{}, err
+	}
+
+	return greyscale.Rendition{
+		Name:       r.Name,
+		ConvertKey: key,
+		ConvertURL: greyscale.ImageURL(bucket, greyscale.Region, key),
+		Processing: observer.summary(),
+	}, nil
+}
@@ -0,0 +1,777 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bucket     = "greyscale"
+	imageTopic = "ImageTopic"
+	errorTopic = "ErrorTopic"
+)
+
+var convertBucket = greyscale.ConvertBucket(bucket)
+
+// fullRendition is a rendition list of a single greyscale rendition, kept in
+// the format of the upload.
+const fullRendition = `[{"name": "full", "pipeline": {"actions": [{"name": "greyscale"}]}}]`
+
+func TestMain(m *testing.M) {
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// setenv sets an env variable for the rest of the test.
+func setenv(t *testing.T, name, value string) {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func testImage(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// animatedGIF returns a w x h gif of frames frames, each shown for one more
+// 100th of a second than the last and disposed of to the background.
+func animatedGIF(t *testing.T, w, h, frames int) []byte {
+	g := &gif.GIF{LoopCount: 2}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette.Plan9)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i * 40)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10+i)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func createdEvent(keys ...string) events.S3Event {
+	var event events.S3Event
+	for _, key := range keys {
+		event.Records = append(event.Records, events.S3EventRecord{
+			EventName: "ObjectCreated:Put",
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: bucket},
+				Object: events.S3Object{Key: key},
+			},
+		})
+	}
+	return event
+}
+
+func decodedSize(t *testing.T, data []byte) image.Point {
+	t.Helper()
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding rendition : %v", err)
+	}
+	return image.Pt(cfg.Width, cfg.Height)
+}
+
+// fixture returns an image of the imageprocessing test data.
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("..", "imageprocessing", "testdata", "formats", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// upload is an object in the greyscale bucket.
+type upload struct {
+	key         string
+	body        []byte
+	contentType string
+	metadata    map[string]string
+}
+
+// handleTest runs a handler, created with env set, over the uploads and
+// event.
+type handleTest struct {
+	name    string
+	env     map[string]string
+	uploads []upload
+	event   events.S3Event
+	errors  map[string]error
+	// wantKeys are the keys stored in the convert bucket with their content
+	// type
+	wantKeys     map[string]string
+	wantMessages int
+	wantErrors   []string
+	check        func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted)
+}
+
+func runHandleTests(t *testing.T, tests []handleTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				setenv(t, name, value)
+			}
+			store := greyscaletest.NewObjectStore()
+			for _, u := range tt.uploads {
+				store.PutObject(bucket, u.key, greyscaletest.Object{
+					Body: u.body,
+					Info: greyscale.ObjectInfo{ContentType: u.contentType, Metadata: u.metadata},
+				})
+			}
+			publisher := greyscaletest.NewPublisher()
+
+			h, err := NewHandler(context.Background(), store, publisher, imageTopic)
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+			store.Errors = tt.errors
+			h.Handle(context.Background(), tt.event)
+
+			keys := store.Keys(convertBucket)
+			if len(keys) != len(tt.wantKeys) {
+				t.Errorf("stored %v, want %v", keys, tt.wantKeys)
+			}
+			for key, contentType := range tt.wantKeys {
+				obj, ok := store.Object(convertBucket, key)
+				if !ok {
+					t.Errorf("%s was not stored, stored %v", key, keys)
+					continue
+				}
+				if obj.Info.ContentType != contentType {
+					t.Errorf("%s content type = %q, want %q", key, obj.Info.ContentType, contentType)
+				}
+			}
+
+			var messages []greyscale.ImageConverted
+			for _, published := range publisher.Messages(imageTopic) {
+				message, err := greyscale.DecodeImageMessage(published.Body)
+				if err != nil {
+					t.Fatalf("published an invalid image message : %v", err)
+				}
+				messages = append(messages, message)
+			}
+			if len(messages) != tt.wantMessages {
+				t.Fatalf("published %d image messages, want %d", len(messages), tt.wantMessages)
+			}
+
+			errorMessages := publisher.Messages(errorTopic)
+			if len(errorMessages) != len(tt.wantErrors) {
+				t.Fatalf("published errors %v, want %d", errorMessages, len(tt.wantErrors))
+			}
+			for i, want := range tt.wantErrors {
+				if !strings.Contains(errorMessages[i].Body, want) {
+					t.Errorf("error %d = %q, want one containing %q", i, errorMessages[i].Body, want)
+				}
+			}
+
+			if tt.check != nil {
+				tt.check(t, store, messages)
+			}
+		})
+	}
+}
+
+func TestHandle(t *testing.T) {
+	pngImage := encodePNG(t, testImage(300, 200))
+
+	runHandleTests(t, []handleTest{
+		{
+			name:    "converts a png to the default renditions",
+			uploads: []upload{{key: "a.png", body: pngImage, contentType: "image/png"}},
+			event:   createdEvent("a.png"),
+			wantKeys: map[string]string{
+				"converted-a.png.png":           "image/png",
+				"converted/a.png/web.jpg":       "image/jpeg",
+				"converted/a.png/thumbnail.jpg": "image/jpeg",
+			},
+			wantMessages: 1,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				message := messages[0]
+				if message.SourceKey != "a.png" || message.ConvertKey != "converted-a.png.png" || message.ImageType != "image/png" {
+					t.Errorf("message = %+v, want the source and primary rendition of a.png", message.GreyImage)
+				}
+				if len(message.Renditions) != 3 {
+					t.Fatalf("message has %d renditions, want 3", len(message.Renditions))
+				}
+				thumbnail, _ := store.Object(convertBucket, "converted/a.png/thumbnail.jpg")
+				if size := decodedSize(t, thumbnail.Body); size != image.Pt(200, 200) {
+					t.Errorf("thumbnail size = %v, want 200x200", size)
+				}
+				// the greyscale every rendition starts with is run once for all
+				for _, r := range message.Renditions {
+					if first := r.Processing.Actions[0]; first.Name != "greyscale" || !first.Shared {
+						t.Errorf("rendition %s first action = %+v, want the shared greyscale", r.Name, first)
+					}
+				}
+				if web := message.Renditions[1].Processing.Actions; len(web) != 2 || web[1].Shared {
+					t.Errorf("web actions = %+v, want its resize alone", web)
+				}
+			},
+		},
+		{
+			name:    "keeps the whole source key",
+			uploads: []upload{{key: "a.png", body: pngImage}, {key: "a.jpg", body: encodeJPEG(t, testImage(40, 40))}},
+			event:   createdEvent("a.png", "a.jpg"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.png.png": "image/png",
+				"converted-a.jpg.jpg": "image/jpeg",
+			},
+			wantMessages: 2,
+		},
+		{
+			name:    "format instruction",
+			uploads: []upload{{key: "a.png", body: pngImage, metadata: map[string]string{"Format": "jpeg"}}},
+			event:   createdEvent("a.png"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.png.jpg": "image/jpeg",
+			},
+			wantMessages: 1,
+		},
+		{
+			name:    "pipeline instruction",
+			uploads: []upload{{key: "a.png", body: pngImage, metadata: map[string]string{"Pipeline": "small"}}},
+			event:   createdEvent("a.png"),
+			env:     map[string]string{profilesEnv: `{"small": [{"name": "small", "pipeline": {"actions": [{"name": "thumbnail", "params": {"width": 10, "height": 10}}]}}]}`},
+			wantKeys: map[string]string{
+				"converted-a.png.png": "image/png",
+			},
+			wantMessages: 1,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				obj, _ := store.Object(convertBucket, "converted-a.png.png")
+				if size := decodedSize(t, obj.Body); size != image.Pt(10, 10) {
+					t.Errorf("size = %v, want the 10x10 of the profile", size)
+				}
+			},
+		},
+		{
+			name:    "keeps the frames of an animated gif",
+			uploads: []upload{{key: "a.gif", body: animatedGIF(t, 20, 10, 3)}},
+			event:   createdEvent("a.gif"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.gif.gif": "image/gif",
+			},
+			wantMessages: 1,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				obj, _ := store.Object(convertBucket, "converted-a.gif.gif")
+				g, err := gif.DecodeAll(bytes.NewReader(obj.Body))
+				if err != nil {
+					t.Fatalf("decoding rendition : %v", err)
+				}
+				if len(g.Image) != 3 || g.LoopCount != 2 {
+					t.Fatalf("rendition has %d frames looping %d times, want 3 looping 2", len(g.Image), g.LoopCount)
+				}
+				for i := range g.Image {
+					if g.Delay[i] != 10+i || g.Disposal[i] != gif.DisposalBackground {
+						t.Errorf("frame %d delay %d disposal %d, want %d and %d", i, g.Delay[i], g.Disposal[i], 10+i, gif.DisposalBackground)
+					}
+				}
+			},
+		},
+		{
+			name:    "an animated gif as jpeg keeps the first frame",
+			uploads: []upload{{key: "a.gif", body: animatedGIF(t, 20, 10, 3), metadata: map[string]string{"Format": "jpeg"}}},
+			event:   createdEvent("a.gif"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.gif.jpg": "image/jpeg",
+			},
+			wantMessages: 1,
+		},
+		{
+			name: "converts webp, bmp and tiff",
+			uploads: []upload{
+				{key: "a.webp", body: fixture(t, "lossy.webp")},
+				{key: "a.bmp", body: fixture(t, "gradient.bmp")},
+				{key: "a.tiff", body: fixture(t, "gradient.tiff")},
+			},
+			event: createdEvent("a.webp", "a.bmp", "a.tiff"),
+			env:   map[string]string{renditionsEnv: `[{"name": "full", "pipeline": {"actions": [{"name": "greyscale"}]}}]`},
+			wantKeys: map[string]string{
+				"converted-a.webp.jpg": "image/jpeg",
+				"converted-a.bmp.jpg":  "image/jpeg",
+				"converted-a.tiff.jpg": "image/jpeg",
+			},
+			wantMessages: 3,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				for key, want := range map[string]image.Point{
+					"converted-a.webp.jpg": image.Pt(150, 100),
+					"converted-a.bmp.jpg":  image.Pt(12, 8),
+					"converted-a.tiff.jpg": image.Pt(10, 6),
+				} {
+					obj, _ := store.Object(convertBucket, key)
+					if size := decodedSize(t, obj.Body); size != want {
+						t.Errorf("%s size = %v, want %v", key, size, want)
+					}
+				}
+			},
+		},
+		{
+			name:    "renders every page of a tiff",
+			uploads: []upload{{key: "a.tiff", body: fixture(t, "pages.tiff")}},
+			event:   createdEvent("a.tiff"),
+			env:     map[string]string{renditionsEnv: `[{"name": "full", "pages": "all", "pipeline": {"actions": [{"name": "greyscale"}]}}]`},
+			wantKeys: map[string]string{
+				"converted-a.tiff.jpg":            "image/jpeg",
+				"converted/a.tiff/full-page2.jpg": "image/jpeg",
+				"converted/a.tiff/full-page3.jpg": "image/jpeg",
+			},
+			wantMessages: 1,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				if len(messages[0].Renditions) != 3 {
+					t.Errorf("message has %d renditions, want one per page", len(messages[0].Renditions))
+				}
+				for key, want := range map[string]image.Point{
+					"converted-a.tiff.jpg":            image.Pt(8, 6),
+					"converted/a.tiff/full-page2.jpg": image.Pt(6, 4),
+					"converted/a.tiff/full-page3.jpg": image.Pt(4, 2),
+				} {
+					obj, _ := store.Object(convertBucket, key)
+					if size := decodedSize(t, obj.Body); size != want {
+						t.Errorf("%s size = %v, want %v", key, size, want)
+					}
+				}
+			},
+		},
+		{
+			name:    "renders the first page of a tiff by default",
+			uploads: []upload{{key: "a.tiff", body: fixture(t, "pages.tiff")}},
+			event:   createdEvent("a.tiff"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.tiff.jpg": "image/jpeg",
+			},
+			wantMessages: 1,
+		},
+		{
+			name:    "leaves allocations untracked by default",
+			uploads: []upload{{key: "a.png", body: pngImage}},
+			event:   createdEvent("a.png"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.png.png": "image/png",
+			},
+			wantMessages: 1,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				processing := messages[0].Renditions[0].Processing
+				if processing.AllocBytes != 0 || processing.Actions[0].Allocs != 0 {
+					t.Errorf("processing = %+v, want no allocations", processing)
+				}
+			},
+		},
+		{
+			name:    "tracks allocations when asked",
+			uploads: []upload{{key: "a.png", body: pngImage}},
+			event:   createdEvent("a.png"),
+			env: map[string]string{
+				renditionsEnv:       fullRendition,
+				trackAllocationsEnv: "true",
+			},
+			wantKeys: map[string]string{
+				"converted-a.png.png": "image/png",
+			},
+			wantMessages: 1,
+			check: func(t *testing.T, store *greyscaletest.ObjectStore, messages []greyscale.ImageConverted) {
+				processing := messages[0].Renditions[0].Processing
+				if processing.AllocBytes == 0 || processing.Actions[0].Allocs == 0 {
+					t.Errorf("processing = %+v, want the allocations of the greyscale action", processing)
+				}
+			},
+		},
+		{
+			name:       "missing upload",
+			event:      createdEvent("missing.png"),
+			wantKeys:   map[string]string{},
+			wantErrors: []string{"error : "},
+		},
+		{
+			name:       "not an image",
+			uploads:    []upload{{key: "a.txt", body: []byte("not an image")}},
+			event:      createdEvent("a.txt"),
+			wantKeys:   map[string]string{},
+			wantErrors: []string{"error : "},
+		},
+		{
+			name:       "invalid instruction",
+			uploads:    []upload{{key: "a.png", body: pngImage, metadata: map[string]string{"Quality": "1000"}}},
+			event:      createdEvent("a.png"),
+			wantKeys:   map[string]string{},
+			wantErrors: []string{"invalid instruction quality"},
+		},
+		{
+			name:       "put fails",
+			uploads:    []upload{{key: "a.png", body: pngImage}},
+			event:      createdEvent("a.png"),
+			errors:     map[string]error{"Put": errors.New("put failed")},
+			wantKeys:   map[string]string{},
+			wantErrors: []string{"put failed"},
+		},
+		{
+			name:    "one bad record does not stop the others",
+			uploads: []upload{{key: "a.png", body: pngImage}},
+			event:   createdEvent("missing.png", "a.png"),
+			env:     map[string]string{renditionsEnv: fullRendition},
+			wantKeys: map[string]string{
+				"converted-a.png.png": "image/png",
+			},
+			wantMessages: 1,
+			wantErrors:   []string{"error : "},
+		},
+	})
+}
+
+func TestHandleRejected(t *testing.T) {
+	pngImage := encodePNG(t, testImage(300, 200))
+	size := int64(len(pngImage))
+	// a gif header claiming 30000x30000 with nothing after it
+	hugeGIF := []byte("GIF89a\x30\x75\x30\x75\x00\x00\x00")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		body []byte
+		// headSize, when set, is the size reported before the upload is
+		// downloaded, as when it is replaced in between
+		headSize   int64
+		errors     map[string]error
+		wantReason string
+		wantLimit  int64
+		wantValue  int64
+	}{
+		{
+			name: "object over the size limit",
+			env:  map[string]string{maxObjectSizeEnv: strconv.FormatInt(size-1, 10)},
+			body: pngImage,
+			// the size is checked before the upload is downloaded
+			errors:     map[string]error{"Get": errors.New("downloaded")},
+			wantReason: greyscale.RejectedObjectTooLarge,
+			wantLimit:  size - 1,
+			wantValue:  size,
+		},
+		{
+			name:     "object grown since it was checked",
+			env:      map[string]string{maxObjectSizeEnv: "100"},
+			body:     pngImage,
+			headSize: 50,
+			// no more than one byte over the limit is read
+			wantReason: greyscale.RejectedObjectTooLarge,
+			wantLimit:  100,
+			wantValue:  101,
+		},
+		{
+			name:       "image over the width limit",
+			env:        map[string]string{maxImageWidthEnv: "100"},
+			body:       pngImage,
+			wantReason: greyscale.RejectedImageTooLarge,
+			wantLimit:  100,
+			wantValue:  300,
+		},
+		{
+			name:       "image over the pixel limit",
+			env:        map[string]string{maxImagePixelsEnv: "59999"},
+			body:       pngImage,
+			wantReason: greyscale.RejectedImageTooLarge,
+			wantLimit:  59999,
+			wantValue:  60000,
+		},
+		{
+			// decoding would fail on the missing pixels, so a rejection
+			// shows only the header was read
+			name:       "header claiming a huge image",
+			body:       hugeGIF,
+			wantReason: greyscale.RejectedImageTooLarge,
+			wantLimit:  int64(imageprocessing.DefaultDecodeLimits.MaxWidth),
+			wantValue:  30000,
+		},
+		{
+			name:       "animation over the frame limit",
+			env:        map[string]string{maxAnimationFramesEnv: "2"},
+			body:       animatedGIF(t, 20, 10, 3),
+			wantReason: greyscale.RejectedAnimationTooLarge,
+		},
+		{
+			name:       "animation over the pixel limit",
+			env:        map[string]string{maxAnimationPixelsEnv: "599"},
+			body:       animatedGIF(t, 20, 10, 3),
+			wantReason: greyscale.RejectedAnimationTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, renditionsEnv, fullRendition)
+			for name, value := range tt.env {
+				setenv(t, name, value)
+			}
+			store := greyscaletest.NewObjectStore()
+			store.PutObject(bucket, "a.img", greyscaletest.Object{Body: tt.body})
+			if tt.headSize != 0 {
+				obj := store.Buckets[bucket]["a.img"]
+				obj.Info.ContentLength = tt.headSize
+				store.Buckets[bucket]["a.img"] = obj
+			}
+			publisher := greyscaletest.NewPublisher()
+
+			h, err := NewHandler(context.Background(), store, publisher, imageTopic)
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+			store.Errors = tt.errors
+			h.Handle(context.Background(), createdEvent("a.img"))
+
+			if keys := store.Keys(convertBucket); len(keys) != 0 {
+				t.Errorf("stored %v, want nothing", keys)
+			}
+			if got := len(publisher.Messages(imageTopic)); got != 0 {
+				t.Errorf("published %d image messages, want 0", got)
+			}
+			errorMessages := publisher.Messages(errorTopic)
+			if len(errorMessages) != 1 {
+				t.Fatalf("published errors %v, want one rejection", errorMessages)
+			}
+			if got := errorMessages[0].Attributes[greyscale.EventTypeAttribute].Value; got != greyscale.ImageRejectedEventType {
+				t.Errorf("eventType = %q, want %q", got, greyscale.ImageRejectedEventType)
+			}
+			var rejected greyscale.ImageRejected
+			if err := json.Unmarshal([]byte(errorMessages[0].Body), &rejected); err != nil {
+				t.Fatalf("published an invalid rejection : %v", err)
+			}
+			if rejected.SourceBucket != bucket || rejected.SourceKey != "a.img" || rejected.Reason != tt.wantReason {
+				t.Errorf("rejection = %+v, want a.img rejected as %s", rejected, tt.wantReason)
+			}
+			if rejected.Limit != tt.wantLimit || rejected.Value != tt.wantValue {
+				t.Errorf("rejection limit %d value %d, want %d and %d", rejected.Limit, rejected.Value, tt.wantLimit, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestHandleOutOfTime(t *testing.T) {
+	setenv(t, renditionsEnv, fullRendition)
+	store := greyscaletest.NewObjectStore()
+	store.PutObject(bucket, "a.png", greyscaletest.Object{Body: encodePNG(t, testImage(300, 200))})
+	publisher := greyscaletest.NewPublisher()
+	h, err := NewHandler(context.Background(), store, publisher, imageTopic)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	// once the deadline has passed the upload is reported, not processed
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	h.Handle(ctx, createdEvent("a.png"))
+
+	if keys := store.Keys(convertBucket); len(keys) != 0 {
+		t.Errorf("stored %v, want nothing", keys)
+	}
+	errorMessages := publisher.Messages(errorTopic)
+	if len(errorMessages) != 1 || !strings.Contains(errorMessages[0].Body, context.DeadlineExceeded.Error()) {
+		t.Errorf("published errors %v, want one for the deadline", errorMessages)
+	}
+}
+
+func TestHandleReusesConfig(t *testing.T) {
+	setenv(t, renditionsBucketEnv, "config")
+	setenv(t, renditionsKeyEnv, "renditions.yaml")
+	store := greyscaletest.NewObjectStore()
+	store.PutObject("config", "renditions.yaml", greyscaletest.Object{
+		Body: []byte("- name: full\n  pipeline:\n    actions:\n      - name: watermark\n        params:\n          image: s3://config/mark.png\n"),
+	})
+	store.PutObject("config", "mark.png", greyscaletest.Object{Body: encodePNG(t, testImage(8, 8))})
+	store.PutObject(bucket, "a.png", greyscaletest.Object{Body: encodePNG(t, testImage(30, 20))})
+	store.PutObject(bucket, "b.png", greyscaletest.Object{Body: encodePNG(t, testImage(30, 20))})
+	publisher := greyscaletest.NewPublisher()
+	h, err := NewHandler(context.Background(), store, publisher, imageTopic)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	// the renditions and the watermark were read when the handler was
+	// created, so invocations do not read them again
+	for _, key := range []string{"renditions.yaml", "mark.png"} {
+		if err := store.Delete(context.Background(), "config", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Handle(context.Background(), createdEvent("a.png"))
+	h.Handle(context.Background(), createdEvent("b.png"))
+
+	if errorMessages := publisher.Messages(errorTopic); len(errorMessages) != 0 {
+		t.Fatalf("published errors %v, want none", errorMessages)
+	}
+	if messages := publisher.Messages(imageTopic); len(messages) != 2 {
+		t.Errorf("published %d image messages, want one per invocation", len(messages))
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	mark := string(encodePNG(t, testImage(8, 8)))
+	watermark := func(image string) string {
+		return `[{"name": "full", "pipeline": {"actions": [{"name": "watermark", "params": {"image": "` + image + `"}}]}}]`
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		objects map[string]string
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "renditions", env: map[string]string{renditionsEnv: fullRendition}},
+		{
+			name:    "renditions from s3",
+			env:     map[string]string{renditionsBucketEnv: "config", renditionsKeyEnv: "renditions.yaml"},
+			objects: map[string]string{"renditions.yaml": "- name: full\n  pipeline:\n    actions:\n      - name: greyscale\n"},
+		},
+		{
+			name:    "invalid renditions",
+			env:     map[string]string{renditionsEnv: `[{"name": "full", "pipeline": {"actions": [{"name": "unknown"}]}}]`},
+			wantErr: "invalid renditions in RENDITIONS",
+		},
+		{
+			name:    "invalid profile",
+			env:     map[string]string{profilesEnv: `{"small": [{"name": "small", "encoder": {"format": "bmp"}}]}`},
+			wantErr: `invalid profile "small"`,
+		},
+		{
+			name:    "watermark from s3",
+			env:     map[string]string{renditionsEnv: watermark("s3://config/mark.png")},
+			objects: map[string]string{"mark.png": mark},
+		},
+		{
+			name:    "missing s3 watermark",
+			env:     map[string]string{renditionsEnv: watermark("s3://config/missing.png")},
+			wantErr: "missing.png",
+		},
+		{
+			name:    "s3 watermark without a key",
+			env:     map[string]string{renditionsEnv: watermark("s3://config/")},
+			wantErr: "expected s3://<bucket>/<key>",
+		},
+		{
+			name:    "missing local watermark",
+			env:     map[string]string{renditionsEnv: watermark("missing.png")},
+			wantErr: "missing.png",
+		},
+		{
+			name:    "invalid object size",
+			env:     map[string]string{maxObjectSizeEnv: "-1"},
+			wantErr: "invalid MAX_OBJECT_SIZE",
+		},
+		{
+			name:    "invalid image width",
+			env:     map[string]string{maxImageWidthEnv: "wide"},
+			wantErr: "invalid MAX_IMAGE_WIDTH",
+		},
+		{
+			name:    "invalid deadline margin",
+			env:     map[string]string{deadlineMarginEnv: "soon"},
+			wantErr: "invalid DEADLINE_MARGIN",
+		},
+		{
+			name:    "invalid allocation tracking",
+			env:     map[string]string{trackAllocationsEnv: "sometimes"},
+			wantErr: "invalid TRACK_ALLOCATIONS",
+		},
+		{
+			name:    "invalid animation frames",
+			env:     map[string]string{maxAnimationFramesEnv: "0"},
+			wantErr: "invalid MAX_ANIMATION_FRAMES",
+		},
+		{
+			name:    "invalid animation pixels",
+			env:     map[string]string{maxAnimationPixelsEnv: "many"},
+			wantErr: "invalid MAX_ANIMATION_PIXELS",
+		},
+		{
+			name:    "missing s3 renditions",
+			env:     map[string]string{renditionsBucketEnv: "config", renditionsKeyEnv: "renditions.yaml"},
+			wantErr: "renditions.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				setenv(t, name, value)
+			}
+			store := greyscaletest.NewObjectStore()
+			for key, body := range tt.objects {
+				store.PutObject("config", key, greyscaletest.Object{Body: []byte(body)})
+			}
+			publisher := greyscaletest.NewPublisher()
+
+			_, err := NewHandler(context.Background(), store, publisher, imageTopic)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewHandler() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewHandler() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			// a bad config fails the cold start, not the uploads
+			if got := len(publisher.Messages(errorTopic)); got != 0 {
+				t.Errorf("published %d errors, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// deadlineMarginEnv optionally overrides defaultDeadlineMargin, as a
+	// duration such as "3s".
+	deadlineMarginEnv = "DEADLINE_MARGIN"
+
+	// defaultDeadlineMargin is the time kept back from the lambda deadline
+	// to report an upload whose processing ran out of time.
+	defaultDeadlineMargin = 5 * time.Second
+)
+
+// readDeadlineMargin returns the deadline margin, overridden by the
+// environment when it is set.
+func readDeadlineMargin() (time.Duration, error) {
+	raw := os.Getenv(deadlineMarginEnv)
+	if raw == "" {
+		return defaultDeadlineMargin, nil
+	}
+	margin, err := time.ParseDuration(raw)
+	if err != nil || margin < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a duration such as 5s", deadlineMarginEnv, raw)
+	}
+	return margin, nil
+}
+
+// workContext returns the context uploads are processed under, ending margin
+// before the deadline of ctx so there is still time to publish an error
+// message once processing is cut short. The margin is capped at a quarter of
+// the time remaining, so short invocations are not left with no time at
+// all. Without a deadline it is simply ctx.
+func workContext(ctx context.Context, margin time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	if remaining := time.Until(deadline); margin > remaining/4 {
+		margin = remaining / 4
+	}
+	return context.WithDeadline(ctx, deadline.Add(-margin))
+}
@@ -0,0 +1,46 @@
+package converter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining time.Duration
+		margin    time.Duration
+		want      time.Duration
+	}{
+		{name: "margin kept back", remaining: time.Minute, margin: 5 * time.Second, want: 55 * time.Second},
+		{name: "margin capped at a quarter", remaining: 8 * time.Second, margin: 5 * time.Second, want: 6 * time.Second},
+		{name: "no margin", remaining: time.Minute, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline := time.Now().Add(tt.remaining)
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+
+			work, cancelWork := workContext(ctx, tt.margin)
+			defer cancelWork()
+			got, ok := work.Deadline()
+			if !ok {
+				t.Fatal("work context has no deadline")
+			}
+			if diff := got.Sub(deadline.Add(tt.want - tt.remaining)); diff < -time.Millisecond || diff > time.Millisecond {
+				t.Errorf("work deadline is %v before the lambda deadline, want %v", deadline.Sub(got), tt.remaining-tt.want)
+			}
+		})
+	}
+
+	work, cancel := workContext(context.Background(), time.Second)
+	if _, ok := work.Deadline(); ok {
+		t.Error("work context of a context without a deadline has one")
+	}
+	cancel()
+	if work.Err() == nil {
+		t.Error("cancelling the work context did not end it")
+	}
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+const (
+	// profilesEnv holds an optional JSON or YAML map of named rendition
+	// lists that uploads can select with the "pipeline" instruction.
+	profilesEnv = "PROFILES"
+	// profilesBucketEnv and profilesKeyEnv locate the same map in an s3
+	// object, used when profilesEnv is not set.
+	profilesBucketEnv = "PROFILES_BUCKET"
+	profilesKeyEnv    = "PROFILES_KEY"
+)
+
+// instructions are the per upload processing choices read from the object
+// metadata (x-amz-meta-*) and tags of an upload. Only the names in
+// instructionParsers are honoured and each value is validated, so uploaders
+// can pick between what ops configured but can't ask for unbounded work.
+type instructions struct {
+	// profile selects a configured rendition list instead of the default
+	profile string
+	// quality overrides the quality of every jpeg rendition
+	quality int
+	// format overrides the output format of every rendition
+	format string
+}
+
+var instructionParsers = map[string]func(in *instructions, value string, profiles map[string][]rendition) error{
+	"pipeline": func(in *instructions, value string, profiles map[string][]rendition) error {
+		if _, ok := profiles[value]; !ok {
+			return fmt.Errorf("unknown pipeline %q, expected one of %v", value, profileNames(profiles))
+		}
+		in.profile = value
+		return nil
+	},
+	"quality": func(in *instructions, value string, profiles map[string][]rendition) error {
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 10 || quality > 100 {
+			return fmt.Errorf("invalid quality %q, expected a number between 10 and 100", value)
+		}
+		in.quality = quality
+		return nil
+	},
+	"format": func(in *instructions, value string, profiles map[string][]rendition) error {
+		if !imageprocessing.HasEncoder(value) {
+			return fmt.Errorf("unknown format %q, expected one of %v", value, imageprocessing.RegisteredEncoders())
+		}
+		in.format = value
+		return nil
+	},
+}
+
+// readInstructions collects the instructions set on an upload. Tags are read
+// first so that metadata, set by the uploader at upload time, wins.
+func readInstructions(ctx context.Context, store greyscale.ObjectStore, bucket, key string, metadata map[string]string, profiles map[string][]rendition) (instructions, error) {
+	values := map[string]string{}
+
+	tags, err := store.Tags(ctx, bucket, key)
+	if err != nil {
+		return instructions{}, err
+	}
+	for name, value := range tags {
+		values[strings.ToLower(name)] = value
+	}
+	// metadata keys are canonicalised as http headers, e.g. "Pipeline"
+	for name, value := range metadata {
+		values[strings.ToLower(name)] = value
+	}
+
+	return parseInstructions(values, profiles)
+}
+
+func parseInstructions(values map[string]string, profiles map[string][]rendition) (instructions, error) {
+	var in instructions
+	for name, value := range values {
+		parse, ok := instructionParsers[name]
+		if !ok {
+			continue
+		}
+		if err := parse(&in, value, profiles); err != nil {
+			return instructions{}, fmt.Errorf("invalid instruction %s : %w", name, err)
+		}
+	}
+	return in, nil
+}
+
+// renditions returns the rendition list the instructions select.
+func (in instructions) renditions(cfg processingConfig) []rendition {
+	if in.profile != "" {
+		return cfg.profiles[in.profile]
+	}
+	return cfg.renditions
+}
+
+// graph returns the graph running the rendition list the instructions
+// select.
+func (in instructions) graph(cfg processingConfig) *imageprocessing.PipelineGraph {
+	if in.profile != "" {
+		return cfg.profileGraphs[in.profile]
+	}
+	return cfg.graph
+}
+
+// encoderSpec applies the format and quality instructions to the encoder a
+// rendition would otherwise use.
+func (in instructions) encoderSpec(spec imageprocessing.EncoderSpec) imageprocessing.EncoderSpec {
+	if in.format != "" && in.format != spec.Format {
+		spec = imageprocessing.EncoderSpec{Format: in.format}
+	}
+	if in.quality != 0 && spec.Format == "jpeg" {
+		params := imageprocessing.Params{}
+		for name, value := range spec.Params {
+			params[name] = value
+		}
+		params["quality"] = in.quality
+		spec.Params = params
+	}
+	return spec
+}
+
+func profileNames(profiles map[string][]rendition) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,81 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+)
+
+func TestParseInstructions(t *testing.T) {
+	profiles := map[string][]rendition{"sepia-thumb": nil}
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    instructions
+		wantErr bool
+	}{
+		{name: "none", values: map[string]string{}},
+		{
+			name:   "all",
+			values: map[string]string{"pipeline": "sepia-thumb", "quality": "80", "format": "png"},
+			want:   instructions{profile: "sepia-thumb", quality: 80, format: "png"},
+		},
+		{name: "unknown names are ignored", values: map[string]string{"colour": "red"}},
+		{name: "unknown pipeline", values: map[string]string{"pipeline": "sparkle"}, wantErr: true},
+		{name: "quality too low", values: map[string]string{"quality": "5"}, wantErr: true},
+		{name: "quality not a number", values: map[string]string{"quality": "high"}, wantErr: true},
+		{name: "unknown format", values: map[string]string{"format": "bmp"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInstructions(tt.values, profiles)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInstructions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseInstructions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstructionsEncoderSpec(t *testing.T) {
+	progressive := imageprocessing.EncoderSpec{Format: "jpeg", Params: imageprocessing.Params{"quality": 85, "progressive": true}}
+	tests := []struct {
+		name string
+		in   instructions
+		spec imageprocessing.EncoderSpec
+		want imageprocessing.EncoderSpec
+	}{
+		{name: "unchanged", spec: progressive, want: progressive},
+		{
+			name: "quality keeps the other jpeg params",
+			in:   instructions{quality: 50},
+			spec: progressive,
+			want: imageprocessing.EncoderSpec{Format: "jpeg", Params: imageprocessing.Params{"quality": 50, "progressive": true}},
+		},
+		{
+			name: "quality ignored for png",
+			in:   instructions{quality: 50},
+			spec: imageprocessing.EncoderSpec{Format: "png"},
+			want: imageprocessing.EncoderSpec{Format: "png"},
+		},
+		{
+			name: "format replaces the params",
+			in:   instructions{format: "gif"},
+			spec: progressive,
+			want: imageprocessing.EncoderSpec{Format: "gif"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.encoderSpec(tt.spec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("encoderSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if progressive.Params["quality"] != 85 {
+		t.Error("encoderSpec() changed the params of the rendition")
+	}
+}
@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+const (
+	// maxObjectSizeEnv optionally overrides defaultMaxObjectSize, in bytes.
+	maxObjectSizeEnv = "MAX_OBJECT_SIZE"
+	// maxImageWidthEnv, maxImageHeightEnv and maxImagePixelsEnv optionally
+	// override the limits of imageprocessing.DefaultDecodeLimits.
+	maxImageWidthEnv  = "MAX_IMAGE_WIDTH"
+	maxImageHeightEnv = "MAX_IMAGE_HEIGHT"
+	maxImagePixelsEnv = "MAX_IMAGE_PIXELS"
+
+	// defaultMaxObjectSize is the largest upload downloaded, as the whole
+	// object is held in memory while it is decoded.
+	defaultMaxObjectSize = 50 << 20
+)
+
+// rejectedError is returned for an upload that is beyond the limits of the
+// lambda. It is reported with an image rejected message rather than as an
+// error.
+type rejectedError struct {
+	reason string
+	detail string
+	limit  int64
+	value  int64
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("rejected, %s : %s", e.reason, e.detail)
+}
+
+// event returns the image rejected message for the upload at key in bucket.
+func (e *rejectedError) event(bucket, key string) greyscale.ImageRejected {
+	event := greyscale.NewImageRejected(bucket, key, e.reason, e.detail)
+	event.Limit = e.limit
+	event.Value = e.value
+	return event
+}
+
+// objectTooLarge returns the rejection of an upload of size bytes.
+func objectTooLarge(size, max int64) *rejectedError {
+	return &rejectedError{
+		reason: greyscale.RejectedObjectTooLarge,
+		detail: fmt.Sprintf("object of %d bytes exceeds the limit of %d", size, max),
+		limit:  max,
+		value:  size,
+	}
+}
+
+// rejection returns err as a *rejectedError when it reports an image beyond
+// the decode or animation limits, and err otherwise.
+func rejection(err error) error {
+	var limitErr *imageprocessing.LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		return &rejectedError{
+			reason: greyscale.RejectedImageTooLarge,
+			detail: err.Error(),
+			limit:  limitErr.Max,
+			value:  limitErr.Value,
+		}
+	case errors.Is(err, imageprocessing.ErrAnimationTooLarge):
+		return &rejectedError{
+			reason: greyscale.RejectedAnimationTooLarge,
+			detail: err.Error(),
+		}
+	}
+	return err
+}
+
+// readDecodeLimits returns the decode limits and the largest upload
+// downloaded, overridden by any that are set in the environment.
+func readDecodeLimits() (imageprocessing.DecodeLimits, int64, error) {
+	limits := imageprocessing.DefaultDecodeLimits
+	maxObjectSize, err := positiveEnv(maxObjectSizeEnv, defaultMaxObjectSize)
+	if err != nil {
+		return limits, 0, err
+	}
+	width, err := positiveEnv(maxImageWidthEnv, int64(limits.MaxWidth))
+	if err != nil {
+		return limits, 0, err
+	}
+	height, err := positiveEnv(maxImageHeightEnv, int64(limits.MaxHeight))
+	if err != nil {
+		return limits, 0, err
+	}
+	if limits.MaxPixels, err = positiveEnv(maxImagePixelsEnv, limits.MaxPixels); err != nil {
+		return limits, 0, err
+	}
+	limits.MaxWidth, limits.MaxHeight = int(width), int(height)
+	return limits, maxObjectSize, nil
+}
+
+// positiveEnv returns the positive number held in the env variable, or def
+// when it is not set.
+func positiveEnv(env string, def int64) (int64, error) {
+	raw := os.Getenv(env)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive number", env, raw)
+	}
+	return v, nil
+}
@@ -0,0 +1,155 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/sirupsen/logrus"
+)
+
+// trackAllocationsEnv optionally turns on the allocation figures of the
+// processing summaries, as true or false.
+const trackAllocationsEnv = "TRACK_ALLOCATIONS"
+
+// readTrackAllocations reports whether the environment turns on allocation
+// tracking, which is off by default.
+func readTrackAllocations() (bool, error) {
+	raw := os.Getenv(trackAllocationsEnv)
+	if raw == "" {
+		return false, nil
+	}
+	track, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, expected true or false", trackAllocationsEnv, raw)
+	}
+	return track, nil
+}
+
+// renditionObserver logs every action run for a rendition and sums them up
+// for the image converted message. Animations run the pipeline once per
+// frame, so the figures of each action add up across frames.
+type renditionObserver struct {
+	logger  *logrus.Entry
+	actions []greyscale.ActionSummary
+}
+
+var _ imageprocessing.PipelineObserver = &renditionObserver{}
+
+func newRenditionObserver(logger *logrus.Entry) *renditionObserver {
+	return &renditionObserver{logger: logger}
+}
+
+func (o *renditionObserver) BeforeAction(ctx context.Context, event imageprocessing.ActionEvent) {}
+
+func (o *renditionObserver) AfterAction(ctx context.Context, event imageprocessing.ActionEvent) {
+	logAction(ctx, o.logger, event)
+	o.record(event)
+}
+
+// record adds event to the summary of its action.
+func (o *renditionObserver) record(event imageprocessing.ActionEvent) {
+	for len(o.actions) <= event.Index {
+		o.actions = append(o.actions, greyscale.ActionSummary{})
+	}
+	summary := &o.actions[event.Index]
+	if summary.Name == "" {
+		summary.Name = event.Name
+		summary.InputWidth, summary.InputHeight = event.InputSize.X, event.InputSize.Y
+	}
+	summary.OutputWidth, summary.OutputHeight = event.OutputSize.X, event.OutputSize.Y
+	summary.DurationMs += milliseconds(event.Duration)
+	summary.Allocs += event.Allocs
+	summary.AllocBytes += event.AllocBytes
+	summary.Shared = len(event.Outputs) > 1
+}
+
+// summary returns the totals of every action run so far.
+func (o *renditionObserver) summary() *greyscale.ProcessingSummary {
+	summary := &greyscale.ProcessingSummary{Actions: o.actions}
+	for _, action := range o.actions {
+		summary.DurationMs += action.DurationMs
+		summary.AllocBytes += action.AllocBytes
+	}
+	return summary
+}
+
+// fields returns the totals as logrus fields, leaving out the allocations
+// when they were not tracked.
+func (o *renditionObserver) fields() logrus.Fields {
+	summary := o.summary()
+	fields := logrus.Fields{"duration_ms": summary.DurationMs}
+	if summary.AllocBytes > 0 {
+		fields["alloc_bytes"] = summary.AllocBytes
+	}
+	return fields
+}
+
+// outputObservers passes the actions of a pipeline graph on to the observer
+// of every rendition they feed. Actions shared by several renditions are
+// logged once and counted in the summary of each.
+type outputObservers struct {
+	logger    *logrus.Entry
+	observers map[string]*renditionObserver
+}
+
+var _ imageprocessing.PipelineObserver = outputObservers{}
+
+// newOutputObservers returns the observers of renditions, keyed by name.
+func newOutputObservers(renditions []rendition, logger *logrus.Entry) outputObservers {
+	o := outputObservers{
+		logger:    logger,
+		observers: make(map[string]*renditionObserver, len(renditions)),
+	}
+	for _, r := range renditions {
+		o.observers[r.Name] = newRenditionObserver(logger.WithField("rendition", r.Name))
+	}
+	return o
+}
+
+func (o outputObservers) BeforeAction(ctx context.Context, event imageprocessing.ActionEvent) {}
+
+func (o outputObservers) AfterAction(ctx context.Context, event imageprocessing.ActionEvent) {
+	logAction(ctx, o.logger.WithField("renditions", event.Outputs), event)
+	for _, name := range event.Outputs {
+		if observer, ok := o.observers[name]; ok {
+			observer.record(event)
+		}
+	}
+}
+
+// logAction logs the figures of an action at debug level, or as a warning
+// when it failed. The allocations are only logged when ctx tracks them.
+func logAction(ctx context.Context, logger *logrus.Entry, event imageprocessing.ActionEvent) {
+	fields := logrus.Fields{
+		"pipeline_action": event.Name,
+		"pipeline_index":  event.Index,
+		"duration_ms":     milliseconds(event.Duration),
+		"input_size":      sizeField(event.InputSize),
+		"output_size":     sizeField(event.OutputSize),
+	}
+	if imageprocessing.AllocationTracking(ctx) {
+		fields["allocs"] = event.Allocs
+		fields["alloc_bytes"] = event.AllocBytes
+	}
+	entry := logger.WithFields(fields)
+	if event.Err != nil {
+		entry.Warnf("pipeline action failed : %v", event.Err)
+	} else {
+		entry.Debug("pipeline action finished")
+	}
+}
+
+// milliseconds returns d in milliseconds, to the microsecond.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func sizeField(size image.Point) string {
+	return fmt.Sprintf("%dx%d", size.X, size.Y)
+}
@@ -0,0 +1,308 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+const (
+	// renditionsEnv holds an optional JSON or YAML list of renditions
+	// overriding defaultRenditions.
+	renditionsEnv = "RENDITIONS"
+	// renditionsBucketEnv and renditionsKeyEnv locate the same list in an s3
+	// object, used when renditionsEnv is not set.
+	renditionsBucketEnv = "RENDITIONS_BUCKET"
+	renditionsKeyEnv    = "RENDITIONS_KEY"
+)
+
+const (
+	// pagesFirst renders only the first page of multi-page uploads, pagesAll
+	// renders every page.
+	pagesFirst = "first"
+	pagesAll   = "all"
+)
+
+// rendition is a named output produced from every upload.
+type rendition struct {
+	Name     string                       `json:"name" yaml:"name"`
+	Pipeline imageprocessing.PipelineSpec `json:"pipeline" yaml:"pipeline"`
+	// Encoder selects the output format, when unset the format of the
+	// uploaded image is kept.
+	Encoder *imageprocessing.EncoderSpec `json:"encoder,omitempty" yaml:"encoder,omitempty"`
+	// Metadata selects the source metadata written into the rendition, when
+	// unset imageprocessing.DefaultMetadataPolicy applies.
+	Metadata *imageprocessing.MetadataPolicy `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Pages selects the pages of multi-page TIFFs that are rendered, pagesFirst
+	// when unset. With pagesAll every page after the first is stored as a
+	// rendition of its own, see pageRendition.
+	Pages string `json:"pages,omitempty" yaml:"pages,omitempty"`
+
+	// processorPipeline runs Pipeline on its own, for the frames of
+	// animations and the later pages of multi-page uploads. It shares its
+	// actions with the graph of the rendition list.
+	processorPipeline imageprocessing.ProcessorPipeline
+}
+
+// the first rendition is the primary one, stored under the legacy
+// "converted-<key>" name, followed by the extension of its format.
+var defaultRenditions = []rendition{
+	{
+		Name:     "full",
+		Pipeline: pipelineSpec(action("greyscale", nil)),
+	},
+	{
+		// greyscale first so the conversion done for the full rendition is
+		// shared rather than repeated
+		Name: "web",
+		Pipeline: pipelineSpec(
+			action("greyscale", nil),
+			action("resize", imageprocessing.Params{"width": 1024, "height": 1024}),
+		),
+		Encoder: &imageprocessing.EncoderSpec{Format: "jpeg", Params: imageprocessing.Params{"quality": 85, "progressive": true}},
+	},
+	{
+		Name: "thumbnail",
+		Pipeline: pipelineSpec(
+			action("greyscale", nil),
+			action("thumbnail", imageprocessing.Params{"width": 200, "height": 200}),
+		),
+		Encoder: &imageprocessing.EncoderSpec{Format: "jpeg", Params: imageprocessing.Params{"quality": 80}},
+	},
+}
+
+func pipelineSpec(actions ...imageprocessing.ActionSpec) imageprocessing.PipelineSpec {
+	return imageprocessing.PipelineSpec{Actions: actions}
+}
+
+func action(name string, params imageprocessing.Params) imageprocessing.ActionSpec {
+	return imageprocessing.ActionSpec{Name: name, Params: params}
+}
+
+// processingConfig holds the renditions run for every upload, the named
+// profiles that uploads can select instead, the limits uploads must be
+// within and the time kept back from the lambda deadline.
+type processingConfig struct {
+	renditions []rendition
+	profiles   map[string][]rendition
+	// graph and profileGraphs run every rendition of a list at once,
+	// sharing the actions their pipelines start with
+	graph           *imageprocessing.PipelineGraph
+	profileGraphs   map[string]*imageprocessing.PipelineGraph
+	animationLimits imageprocessing.AnimationLimits
+	decodeLimits    imageprocessing.DecodeLimits
+	maxObjectSize   int64
+	deadlineMargin  time.Duration
+	// trackAllocations fills in the allocation figures of the processing
+	// summaries, at the cost of stopping the world around every action
+	trackAllocations bool
+}
+
+// loadProcessingConfig reads the rendition list and profiles from the
+// environment or s3, falling back to defaultRenditions and no profiles, and
+// builds the pipeline of each rendition.
+func loadProcessingConfig(ctx context.Context, store greyscale.ObjectStore) (processingConfig, error) {
+	cfg := processingConfig{
+		profiles:      map[string][]rendition{},
+		profileGraphs: map[string]*imageprocessing.PipelineGraph{},
+	}
+	resources := newResourceOpener(ctx, store)
+
+	raw, source, err := readConfig(ctx, store, renditionsEnv, renditionsBucketEnv, renditionsKeyEnv)
+	if err != nil {
+		return processingConfig{}, err
+	}
+	if raw == nil {
+		cfg.renditions = make([]rendition, len(defaultRenditions))
+		copy(cfg.renditions, defaultRenditions)
+		if cfg.graph, err = buildRenditions(cfg.renditions, resources); err != nil {
+			return processingConfig{}, err
+		}
+	} else if cfg.renditions, cfg.graph, err = parseRenditions(raw, resources); err != nil {
+		return processingConfig{}, fmt.Errorf("invalid renditions in %s : %w", source, err)
+	}
+
+	raw, source, err = readConfig(ctx, store, profilesEnv, profilesBucketEnv, profilesKeyEnv)
+	if err != nil {
+		return processingConfig{}, err
+	}
+	if raw != nil {
+		var profiles map[string][]rendition
+		if err := imageprocessing.UnmarshalSpec(raw, &profiles); err != nil {
+			return processingConfig{}, fmt.Errorf("invalid profiles in %s : %w", source, err)
+		}
+		for name, renditions := range profiles {
+			graph, err := buildRenditions(renditions, resources)
+			if err != nil {
+				return processingConfig{}, fmt.Errorf("invalid profile %q in %s : %w", name, source, err)
+			}
+			cfg.profiles[name] = renditions
+			cfg.profileGraphs[name] = graph
+		}
+	}
+
+	if cfg.animationLimits, err = readAnimationLimits(); err != nil {
+		return processingConfig{}, err
+	}
+	if cfg.decodeLimits, cfg.maxObjectSize, err = readDecodeLimits(); err != nil {
+		return processingConfig{}, err
+	}
+	if cfg.deadlineMargin, err = readDeadlineMargin(); err != nil {
+		return processingConfig{}, err
+	}
+	if cfg.trackAllocations, err = readTrackAllocations(); err != nil {
+		return processingConfig{}, err
+	}
+	return cfg, nil
+}
+
+// readConfig returns the document held in the env variable, or in the s3
+// object named by the bucket and key variables, along with a description of
+// where it came from. It returns nil when neither is set.
+func readConfig(ctx context.Context, store greyscale.ObjectStore, env, bucketEnv, keyEnv string) ([]byte, string, error) {
+	if raw := os.Getenv(env); raw != "" {
+		return []byte(raw), env, nil
+	}
+
+	bucket, key := os.Getenv(bucketEnv), os.Getenv(keyEnv)
+	if bucket == "" || key == "" {
+		return nil, "", nil
+	}
+	source := fmt.Sprintf("s3://%s/%s", bucket, key)
+	body, _, err := store.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, source, err
+	}
+	defer body.Close()
+
+	raw := &bytes.Buffer{}
+	if _, err := io.Copy(raw, body); err != nil {
+		return nil, source, fmt.Errorf("error reading %s : %w", source, err)
+	}
+	return raw.Bytes(), source, nil
+}
+
+func parseRenditions(data []byte, resources imageprocessing.ResourceOpener) ([]rendition, *imageprocessing.PipelineGraph, error) {
+	var renditions []rendition
+	if err := imageprocessing.UnmarshalSpec(data, &renditions); err != nil {
+		return nil, nil, err
+	}
+	graph, err := buildRenditions(renditions, resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return renditions, graph, nil
+}
+
+// buildRenditions validates every rendition and builds the graph running
+// their pipelines, so a bad definition is rejected before any image is
+// processed. Resources named by the pipelines are opened with resources.
+func buildRenditions(renditions []rendition, resources imageprocessing.ResourceOpener) (*imageprocessing.PipelineGraph, error) {
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("at least one rendition is required")
+	}
+	outputs := make([]imageprocessing.OutputSpec, len(renditions))
+	names := map[string]bool{}
+	for i, r := range renditions {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rendition %d has no name", i)
+		}
+		// the name is a path segment of the rendition key
+		if strings.Contains(r.Name, "/") {
+			return nil, fmt.Errorf("rendition %q has a / in its name", r.Name)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rendition %q is defined more than once", r.Name)
+		}
+		names[r.Name] = true
+
+		if len(r.Pipeline.Actions) == 0 {
+			return nil, fmt.Errorf("rendition %q : pipeline spec has no actions", r.Name)
+		}
+		outputs[i] = imageprocessing.OutputSpec{Name: r.Name, Pipeline: r.Pipeline}
+
+		if r.Encoder != nil {
+			if _, err := imageprocessing.NewEncoder(*r.Encoder); err != nil {
+				return nil, fmt.Errorf("rendition %q : %w", r.Name, err)
+			}
+		}
+
+		if r.Metadata != nil {
+			if err := r.Metadata.Validate(); err != nil {
+				return nil, fmt.Errorf("rendition %q : %w", r.Name, err)
+			}
+		}
+
+		switch r.Pages {
+		case "", pagesFirst, pagesAll:
+		default:
+			return nil, fmt.Errorf("rendition %q : unknown pages %q, expected %s or %s", r.Name, r.Pages, pagesFirst, pagesAll)
+		}
+	}
+
+	graph, err := imageprocessing.NewGraphFromSpecWithResources(imageprocessing.MergePipelineSpecs(outputs), resources)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range renditions {
+		renditions[i].processorPipeline, _ = graph.Pipeline(r.Name)
+	}
+	return graph, nil
+}
+
+// pageRendition returns the rendition of page, counted from 1, of a
+// multi-page upload. It is stored under the rendition name suffixed with
+// the page number.
+func (r rendition) pageRendition(page int) rendition {
+	r.Name = fmt.Sprintf("%s-page%d", r.Name, page)
+	return r
+}
+
+// anyRenditionPages reports whether any of renditions renders the given
+// pages.
+func anyRenditionPages(renditions []rendition, pages string) bool {
+	for _, r := range renditions {
+		if r.Pages == pages {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataPolicy returns the metadata policy of the rendition.
+func (r rendition) metadataPolicy() imageprocessing.MetadataPolicy {
+	if r.Metadata != nil {
+		return *r.Metadata
+	}
+	return imageprocessing.DefaultMetadataPolicy
+}
+
+// encoderFor returns the encoder for a rendition of an upload decoded from
+// sourceFormat, after applying the upload's instructions. Renditions without
+// an encoder keep the source format, and formats that can be read but not
+// written fall back to jpeg.
+func (r rendition) encoderFor(sourceFormat string, in instructions) (imageprocessing.Encoder, error) {
+	spec := imageprocessing.EncoderSpec{Format: sourceFormat}
+	if r.Encoder != nil {
+		spec = *r.Encoder
+	} else if !imageprocessing.HasEncoder(sourceFormat) {
+		spec.Format = "jpeg"
+	}
+	return imageprocessing.NewEncoder(in.encoderSpec(spec))
+}
+
+// renditionKey returns the key a rendition of sourceKey is stored under, with
+// the extension of the format it was encoded in.
+func renditionKey(sourceKey, name, extension string, primary bool) string {
+	if primary {
+		return greyscale.ConvertKey(sourceKey, extension)
+	}
+	return greyscale.RenditionKey(sourceKey, name, extension)
+}
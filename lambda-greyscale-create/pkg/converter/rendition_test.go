@@ -0,0 +1,23 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+// the delete lambda only removes primary renditions with one of
+// greyscale.ConvertExtensions, so every encoder has to be listed there.
+func TestEncoderExtensionsAreConvertExtensions(t *testing.T) {
+	for _, format := range imageprocessing.RegisteredEncoders() {
+		encoder, err := imageprocessing.NewEncoder(imageprocessing.EncoderSpec{Format: format})
+		if err != nil {
+			t.Fatalf("NewEncoder(%q) error = %v", format, err)
+		}
+		key := renditionKey("photo.png", "full", encoder.Extension(), true)
+		if !greyscale.IsConvertKey(key, "photo.png") {
+			t.Errorf("the %s primary %q would not be deleted, add %q to greyscale.ConvertExtensions", format, key, encoder.Extension())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ciaranRoche/greyscale/pkg/imageprocessing"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+// s3ResourcePrefix marks action resources, such as watermark images, that
+// are read from s3 as s3://<bucket>/<key> rather than from the lambda
+// package.
+const s3ResourcePrefix = "s3://"
+
+// resourceOpener opens the resources named by rendition pipelines from s3 or
+// the local filesystem.
+type resourceOpener struct {
+	ctx   context.Context
+	store greyscale.ObjectStore
+	local imageprocessing.ResourceOpener
+}
+
+var _ imageprocessing.ResourceOpener = resourceOpener{}
+
+func newResourceOpener(ctx context.Context, store greyscale.ObjectStore) resourceOpener {
+	return resourceOpener{
+		ctx:   ctx,
+		store: store,
+		local: imageprocessing.LocalResources{},
+	}
+}
+
+func (o resourceOpener) Open(name string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(name, s3ResourcePrefix) {
+		return o.local.Open(name)
+	}
+	path := strings.TrimPrefix(name, s3ResourcePrefix)
+	sep := strings.Index(path, "/")
+	if sep <= 0 || sep == len(path)-1 {
+		return nil, fmt.Errorf("invalid s3 resource %q, expected s3://<bucket>/<key>", name)
+	}
+	body, _, err := o.store.Get(o.ctx, path[:sep], path[sep+1:])
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
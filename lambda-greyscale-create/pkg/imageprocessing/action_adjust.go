@@ -0,0 +1,257 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// CurvePoint maps an input channel value to an output value on a curve.
+type CurvePoint struct {
+	In, Out uint8
+}
+
+const (
+	// minGamma and maxGamma bound the gamma a spec may ask for.
+	minGamma = 0.1
+	maxGamma = 10
+)
+
+// actionLUT maps every colour channel through a lookup table, so any tone
+// adjustment costs one table read per channel. Adjacent lookup table actions
+// are merged into one when added to a pipeline.
+type actionLUT struct {
+	lut [256]uint8
+}
+
+var _ ImageAction = actionLUT{}
+
+func init() {
+	RegisterAction("brightness", newActionBrightnessFromParams)
+	RegisterAction("contrast", newActionContrastFromParams)
+	RegisterAction("gamma", newActionGammaFromParams)
+	RegisterAction("levels", newActionLevelsFromParams)
+	RegisterAction("curves", newActionCurvesFromParams)
+}
+
+// newLUTAction builds the lookup table of fn, which maps a channel value in
+// [0, 1] to its adjusted value.
+func newLUTAction(fn func(v float64) float64) *actionLUT {
+	a := &actionLUT{}
+	for i := range a.lut {
+		a.lut[i] = clampChannel(fn(float64(i)/0xff) * 0xff)
+	}
+	return a
+}
+
+// then returns the action applying a followed by next.
+func (a actionLUT) then(next actionLUT) *actionLUT {
+	combined := &actionLUT{}
+	for i, v := range a.lut {
+		combined.lut[i] = next.lut[v]
+	}
+	return combined
+}
+
+// NewActionBrightness returns an action adding amount, between -1 and 1, of
+// the full channel range to every channel.
+func NewActionBrightness(amount float64) ImageAction {
+	return newLUTAction(func(v float64) float64 {
+		return v + amount
+	})
+}
+
+// NewActionContrast returns an action stretching channels away from, or
+// squashing them towards, mid grey. amount is between -1, a flat grey, and
+// 1, a hard threshold, 0 leaves the image as it is.
+func NewActionContrast(amount float64) ImageAction {
+	factor := math.Tan((amount + 1) * math.Pi / 4)
+	return newLUTAction(func(v float64) float64 {
+		return (v-0.5)*factor + 0.5
+	})
+}
+
+// NewActionGamma returns an action applying a gamma correction, values above
+// 1 brighten the mid tones and values below darken them.
+func NewActionGamma(gamma float64) ImageAction {
+	return newLUTAction(func(v float64) float64 {
+		return math.Pow(v, 1/gamma)
+	})
+}
+
+// NewActionLevels returns an action stretching the channel range [black,
+// white] to the full range, clipping values outside it, with gamma applied to
+// the mid tones as NewActionGamma does.
+func NewActionLevels(black, white uint8, gamma float64) (ImageAction, error) {
+	if black >= white {
+		return nil, fmt.Errorf("black point %d must be below the white point %d", black, white)
+	}
+	lo, hi := float64(black)/0xff, float64(white)/0xff
+	return newLUTAction(func(v float64) float64 {
+		v = (v - lo) / (hi - lo)
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, 1/gamma)
+	}), nil
+}
+
+// NewActionCurves returns an action mapping channels through the piecewise
+// linear curve joining points, which must be sorted by strictly increasing
+// input. Values before the first point or after the last take its output.
+func NewActionCurves(points []CurvePoint) (ImageAction, error) {
+	if len(points) < 2 {
+		return nil, errors.New("a curve requires at least 2 points")
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].In <= points[i-1].In {
+			return nil, fmt.Errorf("curve points must have increasing inputs, %d follows %d", points[i].In, points[i-1].In)
+		}
+	}
+
+	a := &actionLUT{}
+	for i := range a.lut {
+		in := uint8(i)
+		switch {
+		case in <= points[0].In:
+			a.lut[i] = points[0].Out
+		case in >= points[len(points)-1].In:
+			a.lut[i] = points[len(points)-1].Out
+		default:
+			j := 1
+			for points[j].In < in {
+				j++
+			}
+			p0, p1 := points[j-1], points[j]
+			t := float64(in-p0.In) / float64(p1.In-p0.In)
+			a.lut[i] = clampChannel(float64(p0.Out) + (float64(p1.Out)-float64(p0.Out))*t)
+		}
+	}
+	return a, nil
+}
+
+func newActionBrightnessFromParams(params *ParamReader) (ImageAction, error) {
+	amount := params.FloatRange("amount", 0, -1, 1)
+	return NewActionBrightness(amount), params.Err()
+}
+
+func newActionContrastFromParams(params *ParamReader) (ImageAction, error) {
+	amount := params.FloatRange("amount", 0, -1, 1)
+	return NewActionContrast(amount), params.Err()
+}
+
+func newActionGammaFromParams(params *ParamReader) (ImageAction, error) {
+	if !params.Has("gamma") {
+		params.Fail("gamma", "is required")
+	}
+	gamma := params.FloatRange("gamma", 1, minGamma, maxGamma)
+	return NewActionGamma(gamma), params.Err()
+}
+
+func newActionLevelsFromParams(params *ParamReader) (ImageAction, error) {
+	black := params.IntRange("black", 0, 0, 0xff)
+	white := params.IntRange("white", 0xff, 0, 0xff)
+	gamma := params.FloatRange("gamma", 1, minGamma, maxGamma)
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+	action, err := NewActionLevels(uint8(black), uint8(white), gamma)
+	if err != nil {
+		params.Fail("white", "%v", err)
+		return nil, params.Err()
+	}
+	return action, nil
+}
+
+func newActionCurvesFromParams(params *ParamReader) (ImageAction, error) {
+	points := readCurvePoints(params, "points")
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+	action, err := NewActionCurves(points)
+	if err != nil {
+		params.Fail("points", "%v", err)
+		return nil, params.Err()
+	}
+	return action, nil
+}
+
+// readCurvePoints reads a required list of [in, out] pairs of channel
+// values.
+func readCurvePoints(params *ParamReader, name string) []CurvePoint {
+	v, ok := params.lookup(name)
+	if !ok {
+		params.Fail(name, "is required")
+		return nil
+	}
+	list, isList := v.([]interface{})
+	if !isList {
+		params.Fail(name, "expected a list of [in, out] pairs")
+		return nil
+	}
+
+	points := make([]CurvePoint, 0, len(list))
+	for i, item := range list {
+		pair, isList := item.([]interface{})
+		if !isList || len(pair) != 2 {
+			params.Fail(name, "point %d: expected an [in, out] pair", i+1)
+			return nil
+		}
+		var values [2]uint8
+		for j, value := range pair {
+			n, ok := numberValue(value)
+			if !ok || n != math.Trunc(n) || n < 0 || n > 0xff {
+				params.Fail(name, "point %d: expected an integer between 0 and 255, got %v", i+1, value)
+				return nil
+			}
+			values[j] = uint8(n)
+		}
+		points = append(points, CurvePoint{In: values[0], Out: values[1]})
+	}
+	return points
+}
+
+func (a actionLUT) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionLUT) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride:]
+			d := dst.Pix[y*dst.Stride:]
+			for i := 0; i < w*4; i += 4 {
+				alpha := s[i+3]
+				switch alpha {
+				case 0:
+					continue
+				case 0xff:
+					d[i] = a.lut[s[i]]
+					d[i+1] = a.lut[s[i+1]]
+					d[i+2] = a.lut[s[i+2]]
+				default:
+					// the table maps straight values, so undo the alpha
+					// premultiplication around it
+					for c := 0; c < 3; c++ {
+						straight := (uint32(s[i+c])*0xff + uint32(alpha)/2) / uint32(alpha)
+						if straight > 0xff {
+							straight = 0xff
+						}
+						d[i+c] = uint8((uint32(a.lut[straight])*uint32(alpha) + 0x7f) / 0xff)
+					}
+				}
+				d[i+3] = alpha
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
@@ -0,0 +1,187 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// rampSource returns a 256x1 image whose pixel x has every colour channel set
+// to x, so a lookup table can be read back from it.
+func rampSource() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		img.SetRGBA(x, 0, color.RGBA{R: uint8(x), G: uint8(x), B: uint8(x), A: 0xff})
+	}
+	return img
+}
+
+func TestAdjust(t *testing.T) {
+	mustAction := func(action ImageAction, err error) ImageAction {
+		if err != nil {
+			t.Fatalf("creating action : %v", err)
+		}
+		return action
+	}
+
+	tests := []struct {
+		name   string
+		action ImageAction
+		// want maps channel values to their adjusted values
+		want map[uint8]uint8
+	}{
+		{name: "brightness", action: NewActionBrightness(0.2), want: map[uint8]uint8{0: 51, 100: 151, 204: 255, 255: 255}},
+		{name: "darken", action: NewActionBrightness(-0.2), want: map[uint8]uint8{0: 0, 51: 0, 151: 100, 255: 204}},
+		{name: "brightness -1", action: NewActionBrightness(-1), want: map[uint8]uint8{0: 0, 128: 0, 255: 0}},
+		{name: "no contrast change", action: NewActionContrast(0), want: map[uint8]uint8{0: 0, 1: 1, 127: 127, 200: 200, 255: 255}},
+		{name: "flat contrast", action: NewActionContrast(-1), want: map[uint8]uint8{0: 128, 127: 128, 255: 128}},
+		{name: "threshold contrast", action: NewActionContrast(1), want: map[uint8]uint8{0: 0, 127: 0, 128: 255, 255: 255}},
+		{name: "gamma 1", action: NewActionGamma(1), want: map[uint8]uint8{0: 0, 64: 64, 255: 255}},
+		{name: "gamma 2", action: NewActionGamma(2), want: map[uint8]uint8{0: 0, 64: 128, 255: 255}},
+		{name: "gamma 0.5", action: NewActionGamma(0.5), want: map[uint8]uint8{0: 0, 128: 64, 255: 255}},
+		{name: "levels", action: mustAction(NewActionLevels(50, 200, 1)), want: map[uint8]uint8{0: 0, 50: 0, 110: 102, 200: 255, 230: 255}},
+		{name: "levels gamma", action: mustAction(NewActionLevels(0, 255, 2)), want: map[uint8]uint8{0: 0, 64: 128, 255: 255}},
+		{name: "inverting curve", action: mustAction(NewActionCurves([]CurvePoint{{0, 255}, {255, 0}})), want: map[uint8]uint8{0: 255, 55: 200, 255: 0}},
+		{name: "s curve", action: mustAction(NewActionCurves([]CurvePoint{{64, 0}, {128, 128}, {192, 255}})), want: map[uint8]uint8{0: 0, 64: 0, 96: 64, 128: 128, 160: 192, 192: 255, 255: 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.action.Transform(rampSource())
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			for in, want := range tt.want {
+				c := straightAt(got, int(in), 0)
+				if c.R != want || c.G != want || c.B != want || c.A != 0xff {
+					t.Errorf("%d maps to %v, want %d", in, c, want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewActionLevels(200, 200, 1); err == nil {
+		t.Error("NewActionLevels() accepted a black point equal to the white point")
+	}
+	if _, err := NewActionCurves([]CurvePoint{{0, 0}}); err == nil {
+		t.Error("NewActionCurves() accepted a single point")
+	}
+	if _, err := NewActionCurves([]CurvePoint{{0, 0}, {128, 10}, {128, 20}}); err == nil {
+		t.Error("NewActionCurves() accepted points without increasing inputs")
+	}
+}
+
+// lookup tables map straight colours, so a half transparent pixel keeps its
+// alpha and is adjusted as its opaque colour would be.
+func TestAdjustAlpha(t *testing.T) {
+	invert, err := NewActionCurves([]CurvePoint{{0, 255}, {255, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src  color.NRGBA
+		want color.NRGBA
+	}{
+		{src: color.NRGBA{R: 100, G: 0, B: 255, A: 0xff}, want: color.NRGBA{R: 155, G: 255, B: 0, A: 0xff}},
+		{src: color.NRGBA{R: 100, G: 0, B: 255, A: 0x80}, want: color.NRGBA{R: 155, G: 255, B: 0, A: 0x80}},
+		{src: color.NRGBA{R: 100, G: 0, B: 255, A: 0x20}, want: color.NRGBA{R: 155, G: 255, B: 0, A: 0x20}},
+		{src: color.NRGBA{R: 100, G: 0, B: 255, A: 0}, want: color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		img, err := invert.Transform(pixel(tt.src))
+		if err != nil {
+			t.Fatalf("Transform() error = %v", err)
+		}
+		got := straightAt(img, 0, 0)
+		// premultiplying loses precision at low alpha
+		tolerance := 0
+		if tt.src.A != 0 {
+			tolerance = int(0xff / tt.src.A)
+		}
+		if got.A != tt.want.A || absDiff(got.R, tt.want.R) > tolerance || absDiff(got.G, tt.want.G) > tolerance || absDiff(got.B, tt.want.B) > tolerance {
+			t.Errorf("Transform(%v) = %v, want %v within %d", tt.src, got, tt.want, tolerance)
+		}
+	}
+}
+
+func TestLUTMerge(t *testing.T) {
+	levels, err := NewActionLevels(10, 240, 1.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adjustments := []ImageAction{NewActionBrightness(0.1), NewActionContrast(0.3), NewActionGamma(1.4), levels}
+
+	pipeline := NewProcessorPipeline()
+	for _, action := range adjustments {
+		pipeline.AddAction(action)
+	}
+	if n := len(pipeline.(*processorPipeline).imageProcesses); n != 1 {
+		t.Fatalf("pipeline has %d actions, want the %d lookup tables merged into 1", n, len(adjustments))
+	}
+
+	merged, err := pipeline.Transform(colourSource())
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	var sequential image.Image = colourSource()
+	for _, action := range adjustments {
+		if sequential, err = action.Transform(sequential); err != nil {
+			t.Fatalf("Transform() error = %v", err)
+		}
+	}
+	if !bytes.Equal(toRGBA(merged).Pix, toRGBA(sequential).Pix) {
+		t.Errorf("merged lookup tables differ from applying them one after another, mean error %.2f", meanError(merged, sequential))
+	}
+
+	// any other action keeps the tables on either side of it apart
+	pipeline = NewProcessorPipeline()
+	pipeline.AddAction(NewActionBrightness(0.1))
+	pipeline.AddAction(NewActionGreyScale())
+	pipeline.AddAction(NewActionGamma(1.4))
+	if n := len(pipeline.(*processorPipeline).imageProcesses); n != 3 {
+		t.Errorf("pipeline has %d actions, want 3", n)
+	}
+}
+
+func TestAdjustSpec(t *testing.T) {
+	pair := func(in, out interface{}) []interface{} { return []interface{}{in, out} }
+	tests := []struct {
+		name      string
+		action    ActionSpec
+		wantParam string
+	}{
+		{name: "brightness", action: ActionSpec{Name: "brightness", Params: Params{"amount": -0.5}}},
+		{name: "brightness too large", action: ActionSpec{Name: "brightness", Params: Params{"amount": 2}}, wantParam: "amount"},
+		{name: "contrast too small", action: ActionSpec{Name: "contrast", Params: Params{"amount": -1.1}}, wantParam: "amount"},
+		{name: "gamma", action: ActionSpec{Name: "gamma", Params: Params{"gamma": 2.2}}},
+		{name: "gamma without gamma", action: ActionSpec{Name: "gamma"}, wantParam: "gamma"},
+		{name: "gamma too small", action: ActionSpec{Name: "gamma", Params: Params{"gamma": 0.05}}, wantParam: "gamma"},
+		{name: "levels", action: ActionSpec{Name: "levels", Params: Params{"black": 16, "white": 235, "gamma": 1.1}}},
+		{name: "levels black over white", action: ActionSpec{Name: "levels", Params: Params{"black": 200, "white": 100}}, wantParam: "white"},
+		{name: "levels white over 255", action: ActionSpec{Name: "levels", Params: Params{"white": 256}}, wantParam: "white"},
+		{name: "curves", action: ActionSpec{Name: "curves", Params: Params{"points": []interface{}{pair(0, 10), pair(255, 245)}}}},
+		{name: "curves without points", action: ActionSpec{Name: "curves"}, wantParam: "points"},
+		{name: "curves of one point", action: ActionSpec{Name: "curves", Params: Params{"points": []interface{}{pair(0, 10)}}}, wantParam: "points"},
+		{name: "curves not a list", action: ActionSpec{Name: "curves", Params: Params{"points": "0,10"}}, wantParam: "points"},
+		{name: "curves point of three", action: ActionSpec{Name: "curves", Params: Params{"points": []interface{}{[]interface{}{0, 1, 2}, pair(255, 0)}}}, wantParam: "points"},
+		{name: "curves value over 255", action: ActionSpec{Name: "curves", Params: Params{"points": []interface{}{pair(0, 256), pair(255, 0)}}}, wantParam: "points"},
+		{name: "curves fractional value", action: ActionSpec{Name: "curves", Params: Params{"points": []interface{}{pair(0.5, 0), pair(255, 0)}}}, wantParam: "points"},
+		{name: "curves decreasing", action: ActionSpec{Name: "curves", Params: Params{"points": []interface{}{pair(200, 0), pair(100, 255)}}}, wantParam: "points"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{tt.action}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
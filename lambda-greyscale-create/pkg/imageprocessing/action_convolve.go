@@ -0,0 +1,349 @@
+package imageprocessing
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+const (
+	maxBlurRadius = 100
+	minBlurSigma  = 0.1
+	maxBlurSigma  = 30
+	maxSharpen    = 5
+)
+
+var (
+	embossKernel = Kernel{Width: 3, Height: 3, Weights: []float64{
+		-2, -1, 0,
+		-1, 1, 1,
+		0, 1, 2,
+	}}
+	sobelXKernel = Kernel{Width: 3, Height: 3, Weights: []float64{
+		-1, 0, 1,
+		-2, 0, 2,
+		-1, 0, 1,
+	}}
+	sobelYKernel = Kernel{Width: 3, Height: 3, Weights: []float64{
+		-1, -2, -1,
+		0, 0, 0,
+		1, 2, 1,
+	}}
+	laplacianKernel = Kernel{Width: 3, Height: 3, Weights: []float64{
+		0, 1, 0,
+		1, -4, 1,
+		0, 1, 0,
+	}}
+	laplacianDiagonalKernel = Kernel{Width: 3, Height: 3, Weights: []float64{
+		1, 1, 1,
+		1, -8, 1,
+		1, 1, 1,
+	}}
+)
+
+type actionConvolve struct {
+	kernel Kernel
+	edge   EdgeMode
+}
+
+var _ ImageAction = actionConvolve{}
+
+type actionSeparableConvolve struct {
+	horizontal, vertical []float64
+	edge                 EdgeMode
+}
+
+var _ ImageAction = actionSeparableConvolve{}
+
+type actionUnsharpMask struct {
+	sigma, amount float64
+	threshold     uint8
+	edge          EdgeMode
+}
+
+var _ ImageAction = actionUnsharpMask{}
+
+// actionEdgeDetect convolves the luma of an image with each kernel and
+// writes the magnitude of the responses as grey.
+type actionEdgeDetect struct {
+	kernels []Kernel
+	edge    EdgeMode
+}
+
+var _ ImageAction = actionEdgeDetect{}
+
+func init() {
+	RegisterAction("convolve", newActionConvolveFromParams)
+	RegisterAction("blur", newActionBoxBlurFromParams)
+	RegisterAction("gaussian_blur", newActionGaussianBlurFromParams)
+	RegisterAction("unsharp_mask", newActionUnsharpMaskFromParams)
+	RegisterAction("emboss", func(params *ParamReader) (ImageAction, error) {
+		return NewActionEmboss(readEdgeMode(params, "edge", EdgeClamp)), params.Err()
+	})
+	RegisterAction("sobel", func(params *ParamReader) (ImageAction, error) {
+		return NewActionSobel(readEdgeMode(params, "edge", EdgeClamp)), params.Err()
+	})
+	RegisterAction("laplacian", func(params *ParamReader) (ImageAction, error) {
+		diagonals := params.Bool("diagonals", false)
+		return NewActionLaplacian(diagonals, readEdgeMode(params, "edge", EdgeClamp)), params.Err()
+	})
+}
+
+// NewActionConvolve returns an action convolving images with kernel, as
+// Convolve does.
+func NewActionConvolve(kernel Kernel, edge EdgeMode) (ImageAction, error) {
+	if err := kernel.validate(maxKernelSize); err != nil {
+		return nil, err
+	}
+	return &actionConvolve{
+		kernel: kernel,
+		edge:   edge,
+	}, nil
+}
+
+// NewActionBoxBlur returns an action averaging every pixel with the
+// (2 * radius + 1) square around it.
+func NewActionBoxBlur(radius int, edge EdgeMode) ImageAction {
+	kernel := BoxKernel(radius)
+	return &actionSeparableConvolve{
+		horizontal: kernel,
+		vertical:   kernel,
+		edge:       edge,
+	}
+}
+
+// NewActionGaussianBlur returns an action blurring images with a gaussian of
+// standard deviation sigma pixels.
+func NewActionGaussianBlur(sigma float64, edge EdgeMode) ImageAction {
+	kernel := GaussianKernel(sigma)
+	return &actionSeparableConvolve{
+		horizontal: kernel,
+		vertical:   kernel,
+		edge:       edge,
+	}
+}
+
+// NewActionUnsharpMask returns an action sharpening images by adding amount
+// times the difference between each pixel and its gaussian blur of sigma.
+// Differences below threshold are left alone, so flat areas don't gain
+// noise.
+func NewActionUnsharpMask(sigma, amount float64, threshold uint8, edge EdgeMode) ImageAction {
+	return &actionUnsharpMask{
+		sigma:     sigma,
+		amount:    amount,
+		threshold: threshold,
+		edge:      edge,
+	}
+}
+
+// NewActionEmboss returns an action giving images a relief effect lit from
+// the top left.
+func NewActionEmboss(edge EdgeMode) ImageAction {
+	return &actionConvolve{
+		kernel: embossKernel,
+		edge:   edge,
+	}
+}
+
+// NewActionSobel returns an action replacing images with the magnitude of
+// their sobel luminance gradient, edges are bright on black.
+func NewActionSobel(edge EdgeMode) ImageAction {
+	return &actionEdgeDetect{
+		kernels: []Kernel{sobelXKernel, sobelYKernel},
+		edge:    edge,
+	}
+}
+
+// NewActionLaplacian returns an action replacing images with the absolute
+// laplacian of their luminance, which also counts diagonal neighbours when
+// diagonals is set.
+func NewActionLaplacian(diagonals bool, edge EdgeMode) ImageAction {
+	kernel := laplacianKernel
+	if diagonals {
+		kernel = laplacianDiagonalKernel
+	}
+	return &actionEdgeDetect{
+		kernels: []Kernel{kernel},
+		edge:    edge,
+	}
+}
+
+func newActionConvolveFromParams(params *ParamReader) (ImageAction, error) {
+	kernel := readKernel(params, "kernel")
+	normalize := params.Bool("normalize", true)
+	edge := readEdgeMode(params, "edge", EdgeClamp)
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+	if normalize {
+		kernel = kernel.Normalize()
+	}
+	return NewActionConvolve(kernel, edge)
+}
+
+func newActionBoxBlurFromParams(params *ParamReader) (ImageAction, error) {
+	radius := params.IntRange("radius", 1, 1, maxBlurRadius)
+	edge := readEdgeMode(params, "edge", EdgeClamp)
+	return NewActionBoxBlur(radius, edge), params.Err()
+}
+
+func newActionGaussianBlurFromParams(params *ParamReader) (ImageAction, error) {
+	sigma := params.FloatRange("sigma", 1, minBlurSigma, maxBlurSigma)
+	edge := readEdgeMode(params, "edge", EdgeClamp)
+	return NewActionGaussianBlur(sigma, edge), params.Err()
+}
+
+func newActionUnsharpMaskFromParams(params *ParamReader) (ImageAction, error) {
+	sigma := params.FloatRange("sigma", 1, minBlurSigma, maxBlurSigma)
+	amount := params.FloatRange("amount", 1, 0, maxSharpen)
+	threshold := params.IntRange("threshold", 0, 0, 0xff)
+	edge := readEdgeMode(params, "edge", EdgeClamp)
+	return NewActionUnsharpMask(sigma, amount, uint8(threshold), edge), params.Err()
+}
+
+func readEdgeMode(params *ParamReader, name string, def EdgeMode) EdgeMode {
+	mode, err := ParseEdgeMode(params.String(name, def.String()))
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return def
+	}
+	return mode
+}
+
+// readKernel reads a required kernel given as a list of rows of weights.
+func readKernel(params *ParamReader, name string) Kernel {
+	v, ok := params.lookup(name)
+	if !ok {
+		params.Fail(name, "is required")
+		return Kernel{}
+	}
+	list, isList := v.([]interface{})
+	if !isList || len(list) > maxKernelSize {
+		params.Fail(name, "expected a list of at most %d rows of weights", maxKernelSize)
+		return Kernel{}
+	}
+
+	rows := make([][]float64, len(list))
+	for i, item := range list {
+		row, isList := item.([]interface{})
+		if !isList || len(row) > maxKernelSize {
+			params.Fail(name, "row %d: expected a list of at most %d weights", i+1, maxKernelSize)
+			return Kernel{}
+		}
+		for _, value := range row {
+			n, ok := numberValue(value)
+			if !ok {
+				params.Fail(name, "row %d: expected a number, got %v", i+1, value)
+				return Kernel{}
+			}
+			rows[i] = append(rows[i], n)
+		}
+	}
+
+	kernel, err := NewKernel(rows)
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return Kernel{}
+	}
+	return kernel
+}
+
+func (a actionConvolve) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionConvolve) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	return convolve(ctx, img, a.kernel, a.edge)
+}
+
+func (a actionSeparableConvolve) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionSeparableConvolve) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	return convolveSeparable(ctx, img, a.horizontal, a.vertical, a.edge)
+}
+
+func (a actionUnsharpMask) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionUnsharpMask) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	kernel := GaussianKernel(a.sigma)
+	blurred, err := convolveSeparable(ctx, src, kernel, kernel, a.edge)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	threshold := float64(a.threshold)
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride:]
+			b := blurred.Pix[y*blurred.Stride:]
+			d := dst.Pix[y*dst.Stride:]
+			for i := 0; i < w*4; i += 4 {
+				alpha := s[i+3]
+				for c := 0; c < 3; c++ {
+					v := float64(s[i+c])
+					diff := v - float64(b[i+c])
+					if math.Abs(diff) >= threshold {
+						v += diff * a.amount
+					}
+					d[i+c] = minChannel(clampChannel(v), alpha)
+				}
+				d[i+3] = alpha
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func (a actionEdgeDetect) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionEdgeDetect) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	luma, _ := lumaPlane(ctx, src)
+	plane := make([]float32, len(luma))
+	for i, v := range luma {
+		plane[i] = float32(v)
+	}
+
+	responses := make([][]float32, len(a.kernels))
+	for i, k := range a.kernels {
+		responses[i] = convolvePlane(ctx, plane, w, h, k, a.edge)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride:]
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < w; x++ {
+				sum := 0.0
+				for _, r := range responses {
+					v := float64(r[y*w+x])
+					sum += v * v
+				}
+				alpha := s[x*4+3]
+				grey := clampChannel(math.Sqrt(sum) * float64(alpha) / 0xff)
+				d[x*4], d[x*4+1], d[x*4+2], d[x*4+3] = grey, grey, grey, alpha
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
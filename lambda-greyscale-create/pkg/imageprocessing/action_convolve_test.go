@@ -0,0 +1,214 @@
+package imageprocessing
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// greyImage returns a w x h opaque image whose pixel x, y is the grey value
+// v(x, y).
+func greyImage(w, h int, v func(x, y int) uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			g := v(x, y)
+			img.SetRGBA(x, y, color.RGBA{R: g, G: g, B: g, A: 0xff})
+		}
+	}
+	return img
+}
+
+func TestBlur(t *testing.T) {
+	dot := greyImage(5, 5, func(x, y int) uint8 {
+		if x == 2 && y == 2 {
+			return 0xff
+		}
+		return 0
+	})
+
+	got := toRGBA(transformOrFail(t, NewActionBoxBlur(1, EdgeClamp), dot))
+	for _, p := range []image.Point{{2, 2}, {1, 1}, {3, 2}, {2, 3}} {
+		if c := got.RGBAAt(p.X, p.Y); c.R != 28 || c.A != 0xff {
+			t.Errorf("box blur at %v = %v, want 28", p, c)
+		}
+	}
+	if c := got.RGBAAt(0, 0); c.R != 0 {
+		t.Errorf("box blur at (0, 0) = %v, want it outside the box", c)
+	}
+
+	got = toRGBA(transformOrFail(t, NewActionGaussianBlur(1, EdgeClamp), dot))
+	centre, side, corner := got.RGBAAt(2, 2).R, got.RGBAAt(3, 2).R, got.RGBAAt(3, 3).R
+	if !(centre > side && side > corner && corner > 0) {
+		t.Errorf("gaussian blur centre %d, side %d, corner %d, want them falling away from the centre", centre, side, corner)
+	}
+	for _, p := range []image.Point{{1, 2}, {2, 1}, {2, 3}} {
+		if c := got.RGBAAt(p.X, p.Y).R; c != side {
+			t.Errorf("gaussian blur at %v = %d, want %d as the other sides", p, c, side)
+		}
+	}
+
+	// a flat image stays flat whatever reaches past its edges
+	flat := greyImage(6, 4, func(x, y int) uint8 { return 90 })
+	for _, edge := range []EdgeMode{EdgeClamp, EdgeWrap, EdgeMirror} {
+		for _, action := range []ImageAction{NewActionBoxBlur(3, edge), NewActionGaussianBlur(2, edge)} {
+			got := toRGBA(transformOrFail(t, action, flat))
+			for _, p := range []image.Point{{0, 0}, {5, 3}, {2, 1}} {
+				if c := got.RGBAAt(p.X, p.Y); c != (color.RGBA{R: 90, G: 90, B: 90, A: 0xff}) {
+					t.Errorf("%s blur of a flat image at %v = %v, want 90", edge, p, c)
+				}
+			}
+		}
+	}
+}
+
+func TestUnsharpMask(t *testing.T) {
+	step := greyImage(10, 3, func(x, y int) uint8 {
+		if x < 5 {
+			return 100
+		}
+		return 150
+	})
+
+	got := toRGBA(transformOrFail(t, NewActionUnsharpMask(1, 1, 0, EdgeClamp), step))
+	if dark, light := got.RGBAAt(4, 1).R, got.RGBAAt(5, 1).R; dark >= 100 || light <= 150 {
+		t.Errorf("sharpened edge = %d|%d, want it steeper than 100|150", dark, light)
+	}
+	// columns further than the blur reaches are left alone
+	if c := got.RGBAAt(0, 1).R; c != 100 {
+		t.Errorf("sharpened column 0 = %d, want 100", c)
+	}
+
+	// differences below the threshold are not sharpened
+	got = toRGBA(transformOrFail(t, NewActionUnsharpMask(1, 1, 0xff, EdgeClamp), step))
+	if meanError(got, step) != 0 {
+		t.Errorf("thresholded sharpening changed the image, mean error %.2f", meanError(got, step))
+	}
+
+	// sharpening keeps alpha and the colour stays within it
+	got = toRGBA(transformOrFail(t, NewActionUnsharpMask(2, 5, 0, EdgeClamp), halfTransparent()))
+	src := halfTransparent()
+	for i := 0; i < len(got.Pix); i += 4 {
+		p := got.Pix[i : i+4]
+		if p[3] != src.Pix[i+3] || p[0] > p[3] || p[1] > p[3] || p[2] > p[3] {
+			t.Fatalf("sharpened pixel %v, source alpha %d, want the alpha kept and colour within it", p, src.Pix[i+3])
+		}
+	}
+}
+
+func TestEdgeDetect(t *testing.T) {
+	step := greyImage(8, 4, func(x, y int) uint8 {
+		if x < 4 {
+			return 0
+		}
+		return 0xff
+	})
+	flat := greyImage(8, 4, func(x, y int) uint8 { return 200 })
+
+	tests := []struct {
+		name   string
+		action ImageAction
+	}{
+		{name: "sobel", action: NewActionSobel(EdgeClamp)},
+		{name: "laplacian", action: NewActionLaplacian(false, EdgeClamp)},
+		{name: "laplacian with diagonals", action: NewActionLaplacian(true, EdgeMirror)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toRGBA(transformOrFail(t, tt.action, step))
+			for x, wantEdge := range []bool{false, false, false, true, true, false, false, false} {
+				c := got.RGBAAt(x, 1)
+				if c.R != c.G || c.G != c.B || c.A != 0xff {
+					t.Errorf("column %d = %v, want opaque grey", x, c)
+				}
+				if (c.R > 0) != wantEdge {
+					t.Errorf("column %d = %d, want an edge %v", x, c.R, wantEdge)
+				}
+			}
+
+			got = toRGBA(transformOrFail(t, tt.action, flat))
+			if c := got.RGBAAt(0, 0); c != (color.RGBA{A: 0xff}) {
+				t.Errorf("flat image = %v, want no edges", c)
+			}
+
+			// edges are drawn within the alpha of the source
+			got = toRGBA(transformOrFail(t, tt.action, halfTransparent()))
+			src := halfTransparent()
+			for i := 0; i < len(got.Pix); i += 4 {
+				if p := got.Pix[i : i+4]; p[3] != src.Pix[i+3] || p[0] > p[3] {
+					t.Fatalf("edge pixel %v, source alpha %d, want the alpha kept and grey within it", p, src.Pix[i+3])
+				}
+			}
+		})
+	}
+}
+
+func TestConvolveSpec(t *testing.T) {
+	rows := func(rows ...[]interface{}) []interface{} {
+		list := make([]interface{}, len(rows))
+		for i, row := range rows {
+			list[i] = row
+		}
+		return list
+	}
+	wide := make([]interface{}, 16)
+	for i := range wide {
+		wide[i] = 1
+	}
+
+	tests := []struct {
+		name      string
+		action    ActionSpec
+		wantParam string
+	}{
+		{name: "convolve", action: ActionSpec{Name: "convolve", Params: Params{"kernel": rows([]interface{}{0, -1, 0}, []interface{}{-1, 5, -1}, []interface{}{0, -1, 0})}}},
+		{name: "convolve without kernel", action: ActionSpec{Name: "convolve"}, wantParam: "kernel"},
+		{name: "convolve even kernel", action: ActionSpec{Name: "convolve", Params: Params{"kernel": rows([]interface{}{1, 1})}}, wantParam: "kernel"},
+		{name: "convolve ragged kernel", action: ActionSpec{Name: "convolve", Params: Params{"kernel": rows([]interface{}{1, 1, 1}, []interface{}{1}, []interface{}{1, 1, 1})}}, wantParam: "kernel"},
+		{name: "convolve row too wide", action: ActionSpec{Name: "convolve", Params: Params{"kernel": rows(wide)}}, wantParam: "kernel"},
+		{name: "convolve weight not a number", action: ActionSpec{Name: "convolve", Params: Params{"kernel": rows([]interface{}{"one"})}}, wantParam: "kernel"},
+		{name: "convolve not a list", action: ActionSpec{Name: "convolve", Params: Params{"kernel": 1}}, wantParam: "kernel"},
+		{name: "convolve unknown edge", action: ActionSpec{Name: "convolve", Params: Params{"kernel": rows([]interface{}{1}), "edge": "repeat"}}, wantParam: "edge"},
+		{name: "blur", action: ActionSpec{Name: "blur", Params: Params{"radius": 3, "edge": "wrap"}}},
+		{name: "blur radius 0", action: ActionSpec{Name: "blur", Params: Params{"radius": 0}}, wantParam: "radius"},
+		{name: "gaussian blur sigma too large", action: ActionSpec{Name: "gaussian_blur", Params: Params{"sigma": 31}}, wantParam: "sigma"},
+		{name: "unsharp mask", action: ActionSpec{Name: "unsharp_mask", Params: Params{"sigma": 2, "amount": 1.5, "threshold": 4}}},
+		{name: "unsharp mask amount too large", action: ActionSpec{Name: "unsharp_mask", Params: Params{"amount": 6}}, wantParam: "amount"},
+		{name: "unsharp mask threshold over 255", action: ActionSpec{Name: "unsharp_mask", Params: Params{"threshold": 256}}, wantParam: "threshold"},
+		{name: "emboss", action: ActionSpec{Name: "emboss", Params: Params{"edge": "mirror"}}},
+		{name: "sobel unknown edge", action: ActionSpec{Name: "sobel", Params: Params{"edge": "none"}}, wantParam: "edge"},
+		{name: "laplacian", action: ActionSpec{Name: "laplacian", Params: Params{"diagonals": true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{tt.action}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+
+	// kernels are normalized unless asked not to be
+	row := greyImage(3, 1, func(x, y int) uint8 { return []uint8{0xff, 0, 0}[x] })
+	for _, tt := range []struct {
+		normalize bool
+		want      uint8
+	}{{normalize: true, want: 85}, {normalize: false, want: 0xff}} {
+		params := Params{"kernel": rows([]interface{}{1, 1, 1}), "normalize": tt.normalize}
+		pipeline, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{{Name: "convolve", Params: params}}})
+		if err != nil {
+			t.Fatalf("NewPipelineFromSpec() error = %v", err)
+		}
+		if c := toRGBA(transformOrFail(t, pipeline, row)).RGBAAt(1, 0); c.R != tt.want || c.A != 0xff {
+			t.Errorf("normalize %v: middle pixel = %v, want %d", tt.normalize, c, tt.want)
+		}
+	}
+}
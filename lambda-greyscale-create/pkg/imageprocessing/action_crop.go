@@ -0,0 +1,222 @@
+package imageprocessing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Anchor is the point of an image a crop is aligned to, as fractions of the
+// space left over on each axis: {0, 0} keeps the top left corner and
+// {0.5, 0.5} crops equally from every side.
+type Anchor struct {
+	X, Y float64
+}
+
+var (
+	AnchorCenter      = Anchor{0.5, 0.5}
+	AnchorTop         = Anchor{0.5, 0}
+	AnchorBottom      = Anchor{0.5, 1}
+	AnchorLeft        = Anchor{0, 0.5}
+	AnchorRight       = Anchor{1, 0.5}
+	AnchorTopLeft     = Anchor{0, 0}
+	AnchorTopRight    = Anchor{1, 0}
+	AnchorBottomLeft  = Anchor{0, 1}
+	AnchorBottomRight = Anchor{1, 1}
+)
+
+var anchorNames = map[string]Anchor{
+	"center":       AnchorCenter,
+	"top":          AnchorTop,
+	"bottom":       AnchorBottom,
+	"left":         AnchorLeft,
+	"right":        AnchorRight,
+	"top-left":     AnchorTopLeft,
+	"top-right":    AnchorTopRight,
+	"bottom-left":  AnchorBottomLeft,
+	"bottom-right": AnchorBottomRight,
+}
+
+// ParseAnchor returns the anchor with the given name, e.g. "center" or
+// "top-left".
+func ParseAnchor(name string) (Anchor, error) {
+	if anchor, ok := anchorNames[name]; ok {
+		return anchor, nil
+	}
+	return Anchor{}, fmt.Errorf("unknown anchor %q, expected center, top, bottom, left, right, top-left, top-right, bottom-left or bottom-right", name)
+}
+
+// ParseAspect parses an aspect ratio written as "16:9", "16/9" or "1.777".
+func ParseAspect(s string) (float64, error) {
+	sep := strings.IndexAny(s, ":/")
+	if sep < 0 {
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || !(ratio > 0) || math.IsInf(ratio, 0) {
+			return 0, fmt.Errorf("invalid aspect ratio %q, expected a ratio such as 16:9 or 1.5", s)
+		}
+		return ratio, nil
+	}
+	w, errW := strconv.ParseFloat(strings.TrimSpace(s[:sep]), 64)
+	h, errH := strconv.ParseFloat(strings.TrimSpace(s[sep+1:]), 64)
+	if errW != nil || errH != nil || !(w > 0) || !(h > 0) || math.IsInf(w/h, 0) {
+		return 0, fmt.Errorf("invalid aspect ratio %q, expected a ratio such as 16:9 or 1.5", s)
+	}
+	return w / h, nil
+}
+
+// maxAspect bounds the aspect ratios a spec may ask for.
+const maxAspect = 100
+
+type actionCrop struct {
+	x, y, width, height Length
+}
+
+var _ ImageAction = actionCrop{}
+
+type actionAspectCrop struct {
+	aspect float64
+	anchor Anchor
+}
+
+var _ ImageAction = actionAspectCrop{}
+
+func init() {
+	RegisterAction("crop", newActionCropFromParams)
+	RegisterAction("aspect_crop", newActionAspectCropFromParams)
+}
+
+// NewActionCrop returns an action keeping the width x height rectangle whose
+// top left corner is at x, y. Parts of the rectangle outside the image are
+// dropped.
+func NewActionCrop(x, y, width, height Length) ImageAction {
+	return &actionCrop{
+		x:      x,
+		y:      y,
+		width:  width,
+		height: height,
+	}
+}
+
+// NewActionAspectCrop returns an action cropping images to the largest
+// region with the given width / height ratio, aligned to anchor.
+func NewActionAspectCrop(aspect float64, anchor Anchor) ImageAction {
+	return &actionAspectCrop{
+		aspect: aspect,
+		anchor: anchor,
+	}
+}
+
+func newActionCropFromParams(params *ParamReader) (ImageAction, error) {
+	x := params.Length("x", Length{}, maxResizeDimension)
+	y := params.Length("y", Length{}, maxResizeDimension)
+	width := params.RequiredLength("width", maxResizeDimension)
+	height := params.RequiredLength("height", maxResizeDimension)
+	return NewActionCrop(x, y, width, height), params.Err()
+}
+
+func newActionAspectCropFromParams(params *ParamReader) (ImageAction, error) {
+	aspect := readAspect(params, "aspect")
+	anchor := readAnchor(params, "anchor", AnchorCenter)
+	return NewActionAspectCrop(aspect, anchor), params.Err()
+}
+
+// readAspect reads a required aspect ratio, given as a number or a string
+// accepted by ParseAspect.
+func readAspect(params *ParamReader, name string) float64 {
+	var aspect float64
+	if v, ok := params.lookup(name); !ok {
+		params.Fail(name, "is required")
+		return 0
+	} else if s, isString := v.(string); isString {
+		parsed, err := ParseAspect(s)
+		if err != nil {
+			params.Fail(name, "%v", err)
+			return 0
+		}
+		aspect = parsed
+	} else {
+		aspect = params.Float(name, 0)
+	}
+	if aspect < 1.0/maxAspect || aspect > maxAspect {
+		params.Fail(name, "must be between 1:%d and %d:1, got %g", maxAspect, maxAspect, aspect)
+		return 0
+	}
+	return aspect
+}
+
+func readAnchor(params *ParamReader, name string, def Anchor) Anchor {
+	if !params.Has(name) {
+		params.lookup(name)
+		return def
+	}
+	anchor, err := ParseAnchor(params.String(name, ""))
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return def
+	}
+	return anchor
+}
+
+func (a actionCrop) Transform(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	x, y := a.x.Resolve(b.Dx()), a.y.Resolve(b.Dy())
+	w, h := a.width.Resolve(b.Dx()), a.height.Resolve(b.Dy())
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("invalid crop size %vx%v", a.width, a.height)
+	}
+
+	rect := image.Rect(x, y, x+w, y+h).Intersect(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if rect.Empty() {
+		return nil, fmt.Errorf("crop %v,%v %vx%v lies outside the %dx%d image", a.x, a.y, a.width, a.height, b.Dx(), b.Dy())
+	}
+	return cropImage(img, rect), nil
+}
+
+func (a actionAspectCrop) Transform(img image.Image) (image.Image, error) {
+	if !(a.aspect > 0) || math.IsInf(a.aspect, 0) {
+		return nil, errors.New("aspect crop requires a positive aspect ratio")
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return img, nil
+	}
+	w, h := aspectSize(b.Dx(), b.Dy(), a.aspect)
+	return cropImage(img, anchorRect(b.Dx(), b.Dy(), w, h, a.anchor)), nil
+}
+
+// aspectSize returns the largest size with the given aspect ratio that fits
+// within srcW x srcH.
+func aspectSize(srcW, srcH int, aspect float64) (int, int) {
+	w, h := srcW, int(math.Round(float64(srcW)/aspect))
+	if h > srcH {
+		w, h = int(math.Round(float64(srcH)*aspect)), srcH
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// anchorRect returns the w x h region of a srcW x srcH image aligned to
+// anchor.
+func anchorRect(srcW, srcH, w, h int, anchor Anchor) image.Rectangle {
+	x0 := int(math.Round(float64(srcW-w) * anchor.X))
+	y0 := int(math.Round(float64(srcH-h) * anchor.Y))
+	return image.Rect(x0, y0, x0+w, y0+h)
+}
+
+// cropImage copies rect, relative to the image origin, into a new zero-origin
+// image.
+func cropImage(img image.Image, rect image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min.Add(rect.Min), draw.Src)
+	return dst
+}
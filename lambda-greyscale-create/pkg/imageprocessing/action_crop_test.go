@@ -0,0 +1,396 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// positionSource returns a w x h image whose red and green channels are the
+// x and y of each pixel, so a crop can be located from its first pixel.
+func positionSource(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	return img
+}
+
+// cropOrigin returns the source position of the top left pixel of a crop of
+// a positionSource.
+func cropOrigin(img image.Image) image.Point {
+	c := color.RGBAModel.Convert(img.At(img.Bounds().Min.X, img.Bounds().Min.Y)).(color.RGBA)
+	return image.Pt(int(c.R), int(c.G))
+}
+
+func TestCrop(t *testing.T) {
+	tests := []struct {
+		name                string
+		src                 image.Image
+		x, y, width, height Length
+		want                image.Rectangle
+		wantErr             string
+	}{
+		{
+			name: "pixels",
+			src:  positionSource(100, 50),
+			x:    Pixels(10), y: Pixels(5), width: Pixels(30), height: Pixels(20),
+			want: image.Rect(10, 5, 40, 25),
+		},
+		{
+			name: "percent",
+			src:  positionSource(100, 50),
+			x:    Percent(25), y: Percent(50), width: Percent(50), height: Percent(50),
+			want: image.Rect(25, 25, 75, 50),
+		},
+		{
+			name: "mixed units",
+			src:  positionSource(100, 50),
+			x:    Pixels(0), y: Percent(10), width: Percent(100), height: Pixels(10),
+			want: image.Rect(0, 5, 100, 15),
+		},
+		{
+			name: "percent rounds to the nearest pixel",
+			src:  positionSource(33, 33),
+			x:    Percent(50), y: Percent(0), width: Percent(33.3), height: Percent(100),
+			want: image.Rect(17, 0, 28, 33),
+		},
+		{
+			name: "partly outside is clipped",
+			src:  positionSource(100, 50),
+			x:    Pixels(80), y: Pixels(40), width: Pixels(50), height: Pixels(50),
+			want: image.Rect(80, 40, 100, 50),
+		},
+		{
+			name: "relative to the image origin",
+			src:  positionSource(100, 50).SubImage(image.Rect(20, 10, 100, 50)),
+			x:    Pixels(5), y: Pixels(5), width: Pixels(10), height: Pixels(10),
+			want: image.Rect(25, 15, 35, 25),
+		},
+		{
+			name: "outside",
+			src:  positionSource(100, 50),
+			x:    Pixels(100), y: Pixels(0), width: Pixels(10), height: Pixels(10),
+			wantErr: "lies outside the 100x50 image",
+		},
+		{
+			name: "outside a sub image",
+			src:  positionSource(100, 50).SubImage(image.Rect(20, 10, 100, 50)),
+			x:    Pixels(0), y: Pixels(40), width: Pixels(10), height: Pixels(10),
+			wantErr: "lies outside the 80x40 image",
+		},
+		{
+			name: "percent rounding to nothing",
+			src:  positionSource(10, 10),
+			x:    Pixels(0), y: Pixels(0), width: Percent(1), height: Percent(50),
+			wantErr: "invalid crop size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewActionCrop(tt.x, tt.y, tt.width, tt.height).Transform(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Transform() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if got.Bounds() != image.Rect(0, 0, tt.want.Dx(), tt.want.Dy()) {
+				t.Errorf("Transform() bounds = %v, want %dx%d", got.Bounds(), tt.want.Dx(), tt.want.Dy())
+			}
+			if origin := cropOrigin(got); origin != tt.want.Min {
+				t.Errorf("crop starts at %v, want %v", origin, tt.want.Min)
+			}
+		})
+	}
+}
+
+func TestAspectCrop(t *testing.T) {
+	wide := positionSource(200, 100)
+	tall := positionSource(100, 200)
+	tests := []struct {
+		name   string
+		src    image.Image
+		aspect float64
+		anchor Anchor
+		want   image.Rectangle
+	}{
+		{name: "square of wide center", src: wide, aspect: 1, anchor: AnchorCenter, want: image.Rect(50, 0, 150, 100)},
+		{name: "square of wide left", src: wide, aspect: 1, anchor: AnchorLeft, want: image.Rect(0, 0, 100, 100)},
+		{name: "square of wide right", src: wide, aspect: 1, anchor: AnchorRight, want: image.Rect(100, 0, 200, 100)},
+		{name: "square of wide top ignores y", src: wide, aspect: 1, anchor: AnchorTop, want: image.Rect(50, 0, 150, 100)},
+		{name: "square of tall top", src: tall, aspect: 1, anchor: AnchorTop, want: image.Rect(0, 0, 100, 100)},
+		{name: "square of tall bottom", src: tall, aspect: 1, anchor: AnchorBottom, want: image.Rect(0, 100, 100, 200)},
+		{name: "square of tall bottom right", src: tall, aspect: 1, anchor: AnchorBottomRight, want: image.Rect(0, 100, 100, 200)},
+		{name: "16:9 of wide", src: wide, aspect: 16.0 / 9, anchor: AnchorTopLeft, want: image.Rect(0, 0, 178, 100)},
+		{name: "4:1 of wide", src: wide, aspect: 4, anchor: AnchorBottomRight, want: image.Rect(0, 50, 200, 100)},
+		{name: "same aspect", src: wide, aspect: 2, anchor: AnchorCenter, want: image.Rect(0, 0, 200, 100)},
+		{name: "never less than a pixel", src: positionSource(10, 1), aspect: 100, anchor: AnchorCenter, want: image.Rect(0, 0, 10, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewActionAspectCrop(tt.aspect, tt.anchor).Transform(tt.src)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if got.Bounds() != image.Rect(0, 0, tt.want.Dx(), tt.want.Dy()) {
+				t.Errorf("Transform() bounds = %v, want %dx%d", got.Bounds(), tt.want.Dx(), tt.want.Dy())
+			}
+			if origin := cropOrigin(got); origin != tt.want.Min {
+				t.Errorf("crop starts at %v, want %v", origin, tt.want.Min)
+			}
+		})
+	}
+
+	if _, err := NewActionAspectCrop(0, AnchorCenter).Transform(wide); err == nil {
+		t.Error("Transform() accepted an aspect ratio of 0")
+	}
+}
+
+func TestParseAspect(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{s: "16:9", want: 16.0 / 9},
+		{s: "4/3", want: 4.0 / 3},
+		{s: " 3 : 2 ", want: 1.5},
+		{s: "1.5", want: 1.5},
+		{s: "1", want: 1},
+		{s: "0", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "16:0", wantErr: true},
+		{s: "0:9", wantErr: true},
+		{s: "16:", wantErr: true},
+		{s: "wide", wantErr: true},
+		{s: "Inf", wantErr: true},
+		{s: "NaN", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseAspect(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAspect(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if !tt.wantErr && math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ParseAspect(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Length
+		wantErr bool
+	}{
+		{s: "120", want: Pixels(120)},
+		{s: "120px", want: Pixels(120)},
+		{s: " 12.5 px", want: Length{Value: 12.5}},
+		{s: "25%", want: Percent(25)},
+		{s: "33.3 %", want: Percent(33.3)},
+		{s: "%", wantErr: true},
+		{s: "px", wantErr: true},
+		{s: "25pt", wantErr: true},
+		{s: "NaN%", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseLength(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLength(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseLength(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCropSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    ActionSpec
+		wantParam string
+	}{
+		{name: "crop", action: ActionSpec{Name: "crop", Params: Params{"x": "10%", "y": 5, "width": "50%", "height": "40px"}}},
+		{name: "crop without a height", action: ActionSpec{Name: "crop", Params: Params{"width": 10}}, wantParam: "height"},
+		{name: "crop of zero width", action: ActionSpec{Name: "crop", Params: Params{"width": "0%", "height": 10}}, wantParam: "width"},
+		{name: "crop over 100%", action: ActionSpec{Name: "crop", Params: Params{"width": "101%", "height": 10}}, wantParam: "width"},
+		{name: "crop at a negative offset", action: ActionSpec{Name: "crop", Params: Params{"x": -1, "width": 10, "height": 10}}, wantParam: "x"},
+		{name: "crop over the maximum", action: ActionSpec{Name: "crop", Params: Params{"width": maxResizeDimension + 1, "height": 10}}, wantParam: "width"},
+		{name: "crop in points", action: ActionSpec{Name: "crop", Params: Params{"width": "10pt", "height": 10}}, wantParam: "width"},
+		{name: "aspect crop", action: ActionSpec{Name: "aspect_crop", Params: Params{"aspect": "16:9", "anchor": "top-left"}}},
+		{name: "aspect crop as a number", action: ActionSpec{Name: "aspect_crop", Params: Params{"aspect": 1.5}}},
+		{name: "aspect crop without an aspect", action: ActionSpec{Name: "aspect_crop", Params: Params{"anchor": "top"}}, wantParam: "aspect"},
+		{name: "aspect crop too wide", action: ActionSpec{Name: "aspect_crop", Params: Params{"aspect": "101:1"}}, wantParam: "aspect"},
+		{name: "aspect crop too tall", action: ActionSpec{Name: "aspect_crop", Params: Params{"aspect": 0.001}}, wantParam: "aspect"},
+		{name: "aspect crop unknown anchor", action: ActionSpec{Name: "aspect_crop", Params: Params{"aspect": 1, "anchor": "middle"}}, wantParam: "anchor"},
+		{name: "smart crop", action: ActionSpec{Name: "smart_crop", Params: Params{"width": "50%", "height": 100, "method": "entropy"}}},
+		{name: "smart aspect crop", action: ActionSpec{Name: "smart_crop", Params: Params{"aspect": "1:1"}}},
+		{name: "smart crop aspect and width", action: ActionSpec{Name: "smart_crop", Params: Params{"aspect": "1:1", "width": 10}}, wantParam: "aspect"},
+		{name: "smart crop without a height", action: ActionSpec{Name: "smart_crop", Params: Params{"width": 10}}, wantParam: "height"},
+		{name: "smart crop unknown method", action: ActionSpec{Name: "smart_crop", Params: Params{"aspect": 1, "method": "faces"}}, wantParam: "method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{tt.action}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
+
+// detailSource returns a flat w x h image with a checkered patch at rect.
+func detailSource(w, h int, rect image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}
+			if image.Pt(x, y).In(rect) && (x/2+y/2)%2 == 0 {
+				c = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSmartCrop(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    image.Image
+		action ImageAction
+		// detail is the patch of the source, relative to its origin, the
+		// crop has to contain
+		detail   image.Rectangle
+		wantSize image.Point
+	}{
+		{
+			name:     "edges top left",
+			src:      detailSource(120, 80, image.Rect(4, 4, 24, 24)),
+			action:   NewActionSmartCrop(Pixels(40), Pixels(40), SmartCropEdges),
+			detail:   image.Rect(4, 4, 24, 24),
+			wantSize: image.Pt(40, 40),
+		},
+		{
+			name:     "edges bottom right",
+			src:      detailSource(120, 80, image.Rect(90, 55, 115, 78)),
+			action:   NewActionSmartCrop(Pixels(40), Pixels(40), SmartCropEdges),
+			detail:   image.Rect(90, 55, 115, 78),
+			wantSize: image.Pt(40, 40),
+		},
+		{
+			name:     "entropy",
+			src:      detailSource(120, 80, image.Rect(88, 8, 112, 32)),
+			action:   NewActionSmartCrop(Percent(50), Percent(50), SmartCropEntropy),
+			detail:   image.Rect(88, 8, 112, 32),
+			wantSize: image.Pt(60, 40),
+		},
+		{
+			name:     "aspect",
+			src:      detailSource(200, 50, image.Rect(150, 10, 190, 40)),
+			action:   NewActionSmartAspectCrop(1, SmartCropEdges),
+			detail:   image.Rect(150, 10, 190, 40),
+			wantSize: image.Pt(50, 50),
+		},
+		{
+			// the analysis map is reduced, the crop is scaled back
+			name:     "larger than the analysis size",
+			src:      detailSource(1200, 600, image.Rect(1000, 400, 1160, 560)),
+			action:   NewActionSmartCrop(Pixels(300), Pixels(300), SmartCropEdges),
+			detail:   image.Rect(1000, 400, 1160, 560),
+			wantSize: image.Pt(300, 300),
+		},
+		{
+			name:     "sub image",
+			src:      detailSource(160, 80, image.Rect(130, 50, 150, 70)).SubImage(image.Rect(40, 0, 160, 80)),
+			action:   NewActionSmartCrop(Pixels(40), Pixels(40), SmartCropEdges),
+			detail:   image.Rect(90, 50, 110, 70),
+			wantSize: image.Pt(40, 40),
+		},
+		{
+			name:     "larger than the image",
+			src:      detailSource(30, 20, image.Rect(0, 0, 10, 10)),
+			action:   NewActionSmartCrop(Pixels(50), Pixels(10), SmartCropEdges),
+			detail:   image.Rect(0, 0, 10, 10),
+			wantSize: image.Pt(30, 10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.action.Transform(tt.src)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if got.Bounds() != image.Rect(0, 0, tt.wantSize.X, tt.wantSize.Y) {
+				t.Fatalf("Transform() bounds = %v, want %v", got.Bounds(), tt.wantSize)
+			}
+			window := findWindow(toRGBA(tt.src), got, tt.detail)
+			if window.Empty() {
+				t.Fatal("crop does not hold the detail")
+			}
+			if !tt.detail.In(window) {
+				t.Errorf("crop %v does not contain the detail at %v", window, tt.detail)
+			}
+		})
+	}
+}
+
+func TestSmartCropFlatKeepsCentre(t *testing.T) {
+	// with no detail every window ties, so the one in the centre is kept
+	flat := image.NewGray(image.Rect(0, 0, 100, 60))
+	if got := bestWindow(edgeEnergy(context.Background(), flat), 100, 60, 100, 60, 40, 20, 1); got != image.Rect(30, 20, 70, 40) {
+		t.Errorf("bestWindow() = %v, want the centre 30,20-70,40", got)
+	}
+}
+
+// findWindow returns the region of src, relative to its origin, that crop
+// was taken from, given the detail patch of a detailSource whose top left
+// pixel is white, or an empty rectangle when crop does not hold the patch.
+func findWindow(src *image.RGBA, crop image.Image, detail image.Rectangle) image.Rectangle {
+	c := toRGBA(crop)
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	for y := 0; y < c.Rect.Dy(); y++ {
+		for x := 0; x < c.Rect.Dx(); x++ {
+			if c.RGBAAt(c.Rect.Min.X+x, c.Rect.Min.Y+y) != white {
+				continue
+			}
+			// the first white pixel is the top left of the patch
+			window := image.Rectangle{Min: detail.Min.Sub(image.Pt(x, y)), Max: detail.Min.Sub(image.Pt(x, y)).Add(c.Rect.Size())}
+			if !window.In(image.Rect(0, 0, src.Rect.Dx(), src.Rect.Dy())) {
+				return image.Rectangle{}
+			}
+			for wy := 0; wy < window.Dy(); wy++ {
+				for wx := 0; wx < window.Dx(); wx++ {
+					if src.RGBAAt(src.Rect.Min.X+window.Min.X+wx, src.Rect.Min.Y+window.Min.Y+wy) != c.RGBAAt(c.Rect.Min.X+wx, c.Rect.Min.Y+wy) {
+						return image.Rectangle{}
+					}
+				}
+			}
+			return window
+		}
+	}
+	return image.Rectangle{}
+}
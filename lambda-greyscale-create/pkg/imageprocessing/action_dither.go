@@ -0,0 +1,372 @@
+package imageprocessing
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// ThresholdMethod selects how the level splitting black from white is
+// chosen.
+type ThresholdMethod int
+
+const (
+	// ThresholdFixed uses the configured level.
+	ThresholdFixed ThresholdMethod = iota
+	// ThresholdOtsu picks the level that best separates the luminance
+	// histogram into two classes, using Otsu's method.
+	ThresholdOtsu
+)
+
+var thresholdMethodNames = map[ThresholdMethod]string{
+	ThresholdFixed: "fixed",
+	ThresholdOtsu:  "otsu",
+}
+
+func (m ThresholdMethod) String() string {
+	if name, ok := thresholdMethodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("ThresholdMethod(%d)", int(m))
+}
+
+// ParseThresholdMethod returns the method with the given name.
+func ParseThresholdMethod(name string) (ThresholdMethod, error) {
+	for m, n := range thresholdMethodNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown threshold method %q, expected fixed or otsu", name)
+}
+
+// DitherMethod selects how grey levels are approximated by black and white
+// pixels.
+type DitherMethod int
+
+const (
+	// DitherFloydSteinberg diffuses the whole error of each pixel to its
+	// neighbours.
+	DitherFloydSteinberg DitherMethod = iota
+	// DitherAtkinson diffuses three quarters of the error further afield,
+	// keeping more contrast in highlights and shadows.
+	DitherAtkinson
+	// DitherBayer compares pixels against a repeating ordered matrix, giving
+	// a regular crosshatch pattern.
+	DitherBayer
+)
+
+var ditherMethodNames = map[DitherMethod]string{
+	DitherFloydSteinberg: "floyd_steinberg",
+	DitherAtkinson:       "atkinson",
+	DitherBayer:          "bayer",
+}
+
+func (m DitherMethod) String() string {
+	if name, ok := ditherMethodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("DitherMethod(%d)", int(m))
+}
+
+// ParseDitherMethod returns the method with the given name.
+func ParseDitherMethod(name string) (DitherMethod, error) {
+	for m, n := range ditherMethodNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown dither method %q, expected floyd_steinberg, atkinson or bayer", name)
+}
+
+// diffusion spreads a share of the error of a pixel to the neighbour at dx,
+// dy from it.
+type diffusion struct {
+	dx, dy int
+	weight float64
+}
+
+var (
+	floydSteinbergDiffusion = []diffusion{
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	}
+	atkinsonDiffusion = []diffusion{
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+	}
+)
+
+const (
+	defaultThresholdLevel = 128
+	defaultBayerSize      = 4
+	maxBayerSize          = 16
+)
+
+type actionThreshold struct {
+	method ThresholdMethod
+	level  uint8
+}
+
+var _ ImageAction = actionThreshold{}
+
+type actionDither struct {
+	method DitherMethod
+	// size is the side of the bayer matrix, a power of two
+	size int
+}
+
+var _ ImageAction = actionDither{}
+
+func init() {
+	RegisterAction("threshold", newActionThresholdFromParams)
+	RegisterAction("dither", newActionDitherFromParams)
+}
+
+// NewActionThreshold returns an action turning pixels whose luminance is at
+// least level white and the rest black.
+func NewActionThreshold(level uint8) ImageAction {
+	return &actionThreshold{
+		method: ThresholdFixed,
+		level:  level,
+	}
+}
+
+// NewActionOtsuThreshold returns an action turning images black and white at
+// the level chosen by Otsu's method for each image.
+func NewActionOtsuThreshold() ImageAction {
+	return &actionThreshold{method: ThresholdOtsu}
+}
+
+// NewActionDither returns an action approximating the luminance of images
+// with black and white pixels using an error diffusion method.
+func NewActionDither(method DitherMethod) ImageAction {
+	return &actionDither{
+		method: method,
+		size:   defaultBayerSize,
+	}
+}
+
+// NewActionBayerDither returns an action dithering images to black and white
+// with a size x size ordered matrix, size must be a power of two.
+func NewActionBayerDither(size int) (ImageAction, error) {
+	if size < 2 || size > maxBayerSize || size&(size-1) != 0 {
+		return nil, fmt.Errorf("bayer matrix size must be a power of two between 2 and %d, got %d", maxBayerSize, size)
+	}
+	return &actionDither{
+		method: DitherBayer,
+		size:   size,
+	}, nil
+}
+
+func newActionThresholdFromParams(params *ParamReader) (ImageAction, error) {
+	method, err := ParseThresholdMethod(params.String("method", ThresholdFixed.String()))
+	if err != nil {
+		params.Fail("method", "%v", err)
+	}
+	if method == ThresholdOtsu {
+		return NewActionOtsuThreshold(), params.Err()
+	}
+	level := params.IntRange("level", defaultThresholdLevel, 0, 0xff)
+	return NewActionThreshold(uint8(level)), params.Err()
+}
+
+func newActionDitherFromParams(params *ParamReader) (ImageAction, error) {
+	method, err := ParseDitherMethod(params.String("method", DitherFloydSteinberg.String()))
+	if err != nil {
+		params.Fail("method", "%v", err)
+	}
+	if method != DitherBayer {
+		return NewActionDither(method), params.Err()
+	}
+
+	size := params.Int("size", defaultBayerSize)
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+	action, err := NewActionBayerDither(size)
+	if err != nil {
+		params.Fail("size", "%v", err)
+		return nil, params.Err()
+	}
+	return action, nil
+}
+
+func (a actionThreshold) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionThreshold) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	luma, opaque := lumaPlane(ctx, src)
+
+	var level int
+	switch a.method {
+	case ThresholdFixed:
+		level = int(a.level)
+	case ThresholdOtsu:
+		level = otsuLevel(histogram(luma, opaque, w, image.Rect(0, 0, w, h)))
+	default:
+		return nil, fmt.Errorf("unknown threshold method %v", a.method)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				setBilevel(dst, src, x, y, int(luma[y*w+x]) >= level)
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// otsuLevel returns the lowest level of the bright class that maximises the
+// variance between the classes of hist.
+func otsuLevel(hist [256]float64) int {
+	total, sum := 0.0, 0.0
+	for v, n := range hist {
+		total += n
+		sum += float64(v) * n
+	}
+	if total == 0 {
+		return defaultThresholdLevel
+	}
+
+	best, bestVariance := defaultThresholdLevel, -1.0
+	darkCount, darkSum := 0.0, 0.0
+	for t := 0; t < 0xff; t++ {
+		darkCount += hist[t]
+		darkSum += float64(t) * hist[t]
+		brightCount := total - darkCount
+		if darkCount == 0 || brightCount == 0 {
+			continue
+		}
+		darkMean := darkSum / darkCount
+		brightMean := (sum - darkSum) / brightCount
+		variance := darkCount * brightCount * (darkMean - brightMean) * (darkMean - brightMean)
+		if variance > bestVariance {
+			best, bestVariance = t+1, variance
+		}
+	}
+	return best
+}
+
+func (a actionDither) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionDither) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	luma, _ := lumaPlane(ctx, src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	switch a.method {
+	case DitherFloydSteinberg:
+		diffuseError(ctx, dst, src, luma, floydSteinbergDiffusion)
+	case DitherAtkinson:
+		diffuseError(ctx, dst, src, luma, atkinsonDiffusion)
+	case DitherBayer:
+		if a.size < 2 || a.size&(a.size-1) != 0 {
+			return nil, fmt.Errorf("invalid bayer matrix size %d", a.size)
+		}
+		matrix := bayerMatrix(a.size)
+		cells := float64(a.size * a.size)
+		parallelRows(ctx, h, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				row := matrix[(y%a.size)*a.size:]
+				for x := 0; x < w; x++ {
+					threshold := (float64(row[x%a.size]) + 0.5) / cells * 0xff
+					setBilevel(dst, src, x, y, float64(luma[y*w+x]) > threshold)
+				}
+			}
+		})
+	default:
+		return nil, fmt.Errorf("unknown dither method %v", a.method)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// diffuseError dithers luma into dst, spreading the error of every pixel
+// over the neighbours it has not reached yet. Each pixel depends on those
+// before it, so rows are processed in order rather than in parallel, and it
+// stops at the next row once ctx is done.
+func diffuseError(ctx context.Context, dst, src *image.RGBA, luma []uint8, weights []diffusion) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	depth := 0
+	for _, d := range weights {
+		if d.dy > depth {
+			depth = d.dy
+		}
+	}
+
+	// pending holds the error owed to the current row and the rows below
+	// it, indexed by y modulo the ring size
+	rows := depth + 1
+	pending := make([][]float64, rows)
+	for i := range pending {
+		pending[i] = make([]float64, w)
+	}
+
+	for y := 0; y < h && ctx.Err() == nil; y++ {
+		current := pending[y%rows]
+		for x := 0; x < w; x++ {
+			v := float64(luma[y*w+x]) + current[x]
+			white := v >= 0x80
+			setBilevel(dst, src, x, y, white)
+
+			e := v
+			if white {
+				e -= 0xff
+			}
+			for _, d := range weights {
+				nx, ny := x+d.dx, y+d.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				pending[ny%rows][nx] += e * d.weight
+			}
+		}
+		for x := range current {
+			current[x] = 0
+		}
+	}
+}
+
+// bayerMatrix returns the size x size ordered dither matrix, row by row,
+// holding every value from 0 to size * size - 1.
+func bayerMatrix(size int) []int {
+	matrix := []int{0}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 4*n*n)
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				v := 4 * matrix[y*n+x]
+				next[y*2*n+x] = v
+				next[y*2*n+x+n] = v + 2
+				next[(y+n)*2*n+x] = v + 3
+				next[(y+n)*2*n+x+n] = v + 1
+			}
+		}
+		matrix = next
+	}
+	return matrix
+}
+
+// setBilevel writes a white or black pixel to dst, keeping the alpha of the
+// same pixel of src.
+func setBilevel(dst, src *image.RGBA, x, y int, white bool) {
+	alpha := src.Pix[y*src.Stride+x*4+3]
+	v := uint8(0)
+	if white {
+		v = alpha
+	}
+	p := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+	p[0], p[1], p[2], p[3] = v, v, v, alpha
+}
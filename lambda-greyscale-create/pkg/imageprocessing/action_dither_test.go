@@ -0,0 +1,144 @@
+package imageprocessing
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"sort"
+	"testing"
+)
+
+// whiteShare returns the share of the pixels of img that are white, failing
+// the test if any is neither black nor white.
+func whiteShare(t *testing.T, img image.Image) float64 {
+	t.Helper()
+	rgba := toRGBA(img)
+	white := 0
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		switch p := rgba.Pix[i : i+4]; {
+		case p[0] == p[3] && p[1] == p[3] && p[2] == p[3]:
+			if p[3] != 0 {
+				white++
+			}
+		case p[0] != 0 || p[1] != 0 || p[2] != 0:
+			t.Fatalf("pixel %v is neither black nor white", p)
+		}
+	}
+	return float64(white) / float64(len(rgba.Pix)/4)
+}
+
+func TestThreshold(t *testing.T) {
+	ramp := rampSource()
+	got := toRGBA(transformOrFail(t, NewActionThreshold(100), ramp))
+	for x, want := range map[int]uint8{0: 0, 99: 0, 100: 0xff, 255: 0xff} {
+		if c := got.RGBAAt(x, 0); c != (color.RGBA{R: want, G: want, B: want, A: 0xff}) {
+			t.Errorf("threshold 100 of %d = %v, want %d", x, c, want)
+		}
+	}
+
+	// otsu splits two grey levels between them, wherever they lie
+	for _, levels := range [][2]int{{40, 200}, {10, 60}, {180, 240}} {
+		img := greyColumns(10, 4, func(x int) int { return levels[x%2] })
+		got := toRGBA(transformOrFail(t, NewActionOtsuThreshold(), img))
+		if dark, light := got.RGBAAt(0, 0).R, got.RGBAAt(1, 0).R; dark != 0 || light != 0xff {
+			t.Errorf("otsu of %d and %d = %d and %d, want black and white", levels[0], levels[1], dark, light)
+		}
+	}
+
+	// alpha is kept and a transparent pixel stays transparent
+	got = toRGBA(transformOrFail(t, NewActionThreshold(100), pixel(color.NRGBA{R: 200, G: 200, B: 200, A: 0x80})))
+	if c := got.RGBAAt(0, 0); c != (color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0x80}) {
+		t.Errorf("half transparent white = %v, want white at the same alpha", c)
+	}
+	got = toRGBA(transformOrFail(t, NewActionThreshold(0), pixel(color.NRGBA{})))
+	if c := got.RGBAAt(0, 0); c != (color.RGBA{}) {
+		t.Errorf("transparent pixel = %v, want it transparent", c)
+	}
+}
+
+func TestDither(t *testing.T) {
+	bayer := func(size int) ImageAction {
+		action, err := NewActionBayerDither(size)
+		if err != nil {
+			t.Fatalf("NewActionBayerDither(%d) error = %v", size, err)
+		}
+		return action
+	}
+	tests := []struct {
+		name      string
+		action    ImageAction
+		tolerance float64
+	}{
+		{name: "floyd steinberg", action: NewActionDither(DitherFloydSteinberg), tolerance: 0.05},
+		// atkinson drops a quarter of the error, pushing greys apart
+		{name: "atkinson", action: NewActionDither(DitherAtkinson), tolerance: 0.1},
+		{name: "bayer 2", action: bayer(2), tolerance: 0.05},
+		{name: "bayer 8", action: bayer(8), tolerance: 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the share of white pixels follows the grey they stand in for
+			for _, grey := range []int{0, 64, 128, 191, 255} {
+				img := greyColumns(32, 32, func(x int) int { return grey })
+				share := whiteShare(t, transformOrFail(t, tt.action, img))
+				if want := float64(grey) / 0xff; share < want-tt.tolerance || share > want+tt.tolerance {
+					t.Errorf("grey %d dithers to %.2f white, want about %.2f", grey, share, want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewActionBayerDither(6); err == nil {
+		t.Error("NewActionBayerDither(6) accepted a size that is not a power of two")
+	}
+	if _, err := NewActionBayerDither(32); err == nil {
+		t.Error("NewActionBayerDither(32) accepted a size over 16")
+	}
+}
+
+func TestBayerMatrix(t *testing.T) {
+	if got := bayerMatrix(2); len(got) != 4 || got[0] != 0 || got[1] != 2 || got[2] != 3 || got[3] != 1 {
+		t.Errorf("bayerMatrix(2) = %v, want [0 2 3 1]", got)
+	}
+	for _, size := range []int{2, 4, 8, 16} {
+		got := append([]int{}, bayerMatrix(size)...)
+		sort.Ints(got)
+		for i, v := range got {
+			if v != i {
+				t.Fatalf("bayerMatrix(%d) does not hold every value from 0 to %d once", size, size*size-1)
+			}
+		}
+	}
+}
+
+func TestDitherSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    ActionSpec
+		wantParam string
+	}{
+		{name: "threshold", action: ActionSpec{Name: "threshold", Params: Params{"level": 90}}},
+		{name: "threshold otsu", action: ActionSpec{Name: "threshold", Params: Params{"method": "otsu"}}},
+		{name: "threshold level over 255", action: ActionSpec{Name: "threshold", Params: Params{"level": 256}}, wantParam: "level"},
+		{name: "threshold unknown method", action: ActionSpec{Name: "threshold", Params: Params{"method": "mean"}}, wantParam: "method"},
+		{name: "dither", action: ActionSpec{Name: "dither", Params: Params{"method": "atkinson"}}},
+		{name: "dither bayer", action: ActionSpec{Name: "dither", Params: Params{"method": "bayer", "size": 8}}},
+		{name: "dither bayer of 3", action: ActionSpec{Name: "dither", Params: Params{"method": "bayer", "size": 3}}, wantParam: "size"},
+		{name: "dither unknown method", action: ActionSpec{Name: "dither", Params: Params{"method": "random"}}, wantParam: "method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{tt.action}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package imageprocessing
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+)
+
+// EqualizeMethod selects how the luminance histogram of an image is
+// spread.
+type EqualizeMethod int
+
+const (
+	// EqualizeGlobal flattens the histogram of the whole image.
+	EqualizeGlobal EqualizeMethod = iota
+	// EqualizeAutoLevels stretches the luminance range linearly, ignoring a
+	// percentage of the darkest and brightest pixels.
+	EqualizeAutoLevels
+	// EqualizeCLAHE equalizes each tile of a grid separately, limiting how
+	// far contrast is boosted, and blends between neighbouring tiles.
+	EqualizeCLAHE
+)
+
+var equalizeMethodNames = map[EqualizeMethod]string{
+	EqualizeGlobal:     "global",
+	EqualizeAutoLevels: "auto_levels",
+	EqualizeCLAHE:      "clahe",
+}
+
+func (m EqualizeMethod) String() string {
+	if name, ok := equalizeMethodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("EqualizeMethod(%d)", int(m))
+}
+
+// ParseEqualizeMethod returns the method with the given name.
+func ParseEqualizeMethod(name string) (EqualizeMethod, error) {
+	for m, n := range equalizeMethodNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown equalize method %q, expected global, auto_levels or clahe", name)
+}
+
+const (
+	defaultAutoLevelsClip = 0.5
+	maxAutoLevelsClip     = 25
+	defaultCLAHELimit     = 2
+	maxCLAHELimit         = 100
+	defaultCLAHETiles     = 8
+	maxCLAHETiles         = 64
+)
+
+// actionEqualize remaps the BT.601 luma of every pixel and shifts its
+// channels by the same amount, which leaves the chroma of colour images as
+// it was, so it works before and after greyscale.
+type actionEqualize struct {
+	method EqualizeMethod
+	// clip is the percentage of pixels ignored at each end by auto levels
+	clip float64
+	// limit caps each bin of a CLAHE tile histogram at a multiple of the
+	// average bin, tiles is the number of tiles along each axis
+	limit float64
+	tiles int
+}
+
+var _ ImageAction = actionEqualize{}
+
+func init() {
+	RegisterAction("equalize", newActionEqualizeFromParams)
+}
+
+// NewActionEqualize returns an action equalizing the luminance histogram of
+// the whole image.
+func NewActionEqualize() ImageAction {
+	return &actionEqualize{method: EqualizeGlobal}
+}
+
+// NewActionAutoLevels returns an action stretching the luminance range to
+// the full range, after ignoring clip percent of the darkest and of the
+// brightest pixels.
+func NewActionAutoLevels(clip float64) ImageAction {
+	return &actionEqualize{
+		method: EqualizeAutoLevels,
+		clip:   clip,
+	}
+}
+
+// NewActionCLAHE returns an action applying contrast limited adaptive
+// histogram equalization over a tiles x tiles grid, with histogram bins
+// capped at limit times their average.
+func NewActionCLAHE(limit float64, tiles int) ImageAction {
+	return &actionEqualize{
+		method: EqualizeCLAHE,
+		limit:  limit,
+		tiles:  tiles,
+	}
+}
+
+func newActionEqualizeFromParams(params *ParamReader) (ImageAction, error) {
+	method, err := ParseEqualizeMethod(params.String("method", EqualizeGlobal.String()))
+	if err != nil {
+		params.Fail("method", "%v", err)
+	}
+
+	// each method only reads its own parameters, so the others are reported
+	// as unknown
+	switch method {
+	case EqualizeAutoLevels:
+		clip := params.FloatRange("clip", defaultAutoLevelsClip, 0, maxAutoLevelsClip)
+		return NewActionAutoLevels(clip), params.Err()
+	case EqualizeCLAHE:
+		limit := params.FloatRange("limit", defaultCLAHELimit, 1, maxCLAHELimit)
+		tiles := params.IntRange("tiles", defaultCLAHETiles, 1, maxCLAHETiles)
+		return NewActionCLAHE(limit, tiles), params.Err()
+	default:
+		return NewActionEqualize(), params.Err()
+	}
+}
+
+func (a actionEqualize) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionEqualize) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	luma, opaque := lumaPlane(ctx, src)
+
+	var remap func(x, y int, v uint8) uint8
+	switch a.method {
+	case EqualizeGlobal:
+		lut := equalizeLUT(histogram(luma, opaque, w, image.Rect(0, 0, w, h)), 0)
+		remap = func(x, y int, v uint8) uint8 { return lut[v] }
+	case EqualizeAutoLevels:
+		if a.clip < 0 || a.clip >= 50 {
+			return nil, fmt.Errorf("invalid auto levels clip %g%%", a.clip)
+		}
+		lut := autoLevelsLUT(histogram(luma, opaque, w, image.Rect(0, 0, w, h)), a.clip)
+		remap = func(x, y int, v uint8) uint8 { return lut[v] }
+	case EqualizeCLAHE:
+		if a.tiles < 1 || a.limit < 1 {
+			return nil, fmt.Errorf("invalid clahe limit %g or tiles %d", a.limit, a.tiles)
+		}
+		remap = claheRemap(ctx, luma, opaque, w, h, a.limit, a.tiles)
+	default:
+		return nil, fmt.Errorf("unknown equalize method %v", a.method)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride:]
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < w; x++ {
+				i := x * 4
+				alpha := s[i+3]
+				if alpha == 0 {
+					continue
+				}
+				v := luma[y*w+x]
+				shift := float64(remap(x, y, v)) - float64(v)
+				scale := float64(alpha) / 0xff
+				for c := 0; c < 3; c++ {
+					straight := float64(s[i+c]) / scale
+					d[i+c] = clampChannel(math.Max(0, math.Min(straight+shift, 0xff)) * scale)
+				}
+				d[i+3] = alpha
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// lumaPlane returns the luma of the straight colour of every pixel of src,
+// along with whether each pixel is visible at all.
+func lumaPlane(ctx context.Context, src *image.RGBA) ([]uint8, []bool) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	luma := make([]uint8, w*h)
+	opaque := make([]bool, w*h)
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride:]
+			for x := 0; x < w; x++ {
+				p := s[x*4 : x*4+4]
+				if p[3] == 0 {
+					continue
+				}
+				r, g, b := p[0], p[1], p[2]
+				if p[3] != 0xff {
+					r, g, b = unpremultiply(r, p[3]), unpremultiply(g, p[3]), unpremultiply(b, p[3])
+				}
+				luma[y*w+x] = bt601Luma(r, g, b)
+				opaque[y*w+x] = true
+			}
+		}
+	})
+	return luma, opaque
+}
+
+func unpremultiply(c, a uint8) uint8 {
+	v := (uint32(c)*0xff + uint32(a)/2) / uint32(a)
+	if v > 0xff {
+		return 0xff
+	}
+	return uint8(v)
+}
+
+// histogram counts the visible luma values within rect.
+func histogram(luma []uint8, opaque []bool, stride int, rect image.Rectangle) [256]float64 {
+	var hist [256]float64
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if opaque[y*stride+x] {
+				hist[luma[y*stride+x]]++
+			}
+		}
+	}
+	return hist
+}
+
+// equalizeLUT returns the table flattening hist. When limit is set, bins are
+// first capped at limit times the average bin and the excess spread evenly.
+func equalizeLUT(hist [256]float64, limit float64) [256]uint8 {
+	total := 0.0
+	for _, n := range hist {
+		total += n
+	}
+	var lut [256]uint8
+	if total == 0 {
+		for i := range lut {
+			lut[i] = uint8(i)
+		}
+		return lut
+	}
+
+	if limit > 0 {
+		ceiling := limit * total / 256
+		excess := 0.0
+		for i, n := range hist {
+			if n > ceiling {
+				excess += n - ceiling
+				hist[i] = ceiling
+			}
+		}
+		for i := range hist {
+			hist[i] += excess / 256
+		}
+	}
+
+	// the darkest value present maps to black
+	first := 0.0
+	for _, n := range hist {
+		if n > 0 {
+			first = n
+			break
+		}
+	}
+	cdf := 0.0
+	for i, n := range hist {
+		cdf += n
+		if total > first {
+			lut[i] = clampChannel((cdf - first) / (total - first) * 0xff)
+		} else {
+			lut[i] = uint8(i)
+		}
+	}
+	return lut
+}
+
+// autoLevelsLUT returns the table stretching the range between the clip and
+// 100 - clip percentiles of hist to the full range.
+func autoLevelsLUT(hist [256]float64, clip float64) [256]uint8 {
+	total := 0.0
+	for _, n := range hist {
+		total += n
+	}
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = uint8(i)
+	}
+	if total == 0 {
+		return lut
+	}
+
+	cut := total * clip / 100
+	lo, hi := 0, 0xff
+	for sum := 0.0; lo < 0xff; lo++ {
+		if sum += hist[lo]; sum > cut {
+			break
+		}
+	}
+	for sum := 0.0; hi > 0; hi-- {
+		if sum += hist[hi]; sum > cut {
+			break
+		}
+	}
+	if hi <= lo {
+		return lut
+	}
+	for i := range lut {
+		lut[i] = clampChannel(float64(i-lo) / float64(hi-lo) * 0xff)
+	}
+	return lut
+}
+
+// claheRemap equalizes each tile of the luma plane with a clip limited
+// histogram and returns a mapping that interpolates bilinearly between the
+// tables of the four tiles whose centres surround a pixel.
+func claheRemap(ctx context.Context, luma []uint8, opaque []bool, w, h int, limit float64, tiles int) func(x, y int, v uint8) uint8 {
+	tilesX, tilesY := tiles, tiles
+	if tilesX > w {
+		tilesX = w
+	}
+	if tilesY > h {
+		tilesY = h
+	}
+	tileW, tileH := (w+tilesX-1)/tilesX, (h+tilesY-1)/tilesY
+	tilesX, tilesY = (w+tileW-1)/tileW, (h+tileH-1)/tileH
+
+	luts := make([][256]uint8, tilesX*tilesY)
+	parallelRows(ctx, tilesY, func(ty0, ty1 int) {
+		for ty := ty0; ty < ty1; ty++ {
+			for tx := 0; tx < tilesX; tx++ {
+				rect := image.Rect(tx*tileW, ty*tileH, (tx+1)*tileW, (ty+1)*tileH).Intersect(image.Rect(0, 0, w, h))
+				luts[ty*tilesX+tx] = equalizeLUT(histogram(luma, opaque, w, rect), limit)
+			}
+		}
+	})
+
+	// tile returns the tiles either side of a pixel and how far it lies
+	// between their centres
+	tile := func(p, size, count int) (int, int, float64) {
+		f := (float64(p)+0.5)/float64(size) - 0.5
+		i0 := int(math.Floor(f))
+		if i0 < 0 {
+			return 0, 0, 0
+		}
+		if i0 >= count-1 {
+			return count - 1, count - 1, 0
+		}
+		return i0, i0 + 1, f - float64(i0)
+	}
+
+	return func(x, y int, v uint8) uint8 {
+		x0, x1, tx := tile(x, tileW, tilesX)
+		y0, y1, ty := tile(y, tileH, tilesY)
+		top := float64(luts[y0*tilesX+x0][v])*(1-tx) + float64(luts[y0*tilesX+x1][v])*tx
+		bottom := float64(luts[y1*tilesX+x0][v])*(1-tx) + float64(luts[y1*tilesX+x1][v])*tx
+		return clampChannel(top*(1-ty) + bottom*ty)
+	}
+}
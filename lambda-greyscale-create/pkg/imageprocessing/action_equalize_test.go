@@ -0,0 +1,230 @@
+package imageprocessing
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// greyColumns returns a w x h image whose column x is the grey value
+// column(x), or transparent when column returns a negative value.
+func greyColumns(w, h int, column func(x int) int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if v := column(x); v >= 0 {
+				img.SetRGBA(x, y, color.RGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 0xff})
+			}
+		}
+	}
+	return img
+}
+
+func transformOrFail(t *testing.T, action ImageAction, img image.Image) image.Image {
+	t.Helper()
+	got, err := action.Transform(img)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	return got
+}
+
+func TestEqualizeGlobal(t *testing.T) {
+	// two grey levels spread to black and white
+	twoLevels := greyColumns(8, 4, func(x int) int { return 100 + 50*(x%2) })
+	got := transformOrFail(t, NewActionEqualize(), twoLevels)
+	for x, want := range []uint8{0, 0xff} {
+		if c := straightAt(got, x, 0); c != (color.NRGBA{R: want, G: want, B: want, A: 0xff}) {
+			t.Errorf("column %d = %v, want %d", x, c, want)
+		}
+	}
+
+	// transparent pixels are left out of the histogram and stay transparent
+	withTransparent := greyColumns(12, 4, func(x int) int {
+		if x >= 8 {
+			return -1
+		}
+		return 100 + 50*(x%2)
+	})
+	got = transformOrFail(t, NewActionEqualize(), withTransparent)
+	for x, want := range map[int]color.NRGBA{0: {A: 0xff}, 1: {R: 0xff, G: 0xff, B: 0xff, A: 0xff}, 8: {}} {
+		if c := straightAt(got, x, 0); c != want {
+			t.Errorf("column %d = %v, want %v", x, c, want)
+		}
+	}
+
+	// a narrow ramp is stretched over the whole range, keeping its order
+	got = transformOrFail(t, NewActionEqualize(), greyColumns(32, 2, func(x int) int { return 100 + x }))
+	if first, last := straightAt(got, 0, 0).R, straightAt(got, 31, 0).R; first != 0 || last != 0xff {
+		t.Errorf("ramp spans %d-%d, want 0-255", first, last)
+	}
+	for x := 1; x < 32; x++ {
+		if straightAt(got, x, 0).R <= straightAt(got, x-1, 0).R {
+			t.Fatalf("column %d is not brighter than column %d", x, x-1)
+		}
+	}
+
+	// a single grey level has nothing to spread
+	flat := greyColumns(4, 4, func(x int) int { return 77 })
+	if c := straightAt(transformOrFail(t, NewActionEqualize(), flat), 0, 0); c.R != 77 {
+		t.Errorf("flat image = %v, want it unchanged", c)
+	}
+}
+
+// equalizing works on luma and shifts every channel by the same amount, so
+// the differences between the channels of a pixel are kept.
+func TestEqualizeKeepsChroma(t *testing.T) {
+	// a grey ramp sets the histogram, and the coloured pixels in its middle
+	// are moved without reaching either end of the range
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 2))
+	for x := 0; x < 64; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(70 + x), G: uint8(70 + x), B: uint8(70 + x), A: 0xff})
+		img.SetNRGBA(x, 1, color.NRGBA{R: uint8(70 + x), G: uint8(70 + x), B: uint8(70 + x), A: 0xff})
+	}
+	img.SetNRGBA(10, 1, color.NRGBA{R: 110, G: 90, B: 70, A: 0xff})
+	img.SetNRGBA(11, 1, color.NRGBA{R: 120, G: 100, B: 80, A: 0x80})
+
+	got := transformOrFail(t, NewActionEqualize(), img)
+	for _, x := range []int{10, 11} {
+		src, c := img.NRGBAAt(x, 1), straightAt(got, x, 1)
+		if c.A != src.A {
+			t.Errorf("pixel %d alpha = %#x, want %#x", x, c.A, src.A)
+		}
+		if c.G == src.G {
+			t.Errorf("pixel %d = %v, want it equalized", x, c)
+		}
+		if d := int(c.R) - int(c.G); d < 18 || d > 22 {
+			t.Errorf("pixel %d = %v, red is %d above green, want about 20", x, c, d)
+		}
+		if d := int(c.G) - int(c.B); d < 18 || d > 22 {
+			t.Errorf("pixel %d = %v, green is %d above blue, want about 20", x, c, d)
+		}
+	}
+}
+
+func TestAutoLevels(t *testing.T) {
+	// a ramp from 50 to 200 with one black and one white outlier column in
+	// every 152
+	ramp := greyColumns(152, 4, func(x int) int {
+		switch x {
+		case 0:
+			return 0
+		case 151:
+			return 0xff
+		}
+		return 50 + x - 1
+	})
+
+	tests := []struct {
+		name string
+		clip float64
+		// want maps source columns to the grey they become
+		want map[int]uint8
+	}{
+		{name: "no clip keeps the outliers", clip: 0, want: map[int]uint8{0: 0, 1: 50, 76: 125, 150: 199, 151: 255}},
+		{name: "clip ignores the outliers", clip: 1, want: map[int]uint8{0: 0, 1: 0, 61: 103, 150: 255, 151: 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transformOrFail(t, NewActionAutoLevels(tt.clip), ramp)
+			for x, want := range tt.want {
+				if c := straightAt(got, x, 0); c.R != want || c.G != want || c.B != want {
+					t.Errorf("column %d = %v, want %d", x, c, want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewActionAutoLevels(50).Transform(ramp); err == nil {
+		t.Error("Transform() accepted a clip of 50%")
+	}
+}
+
+func TestCLAHE(t *testing.T) {
+	// with a single tile and a limit no bin reaches, CLAHE is the global
+	// equalization
+	ramp := greyColumns(32, 32, func(x int) int { return 100 + x })
+	global := toRGBA(transformOrFail(t, NewActionEqualize(), ramp))
+	clahe := toRGBA(transformOrFail(t, NewActionCLAHE(100, 1), ramp))
+	if meanError(global, clahe) != 0 {
+		t.Errorf("one tile CLAHE differs from global equalization, mean error %.2f", meanError(global, clahe))
+	}
+
+	// a low limit holds the contrast back
+	limited := transformOrFail(t, NewActionCLAHE(1.5, 1), ramp)
+	if span := int(straightAt(limited, 31, 0).R) - int(straightAt(limited, 0, 0).R); span > 200 {
+		t.Errorf("limited ramp spans %d levels, want the limit to keep it well below 255", span)
+	}
+
+	// each tile is equalized on its own, so a dark half is stretched further
+	// than it is by the histogram of the whole image
+	halves := greyColumns(64, 16, func(x int) int {
+		if x < 32 {
+			return 20 + x
+		}
+		return 200 + x - 32
+	})
+	global = toRGBA(transformOrFail(t, NewActionEqualize(), halves))
+	clahe = toRGBA(transformOrFail(t, NewActionCLAHE(100, 2), halves))
+	// column 15 lies before the centre of the first tile, so only its table
+	// applies
+	if g, c := global.RGBAAt(15, 0).R, clahe.RGBAAt(15, 0).R; int(c) < int(g)+40 {
+		t.Errorf("column 15 = %d with CLAHE and %d globally, want CLAHE to stretch the dark tile further", c, g)
+	}
+	// neighbouring tiles are blended, so no column jumps between them
+	for x := 1; x < 64; x++ {
+		if d := int(clahe.RGBAAt(x, 8).R) - int(clahe.RGBAAt(x-1, 8).R); d < -24 || d > 24 {
+			t.Errorf("columns %d and %d differ by %d, want a smooth ramp", x-1, x, d)
+		}
+	}
+
+	// a flat image is left close to its grey
+	flat := greyColumns(16, 16, func(x int) int { return 128 })
+	if c := straightAt(transformOrFail(t, NewActionCLAHE(2, 4), flat), 5, 5).R; absDiff(c, 128) > 3 {
+		t.Errorf("flat image = %d, want about 128", c)
+	}
+
+	// more tiles than pixels are reduced to one tile per pixel
+	transformOrFail(t, NewActionCLAHE(2, 64), greyColumns(3, 2, func(x int) int { return x * 100 }))
+
+	if _, err := NewActionCLAHE(0.5, 8).Transform(flat); err == nil {
+		t.Error("Transform() accepted a limit below 1")
+	}
+	if _, err := NewActionCLAHE(2, 0).Transform(flat); err == nil {
+		t.Error("Transform() accepted 0 tiles")
+	}
+}
+
+func TestEqualizeSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    Params
+		wantParam string
+	}{
+		{name: "global"},
+		{name: "auto levels", params: Params{"method": "auto_levels", "clip": 2}},
+		{name: "clahe", params: Params{"method": "clahe", "limit": 3, "tiles": 4}},
+		{name: "unknown method", params: Params{"method": "histogram"}, wantParam: "method"},
+		{name: "clip too large", params: Params{"method": "auto_levels", "clip": 30}, wantParam: "clip"},
+		{name: "limit too small", params: Params{"method": "clahe", "limit": 0.5}, wantParam: "limit"},
+		{name: "too many tiles", params: Params{"method": "clahe", "tiles": 65}, wantParam: "tiles"},
+		{name: "clip of global", params: Params{"clip": 2}, wantParam: "clip"},
+		{name: "tiles of auto levels", params: Params{"method": "auto_levels", "tiles": 2}, wantParam: "tiles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{{Name: "equalize", Params: tt.params}}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
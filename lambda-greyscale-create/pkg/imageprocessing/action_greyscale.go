@@ -1,96 +1,247 @@
 package imageprocessing
 
 import (
+	"context"
 	"fmt"
 	"image"
-	image2 "image"
 	"image/color"
 	_ "image/png"
-	"sync"
 )
 
-type actionGreyScale struct{}
+// GreyMethod selects how the channels of a pixel are combined into its grey
+// value.
+type GreyMethod int
+
+const (
+	// GreyLuminosity weights the channels 0.21, 0.72 and 0.07 by their
+	// perceived luminosity.
+	GreyLuminosity GreyMethod = iota
+	// GreyBT601 uses the luma weights of ITU-R BT.601, as used by jpeg.
+	GreyBT601
+	// GreyBT709 uses the luma weights of ITU-R BT.709, as used by sRGB.
+	GreyBT709
+	// GreyAverage weights the channels equally.
+	GreyAverage
+	// GreyLightness averages the brightest and darkest channel.
+	GreyLightness
+)
+
+var greyMethodNames = map[GreyMethod]string{
+	GreyLuminosity: "luminosity",
+	GreyBT601:      "bt601",
+	GreyBT709:      "bt709",
+	GreyAverage:    "average",
+	GreyLightness:  "lightness",
+}
+
+func (m GreyMethod) String() string {
+	if name, ok := greyMethodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("GreyMethod(%d)", int(m))
+}
+
+// ParseGreyMethod returns the method with the given name.
+func ParseGreyMethod(name string) (GreyMethod, error) {
+	for m, n := range greyMethodNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown greyscale method %q, expected luminosity, bt601, bt709, average or lightness", name)
+}
+
+// greyFunc returns the function computing the grey value of a pixel.
+func (m GreyMethod) greyFunc() (func(r, g, b uint8) uint8, error) {
+	switch m {
+	case GreyLuminosity:
+		return greyValue, nil
+	case GreyBT601:
+		return bt601Luma, nil
+	case GreyBT709:
+		return func(r, g, b uint8) uint8 {
+			return uint8((2126*uint32(r) + 7152*uint32(g) + 722*uint32(b) + 5000) / 10000)
+		}, nil
+	case GreyAverage:
+		return func(r, g, b uint8) uint8 {
+			return uint8((uint32(r) + uint32(g) + uint32(b) + 1) / 3)
+		}, nil
+	case GreyLightness:
+		return func(r, g, b uint8) uint8 {
+			max, min := r, r
+			for _, c := range [2]uint8{g, b} {
+				if c > max {
+					max = c
+				}
+				if c < min {
+					min = c
+				}
+			}
+			return uint8((uint32(max) + uint32(min) + 1) / 2)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown greyscale method %v", m)
+	}
+}
+
+type actionGreyScale struct {
+	method GreyMethod
+}
 
 var _ ImageAction = actionGreyScale{}
 
+func init() {
+	RegisterAction("greyscale", func(params *ParamReader) (ImageAction, error) {
+		method := readGreyMethod(params, "method", GreyLuminosity)
+		return NewActionGreyScaleMethod(method), params.Err()
+	})
+}
+
 func NewActionGreyScale() ImageAction {
 	return &actionGreyScale{}
 }
 
-func (a actionGreyScale) Transform(image image.Image) (image.Image, error) {
-	// convert image to 3 dimensional array
-	size:= image.Bounds().Size()
-	var pixels [][]color.Color
-	//put pixels into two three two dimensional array
-	for i:=0; i<size.X;i++{
-		var y []color.Color
-		for j:=0; j<size.Y;j++{
-			y = append(y,image.At(i,j))
-		}
-		pixels = append(pixels,y)
+// NewActionGreyScaleMethod returns an action converting images to greyscale
+// with the given method.
+func NewActionGreyScaleMethod(method GreyMethod) ImageAction {
+	return &actionGreyScale{method: method}
+}
+
+func readGreyMethod(params *ParamReader, name string, def GreyMethod) GreyMethod {
+	method, err := ParseGreyMethod(params.String(name, def.String()))
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return def
 	}
+	return method
+}
 
-	// convert pixels to greyScale
-	convertedPixels := greyScale(pixels)
+// Transform converts the image to greyscale using the action's method. The
+// common decoder outputs are read straight from their pixel buffers, anything
+// else falls back to the generic color.Color path.
+func (a actionGreyScale) Transform(src image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), src)
+}
 
-	// convert RGBA back to image
-	rect := image2.Rect(0,0,len(convertedPixels),len(convertedPixels[0]))
-	nImg := image2.NewRGBA(rect)
+func (a actionGreyScale) TransformContext(ctx context.Context, src image.Image) (image.Image, error) {
+	grey, err := a.method.greyFunc()
+	if err != nil {
+		return nil, err
+	}
 
-	for x:=0; x<len(convertedPixels);x++{
-		for y:=0; y<len(convertedPixels[0]);y++ {
-			q:=convertedPixels[x]
-			if q==nil{
-				continue
-			}
-			p := convertedPixels[x][y]
-			if p==nil{
-				continue
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	switch img := src.(type) {
+	case *image.RGBA:
+		greyScaleRGBA(ctx, dst, img, grey)
+	case *image.NRGBA:
+		greyScaleNRGBA(ctx, dst, img, grey)
+	case *image.YCbCr:
+		greyScaleYCbCr(ctx, dst, img, grey)
+	case *image.Gray:
+		greyScaleGray(ctx, dst, img, grey)
+	default:
+		greyScaleGeneric(ctx, dst, src, grey)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// bt601Luma is the BT.601 luma of a pixel, the Y of its YCbCr form.
+func bt601Luma(r, g, b uint8) uint8 {
+	return uint8((299*uint32(r) + 587*uint32(g) + 114*uint32(b) + 500) / 1000)
+}
+
+// greyValue weights each channel by its perceived luminosity.
+func greyValue(r, g, b uint8) uint8 {
+	return uint8(float64(r)*0.21 + float64(g)*0.72 + float64(b)*0.07)
+}
+
+func setGrey(dst []uint8, grey func(r, g, b uint8) uint8, r, g, b, a uint8) {
+	v := grey(r, g, b)
+	dst[0] = v
+	dst[1] = v
+	dst[2] = v
+	dst[3] = a
+}
+
+func greyScaleRGBA(ctx context.Context, dst, src *image.RGBA, grey func(r, g, b uint8) uint8) {
+	b := src.Bounds()
+	parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride:]
+			for i := 0; i < b.Dx()*4; i += 4 {
+				setGrey(d[i:i+4], grey, s[i], s[i+1], s[i+2], s[i+3])
 			}
-			original,ok := color.RGBAModel.Convert(p).(color.RGBA)
-			if ok{
-				nImg.Set(x,y,original)
+		}
+	})
+}
+
+func greyScaleNRGBA(ctx context.Context, dst *image.RGBA, src *image.NRGBA, grey func(r, g, b uint8) uint8) {
+	b := src.Bounds()
+	parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride:]
+			for i := 0; i < b.Dx()*4; i += 4 {
+				a := s[i+3]
+				setGrey(d[i:i+4], grey, premultiply(s[i], a), premultiply(s[i+1], a), premultiply(s[i+2], a), a)
 			}
 		}
-	}
-	converted := nImg.SubImage(rect)
-	return converted, nil
+	})
 }
 
-func greyScale(pixels [][]color.Color) [][]color.Color{
-	xLen := len(pixels)
-	yLen := len(pixels[0])
+// premultiply matches the rounding of color.NRGBA.RGBA followed by
+// color.RGBAModel, so the fast path is byte-for-byte identical to it.
+func premultiply(c, a uint8) uint8 {
+	v := uint32(c)
+	v |= v << 8
+	v *= uint32(a)
+	v /= 0xff
+	return uint8(v >> 8)
+}
 
-	//create new image
-	newImage:=make([][]color.Color, xLen)
-	for i:=0;i<len(newImage);i++{
-		newImage[i] = make([]color.Color,yLen)
-	}
+func greyScaleYCbCr(ctx context.Context, dst *image.RGBA, src *image.YCbCr, grey func(r, g, b uint8) uint8) {
+	b := src.Bounds()
+	parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				yi := src.YOffset(b.Min.X+x, b.Min.Y+y)
+				ci := src.COffset(b.Min.X+x, b.Min.Y+y)
+				r, g, bl, _ := color.YCbCr{Y: src.Y[yi], Cb: src.Cb[ci], Cr: src.Cr[ci]}.RGBA()
+				setGrey(d[x*4:x*4+4], grey, uint8(r>>8), uint8(g>>8), uint8(bl>>8), 0xff)
+			}
+		}
+	})
+}
 
-	//idea is processing pixels in parallel
-	wg := sync.WaitGroup{}
-	for x:=0;x<xLen;x++{
-		for y:=0;y<yLen; y++{
-			wg.Add(1)
-			go func(x,y int) {
-				pixel :=pixels[x][y]
-				originalColor, ok := color.RGBAModel.Convert(pixel).(color.RGBA)
-				if !ok{
-					fmt.Println("snap something went wrong")
-				}
-				grey := uint8(float64(originalColor.R)*0.21 + float64(originalColor.G)*0.72 + float64(originalColor.B)*0.07)
-				col :=color.RGBA{
-					R: grey,
-					G: grey,
-					B: grey,
-					A: originalColor.A,
-				}
-				newImage[x][y] = col
-				wg.Done()
-			}(x,y)
+func greyScaleGray(ctx context.Context, dst *image.RGBA, src *image.Gray, grey func(r, g, b uint8) uint8) {
+	b := src.Bounds()
+	parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				setGrey(d[x*4:x*4+4], grey, s[x], s[x], s[x], 0xff)
+			}
+		}
+	})
+}
 
+func greyScaleGeneric(ctx context.Context, dst *image.RGBA, src image.Image, grey func(r, g, b uint8) uint8) {
+	b := src.Bounds()
+	parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				c := color.RGBAModel.Convert(src.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+				setGrey(d[x*4:x*4+4], grey, c.R, c.G, c.B, c.A)
+			}
 		}
-	}
-	wg.Wait()
-	return newImage
-}
\ No newline at end of file
+	})
+}
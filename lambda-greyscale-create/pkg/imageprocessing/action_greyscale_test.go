@@ -0,0 +1,167 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// legacyGreyScale is the per pixel greyscale the pixel buffer paths
+// replaced, kept to check they still give the same output.
+func legacyGreyScale(src image.Image) *image.RGBA {
+	size := src.Bounds().Size()
+	pixels := make([][]color.Color, size.X)
+	for x := range pixels {
+		pixels[x] = make([]color.Color, size.Y)
+		for y := range pixels[x] {
+			pixels[x][y] = src.At(x, y)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for x := 0; x < size.X; x++ {
+		for y := 0; y < size.Y; y++ {
+			wg.Add(1)
+			go func(x, y int) {
+				defer wg.Done()
+				c := color.RGBAModel.Convert(pixels[x][y]).(color.RGBA)
+				grey := uint8(float64(c.R)*0.21 + float64(c.G)*0.72 + float64(c.B)*0.07)
+				pixels[x][y] = color.RGBA{R: grey, G: grey, B: grey, A: c.A}
+			}(x, y)
+		}
+	}
+	wg.Wait()
+
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for x := 0; x < size.X; x++ {
+		for y := 0; y < size.Y; y++ {
+			dst.Set(x, y, pixels[x][y])
+		}
+	}
+	return dst
+}
+
+var ycbcrRatios = []image.YCbCrSubsampleRatio{
+	image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio422,
+	image.YCbCrSubsampleRatio420,
+	image.YCbCrSubsampleRatio440,
+	image.YCbCrSubsampleRatio411,
+	image.YCbCrSubsampleRatio410,
+}
+
+// greyscaleSources returns an image of every kind the greyscale action has a
+// path for, filled with random pixels.
+func greyscaleSources(w, h int) map[string]image.Image {
+	rnd := rand.New(rand.NewSource(1))
+	fill := func(pix []uint8) {
+		rnd.Read(pix)
+	}
+	r := image.Rect(0, 0, w, h)
+
+	sources := map[string]image.Image{}
+	rgba := image.NewRGBA(r)
+	fill(rgba.Pix)
+	// keep the colours premultiplied so they stay valid
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			if rgba.Pix[i+c] > rgba.Pix[i+3] {
+				rgba.Pix[i+c] = rgba.Pix[i+3]
+			}
+		}
+	}
+	sources["rgba"] = rgba
+
+	nrgba := image.NewNRGBA(r)
+	fill(nrgba.Pix)
+	sources["nrgba"] = nrgba
+
+	for _, ratio := range ycbcrRatios {
+		ycbcr := image.NewYCbCr(r, ratio)
+		fill(ycbcr.Y)
+		fill(ycbcr.Cb)
+		fill(ycbcr.Cr)
+		sources[fmt.Sprintf("ycbcr-%v", ratio)] = ycbcr
+	}
+
+	gray := image.NewGray(r)
+	fill(gray.Pix)
+	sources["gray"] = gray
+
+	paletted := image.NewPaletted(r, palette.Plan9)
+	fill(paletted.Pix)
+	sources["generic"] = paletted
+	return sources
+}
+
+func TestGreyScaleMatchesLegacy(t *testing.T) {
+	// odd sizes leave partial chroma blocks and uneven row bands
+	for name, src := range greyscaleSources(67, 41) {
+		t.Run(name, func(t *testing.T) {
+			got, err := NewActionGreyScale().Transform(src)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			want := legacyGreyScale(src)
+			gotRGBA, ok := got.(*image.RGBA)
+			if !ok {
+				t.Fatalf("Transform() returned %T, want *image.RGBA", got)
+			}
+			if gotRGBA.Rect != want.Rect {
+				t.Fatalf("Transform() bounds = %v, want %v", gotRGBA.Rect, want.Rect)
+			}
+			if !bytes.Equal(gotRGBA.Pix, want.Pix) {
+				t.Errorf("Transform() pixels differ from the per pixel implementation")
+			}
+		})
+	}
+}
+
+func TestGreyScaleSubImage(t *testing.T) {
+	src := greyscaleSources(64, 64)["nrgba"].(*image.NRGBA)
+	sub := src.SubImage(image.Rect(10, 20, 40, 50))
+
+	got, err := NewActionGreyScale().Transform(sub)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if got.Bounds() != image.Rect(0, 0, 30, 30) {
+		t.Fatalf("Transform() bounds = %v, want %v", got.Bounds(), image.Rect(0, 0, 30, 30))
+	}
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 30; x++ {
+			c := color.RGBAModel.Convert(sub.At(10+x, 20+y)).(color.RGBA)
+			v := greyValue(c.R, c.G, c.B)
+			if want := (color.RGBA{R: v, G: v, B: v, A: c.A}); got.At(x, y) != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got.At(x, y), want)
+			}
+		}
+	}
+}
+
+func BenchmarkGreyscale(b *testing.B) {
+	sources := greyscaleSources(1024, 768)
+	for _, name := range []string{"rgba", "nrgba", "ycbcr-YCbCrSubsampleRatio420", "gray", "generic"} {
+		src := sources[name]
+		b.Run(name, func(b *testing.B) {
+			action := NewActionGreyScale()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := action.Transform(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/legacy", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				legacyGreyScale(src)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package imageprocessing
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// FlipDirection is the axis an image is mirrored along.
+type FlipDirection int
+
+const (
+	// FlipHorizontal mirrors the image left to right.
+	FlipHorizontal FlipDirection = iota
+	// FlipVertical mirrors the image top to bottom.
+	FlipVertical
+)
+
+var flipDirectionNames = map[FlipDirection]string{
+	FlipHorizontal: "horizontal",
+	FlipVertical:   "vertical",
+}
+
+func (d FlipDirection) String() string {
+	if name, ok := flipDirectionNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("FlipDirection(%d)", int(d))
+}
+
+// ParseFlipDirection returns the direction with the given name.
+func ParseFlipDirection(name string) (FlipDirection, error) {
+	for d, n := range flipDirectionNames {
+		if n == name {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown flip direction %q, expected horizontal or vertical", name)
+}
+
+type actionOrient struct {
+	orientation Orientation
+}
+
+var _ ImageAction = actionOrient{}
+
+func init() {
+	RegisterAction("rotate", newActionRotateFromParams)
+	RegisterAction("flip", newActionFlipFromParams)
+}
+
+// NewActionRotate returns an action rotating images clockwise by degrees,
+// which must be a multiple of 90.
+func NewActionRotate(degrees int) (ImageAction, error) {
+	switch ((degrees % 360) + 360) % 360 {
+	case 0:
+		return &actionOrient{orientation: OrientationNormal}, nil
+	case 90:
+		return &actionOrient{orientation: OrientationRotate90}, nil
+	case 180:
+		return &actionOrient{orientation: OrientationRotate180}, nil
+	case 270:
+		return &actionOrient{orientation: OrientationRotate270}, nil
+	default:
+		return nil, fmt.Errorf("can not rotate by %d degrees, expected a multiple of 90", degrees)
+	}
+}
+
+// NewActionFlip returns an action mirroring images in the given direction.
+func NewActionFlip(direction FlipDirection) ImageAction {
+	if direction == FlipVertical {
+		return &actionOrient{orientation: OrientationFlipVertical}
+	}
+	return &actionOrient{orientation: OrientationFlipHorizontal}
+}
+
+// NewActionOrient returns an action displaying images stored with the given
+// EXIF orientation upright.
+func NewActionOrient(orientation Orientation) ImageAction {
+	return &actionOrient{orientation: orientation}
+}
+
+func newActionRotateFromParams(params *ParamReader) (ImageAction, error) {
+	degrees := params.RequiredInt("degrees")
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+	action, err := NewActionRotate(degrees)
+	if err != nil {
+		params.Fail("degrees", "%v", err)
+		return nil, params.Err()
+	}
+	return action, nil
+}
+
+func newActionFlipFromParams(params *ParamReader) (ImageAction, error) {
+	direction, err := ParseFlipDirection(params.String("direction", FlipHorizontal.String()))
+	if err != nil {
+		params.Fail("direction", "%v", err)
+	}
+	return NewActionFlip(direction), params.Err()
+}
+
+func (a actionOrient) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionOrient) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	if a.orientation < OrientationNormal || a.orientation > OrientationRotate270 {
+		return nil, fmt.Errorf("invalid orientation %d", a.orientation)
+	}
+	return AutoOrientContext(ctx, img, a.orientation)
+}
+
+// AutoOrient returns img transformed so that an image stored with the given
+// EXIF orientation is upright, covering the mirrored orientations as well as
+// the rotations. Images that are already upright are returned as they are.
+func AutoOrient(img image.Image, orientation Orientation) image.Image {
+	return autoOrient(context.Background(), img, orientation)
+}
+
+// AutoOrientContext is AutoOrient returning ctx.Err() once ctx is done.
+func AutoOrientContext(ctx context.Context, img image.Image, orientation Orientation) (image.Image, error) {
+	oriented := autoOrient(ctx, img, orientation)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return oriented, nil
+}
+
+func autoOrient(ctx context.Context, img image.Image, orientation Orientation) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate270 {
+		return img
+	}
+
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dstW, dstH := w, h
+	if orientation >= OrientationTranspose {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	// sourceAt maps a destination pixel to the source pixel displayed there
+	var sourceAt func(x, y int) (int, int)
+	switch orientation {
+	case OrientationFlipHorizontal:
+		sourceAt = func(x, y int) (int, int) { return w - 1 - x, y }
+	case OrientationRotate180:
+		sourceAt = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case OrientationFlipVertical:
+		sourceAt = func(x, y int) (int, int) { return x, h - 1 - y }
+	case OrientationTranspose:
+		sourceAt = func(x, y int) (int, int) { return y, x }
+	case OrientationRotate90:
+		sourceAt = func(x, y int) (int, int) { return y, h - 1 - x }
+	case OrientationTransverse:
+		sourceAt = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case OrientationRotate270:
+		sourceAt = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	parallelRows(ctx, dstH, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := dst.Pix[y*dst.Stride:]
+			for x := 0; x < dstW; x++ {
+				sx, sy := sourceAt(x, y)
+				copy(row[x*4:x*4+4], src.Pix[sy*src.Stride+sx*4:])
+			}
+		}
+	})
+	return dst
+}
@@ -0,0 +1,240 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// labelled returns an image of the rows of labels, one pixel per letter, the
+// letter being stored in the red channel.
+func labelled(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, label := range row {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(label), A: 0xff})
+		}
+	}
+	return img
+}
+
+// labels returns the rows of labels of an image made by labelled.
+func labels(img image.Image) []string {
+	b := img.Bounds()
+	rows := make([]string, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row.WriteByte(byte(r >> 8))
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+func TestAutoOrient(t *testing.T) {
+	// the stored image is
+	//   abc
+	//   def
+	// and want is how each orientation displays it
+	tests := []struct {
+		orientation Orientation
+		want        []string
+	}{
+		{orientation: OrientationNormal, want: []string{"abc", "def"}},
+		{orientation: OrientationFlipHorizontal, want: []string{"cba", "fed"}},
+		{orientation: OrientationRotate180, want: []string{"fed", "cba"}},
+		{orientation: OrientationFlipVertical, want: []string{"def", "abc"}},
+		{orientation: OrientationTranspose, want: []string{"ad", "be", "cf"}},
+		{orientation: OrientationRotate90, want: []string{"da", "eb", "fc"}},
+		{orientation: OrientationTransverse, want: []string{"fc", "eb", "da"}},
+		{orientation: OrientationRotate270, want: []string{"cf", "be", "ad"}},
+		// out of range orientations are left as they are
+		{orientation: 0, want: []string{"abc", "def"}},
+		{orientation: 9, want: []string{"abc", "def"}},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.orientation)), func(t *testing.T) {
+			got := labels(AutoOrient(labelled("abc", "def"), tt.orientation))
+			if strings.Join(got, "/") != strings.Join(tt.want, "/") {
+				t.Errorf("AutoOrient(%d) = %v, want %v", tt.orientation, got, tt.want)
+			}
+
+			// a sub image is oriented from its own origin
+			sub := labelled("xxxx", "xabc", "xdef").SubImage(image.Rect(1, 1, 4, 3))
+			if got := labels(AutoOrient(sub, tt.orientation)); strings.Join(got, "/") != strings.Join(tt.want, "/") {
+				t.Errorf("AutoOrient(%d) of a sub image = %v, want %v", tt.orientation, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotateAndFlip(t *testing.T) {
+	rotate := func(degrees int) ImageAction {
+		action, err := NewActionRotate(degrees)
+		if err != nil {
+			t.Fatalf("NewActionRotate(%d) error = %v", degrees, err)
+		}
+		return action
+	}
+
+	tests := []struct {
+		name   string
+		action ImageAction
+		want   []string
+	}{
+		{name: "rotate 0", action: rotate(0), want: []string{"abc", "def"}},
+		{name: "rotate 90", action: rotate(90), want: []string{"da", "eb", "fc"}},
+		{name: "rotate 180", action: rotate(180), want: []string{"fed", "cba"}},
+		{name: "rotate 270", action: rotate(270), want: []string{"cf", "be", "ad"}},
+		{name: "rotate -90", action: rotate(-90), want: []string{"cf", "be", "ad"}},
+		{name: "rotate 450", action: rotate(450), want: []string{"da", "eb", "fc"}},
+		{name: "flip horizontal", action: NewActionFlip(FlipHorizontal), want: []string{"cba", "fed"}},
+		{name: "flip vertical", action: NewActionFlip(FlipVertical), want: []string{"def", "abc"}},
+		{name: "orient", action: NewActionOrient(OrientationTransverse), want: []string{"fc", "eb", "da"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.action.Transform(labelled("abc", "def"))
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if rows := labels(got); strings.Join(rows, "/") != strings.Join(tt.want, "/") {
+				t.Errorf("Transform() = %v, want %v", rows, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewActionRotate(45); err == nil {
+		t.Error("NewActionRotate(45) accepted an angle that is not a multiple of 90")
+	}
+	if _, err := NewActionOrient(9).Transform(labelled("abc")); err == nil {
+		t.Error("Transform() accepted orientation 9")
+	}
+}
+
+func TestOrientSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    ActionSpec
+		wantParam string
+	}{
+		{name: "rotate", action: ActionSpec{Name: "rotate", Params: Params{"degrees": 270}}},
+		{name: "rotate without degrees", action: ActionSpec{Name: "rotate"}, wantParam: "degrees"},
+		{name: "rotate by 45", action: ActionSpec{Name: "rotate", Params: Params{"degrees": 45}}, wantParam: "degrees"},
+		{name: "flip", action: ActionSpec{Name: "flip"}},
+		{name: "flip vertical", action: ActionSpec{Name: "flip", Params: Params{"direction": "vertical"}}},
+		{name: "flip diagonal", action: ActionSpec{Name: "flip", Params: Params{"direction": "diagonal"}}, wantParam: "direction"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{tt.action}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
+
+// exifTIFF returns a TIFF structure in the given byte order whose first IFD
+// holds an orientation entry of the given type and value.
+func exifTIFF(order binary.ByteOrder, typ uint16, value uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	entry := tiff[10:]
+	order.PutUint16(entry, exifTagOrientation)
+	order.PutUint16(entry[2:], typ)
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], value)
+	return tiff
+}
+
+// withExif returns a jpeg holding tiff in an EXIF APP1 segment, after a
+// JFIF APP0 segment as cameras write them.
+func withExif(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, labelled("abc", "def"), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	app0 := []byte{0xff, 0xe0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0}
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	app1 := []byte{0xff, jpegAPP1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app0...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestReadOrientation(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, labelled("abc")); err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, labelled("abc"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		name    string
+		data    []byte
+		want    Orientation
+		wantErr bool
+	}
+	tests := []test{
+		{name: "png", data: pngData.Bytes(), want: OrientationNormal},
+		{name: "jpeg without exif", data: plain.Bytes(), want: OrientationNormal},
+		{name: "out of range", data: withExif(t, exifTIFF(binary.BigEndian, exifTypeShort, 9)), want: OrientationNormal},
+		// an orientation that is not a single short is ignored, like any other
+		// tag of the wrong type
+		{name: "not a short", data: withExif(t, exifTIFF(binary.BigEndian, exifTypeLong, 6)), want: OrientationNormal},
+		{name: "bad byte order", data: withExif(t, append([]byte("XX"), exifTIFF(binary.BigEndian, exifTypeShort, 6)[2:]...)), wantErr: true},
+		{name: "truncated ifd", data: withExif(t, exifTIFF(binary.LittleEndian, exifTypeShort, 6)[:16]), wantErr: true},
+		{name: "truncated segment", data: withExif(t, exifTIFF(binary.LittleEndian, exifTypeShort, 6))[:30], wantErr: true},
+	}
+	for o := OrientationNormal; o <= OrientationRotate270; o++ {
+		tests = append(tests,
+			test{name: "big endian " + strconv.Itoa(int(o)), data: withExif(t, exifTIFF(binary.BigEndian, exifTypeShort, uint16(o))), want: o},
+			test{name: "little endian " + strconv.Itoa(int(o)), data: withExif(t, exifTIFF(binary.LittleEndian, exifTypeShort, uint16(o))), want: o},
+		)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadOrientation(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadOrientation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ReadOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
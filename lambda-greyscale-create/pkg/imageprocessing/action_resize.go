@@ -0,0 +1,225 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ResizeMode controls how the source aspect ratio is treated when resizing.
+type ResizeMode int
+
+const (
+	// ResizeFit scales the image down to fit within the target box,
+	// preserving its aspect ratio. Images already inside the box are left
+	// untouched.
+	ResizeFit ResizeMode = iota
+	// ResizeFill scales the image to cover the target box and crops the
+	// overflow equally from both sides.
+	ResizeFill
+	// ResizeExact scales the image to the target size, distorting it if the
+	// aspect ratios differ.
+	ResizeExact
+)
+
+var resizeModeNames = map[ResizeMode]string{
+	ResizeFit:   "fit",
+	ResizeFill:  "fill",
+	ResizeExact: "exact",
+}
+
+func (m ResizeMode) String() string {
+	if name, ok := resizeModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("ResizeMode(%d)", int(m))
+}
+
+// ParseResizeMode returns the mode with the given name.
+func ParseResizeMode(name string) (ResizeMode, error) {
+	for m, n := range resizeModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown resize mode %q, expected fit, fill or exact", name)
+}
+
+// maxResizeDimension bounds the sizes an image may be resized to, and so
+// the sizes a spec may ask for.
+const maxResizeDimension = 16384
+
+type actionResize struct {
+	width  int
+	height int
+	mode   ResizeMode
+	filter ResampleFilter
+}
+
+var _ ImageAction = actionResize{}
+
+func init() {
+	RegisterAction("resize", newActionResizeFromParams)
+	RegisterAction("thumbnail", newActionThumbnailFromParams)
+}
+
+// NewActionResize returns an action scaling images to width x height. For
+// ResizeFit and ResizeExact either dimension may be 0, in which case it is
+// derived from the other using the source aspect ratio.
+func NewActionResize(width, height int, mode ResizeMode, filter ResampleFilter) ImageAction {
+	return &actionResize{
+		width:  width,
+		height: height,
+		mode:   mode,
+		filter: filter,
+	}
+}
+
+// NewActionThumbnail returns an action producing a width x height thumbnail
+// cropped from the centre of the image.
+func NewActionThumbnail(width, height int) ImageAction {
+	return NewActionResize(width, height, ResizeFill, FilterLanczos)
+}
+
+func newActionResizeFromParams(params *ParamReader) (ImageAction, error) {
+	width := params.IntRange("width", 0, 0, maxResizeDimension)
+	height := params.IntRange("height", 0, 0, maxResizeDimension)
+	mode := readResizeMode(params, "mode", ResizeFit)
+	filter := readResampleFilter(params, "filter", FilterCatmullRom)
+	if width == 0 && height == 0 {
+		params.Fail("width", "a width or a height is required")
+	}
+	if mode == ResizeFill && (width == 0 || height == 0) {
+		params.Fail("mode", "fill requires both a width and a height")
+	}
+	return NewActionResize(width, height, mode, filter), params.Err()
+}
+
+func newActionThumbnailFromParams(params *ParamReader) (ImageAction, error) {
+	width := params.RequiredIntRange("width", 1, maxResizeDimension)
+	height := params.RequiredIntRange("height", 1, maxResizeDimension)
+	filter := readResampleFilter(params, "filter", FilterLanczos)
+	return NewActionResize(width, height, ResizeFill, filter), params.Err()
+}
+
+func readResizeMode(params *ParamReader, name string, def ResizeMode) ResizeMode {
+	mode, err := ParseResizeMode(params.String(name, def.String()))
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return def
+	}
+	return mode
+}
+
+func readResampleFilter(params *ParamReader, name string, def ResampleFilter) ResampleFilter {
+	filter, err := ParseResampleFilter(params.String(name, def.String()))
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return def
+	}
+	return filter
+}
+
+func (a actionResize) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionResize) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	if a.width < 0 || a.height < 0 {
+		return nil, fmt.Errorf("invalid resize dimensions %dx%d", a.width, a.height)
+	}
+	if a.width == 0 && a.height == 0 {
+		return nil, errors.New("resize requires a width or a height")
+	}
+
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	if srcW == 0 || srcH == 0 {
+		return img, nil
+	}
+
+	src := toRGBA(img)
+	switch a.mode {
+	case ResizeFit:
+		w, h := fitSize(srcW, srcH, a.width, a.height)
+		if w >= srcW && h >= srcH {
+			return src, nil
+		}
+		return resample(ctx, src, w, h, a.filter)
+	case ResizeFill:
+		if a.width == 0 || a.height == 0 {
+			return nil, errors.New("fill resize requires both a width and a height")
+		}
+		if err := checkResizeSize(a.width, a.height); err != nil {
+			return nil, err
+		}
+		crop := fillCrop(srcW, srcH, a.width, a.height)
+		return resample(ctx, src.SubImage(crop).(*image.RGBA), a.width, a.height, a.filter)
+	case ResizeExact:
+		w, h := a.width, a.height
+		if w == 0 {
+			w = scaleDimension(srcW, h, srcH)
+		}
+		if h == 0 {
+			h = scaleDimension(srcH, w, srcW)
+		}
+		// the side derived from a very narrow or short source can be far
+		// larger than the one asked for
+		if err := checkResizeSize(w, h); err != nil {
+			return nil, err
+		}
+		return resample(ctx, src, w, h, a.filter)
+	default:
+		return nil, fmt.Errorf("unknown resize mode %v", a.mode)
+	}
+}
+
+// checkResizeSize returns an error when w x h is larger than an image may be
+// resized to.
+func checkResizeSize(w, h int) error {
+	if w > maxResizeDimension || h > maxResizeDimension {
+		return fmt.Errorf("resize to %dx%d exceeds the maximum of %d pixels a side", w, h, maxResizeDimension)
+	}
+	return nil
+}
+
+// fitSize returns the largest size with the source aspect ratio that fits
+// within maxW x maxH, where a zero bound is unconstrained.
+func fitSize(srcW, srcH, maxW, maxH int) (int, int) {
+	if maxW == 0 {
+		return scaleDimension(srcW, maxH, srcH), maxH
+	}
+	if maxH == 0 {
+		return maxW, scaleDimension(srcH, maxW, srcW)
+	}
+	// compare srcW/srcH with maxW/maxH without dividing
+	if srcW*maxH > maxW*srcH {
+		return maxW, scaleDimension(srcH, maxW, srcW)
+	}
+	return scaleDimension(srcW, maxH, srcH), maxH
+}
+
+// fillCrop returns the centred region of a srcW x srcH image that has the
+// aspect ratio of w x h.
+func fillCrop(srcW, srcH, w, h int) image.Rectangle {
+	cropW, cropH := srcW, srcH
+	if srcW*h > w*srcH {
+		cropW = scaleDimension(w, srcH, h)
+	} else {
+		cropH = scaleDimension(h, srcW, w)
+	}
+	x0 := (srcW - cropW) / 2
+	y0 := (srcH - cropH) / 2
+	return image.Rect(x0, y0, x0+cropW, y0+cropH)
+}
+
+// scaleDimension returns v * num / den rounded to the nearest pixel, never
+// less than one.
+func scaleDimension(v, num, den int) int {
+	s := (v*num + den/2) / den
+	if s < 1 {
+		return 1
+	}
+	return s
+}
@@ -0,0 +1,152 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// resizeSource returns a 48x32 image with gradients, hard edges and a
+// transparent corner, which every filter treats differently.
+func resizeSource() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			c := color.NRGBA{R: uint8(x * 255 / 47), G: uint8(y * 255 / 31), B: 0x80, A: 0xff}
+			if (x/6+y/6)%2 == 0 {
+				c.B = 0x10
+			}
+			if x >= 40 && y < 8 {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// checkGolden compares img with the png in testdata/name, or writes it there
+// when the tests are run with -update.
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encoding %s : %v", name, err)
+	}
+	if *update {
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("writing %s : %v", path, err)
+		}
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s, run the tests with -update to create it : %v", path, err)
+	}
+	want, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding %s : %v", path, err)
+	}
+	if want.Bounds() != img.Bounds() {
+		t.Fatalf("bounds = %v, want %v from %s", img.Bounds(), want.Bounds(), path)
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			got, exp := color.NRGBAModel.Convert(img.At(x, y)), color.NRGBAModel.Convert(want.At(x, y))
+			if got != exp {
+				t.Fatalf("pixel (%d, %d) = %v, want %v from %s", x, y, got, exp, path)
+			}
+		}
+	}
+}
+
+func TestResizeGolden(t *testing.T) {
+	modes := []struct {
+		mode          ResizeMode
+		width, height int
+		want          image.Rectangle
+	}{
+		{mode: ResizeFit, width: 20, height: 20, want: image.Rect(0, 0, 20, 13)},
+		{mode: ResizeFill, width: 20, height: 20, want: image.Rect(0, 0, 20, 20)},
+		{mode: ResizeExact, width: 64, height: 12, want: image.Rect(0, 0, 64, 12)},
+	}
+	filters := []ResampleFilter{FilterNearest, FilterBilinear, FilterCatmullRom, FilterLanczos}
+
+	src := resizeSource()
+	for _, m := range modes {
+		for _, filter := range filters {
+			name := fmt.Sprintf("%v-%v", m.mode, filter)
+			t.Run(name, func(t *testing.T) {
+				got, err := NewActionResize(m.width, m.height, m.mode, filter).Transform(src)
+				if err != nil {
+					t.Fatalf("Transform() error = %v", err)
+				}
+				if got.Bounds() != m.want {
+					t.Fatalf("Transform() bounds = %v, want %v", got.Bounds(), m.want)
+				}
+				checkGolden(t, filepath.Join("resize", name+".png"), got)
+			})
+		}
+	}
+}
+
+func TestResizeSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           image.Rectangle
+		mode          ResizeMode
+		width, height int
+		want          image.Rectangle
+		wantErr       string
+	}{
+		{name: "fit width only", src: image.Rect(0, 0, 400, 200), mode: ResizeFit, width: 100, want: image.Rect(0, 0, 100, 50)},
+		{name: "fit height only", src: image.Rect(0, 0, 400, 200), mode: ResizeFit, height: 100, want: image.Rect(0, 0, 200, 100)},
+		{name: "fit tall into box", src: image.Rect(0, 0, 200, 400), mode: ResizeFit, width: 100, height: 100, want: image.Rect(0, 0, 50, 100)},
+		{name: "fit never enlarges", src: image.Rect(0, 0, 40, 20), mode: ResizeFit, width: 100, height: 100, want: image.Rect(0, 0, 40, 20)},
+		{name: "fill", src: image.Rect(0, 0, 400, 200), mode: ResizeFill, width: 50, height: 80, want: image.Rect(0, 0, 50, 80)},
+		{name: "fill without height", src: image.Rect(0, 0, 400, 200), mode: ResizeFill, width: 50, wantErr: "fill resize requires both"},
+		{name: "exact enlarges", src: image.Rect(0, 0, 40, 20), mode: ResizeExact, width: 100, height: 10, want: image.Rect(0, 0, 100, 10)},
+		{name: "exact width only", src: image.Rect(0, 0, 400, 200), mode: ResizeExact, width: 100, want: image.Rect(0, 0, 100, 50)},
+		{name: "exact derived width too large", src: image.Rect(0, 0, 10000, 1), mode: ResizeExact, height: 10000, wantErr: "exceeds the maximum"},
+		{name: "exact derived height too large", src: image.Rect(0, 0, 1, 10000), mode: ResizeExact, width: 10000, wantErr: "exceeds the maximum"},
+		{name: "no size", src: image.Rect(0, 0, 40, 20), mode: ResizeExact, wantErr: "requires a width or a height"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewActionResize(tt.width, tt.height, tt.mode, FilterBilinear).Transform(image.NewRGBA(tt.src))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Transform() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if got.Bounds() != tt.want {
+				t.Errorf("Transform() bounds = %v, want %v", got.Bounds(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResizeSpecLimits(t *testing.T) {
+	_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{
+		{Name: "resize", Params: map[string]interface{}{"width": maxResizeDimension + 1}},
+	}})
+	if err == nil {
+		t.Fatal("NewPipelineFromSpec() accepted a width over the maximum")
+	}
+}
@@ -0,0 +1,317 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// SmartCropMethod selects how the detail of a region is measured.
+type SmartCropMethod int
+
+const (
+	// SmartCropEdges scores regions by their edge energy, the sum of the
+	// luminance gradient magnitudes.
+	SmartCropEdges SmartCropMethod = iota
+	// SmartCropEntropy scores regions by the entropy of their luminance
+	// histograms, favouring texture over single strong edges.
+	SmartCropEntropy
+)
+
+var smartCropMethodNames = map[SmartCropMethod]string{
+	SmartCropEdges:   "edges",
+	SmartCropEntropy: "entropy",
+}
+
+func (m SmartCropMethod) String() string {
+	if name, ok := smartCropMethodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SmartCropMethod(%d)", int(m))
+}
+
+// ParseSmartCropMethod returns the method with the given name.
+func ParseSmartCropMethod(name string) (SmartCropMethod, error) {
+	for m, n := range smartCropMethodNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown smart crop method %q, expected edges or entropy", name)
+}
+
+const (
+	// smartCropAnalysisSize bounds the longer side of the luminance map the
+	// crop is chosen on, the result is scaled back to the source.
+	smartCropAnalysisSize = 256
+	// smartCropCellSize is the side of the cells the entropy is measured
+	// over, in analysis pixels.
+	smartCropCellSize = 8
+	// smartCropLevels is the number of luminance levels in an entropy
+	// histogram.
+	smartCropLevels = 16
+)
+
+type actionSmartCrop struct {
+	width, height Length
+	aspect        float64
+	method        SmartCropMethod
+}
+
+var _ ImageAction = actionSmartCrop{}
+
+func init() {
+	RegisterAction("smart_crop", newActionSmartCropFromParams)
+}
+
+// NewActionSmartCrop returns an action keeping the width x height region of
+// an image with the most detail.
+func NewActionSmartCrop(width, height Length, method SmartCropMethod) ImageAction {
+	return &actionSmartCrop{
+		width:  width,
+		height: height,
+		method: method,
+	}
+}
+
+// NewActionSmartAspectCrop returns an action keeping the largest region with
+// the given width / height ratio that has the most detail.
+func NewActionSmartAspectCrop(aspect float64, method SmartCropMethod) ImageAction {
+	return &actionSmartCrop{
+		aspect: aspect,
+		method: method,
+	}
+}
+
+func newActionSmartCropFromParams(params *ParamReader) (ImageAction, error) {
+	method := readSmartCropMethod(params, "method", SmartCropEdges)
+	if params.Has("aspect") {
+		if params.Has("width") || params.Has("height") {
+			params.Fail("aspect", "can not be combined with a width or height")
+		}
+		aspect := readAspect(params, "aspect")
+		return NewActionSmartAspectCrop(aspect, method), params.Err()
+	}
+	width := params.RequiredLength("width", maxResizeDimension)
+	height := params.RequiredLength("height", maxResizeDimension)
+	return NewActionSmartCrop(width, height, method), params.Err()
+}
+
+func readSmartCropMethod(params *ParamReader, name string, def SmartCropMethod) SmartCropMethod {
+	method, err := ParseSmartCropMethod(params.String(name, def.String()))
+	if err != nil {
+		params.Fail(name, "%v", err)
+		return def
+	}
+	return method
+}
+
+func (a actionSmartCrop) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionSmartCrop) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return img, nil
+	}
+
+	var w, h int
+	if a.aspect != 0 {
+		if !(a.aspect > 0) || math.IsInf(a.aspect, 0) {
+			return nil, errors.New("smart crop requires a positive aspect ratio")
+		}
+		w, h = aspectSize(b.Dx(), b.Dy(), a.aspect)
+	} else {
+		w, h = a.width.Resolve(b.Dx()), a.height.Resolve(b.Dy())
+		if w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("invalid smart crop size %vx%v", a.width, a.height)
+		}
+		if w > b.Dx() {
+			w = b.Dx()
+		}
+		if h > b.Dy() {
+			h = b.Dy()
+		}
+	}
+	if w == b.Dx() && h == b.Dy() {
+		return cropImage(img, image.Rect(0, 0, w, h)), nil
+	}
+
+	src := toRGBA(img)
+	lum, scale := luminanceMap(ctx, src)
+	var energy []float64
+	switch a.method {
+	case SmartCropEdges:
+		energy = edgeEnergy(ctx, lum)
+	case SmartCropEntropy:
+		energy = entropyEnergy(ctx, lum)
+	default:
+		return nil, fmt.Errorf("unknown smart crop method %v", a.method)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return cropImage(src, bestWindow(energy, lum.Rect.Dx(), lum.Rect.Dy(), b.Dx(), b.Dy(), w, h, scale)), nil
+}
+
+// luminanceMap returns the luminance of src box-filtered down so its longer
+// side is at most smartCropAnalysisSize, along with the integer factor it
+// was reduced by.
+func luminanceMap(ctx context.Context, src *image.RGBA) (*image.Gray, int) {
+	b := src.Bounds()
+	scale := 1
+	for (b.Dx()+scale-1)/scale > smartCropAnalysisSize || (b.Dy()+scale-1)/scale > smartCropAnalysisSize {
+		scale++
+	}
+	w, h := (b.Dx()+scale-1)/scale, (b.Dy()+scale-1)/scale
+	lum := image.NewGray(image.Rect(0, 0, w, h))
+
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				sum, n := 0, 0
+				for sy := y * scale; sy < (y+1)*scale && sy < b.Dy(); sy++ {
+					row := src.Pix[sy*src.Stride:]
+					for sx := x * scale; sx < (x+1)*scale && sx < b.Dx(); sx++ {
+						p := row[sx*4 : sx*4+3]
+						sum += int(greyValue(p[0], p[1], p[2]))
+						n++
+					}
+				}
+				lum.Pix[y*lum.Stride+x] = uint8(sum / n)
+			}
+		}
+	})
+	return lum, scale
+}
+
+// edgeEnergy returns the sobel gradient magnitude of every pixel of lum.
+func edgeEnergy(ctx context.Context, lum *image.Gray) []float64 {
+	w, h := lum.Rect.Dx(), lum.Rect.Dy()
+	energy := make([]float64, w*h)
+	at := func(x, y int) float64 {
+		if x < 0 {
+			x = 0
+		} else if x >= w {
+			x = w - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= h {
+			y = h - 1
+		}
+		return float64(lum.Pix[y*lum.Stride+x])
+	}
+
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+				gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+				energy[y*w+x] = math.Sqrt(gx*gx + gy*gy)
+			}
+		}
+	})
+	return energy
+}
+
+// entropyEnergy returns, for every pixel of lum, the histogram entropy of the
+// smartCropCellSize cell it lies in.
+func entropyEnergy(ctx context.Context, lum *image.Gray) []float64 {
+	w, h := lum.Rect.Dx(), lum.Rect.Dy()
+	energy := make([]float64, w*h)
+	cellsY := (h + smartCropCellSize - 1) / smartCropCellSize
+
+	parallelRows(ctx, cellsY, func(c0, c1 int) {
+		var hist [smartCropLevels]int
+		for cy := c0; cy < c1; cy++ {
+			y0, y1 := cy*smartCropCellSize, (cy+1)*smartCropCellSize
+			if y1 > h {
+				y1 = h
+			}
+			for x0 := 0; x0 < w; x0 += smartCropCellSize {
+				x1 := x0 + smartCropCellSize
+				if x1 > w {
+					x1 = w
+				}
+
+				hist = [smartCropLevels]int{}
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						hist[int(lum.Pix[y*lum.Stride+x])*smartCropLevels/256]++
+					}
+				}
+				n := float64((x1 - x0) * (y1 - y0))
+				entropy := 0.0
+				for _, count := range hist {
+					if count > 0 {
+						p := float64(count) / n
+						entropy -= p * math.Log2(p)
+					}
+				}
+
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						energy[y*w+x] = entropy
+					}
+				}
+			}
+		}
+	})
+	return energy
+}
+
+// bestWindow returns the w x h region of a srcW x srcH image whose energy,
+// measured on a map reduced by scale, is highest. Ties go to the region
+// closest to the centre.
+func bestWindow(energy []float64, mapW, mapH, srcW, srcH, w, h, scale int) image.Rectangle {
+	// summed area table, with a zero row and column in front
+	stride := mapW + 1
+	sat := make([]float64, stride*(mapH+1))
+	for y := 0; y < mapH; y++ {
+		rowSum := 0.0
+		for x := 0; x < mapW; x++ {
+			rowSum += energy[y*mapW+x]
+			sat[(y+1)*stride+x+1] = sat[y*stride+x+1] + rowSum
+		}
+	}
+
+	winW, winH := (w+scale/2)/scale, (h+scale/2)/scale
+	if winW < 1 {
+		winW = 1
+	} else if winW > mapW {
+		winW = mapW
+	}
+	if winH < 1 {
+		winH = 1
+	} else if winH > mapH {
+		winH = mapH
+	}
+
+	centreX, centreY := float64(mapW-winW)/2, float64(mapH-winH)/2
+	bestX, bestY := 0, 0
+	bestScore, bestDist := math.Inf(-1), math.Inf(1)
+	for y := 0; y+winH <= mapH; y++ {
+		for x := 0; x+winW <= mapW; x++ {
+			score := sat[(y+winH)*stride+x+winW] - sat[y*stride+x+winW] - sat[(y+winH)*stride+x] + sat[y*stride+x]
+			dist := math.Hypot(float64(x)-centreX, float64(y)-centreY)
+			if score > bestScore+1e-9 || (score > bestScore-1e-9 && dist < bestDist) {
+				bestX, bestY, bestScore, bestDist = x, y, score, dist
+			}
+		}
+	}
+
+	// scale the window back, keeping it inside the source
+	x0, y0 := bestX*scale, bestY*scale
+	if x0+w > srcW {
+		x0 = srcW - w
+	}
+	if y0+h > srcH {
+		y0 = srcH - h
+	}
+	return image.Rect(x0, y0, x0+w, y0+h)
+}
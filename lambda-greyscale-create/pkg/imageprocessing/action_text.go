@@ -0,0 +1,174 @@
+package imageprocessing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// maxTextLength bounds the characters a text overlay may draw.
+	maxTextLength = 256
+	// maxTextSize bounds the height of the text, in pixels.
+	maxTextSize = 2048
+)
+
+var (
+	defaultTextSize  = Percent(5)
+	defaultTextColor = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+var (
+	defaultFontOnce sync.Once
+	defaultFont     *opentype.Font
+	defaultFontErr  error
+)
+
+// DefaultFont returns the Go Regular font embedded in the binary, used by
+// text overlays that don't name a font.
+func DefaultFont() (*opentype.Font, error) {
+	defaultFontOnce.Do(func() {
+		defaultFont, defaultFontErr = opentype.Parse(goregular.TTF)
+	})
+	return defaultFont, defaultFontErr
+}
+
+type actionText struct {
+	lines  []string
+	font   *opentype.Font
+	color  color.NRGBA
+	anchor Anchor
+	// size is the font size, a percentage is of the image height
+	size, margin Length
+}
+
+var _ ImageAction = actionText{}
+
+func init() {
+	RegisterAction("text", newActionTextFromParams)
+}
+
+// NewActionText returns an action drawing text over images in font f, or
+// DefaultFont when f is nil. Lines are separated by "\n" and aligned with
+// each other as the block is aligned to anchor, within margin of the edges.
+// The alpha of c is scaled by opacity, between 0 and 1.
+func NewActionText(text string, f *opentype.Font, size Length, c color.NRGBA, opacity float64, anchor Anchor, margin Length) (ImageAction, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("text must not be empty")
+	}
+	if n := utf8.RuneCountInString(text); n > maxTextLength {
+		return nil, fmt.Errorf("text has %d characters, at most %d are allowed", n, maxTextLength)
+	}
+	if size.IsZero() {
+		return nil, errors.New("text size must not be zero")
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("opacity must be between 0 and 1, got %g", opacity)
+	}
+	if f == nil {
+		var err error
+		if f, err = DefaultFont(); err != nil {
+			return nil, fmt.Errorf("error loading default font : %w", err)
+		}
+	}
+	c.A = clampChannel(float64(c.A) * opacity)
+	return &actionText{
+		lines:  strings.Split(text, "\n"),
+		font:   f,
+		color:  c,
+		anchor: anchor,
+		size:   size,
+		margin: margin,
+	}, nil
+}
+
+func newActionTextFromParams(params *ParamReader) (ImageAction, error) {
+	text := params.String("text", "")
+	var f *opentype.Font
+	if params.Has("font") {
+		data := params.Resource("font")
+		if data != nil {
+			parsed, err := opentype.Parse(data)
+			if err != nil {
+				params.Fail("font", "error parsing font : %v", err)
+			}
+			f = parsed
+		}
+	}
+	size := params.Length("size", defaultTextSize, maxTextSize)
+	c := params.Color("color", defaultTextColor)
+	opacity := params.FloatRange("opacity", 1, 0, 1)
+	anchor := readAnchor(params, "position", AnchorBottomRight)
+	margin := params.Length("margin", defaultOverlayMargin, maxOverlayMargin)
+	if size.IsZero() {
+		params.Fail("size", "must not be zero")
+	}
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+
+	action, err := NewActionText(text, f, size, c, opacity, anchor, margin)
+	if err != nil {
+		params.Fail("text", "%v", err)
+		return nil, params.Err()
+	}
+	return action, nil
+}
+
+func (a actionText) Transform(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	size := a.size.Resolve(h)
+	if size < 1 || a.color.A == 0 {
+		return dst, nil
+	}
+	face, err := opentype.NewFace(a.font, &opentype.FaceOptions{
+		Size:    float64(size),
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating font face : %w", err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	widths := make([]int, len(a.lines))
+	blockW := 0
+	for i, line := range a.lines {
+		widths[i] = font.MeasureString(face, line).Ceil()
+		if widths[i] > blockW {
+			blockW = widths[i]
+		}
+	}
+	blockH := lineHeight*(len(a.lines)-1) + (metrics.Ascent + metrics.Descent).Ceil()
+	rect := overlayRect(w, h, blockW, blockH, a.anchor, a.margin)
+
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(a.color),
+		Face: face,
+	}
+	for i, line := range a.lines {
+		x := rect.Min.X + int(math.Round(float64(blockW-widths[i])*a.anchor.X))
+		y := rect.Min.Y + metrics.Ascent.Ceil() + i*lineHeight
+		drawer.Dot = fixed.P(x, y)
+		drawer.DrawString(line)
+	}
+	return dst, nil
+}
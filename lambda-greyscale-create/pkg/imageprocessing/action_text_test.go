@@ -0,0 +1,142 @@
+package imageprocessing
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/gomono"
+)
+
+func TestText(t *testing.T) {
+	black := color.NRGBA{A: 0xff}
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	area := image.Rect(0, 0, 200, 100)
+
+	tests := []struct {
+		name   string
+		text   string
+		anchor Anchor
+		margin Length
+		// within is where every lit pixel must be
+		within image.Rectangle
+	}{
+		{name: "top left", text: "Hi", anchor: AnchorTopLeft, margin: Pixels(10), within: image.Rect(10, 10, 100, 50)},
+		{name: "bottom right", text: "Hi", anchor: AnchorBottomRight, margin: Pixels(10), within: image.Rect(100, 50, 190, 90)},
+		{name: "two lines", text: "Hi\nthere", anchor: AnchorBottomLeft, margin: Pixels(0), within: image.Rect(0, 40, 100, 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := NewActionText(tt.text, nil, Pixels(20), red, 1, tt.anchor, tt.margin)
+			if err != nil {
+				t.Fatalf("NewActionText() error = %v", err)
+			}
+			got := transformOrFail(t, action, solid(200, 100, black))
+			if got.Bounds() != area {
+				t.Fatalf("bounds = %v, want %v", got.Bounds(), area)
+			}
+			lit := litBounds(got)
+			if lit.Empty() {
+				t.Fatal("no text was drawn")
+			}
+			if !lit.In(tt.within) {
+				t.Errorf("text drawn at %v, want it within %v", lit, tt.within)
+			}
+		})
+	}
+
+	// more lines make a taller block, and a percentage size follows the
+	// image height
+	lineHeight := func(text string, size Length, h int) int {
+		action, err := NewActionText(text, nil, size, red, 1, AnchorTopLeft, Pixels(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return litBounds(transformOrFail(t, action, solid(400, h, black))).Dy()
+	}
+	if one, two := lineHeight("H", Pixels(20), 100), lineHeight("H\nH", Pixels(20), 100); two < 2*one {
+		t.Errorf("two lines are %d pixels high, want at least twice the %d of one", two, one)
+	}
+	if small, large := lineHeight("H", Percent(10), 100), lineHeight("H", Percent(10), 200); large < 2*small-1 || large > 2*small+1 {
+		t.Errorf("10%% text is %d pixels high on a 200 pixel image, want twice the %d on a 100 pixel one", large, small)
+	}
+
+	// opacity scales the colour drawn, none leaves the image as it is
+	action, err := NewActionText("H", nil, Pixels(40), red, 0.5, AnchorCenter, Pixels(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := transformOrFail(t, action, solid(100, 100, black))
+	max := uint8(0)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			if r := straightAt(got, x, y).R; r > max {
+				max = r
+			}
+		}
+	}
+	if max < 0x70 || max > 0x90 {
+		t.Errorf("brightest pixel of half opaque text = %d, want about 128", max)
+	}
+	action, err = NewActionText("H", nil, Pixels(40), red, 0, AnchorCenter, Pixels(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lit := litBounds(transformOrFail(t, action, solid(100, 100, black))); !lit.Empty() {
+		t.Errorf("transparent text drew at %v", lit)
+	}
+
+	for name, create := range map[string]func() (ImageAction, error){
+		"empty text": func() (ImageAction, error) {
+			return NewActionText(" \n", nil, Pixels(20), red, 1, AnchorCenter, Pixels(0))
+		},
+		"too long text": func() (ImageAction, error) {
+			return NewActionText(strings.Repeat("a", 257), nil, Pixels(20), red, 1, AnchorCenter, Pixels(0))
+		},
+		"zero size": func() (ImageAction, error) {
+			return NewActionText("a", nil, Pixels(0), red, 1, AnchorCenter, Pixels(0))
+		},
+		"opacity over 1": func() (ImageAction, error) {
+			return NewActionText("a", nil, Pixels(20), red, 2, AnchorCenter, Pixels(0))
+		},
+	} {
+		if _, err := create(); err == nil {
+			t.Errorf("NewActionText() accepted %s", name)
+		}
+	}
+}
+
+func TestTextSpec(t *testing.T) {
+	resources := mapResources{"mono.ttf": gomono.TTF, "notes.txt": []byte("not a font")}
+	tests := []struct {
+		name      string
+		params    Params
+		wantParam string
+	}{
+		{name: "text", params: Params{"text": "© Greyscale", "size": "4%", "color": "#ffffff80", "position": "bottom-left"}},
+		{name: "font", params: Params{"text": "a", "font": "mono.ttf"}},
+		{name: "missing font", params: Params{"text": "a", "font": "missing.ttf"}, wantParam: "font"},
+		{name: "not a font", params: Params{"text": "a", "font": "notes.txt"}, wantParam: "font"},
+		{name: "no text", params: Params{}, wantParam: "text"},
+		{name: "zero size", params: Params{"text": "a", "size": 0}, wantParam: "size"},
+		{name: "size too large", params: Params{"text": "a", "size": 4096}, wantParam: "size"},
+		{name: "bad colour", params: Params{"text": "a", "color": "light"}, wantParam: "color"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpecWithResources(PipelineSpec{Actions: []ActionSpec{{Name: "text", Params: tt.params}}}, resources)
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpecWithResources() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpecWithResources() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package imageprocessing
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ChannelMatrix maps the red, green and blue channels of a pixel to new
+// values. Each row produces one output channel as a weighted sum of the input
+// channels plus the offset in its fourth column, in 0-255 channel units.
+type ChannelMatrix [3][4]float64
+
+// IdentityMatrix leaves every pixel as it is.
+var IdentityMatrix = ChannelMatrix{
+	{1, 0, 0, 0},
+	{0, 1, 0, 0},
+	{0, 0, 1, 0},
+}
+
+// SepiaMatrix is the common sepia tone, as used by CSS filters.
+var SepiaMatrix = ChannelMatrix{
+	{0.393, 0.769, 0.189, 0},
+	{0.349, 0.686, 0.168, 0},
+	{0.272, 0.534, 0.131, 0},
+}
+
+const (
+	// maxChannelWeight bounds the channel mixer weights a spec may ask for.
+	maxChannelWeight = 8
+	// maxChannelOffset bounds the channel mixer offsets.
+	maxChannelOffset = 255
+)
+
+// blend returns the matrix amount of the way from m to other.
+func (m ChannelMatrix) blend(other ChannelMatrix, amount float64) ChannelMatrix {
+	var out ChannelMatrix
+	for row := range m {
+		for col := range m[row] {
+			out[row][col] = m[row][col] + (other[row][col]-m[row][col])*amount
+		}
+	}
+	return out
+}
+
+type actionChannelMixer struct {
+	matrix ChannelMatrix
+}
+
+var _ ImageAction = actionChannelMixer{}
+
+type actionDuotone struct {
+	shadow, highlight color.NRGBA
+}
+
+var _ ImageAction = actionDuotone{}
+
+type actionTint struct {
+	color    color.NRGBA
+	strength float64
+}
+
+var _ ImageAction = actionTint{}
+
+func init() {
+	RegisterAction("sepia", newActionSepiaFromParams)
+	RegisterAction("duotone", newActionDuotoneFromParams)
+	RegisterAction("tint", newActionTintFromParams)
+	RegisterAction("channel_mixer", newActionChannelMixerFromParams)
+}
+
+// NewActionChannelMixer returns an action mapping the channels of every
+// pixel through matrix.
+func NewActionChannelMixer(matrix ChannelMatrix) ImageAction {
+	return &actionChannelMixer{matrix: matrix}
+}
+
+// NewActionSepia returns an action toning images sepia, strength blends
+// between the original at 0 and the full tone at 1.
+func NewActionSepia(strength float64) ImageAction {
+	return &actionChannelMixer{matrix: IdentityMatrix.blend(SepiaMatrix, strength)}
+}
+
+// NewActionDuotone returns an action mapping the luminosity of every pixel
+// onto the gradient from shadow to highlight. The alpha of both colours is
+// ignored.
+func NewActionDuotone(shadow, highlight color.NRGBA) ImageAction {
+	return &actionDuotone{
+		shadow:    shadow,
+		highlight: highlight,
+	}
+}
+
+// NewActionTint returns an action colouring images with c, strength blends
+// between the original at 0 and the luminosity multiplied by c at 1. The
+// alpha of c is ignored.
+func NewActionTint(c color.NRGBA, strength float64) ImageAction {
+	return &actionTint{
+		color:    c,
+		strength: strength,
+	}
+}
+
+func newActionSepiaFromParams(params *ParamReader) (ImageAction, error) {
+	strength := params.FloatRange("strength", 1, 0, 1)
+	return NewActionSepia(strength), params.Err()
+}
+
+func newActionDuotoneFromParams(params *ParamReader) (ImageAction, error) {
+	shadow := params.RequiredColor("shadow")
+	highlight := params.RequiredColor("highlight")
+	return NewActionDuotone(shadow, highlight), params.Err()
+}
+
+func newActionTintFromParams(params *ParamReader) (ImageAction, error) {
+	c := params.RequiredColor("color")
+	strength := params.FloatRange("strength", 0.5, 0, 1)
+	return NewActionTint(c, strength), params.Err()
+}
+
+func newActionChannelMixerFromParams(params *ParamReader) (ImageAction, error) {
+	matrix := readChannelMatrix(params, "matrix")
+	return NewActionChannelMixer(matrix), params.Err()
+}
+
+// readChannelMatrix reads a required matrix given as three rows, one per
+// output channel, of three weights and an optional offset.
+func readChannelMatrix(params *ParamReader, name string) ChannelMatrix {
+	v, ok := params.lookup(name)
+	if !ok {
+		params.Fail(name, "is required")
+		return IdentityMatrix
+	}
+	rows, isList := v.([]interface{})
+	if !isList || len(rows) != 3 {
+		params.Fail(name, "expected 3 rows of red, green and blue weights and an optional offset")
+		return IdentityMatrix
+	}
+
+	var matrix ChannelMatrix
+	for i, row := range rows {
+		values, isList := row.([]interface{})
+		if !isList || len(values) < 3 || len(values) > 4 {
+			params.Fail(name, "row %d: expected red, green and blue weights and an optional offset", i+1)
+			return IdentityMatrix
+		}
+		for j, value := range values {
+			n, ok := numberValue(value)
+			if !ok {
+				params.Fail(name, "row %d: expected a number, got %v", i+1, value)
+				return IdentityMatrix
+			}
+			if j < 3 && math.Abs(n) > maxChannelWeight {
+				params.Fail(name, "row %d: weights must be between -%d and %d, got %g", i+1, maxChannelWeight, maxChannelWeight, n)
+				return IdentityMatrix
+			}
+			if j == 3 && math.Abs(n) > maxChannelOffset {
+				params.Fail(name, "row %d: offset must be between -%d and %d, got %g", i+1, maxChannelOffset, maxChannelOffset, n)
+				return IdentityMatrix
+			}
+			matrix[i][j] = n
+		}
+	}
+	return matrix
+}
+
+func (a actionChannelMixer) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionChannelMixer) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	m := a.matrix
+	return toneMap(ctx, img, func(r, g, b float64) (float64, float64, float64) {
+		return m[0][0]*r + m[0][1]*g + m[0][2]*b + m[0][3],
+			m[1][0]*r + m[1][1]*g + m[1][2]*b + m[1][3],
+			m[2][0]*r + m[2][1]*g + m[2][2]*b + m[2][3]
+	})
+}
+
+func (a actionDuotone) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionDuotone) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	s, h := a.shadow, a.highlight
+	return toneMap(ctx, img, func(r, g, b float64) (float64, float64, float64) {
+		t := luminosity(r, g, b) / 0xff
+		return float64(s.R) + (float64(h.R)-float64(s.R))*t,
+			float64(s.G) + (float64(h.G)-float64(s.G))*t,
+			float64(s.B) + (float64(h.B)-float64(s.B))*t
+	})
+}
+
+func (a actionTint) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionTint) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	c, strength := a.color, a.strength
+	return toneMap(ctx, img, func(r, g, b float64) (float64, float64, float64) {
+		l := luminosity(r, g, b) / 0xff
+		return r + (l*float64(c.R)-r)*strength,
+			g + (l*float64(c.G)-g)*strength,
+			b + (l*float64(c.B)-b)*strength
+	})
+}
+
+// luminosity is greyValue without the rounding.
+func luminosity(r, g, b float64) float64 {
+	return r*0.21 + g*0.72 + b*0.07
+}
+
+// toneMap returns a copy of img with fn applied to the colour of every pixel.
+// fn works on straight, not premultiplied, channel values and its results
+// are clamped to [0, 255]. It returns ctx.Err() once ctx is done.
+func toneMap(ctx context.Context, img image.Image, fn func(r, g, b float64) (float64, float64, float64)) (*image.RGBA, error) {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride:]
+			d := dst.Pix[y*dst.Stride:]
+			for i := 0; i < w*4; i += 4 {
+				a := s[i+3]
+				if a == 0 {
+					continue
+				}
+				alpha := float64(a) / 0xff
+				r, g, b := fn(float64(s[i])/alpha, float64(s[i+1])/alpha, float64(s[i+2])/alpha)
+				// clamp before premultiplying so no channel exceeds alpha
+				d[i] = clampChannel(math.Min(r, 0xff) * alpha)
+				d[i+1] = clampChannel(math.Min(g, 0xff) * alpha)
+				d[i+2] = clampChannel(math.Min(b, 0xff) * alpha)
+				d[i+3] = a
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// clampChannel rounds v to the nearest channel value.
+func clampChannel(v float64) uint8 {
+	switch {
+	case v <= 0 || math.IsNaN(v):
+		return 0
+	case v >= 0xff:
+		return 0xff
+	default:
+		return uint8(v + 0.5)
+	}
+}
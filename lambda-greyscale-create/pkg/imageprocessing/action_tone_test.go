@@ -0,0 +1,241 @@
+package imageprocessing
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// pixel returns a 1x1 image of c.
+func pixel(c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, c)
+	return img
+}
+
+// straightAt returns the straight, not premultiplied, colour of img at x, y.
+func straightAt(img image.Image, x, y int) color.NRGBA {
+	b := img.Bounds()
+	return color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+}
+
+func TestGreyMethods(t *testing.T) {
+	c := color.NRGBA{R: 200, G: 100, B: 50, A: 0xff}
+	tests := []struct {
+		method GreyMethod
+		want   uint8
+	}{
+		{method: GreyLuminosity, want: 117},
+		{method: GreyBT601, want: 124},
+		{method: GreyBT709, want: 118},
+		{method: GreyAverage, want: 117},
+		{method: GreyLightness, want: 125},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method.String(), func(t *testing.T) {
+			// every source type takes its own fast path
+			sources := map[string]image.Image{
+				"nrgba": pixel(c),
+				"rgba":  toRGBA(pixel(c)),
+				"paletted": image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{
+					color.NRGBA{R: c.R, G: c.G, B: c.B, A: c.A},
+				}),
+			}
+			for name, src := range sources {
+				got, err := NewActionGreyScaleMethod(tt.method).Transform(src)
+				if err != nil {
+					t.Fatalf("Transform() error = %v", err)
+				}
+				want := color.NRGBA{R: tt.want, G: tt.want, B: tt.want, A: 0xff}
+				if got := straightAt(got, 0, 0); got != want {
+					t.Errorf("%s: Transform() = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewActionGreyScaleMethod(GreyLightness + 1).Transform(pixel(c)); err == nil {
+		t.Error("Transform() accepted an unknown method")
+	}
+}
+
+func TestTone(t *testing.T) {
+	mixer := func(m ChannelMatrix) ImageAction { return NewActionChannelMixer(m) }
+	tests := []struct {
+		name   string
+		action ImageAction
+		src    color.NRGBA
+		want   color.NRGBA
+	}{
+		{name: "sepia", action: NewActionSepia(1), src: color.NRGBA{R: 100, G: 150, B: 200, A: 0xff}, want: color.NRGBA{R: 192, G: 171, B: 134, A: 0xff}},
+		{name: "sepia of white clamps", action: NewActionSepia(1), src: color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, want: color.NRGBA{R: 0xff, G: 0xff, B: 239, A: 0xff}},
+		{name: "half sepia", action: NewActionSepia(0.5), src: color.NRGBA{R: 100, G: 150, B: 200, A: 0xff}, want: color.NRGBA{R: 146, G: 161, B: 167, A: 0xff}},
+		{name: "no sepia", action: NewActionSepia(0), src: color.NRGBA{R: 100, G: 150, B: 200, A: 0xff}, want: color.NRGBA{R: 100, G: 150, B: 200, A: 0xff}},
+		{name: "duotone black", action: NewActionDuotone(color.NRGBA{B: 0x80}, color.NRGBA{R: 0xff, G: 0xff}), src: color.NRGBA{A: 0xff}, want: color.NRGBA{B: 0x80, A: 0xff}},
+		{name: "duotone white", action: NewActionDuotone(color.NRGBA{B: 0x80}, color.NRGBA{R: 0xff, G: 0xff}), src: color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, want: color.NRGBA{R: 0xff, G: 0xff, A: 0xff}},
+		{name: "duotone grey", action: NewActionDuotone(color.NRGBA{B: 0x80}, color.NRGBA{R: 0xff, G: 0xff}), src: color.NRGBA{R: 128, G: 128, B: 128, A: 0xff}, want: color.NRGBA{R: 128, G: 128, B: 64, A: 0xff}},
+		{name: "full tint", action: NewActionTint(color.NRGBA{R: 0xff}, 1), src: color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, want: color.NRGBA{R: 0xff, A: 0xff}},
+		{name: "half tint", action: NewActionTint(color.NRGBA{R: 0xff}, 0.5), src: color.NRGBA{R: 200, G: 200, B: 200, A: 0xff}, want: color.NRGBA{R: 200, G: 100, B: 100, A: 0xff}},
+		{name: "no tint", action: NewActionTint(color.NRGBA{R: 0xff}, 0), src: color.NRGBA{R: 10, G: 20, B: 30, A: 0xff}, want: color.NRGBA{R: 10, G: 20, B: 30, A: 0xff}},
+		{name: "mixer identity", action: mixer(IdentityMatrix), src: color.NRGBA{R: 10, G: 20, B: 30, A: 0xff}, want: color.NRGBA{R: 10, G: 20, B: 30, A: 0xff}},
+		{name: "mixer swap red and blue", action: mixer(ChannelMatrix{{0, 0, 1, 0}, {0, 1, 0, 0}, {1, 0, 0, 0}}), src: color.NRGBA{R: 10, G: 20, B: 30, A: 0xff}, want: color.NRGBA{R: 30, G: 20, B: 10, A: 0xff}},
+		{name: "mixer offsets clamp", action: mixer(ChannelMatrix{{1, 0, 0, 100}, {0, 1, 0, -100}, {0, 0, 1, 5}}), src: color.NRGBA{R: 200, G: 20, B: 30, A: 0xff}, want: color.NRGBA{R: 0xff, G: 0, B: 35, A: 0xff}},
+		{name: "transparent pixels stay transparent", action: mixer(ChannelMatrix{{0, 0, 0, 255}, {0, 0, 0, 255}, {0, 0, 0, 255}}), src: color.NRGBA{R: 10, G: 20, B: 30}, want: color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.action.Transform(pixel(tt.src))
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if got := straightAt(got, 0, 0); got != tt.want {
+				t.Errorf("Transform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// the tones work on straight colours, so a half transparent pixel keeps its
+// alpha and is toned as its opaque colour would be.
+func TestToneAlpha(t *testing.T) {
+	actions := map[string]ImageAction{
+		"sepia":   NewActionSepia(1),
+		"duotone": NewActionDuotone(color.NRGBA{B: 0x80}, color.NRGBA{R: 0xff, G: 0xff}),
+		"tint":    NewActionTint(color.NRGBA{R: 0xff, G: 0x80}, 0.7),
+		"mixer":   NewActionChannelMixer(ChannelMatrix{{0.5, 0.5, 0, 40}, {0, 1, 0, 0}, {0, 0, 2, 0}}),
+	}
+	opaque := color.NRGBA{R: 100, G: 150, B: 200, A: 0xff}
+	for name, action := range actions {
+		t.Run(name, func(t *testing.T) {
+			wantImg, err := action.Transform(pixel(opaque))
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			want := straightAt(wantImg, 0, 0)
+
+			for _, alpha := range []uint8{0x80, 0x40} {
+				src := opaque
+				src.A = alpha
+				img, err := action.Transform(pixel(src))
+				if err != nil {
+					t.Fatalf("Transform() error = %v", err)
+				}
+				premultiplied := toRGBA(img).RGBAAt(0, 0)
+				if premultiplied.R > premultiplied.A || premultiplied.G > premultiplied.A || premultiplied.B > premultiplied.A {
+					t.Errorf("alpha %#x: %v has a channel over its alpha", alpha, premultiplied)
+				}
+				got := straightAt(img, 0, 0)
+				if got.A != alpha {
+					t.Errorf("alpha %#x: alpha = %#x, want it kept", alpha, got.A)
+				}
+				// premultiplying loses precision at low alpha
+				tolerance := int(0xff/alpha) + 1
+				if absDiff(got.R, want.R) > tolerance || absDiff(got.G, want.G) > tolerance || absDiff(got.B, want.B) > tolerance {
+					t.Errorf("alpha %#x: colour = %v, want %v within %d", alpha, got, want, tolerance)
+				}
+			}
+		})
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{s: "#a0522d", want: color.NRGBA{R: 0xa0, G: 0x52, B: 0x2d, A: 0xff}},
+		{s: "a0522d", want: color.NRGBA{R: 0xa0, G: 0x52, B: 0x2d, A: 0xff}},
+		{s: "#A0522D80", want: color.NRGBA{R: 0xa0, G: 0x52, B: 0x2d, A: 0x80}},
+		{s: "#f80", want: color.NRGBA{R: 0xff, G: 0x88, B: 0x00, A: 0xff}},
+		{s: " #000 ", want: color.NRGBA{A: 0xff}},
+		{s: "#ff", wantErr: true},
+		{s: "#a0522", wantErr: true},
+		{s: "#ggg", wantErr: true},
+		{s: "sienna", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseColor(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseColor(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseColor(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToneSpec(t *testing.T) {
+	row := func(values ...interface{}) []interface{} { return values }
+	tests := []struct {
+		name      string
+		action    ActionSpec
+		wantParam string
+	}{
+		{name: "greyscale", action: ActionSpec{Name: "greyscale", Params: Params{"method": "bt709"}}},
+		{name: "greyscale unknown method", action: ActionSpec{Name: "greyscale", Params: Params{"method": "red"}}, wantParam: "method"},
+		{name: "sepia", action: ActionSpec{Name: "sepia"}},
+		{name: "sepia too strong", action: ActionSpec{Name: "sepia", Params: Params{"strength": 1.5}}, wantParam: "strength"},
+		{name: "duotone", action: ActionSpec{Name: "duotone", Params: Params{"shadow": "#000080", "highlight": "#ff0"}}},
+		{name: "duotone without highlight", action: ActionSpec{Name: "duotone", Params: Params{"shadow": "#000080"}}, wantParam: "highlight"},
+		{name: "tint", action: ActionSpec{Name: "tint", Params: Params{"color": "#a0522d", "strength": 0.3}}},
+		{name: "tint bad colour", action: ActionSpec{Name: "tint", Params: Params{"color": "sienna"}}, wantParam: "color"},
+		{name: "tint negative strength", action: ActionSpec{Name: "tint", Params: Params{"color": "#fff", "strength": -0.1}}, wantParam: "strength"},
+		{
+			name:   "channel mixer",
+			action: ActionSpec{Name: "channel_mixer", Params: Params{"matrix": []interface{}{row(1, 0, 0), row(0, 1.5, 0, -20), row(0, 0, 1)}}},
+		},
+		{name: "channel mixer without matrix", action: ActionSpec{Name: "channel_mixer"}, wantParam: "matrix"},
+		{
+			name:      "channel mixer with two rows",
+			action:    ActionSpec{Name: "channel_mixer", Params: Params{"matrix": []interface{}{row(1, 0, 0), row(0, 1, 0)}}},
+			wantParam: "matrix",
+		},
+		{
+			name:      "channel mixer with a short row",
+			action:    ActionSpec{Name: "channel_mixer", Params: Params{"matrix": []interface{}{row(1, 0), row(0, 1, 0), row(0, 0, 1)}}},
+			wantParam: "matrix",
+		},
+		{
+			name:      "channel mixer weight too large",
+			action:    ActionSpec{Name: "channel_mixer", Params: Params{"matrix": []interface{}{row(9, 0, 0), row(0, 1, 0), row(0, 0, 1)}}},
+			wantParam: "matrix",
+		},
+		{
+			name:      "channel mixer offset too large",
+			action:    ActionSpec{Name: "channel_mixer", Params: Params{"matrix": []interface{}{row(1, 0, 0, 256), row(0, 1, 0), row(0, 0, 1)}}},
+			wantParam: "matrix",
+		},
+		{
+			name:      "channel mixer with a string",
+			action:    ActionSpec{Name: "channel_mixer", Params: Params{"matrix": []interface{}{row(1, 0, "0"), row(0, 1, 0), row(0, 0, 1)}}},
+			wantParam: "matrix",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{tt.action}})
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpec() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// maxOverlayMargin bounds the margin, in pixels, kept between an overlay and
+// the edges of an image.
+const maxOverlayMargin = 4096
+
+var defaultOverlayMargin = Pixels(10)
+
+type actionWatermark struct {
+	mark   *image.RGBA
+	anchor Anchor
+	margin Length
+	// opacity scales the alpha of the mark, scale is the share of the image
+	// width the mark spans, zero keeps its own size
+	opacity, scale float64
+}
+
+var _ ImageAction = actionWatermark{}
+
+func init() {
+	RegisterAction("watermark", newActionWatermarkFromParams)
+}
+
+// NewActionWatermark returns an action drawing mark over images, aligned to
+// anchor within margin of the edges. opacity, between 0 and 1, scales the
+// alpha of the mark. When scale is above zero the mark is resized to span
+// that share of the image width, otherwise it is drawn at its own size.
+func NewActionWatermark(mark image.Image, anchor Anchor, margin Length, opacity, scale float64) (ImageAction, error) {
+	if mark.Bounds().Empty() {
+		return nil, errors.New("watermark image is empty")
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("opacity must be between 0 and 1, got %g", opacity)
+	}
+	if scale < 0 || scale > 1 {
+		return nil, fmt.Errorf("scale must be between 0 and 1, got %g", scale)
+	}
+	return &actionWatermark{
+		mark:    toRGBA(mark),
+		anchor:  anchor,
+		margin:  margin,
+		opacity: opacity,
+		scale:   scale,
+	}, nil
+}
+
+func newActionWatermarkFromParams(params *ParamReader) (ImageAction, error) {
+	data := params.Resource("image")
+	anchor := readAnchor(params, "position", AnchorBottomRight)
+	margin := params.Length("margin", defaultOverlayMargin, maxOverlayMargin)
+	opacity := params.FloatRange("opacity", 1, 0, 1)
+	scale := params.FloatRange("scale", 0, 0, 1)
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+
+	mark, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		params.Fail("image", "error decoding watermark : %v", err)
+		return nil, params.Err()
+	}
+	action, err := NewActionWatermark(mark, anchor, margin, opacity, scale)
+	if err != nil {
+		params.Fail("image", "%v", err)
+		return nil, params.Err()
+	}
+	return action, nil
+}
+
+func (a actionWatermark) Transform(img image.Image) (image.Image, error) {
+	return a.TransformContext(context.Background(), img)
+}
+
+func (a actionWatermark) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	if dst.Rect.Empty() {
+		return dst, nil
+	}
+
+	mark := a.mark
+	if a.scale > 0 {
+		markW := int(math.Round(float64(w) * a.scale))
+		if markW < 1 {
+			markW = 1
+		}
+		markH := scaleDimension(mark.Rect.Dy(), markW, mark.Rect.Dx())
+		var err error
+		mark, err = resample(ctx, mark, markW, markH, FilterCatmullRom)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rect := overlayRect(w, h, mark.Rect.Dx(), mark.Rect.Dy(), a.anchor, a.margin)
+	draw.DrawMask(dst, rect, mark, image.Point{}, opacityMask(a.opacity), image.Point{}, draw.Over)
+	return dst, nil
+}
+
+// overlayRect returns where a w x h overlay is drawn on a dstW x dstH image,
+// aligned to anchor within the area inset by margin from every edge.
+func overlayRect(dstW, dstH, w, h int, anchor Anchor, margin Length) image.Rectangle {
+	mx, my := margin.Resolve(dstW), margin.Resolve(dstH)
+	return anchorRect(dstW-2*mx, dstH-2*my, w, h, anchor).Add(image.Pt(mx, my))
+}
+
+// opacityMask returns the mask drawing an overlay at opacity, nil when it is
+// fully opaque.
+func opacityMask(opacity float64) image.Image {
+	if opacity >= 1 {
+		return nil
+	}
+	return image.NewUniform(color.Alpha{A: clampChannel(opacity * 0xff)})
+}
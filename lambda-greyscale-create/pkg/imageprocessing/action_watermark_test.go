@@ -0,0 +1,141 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// solid returns a w x h image of c.
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// litBounds returns the smallest rectangle holding every pixel of img with
+// any red, the pixels an overlay drew on a black image.
+func litBounds(img image.Image) image.Rectangle {
+	var lit image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r > 0 {
+				lit = lit.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return lit
+}
+
+func TestWatermark(t *testing.T) {
+	black := color.NRGBA{A: 0xff}
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	mark := solid(10, 5, red)
+
+	tests := []struct {
+		name    string
+		anchor  Anchor
+		margin  Length
+		opacity float64
+		scale   float64
+		want    image.Rectangle
+		// wantRed is the red of the pixels under the mark
+		wantRed uint8
+	}{
+		{name: "bottom right", anchor: AnchorBottomRight, margin: Pixels(10), opacity: 1, want: image.Rect(80, 65, 90, 70), wantRed: 0xff},
+		{name: "top left", anchor: AnchorTopLeft, margin: Pixels(10), opacity: 1, want: image.Rect(10, 10, 20, 15), wantRed: 0xff},
+		{name: "center", anchor: AnchorCenter, margin: Pixels(10), opacity: 1, want: image.Rect(45, 38, 55, 43), wantRed: 0xff},
+		{name: "no margin", anchor: AnchorBottomLeft, margin: Pixels(0), opacity: 1, want: image.Rect(0, 75, 10, 80), wantRed: 0xff},
+		// a percentage margin is of the width across and the height down
+		{name: "percent margin", anchor: AnchorTopRight, margin: Percent(10), opacity: 1, want: image.Rect(80, 8, 90, 13), wantRed: 0xff},
+		{name: "half opacity", anchor: AnchorTopLeft, margin: Pixels(0), opacity: 0.5, want: image.Rect(0, 0, 10, 5), wantRed: 0x80},
+		{name: "scaled to half the width", anchor: AnchorTopLeft, margin: Pixels(0), opacity: 1, scale: 0.5, want: image.Rect(0, 0, 50, 25), wantRed: 0xff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := NewActionWatermark(mark, tt.anchor, tt.margin, tt.opacity, tt.scale)
+			if err != nil {
+				t.Fatalf("NewActionWatermark() error = %v", err)
+			}
+			got := transformOrFail(t, action, solid(100, 80, black))
+			if lit := litBounds(got); lit != tt.want {
+				t.Errorf("mark drawn at %v, want %v", lit, tt.want)
+			}
+			centre := tt.want.Min.Add(tt.want.Size().Div(2))
+			if c := straightAt(got, centre.X, centre.Y); c.R != tt.wantRed || c.A != 0xff {
+				t.Errorf("pixel under the mark = %v, want red %d over opaque black", c, tt.wantRed)
+			}
+		})
+	}
+
+	// a half transparent mark blends with what is under it, and a mark
+	// larger than the image is clipped to it
+	action, err := NewActionWatermark(solid(300, 300, color.NRGBA{R: 0xff, A: 0x80}), AnchorCenter, Pixels(0), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := transformOrFail(t, action, solid(100, 80, black))
+	if got.Bounds() != image.Rect(0, 0, 100, 80) {
+		t.Errorf("watermarked bounds = %v, want the 100x80 of the image", got.Bounds())
+	}
+	if c := straightAt(got, 0, 0); c.R != 0x80 || c.A != 0xff {
+		t.Errorf("pixel under a half transparent mark = %v, want red 128", c)
+	}
+
+	for name, create := range map[string]func() (ImageAction, error){
+		"empty mark": func() (ImageAction, error) {
+			return NewActionWatermark(image.NewNRGBA(image.Rect(0, 0, 0, 0)), AnchorCenter, Pixels(0), 1, 0)
+		},
+		"opacity above": func() (ImageAction, error) { return NewActionWatermark(mark, AnchorCenter, Pixels(0), 1.5, 0) },
+		"scale below":   func() (ImageAction, error) { return NewActionWatermark(mark, AnchorCenter, Pixels(0), 1, -0.5) },
+	} {
+		if _, err := create(); err == nil {
+			t.Errorf("NewActionWatermark() accepted an %s", name)
+		}
+	}
+}
+
+func TestWatermarkSpec(t *testing.T) {
+	var mark bytes.Buffer
+	if err := png.Encode(&mark, solid(4, 4, color.NRGBA{R: 0xff, A: 0xff})); err != nil {
+		t.Fatal(err)
+	}
+	resources := mapResources{"mark.png": mark.Bytes(), "notes.txt": []byte("not an image")}
+
+	tests := []struct {
+		name      string
+		params    Params
+		wantParam string
+	}{
+		{name: "watermark", params: Params{"image": "mark.png", "position": "top-left", "margin": "5%", "opacity": 0.3, "scale": 0.2}},
+		{name: "missing image", params: Params{"image": "missing.png"}, wantParam: "image"},
+		{name: "no image", params: Params{}, wantParam: "image"},
+		{name: "not an image", params: Params{"image": "notes.txt"}, wantParam: "image"},
+		{name: "unknown position", params: Params{"image": "mark.png", "position": "middle"}, wantParam: "position"},
+		{name: "opacity above 1", params: Params{"image": "mark.png", "opacity": 2}, wantParam: "opacity"},
+		{name: "scale above 1", params: Params{"image": "mark.png", "scale": 1.5}, wantParam: "scale"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipelineFromSpecWithResources(PipelineSpec{Actions: []ActionSpec{{Name: "watermark", Params: tt.params}}}, resources)
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("NewPipelineFromSpecWithResources() error = %v", err)
+				}
+				return
+			}
+			var paramErr *ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+				t.Errorf("NewPipelineFromSpecWithResources() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
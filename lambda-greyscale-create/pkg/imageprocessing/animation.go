@@ -0,0 +1,209 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// ErrAnimationTooLarge is returned by DecodeAnimation for animations beyond
+// its limits.
+var ErrAnimationTooLarge = errors.New("animation is too large")
+
+var errGIFTruncated = errors.New("gif: truncated data")
+
+// AnimationLimits bound the work an animated upload can ask for.
+type AnimationLimits struct {
+	// MaxFrames is the most frames an animation may have.
+	MaxFrames int
+	// MaxPixels is the most pixels of all frames together, each frame
+	// counting as the whole canvas.
+	MaxPixels int64
+}
+
+// DefaultAnimationLimits keep the decoded frames of an animation within a
+// couple of hundred megabytes.
+var DefaultAnimationLimits = AnimationLimits{
+	MaxFrames: 300,
+	MaxPixels: 50000000,
+}
+
+// Animation is a decoded animated GIF. Each frame is composited onto the
+// frames before it as the disposal methods require, so every frame is a
+// complete image that actions can transform on its own.
+type Animation struct {
+	Frames []image.Image
+	// Delays are the time each frame is shown, in 100ths of a second.
+	Delays []int
+	// Disposal are the gif disposal methods of each frame.
+	Disposal []byte
+	// LoopCount is 0 to loop forever, -1 to show the frames once and n to
+	// show them n+1 times.
+	LoopCount int
+}
+
+// AnimationEncoder is implemented by encoders that can write every frame of
+// an Animation.
+type AnimationEncoder interface {
+	EncodeAnimation(io.Writer, *Animation) error
+}
+
+// DecodeAnimation decodes every frame of a GIF, checking the frame count and
+// size against limits before any frame is decoded.
+func DecodeAnimation(data []byte, limits AnimationLimits) (*Animation, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	count, err := gifFrameCount(data)
+	if err != nil {
+		return nil, err
+	}
+	if count > limits.MaxFrames {
+		return nil, fmt.Errorf("%w, %d frames exceeds %d", ErrAnimationTooLarge, count, limits.MaxFrames)
+	}
+	if pixels := int64(count) * int64(cfg.Width) * int64(cfg.Height); pixels > limits.MaxPixels {
+		return nil, fmt.Errorf("%w, %d frames of %dx%d exceeds %d pixels", ErrAnimationTooLarge, count, cfg.Width, cfg.Height, limits.MaxPixels)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Animation{
+		Frames:    make([]image.Image, len(g.Image)),
+		Delays:    append([]int(nil), g.Delay...),
+		Disposal:  append([]byte(nil), g.Disposal...),
+		LoopCount: g.LoopCount,
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		if g.Disposal[i] == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		a.Frames[i] = cloneRGBA(canvas)
+
+		switch g.Disposal[i] {
+		case gif.DisposalBackground:
+			// viewers clear to transparent rather than the background colour
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return a, nil
+}
+
+// IsAnimatedGIF reports whether data is a GIF with more than one frame.
+func IsAnimatedGIF(data []byte) bool {
+	count, err := gifFrameCount(data)
+	return err == nil && count > 1
+}
+
+// Bounds returns the bounds of the first frame.
+func (a *Animation) Bounds() image.Rectangle {
+	if len(a.Frames) == 0 {
+		return image.Rectangle{}
+	}
+	return a.Frames[0].Bounds()
+}
+
+// Transform returns the animation with every frame transformed by action,
+// keeping the timing, disposal and loop count. Every transformed frame must
+// have the same size.
+func (a *Animation) Transform(action ImageAction) (*Animation, error) {
+	return a.TransformContext(context.Background(), action)
+}
+
+// TransformContext is Transform stopping with ctx.Err() once ctx is done.
+func (a *Animation) TransformContext(ctx context.Context, action ImageAction) (*Animation, error) {
+	contextAction := ActionWithContext(action)
+	out := &Animation{
+		Frames:    make([]image.Image, len(a.Frames)),
+		Delays:    append([]int(nil), a.Delays...),
+		Disposal:  append([]byte(nil), a.Disposal...),
+		LoopCount: a.LoopCount,
+	}
+	for i, frame := range a.Frames {
+		transformed, err := contextAction.TransformContext(ctx, frame)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d : %w", i, err)
+		}
+		if i > 0 && transformed.Bounds().Size() != out.Frames[0].Bounds().Size() {
+			return nil, fmt.Errorf("frame %d is %v, expected %v like the first frame", i, transformed.Bounds().Size(), out.Frames[0].Bounds().Size())
+		}
+		out.Frames[i] = transformed
+	}
+	return out, nil
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+// gifFrameCount counts the image descriptors of a GIF by walking its blocks,
+// without decompressing any of them.
+func gifFrameCount(data []byte) (int, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0, errors.New("gif: not a gif image")
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	frames := 0
+	for pos < len(data) {
+		var err error
+		switch data[pos] {
+		case 0x21:
+			// extension introducer and label, then data sub-blocks
+			pos, err = skipGIFSubBlocks(data, pos+2)
+		case 0x2c:
+			// image descriptor, optional local colour table, lzw minimum
+			// code size, then data sub-blocks
+			if pos+10 > len(data) {
+				return 0, errGIFTruncated
+			}
+			if flags := data[pos+9]; flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos, err = skipGIFSubBlocks(data, pos+11)
+			frames++
+		case 0x3b:
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("gif: unknown block type 0x%02x", data[pos])
+		}
+		if err != nil {
+			return 0, errGIFTruncated
+		}
+	}
+	// the trailer is missing, which decoders tolerate
+	return frames, nil
+}
+
+// skipGIFSubBlocks returns the position after the data sub-blocks starting at
+// pos.
+func skipGIFSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		n := int(data[pos])
+		pos += n + 1
+		if n == 0 {
+			return pos, nil
+		}
+	}
+}
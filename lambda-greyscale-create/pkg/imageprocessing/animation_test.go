@@ -0,0 +1,139 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+var (
+	frameRed   = color.RGBA{R: 0xff, A: 0xff}
+	frameGreen = color.RGBA{G: 0xff, A: 0xff}
+)
+
+// twoFrameGIF returns a 4x4 gif of a red frame, then a green frame over its
+// left half disposed of with disposal.
+func twoFrameGIF(t *testing.T, disposal byte) []byte {
+	t.Helper()
+	p := color.Palette{color.RGBA{}, frameRed, frameGreen}
+	first := image.NewPaletted(image.Rect(0, 0, 4, 4), p)
+	for i := range first.Pix {
+		first.Pix[i] = 1
+	}
+	second := image.NewPaletted(image.Rect(0, 0, 2, 4), p)
+	for i := range second.Pix {
+		second.Pix[i] = 2
+	}
+	g := &gif.GIF{
+		Image:    []*image.Paletted{first, second},
+		Delay:    []int{5, 7},
+		Disposal: []byte{gif.DisposalNone, disposal},
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeAnimation(t *testing.T) {
+	a, err := DecodeAnimation(twoFrameGIF(t, gif.DisposalBackground), DefaultAnimationLimits)
+	if err != nil {
+		t.Fatalf("DecodeAnimation() error = %v", err)
+	}
+	if len(a.Frames) != 2 || a.Delays[1] != 7 || a.Disposal[1] != gif.DisposalBackground {
+		t.Fatalf("decoded %d frames, delays %v, disposal %v", len(a.Frames), a.Delays, a.Disposal)
+	}
+	// the second frame is drawn over the first, so it is whole
+	second := toRGBA(a.Frames[1])
+	if second.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Fatalf("second frame bounds = %v, want the whole canvas", second.Bounds())
+	}
+	if left, right := second.RGBAAt(0, 0), second.RGBAAt(3, 0); left != frameGreen || right != frameRed {
+		t.Errorf("second frame = %v on the left and %v on the right, want green over red", left, right)
+	}
+
+	if !IsAnimatedGIF(twoFrameGIF(t, gif.DisposalNone)) {
+		t.Error("IsAnimatedGIF() = false for two frames")
+	}
+	var single bytes.Buffer
+	if err := gif.Encode(&single, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{frameRed}), nil); err != nil {
+		t.Fatal(err)
+	}
+	if IsAnimatedGIF(single.Bytes()) || IsAnimatedGIF([]byte("GIF89a")) {
+		t.Error("IsAnimatedGIF() = true for a single frame or a truncated gif")
+	}
+}
+
+func TestDecodeAnimationLimits(t *testing.T) {
+	data := twoFrameGIF(t, gif.DisposalNone)
+	for name, limits := range map[string]AnimationLimits{
+		"frames": {MaxFrames: 1, MaxPixels: 1000},
+		"pixels": {MaxFrames: 10, MaxPixels: 31},
+	} {
+		if _, err := DecodeAnimation(data, limits); !errors.Is(err, ErrAnimationTooLarge) {
+			t.Errorf("DecodeAnimation() over the %s limit error = %v, want ErrAnimationTooLarge", name, err)
+		}
+	}
+	if _, err := DecodeAnimation(data, AnimationLimits{MaxFrames: 2, MaxPixels: 32}); err != nil {
+		t.Errorf("DecodeAnimation() at the limits error = %v", err)
+	}
+}
+
+func TestEncodeAnimation(t *testing.T) {
+	// the left half of the frame is cleared to transparent
+	frame := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 4; x < 8; x++ {
+			frame.SetNRGBA(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+	a := &Animation{
+		Frames:    []image.Image{frame, frame},
+		Delays:    []int{3, 9},
+		Disposal:  []byte{gif.DisposalPrevious, gif.DisposalBackground},
+		LoopCount: -1,
+	}
+
+	var b bytes.Buffer
+	if err := NewGIFEncoder(16).(AnimationEncoder).EncodeAnimation(&b, a); err != nil {
+		t.Fatalf("EncodeAnimation() error = %v", err)
+	}
+	g, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatalf("decoding animation : %v", err)
+	}
+	if len(g.Image) != 2 || g.Delay[1] != 9 || g.Disposal[0] != gif.DisposalPrevious || g.LoopCount != -1 {
+		t.Fatalf("encoded %d frames, delays %v, disposal %v, loop count %d", len(g.Image), g.Delay, g.Disposal, g.LoopCount)
+	}
+	for i, img := range g.Image {
+		if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("frame %d cleared pixel is opaque", i)
+		}
+		if c := toRGBA(img).RGBAAt(7, 0); c != frameRed {
+			t.Errorf("frame %d opaque pixel = %v, want red", i, c)
+		}
+	}
+}
+
+func TestAnimationTransform(t *testing.T) {
+	a, err := DecodeAnimation(twoFrameGIF(t, gif.DisposalNone), DefaultAnimationLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.Transform(NewActionGreyScale())
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if len(got.Frames) != 2 || got.Delays[1] != 7 {
+		t.Fatalf("transformed %d frames with delays %v, want the 2 of the source", len(got.Frames), got.Delays)
+	}
+	for i, frame := range got.Frames {
+		if !isGrey(frame) {
+			t.Errorf("frame %d is not grey", i)
+		}
+	}
+}
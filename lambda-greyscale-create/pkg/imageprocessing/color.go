@@ -0,0 +1,50 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// ParseColor parses a hex colour written as "#rgb", "#rrggbb" or
+// "#rrggbbaa", the leading "#" is optional.
+func ParseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q, expected a hex colour such as #a0522d", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// Color reads a colour parameter accepted by ParseColor, returning def when
+// it is not set.
+func (r *ParamReader) Color(name string, def color.NRGBA) color.NRGBA {
+	if !r.Has(name) {
+		r.lookup(name)
+		return def
+	}
+	c, err := ParseColor(r.String(name, ""))
+	if err != nil {
+		r.Fail(name, "%v", err)
+		return def
+	}
+	return c
+}
+
+// RequiredColor reads a colour parameter that must be set.
+func (r *ParamReader) RequiredColor(name string) color.NRGBA {
+	if !r.Has(name) {
+		r.lookup(name)
+		r.Fail(name, "is required")
+		return color.NRGBA{}
+	}
+	return r.Color(name, color.NRGBA{})
+}
@@ -0,0 +1,80 @@
+package imageprocessing
+
+import (
+	"context"
+	"image"
+)
+
+// ContextImageAction is an ImageAction that can be cancelled. TransformContext
+// stops early and returns ctx.Err() once ctx is done.
+type ContextImageAction interface {
+	ImageAction
+	TransformContext(context.Context, image.Image) (image.Image, error)
+}
+
+// ContextProcessorPipeline is a ProcessorPipeline that can be cancelled
+// between, and within, its actions.
+type ContextProcessorPipeline interface {
+	ProcessorPipeline
+	TransformContext(context.Context, image.Image) (image.Image, error)
+}
+
+// ActionWithContext returns action as a ContextImageAction. Actions without
+// their own TransformContext are wrapped to check ctx before and after
+// running, they cannot be stopped part of the way through.
+func ActionWithContext(action ImageAction) ContextImageAction {
+	if a, ok := action.(ContextImageAction); ok {
+		return a
+	}
+	return contextAction{action: action}
+}
+
+// PipelineWithContext returns pipeline as a ContextProcessorPipeline,
+// wrapping pipelines without their own TransformContext in the same way as
+// ActionWithContext.
+func PipelineWithContext(pipeline ProcessorPipeline) ContextProcessorPipeline {
+	if p, ok := pipeline.(ContextProcessorPipeline); ok {
+		return p
+	}
+	return contextPipeline{ProcessorPipeline: pipeline}
+}
+
+type contextAction struct {
+	action ImageAction
+}
+
+var _ ContextImageAction = contextAction{}
+
+func (a contextAction) Transform(img image.Image) (image.Image, error) {
+	return a.action.Transform(img)
+}
+
+func (a contextAction) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	return transformChecked(ctx, a.action, img)
+}
+
+type contextPipeline struct {
+	ProcessorPipeline
+}
+
+var _ ContextProcessorPipeline = contextPipeline{}
+
+func (p contextPipeline) TransformContext(ctx context.Context, img image.Image) (image.Image, error) {
+	return transformChecked(ctx, p.ProcessorPipeline, img)
+}
+
+// transformChecked runs action unless ctx is already done, and discards its
+// result if ctx finished while it ran.
+func transformChecked(ctx context.Context, action ImageAction, img image.Image) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	out, err := action.Transform(img)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
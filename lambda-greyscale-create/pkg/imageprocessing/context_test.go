@@ -0,0 +1,152 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countdownContext is done once Err has been called more than n times, so a
+// test can cancel part of the way through an action.
+type countdownContext struct {
+	context.Context
+	calls int64
+	n     int64
+}
+
+func (c *countdownContext) Err() error {
+	if atomic.AddInt64(&c.calls, 1) > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestParallelRows(t *testing.T) {
+	const height = 10000
+	var mu sync.Mutex
+	seen := make([]int, height)
+	parallelRows(context.Background(), height, func(y0, y1 int) {
+		mu.Lock()
+		defer mu.Unlock()
+		for y := y0; y < y1; y++ {
+			seen[y]++
+		}
+	})
+	for y, n := range seen {
+		if n != 1 {
+			t.Fatalf("row %d ran %d times, want once", y, n)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bands := int64(0)
+	parallelRows(ctx, height, func(y0, y1 int) { atomic.AddInt64(&bands, 1) })
+	if bands != 0 {
+		t.Errorf("ran %d bands after ctx was done, want none", bands)
+	}
+
+	// bands not yet started are skipped once ctx is done part of the way
+	countdown := &countdownContext{Context: context.Background(), n: 3}
+	bands = 0
+	parallelRows(countdown, height, func(y0, y1 int) { atomic.AddInt64(&bands, 1) })
+	if all := int64(height / maxRowsPerBand); bands >= all {
+		t.Errorf("ran %d bands of %d after ctx was done, want it to stop", bands, all)
+	}
+}
+
+func TestActionsCancelled(t *testing.T) {
+	src := colourSource()
+	specs := []ActionSpec{
+		{Name: "greyscale"},
+		{Name: "resize", Params: Params{"width": 50}},
+		{Name: "smart_crop", Params: Params{"width": 40, "height": 40}},
+		{Name: "rotate", Params: Params{"degrees": 90}},
+		{Name: "brightness", Params: Params{"amount": 0.2}},
+		{Name: "equalize", Params: Params{"method": "clahe"}},
+		{Name: "gaussian_blur", Params: Params{"sigma": 2}},
+		{Name: "unsharp_mask"},
+		{Name: "sobel"},
+		{Name: "threshold"},
+		{Name: "dither"},
+		{Name: "tint", Params: Params{"color": "#336699"}},
+	}
+	for _, spec := range specs {
+		t.Run(spec.Name, func(t *testing.T) {
+			action, err := NewAction(spec)
+			if err != nil {
+				t.Fatalf("NewAction() error = %v", err)
+			}
+			if _, ok := action.(ContextImageAction); !ok {
+				t.Fatalf("%T has no TransformContext", action)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := ActionWithContext(action).TransformContext(ctx, src); !errors.Is(err, context.Canceled) {
+				t.Errorf("TransformContext() with ctx done error = %v, want ctx.Err()", err)
+			}
+
+			// done once the action has started
+			countdown := &countdownContext{Context: context.Background(), n: 1}
+			if _, err := ActionWithContext(action).TransformContext(countdown, src); !errors.Is(err, context.Canceled) {
+				t.Errorf("TransformContext() with ctx done part way error = %v, want ctx.Err()", err)
+			}
+		})
+	}
+}
+
+// cancellingAction cancels a context when it runs.
+type cancellingAction struct {
+	cancel context.CancelFunc
+}
+
+func (a cancellingAction) Transform(img image.Image) (image.Image, error) {
+	a.cancel()
+	return img, nil
+}
+
+// countingAction counts the times it runs.
+type countingAction struct {
+	runs *int
+}
+
+func (a countingAction) Transform(img image.Image) (image.Image, error) {
+	*a.runs++
+	return img, nil
+}
+
+func TestPipelineCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := 0
+	pipeline := NewProcessorPipeline()
+	pipeline.AddAction(NewActionGreyScale())
+	pipeline.AddAction(cancellingAction{cancel: cancel})
+	pipeline.AddAction(countingAction{runs: &runs})
+
+	img, err := PipelineWithContext(pipeline).TransformContext(ctx, colourSource())
+	if !errors.Is(err, context.Canceled) || img != nil {
+		t.Errorf("TransformContext() = %v, %v, want no image and ctx.Err()", img, err)
+	}
+	if runs != 0 {
+		t.Errorf("the action after the cancellation ran %d times, want none", runs)
+	}
+
+	// animations stop between frames
+	a := &Animation{Frames: []image.Image{colourSource(), colourSource()}, Delays: []int{1, 1}, Disposal: []byte{0, 0}}
+	ctx, cancel = context.WithCancel(context.Background())
+	runs = 0
+	second := NewProcessorPipeline()
+	second.AddAction(countingAction{runs: &runs})
+	second.AddAction(cancellingAction{cancel: cancel})
+	if _, err := a.TransformContext(ctx, second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Animation.TransformContext() error = %v, want ctx.Err()", err)
+	}
+	if runs != 1 {
+		t.Errorf("ran %d frames, want the first only", runs)
+	}
+}
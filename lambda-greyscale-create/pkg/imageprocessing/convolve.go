@@ -0,0 +1,314 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// EdgeMode selects the pixels a kernel reads where it overhangs the image.
+type EdgeMode int
+
+const (
+	// EdgeClamp repeats the outermost row or column.
+	EdgeClamp EdgeMode = iota
+	// EdgeWrap reads from the opposite side, as if the image were tiled.
+	EdgeWrap
+	// EdgeMirror reflects the image about its outermost row or column.
+	EdgeMirror
+)
+
+var edgeModeNames = map[EdgeMode]string{
+	EdgeClamp:  "clamp",
+	EdgeWrap:   "wrap",
+	EdgeMirror: "mirror",
+}
+
+func (m EdgeMode) String() string {
+	if name, ok := edgeModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("EdgeMode(%d)", int(m))
+}
+
+// ParseEdgeMode returns the edge mode with the given name.
+func ParseEdgeMode(name string) (EdgeMode, error) {
+	for m, n := range edgeModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown edge mode %q, expected clamp, wrap or mirror", name)
+}
+
+// index maps a possibly out of range coordinate along an axis of n pixels to
+// the pixel it reads.
+func (m EdgeMode) index(i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch m {
+	case EdgeWrap:
+		return ((i % n) + n) % n
+	case EdgeMirror:
+		if n == 1 {
+			return 0
+		}
+		period := 2 * (n - 1)
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - i
+		}
+		return i
+	default:
+		if i < 0 {
+			return 0
+		}
+		return n - 1
+	}
+}
+
+// maxKernelSize bounds the width and height of kernels, separable kernels
+// are applied one axis at a time so are bounded by maxSeparableKernelSize.
+const (
+	maxKernelSize          = 15
+	maxSeparableKernelSize = 201
+)
+
+// Kernel is a convolution kernel of odd width and height, centred on the
+// pixel being computed. Weights are stored row by row.
+type Kernel struct {
+	Width, Height int
+	Weights       []float64
+}
+
+// NewKernel returns the kernel with the given rows of weights.
+func NewKernel(rows [][]float64) (Kernel, error) {
+	if len(rows) == 0 || len(rows)%2 == 0 {
+		return Kernel{}, fmt.Errorf("a kernel needs an odd number of rows, got %d", len(rows))
+	}
+	k := Kernel{Width: len(rows[0]), Height: len(rows)}
+	if k.Width%2 == 0 {
+		return Kernel{}, fmt.Errorf("a kernel needs an odd number of columns, got %d", k.Width)
+	}
+	for i, row := range rows {
+		if len(row) != k.Width {
+			return Kernel{}, fmt.Errorf("kernel row %d has %d columns, expected %d", i+1, len(row), k.Width)
+		}
+		k.Weights = append(k.Weights, row...)
+	}
+	return k, nil
+}
+
+// Sum returns the sum of the weights.
+func (k Kernel) Sum() float64 {
+	sum := 0.0
+	for _, w := range k.Weights {
+		sum += w
+	}
+	return sum
+}
+
+// Normalize returns the kernel scaled so its weights sum to 1, so it keeps
+// the brightness of an image. Kernels summing to zero are returned as they
+// are.
+func (k Kernel) Normalize() Kernel {
+	sum := k.Sum()
+	if math.Abs(sum) < 1e-9 {
+		return k
+	}
+	weights := make([]float64, len(k.Weights))
+	for i, w := range k.Weights {
+		weights[i] = w / sum
+	}
+	return Kernel{Width: k.Width, Height: k.Height, Weights: weights}
+}
+
+func (k Kernel) validate(maxSize int) error {
+	switch {
+	case k.Width%2 == 0 || k.Height%2 == 0:
+		return fmt.Errorf("kernel size %dx%d is not odd", k.Width, k.Height)
+	case k.Width > maxSize || k.Height > maxSize:
+		return fmt.Errorf("kernel size %dx%d exceeds %dx%d", k.Width, k.Height, maxSize, maxSize)
+	case len(k.Weights) != k.Width*k.Height:
+		return errors.New("kernel weights do not match its size")
+	}
+	return nil
+}
+
+// BoxKernel returns the 1D kernel averaging the 2 * radius + 1 pixels around
+// a pixel.
+func BoxKernel(radius int) []float64 {
+	weights := make([]float64, 2*radius+1)
+	for i := range weights {
+		weights[i] = 1 / float64(len(weights))
+	}
+	return weights
+}
+
+// GaussianKernel returns the normalized 1D gaussian kernel of sigma, cut off
+// at three standard deviations.
+func GaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	if radius < 1 {
+		radius = 1
+	}
+	weights := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range weights {
+		d := float64(i - radius)
+		weights[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return weights
+}
+
+// Convolve returns img convolved with k, reading pixels past the borders as
+// edge selects. Kernels summing to 1, such as blurs, filter all four
+// channels and so also soften transparent edges, any other kernel only
+// filters colour and each pixel keeps its alpha.
+func Convolve(img image.Image, k Kernel, edge EdgeMode) (*image.RGBA, error) {
+	return convolve(context.Background(), img, k, edge)
+}
+
+func convolve(ctx context.Context, img image.Image, k Kernel, edge EdgeMode) (*image.RGBA, error) {
+	if err := k.validate(maxKernelSize); err != nil {
+		return nil, err
+	}
+	dst := convolveRGBA(ctx, toRGBA(img), k, edge, math.Abs(k.Sum()-1) > 1e-6)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// ConvolveSeparable returns img convolved with the kernel that is the outer
+// product of vertical and horizontal, applied as a horizontal then a
+// vertical pass so the cost grows with the kernel width rather than its
+// area. Each pass filters alpha or keeps it as Convolve would for that
+// pass's own kernel.
+func ConvolveSeparable(img image.Image, horizontal, vertical []float64, edge EdgeMode) (*image.RGBA, error) {
+	return convolveSeparable(context.Background(), img, horizontal, vertical, edge)
+}
+
+func convolveSeparable(ctx context.Context, img image.Image, horizontal, vertical []float64, edge EdgeMode) (*image.RGBA, error) {
+	h := Kernel{Width: len(horizontal), Height: 1, Weights: horizontal}
+	v := Kernel{Width: 1, Height: len(vertical), Weights: vertical}
+	if err := h.validate(maxSeparableKernelSize); err != nil {
+		return nil, err
+	}
+	if err := v.validate(maxSeparableKernelSize); err != nil {
+		return nil, err
+	}
+	dst := convolveRGBA(ctx, toRGBA(img), h, edge, math.Abs(h.Sum()-1) > 1e-6)
+	dst = convolveRGBA(ctx, dst, v, edge, math.Abs(v.Sum()-1) > 1e-6)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// convolveRGBA convolves the colour channels of src with k, and the alpha
+// channel too unless keepAlpha is set. Colour channels are capped at alpha
+// afterwards, so kernels with negative weights still produce valid
+// premultiplied pixels.
+func convolveRGBA(ctx context.Context, src *image.RGBA, k Kernel, edge EdgeMode, keepAlpha bool) *image.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	colOffsets := edgeOffsets(w, k.Width, edge, 4)
+
+	parallelRows(ctx, h, func(y0, y1 int) {
+		rows := make([][]uint8, k.Height)
+		for y := y0; y < y1; y++ {
+			for ky := range rows {
+				sy := edge.index(y+ky-k.Height/2, h)
+				rows[ky] = src.Pix[sy*src.Stride:]
+			}
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < w; x++ {
+				cols := colOffsets[x : x+k.Width]
+
+				var r, g, b, a float64
+				for ky, row := range rows {
+					weights := k.Weights[ky*k.Width:]
+					for kx, col := range cols {
+						wt := weights[kx]
+						if wt == 0 {
+							continue
+						}
+						p := row[col : col+4]
+						r += wt * float64(p[0])
+						g += wt * float64(p[1])
+						b += wt * float64(p[2])
+						a += wt * float64(p[3])
+					}
+				}
+
+				alpha := clampChannel(a)
+				if keepAlpha {
+					alpha = src.Pix[y*src.Stride+x*4+3]
+				}
+				p := d[x*4 : x*4+4]
+				p[0] = minChannel(clampChannel(r), alpha)
+				p[1] = minChannel(clampChannel(g), alpha)
+				p[2] = minChannel(clampChannel(b), alpha)
+				p[3] = alpha
+			}
+		}
+	})
+	return dst
+}
+
+// convolvePlane convolves a single channel plane of w x h values with k,
+// keeping the unclamped result.
+func convolvePlane(ctx context.Context, plane []float32, w, h int, k Kernel, edge EdgeMode) []float32 {
+	out := make([]float32, w*h)
+	colOffsets := edgeOffsets(w, k.Width, edge, 1)
+
+	parallelRows(ctx, h, func(y0, y1 int) {
+		rows := make([]int, k.Height)
+		for y := y0; y < y1; y++ {
+			for ky := range rows {
+				rows[ky] = edge.index(y+ky-k.Height/2, h) * w
+			}
+			for x := 0; x < w; x++ {
+				cols := colOffsets[x : x+k.Width]
+				sum := 0.0
+				for ky, row := range rows {
+					weights := k.Weights[ky*k.Width:]
+					for kx, col := range cols {
+						sum += weights[kx] * float64(plane[row+col])
+					}
+				}
+				out[y*w+x] = float32(sum)
+			}
+		}
+	})
+	return out
+}
+
+// edgeOffsets returns the offset, in units of step, of the pixel read for
+// every position a kernel of the given size reaches along an axis of n
+// pixels, so the taps for pixel x are offsets[x : x+size].
+func edgeOffsets(n, size int, edge EdgeMode, step int) []int {
+	if n == 0 {
+		return nil
+	}
+	offsets := make([]int, n+size-1)
+	for i := range offsets {
+		offsets[i] = edge.index(i-size/2, n) * step
+	}
+	return offsets
+}
+
+func minChannel(c, max uint8) uint8 {
+	if c > max {
+		return max
+	}
+	return c
+}
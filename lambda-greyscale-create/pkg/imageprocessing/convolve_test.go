@@ -0,0 +1,219 @@
+package imageprocessing
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// premultipliedRow returns a w x 1 image of the given premultiplied pixels.
+func premultipliedRow(pixels ...color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(pixels), 1))
+	for x, c := range pixels {
+		img.SetRGBA(x, 0, c)
+	}
+	return img
+}
+
+func TestEdgeModeIndex(t *testing.T) {
+	tests := []struct {
+		edge EdgeMode
+		n    int
+		// want maps coordinates to the pixel they read
+		want map[int]int
+	}{
+		{edge: EdgeClamp, n: 4, want: map[int]int{-5: 0, -1: 0, 0: 0, 3: 3, 4: 3, 9: 3}},
+		{edge: EdgeWrap, n: 4, want: map[int]int{-5: 3, -1: 3, 0: 0, 3: 3, 4: 0, 9: 1}},
+		{edge: EdgeMirror, n: 4, want: map[int]int{-5: 1, -2: 2, -1: 1, 0: 0, 3: 3, 4: 2, 6: 0, 9: 3}},
+		{edge: EdgeMirror, n: 1, want: map[int]int{-2: 0, 0: 0, 3: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.edge.String(), func(t *testing.T) {
+			for i, want := range tt.want {
+				if got := tt.edge.index(i, tt.n); got != want {
+					t.Errorf("index(%d, %d) = %d, want %d", i, tt.n, got, want)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{"clamp", "wrap", "mirror"} {
+		if m, err := ParseEdgeMode(name); err != nil || m.String() != name {
+			t.Errorf("ParseEdgeMode(%q) = %v, %v", name, m, err)
+		}
+	}
+	if _, err := ParseEdgeMode("repeat"); err == nil {
+		t.Error("ParseEdgeMode() accepted an unknown mode")
+	}
+}
+
+func TestConvolveEdges(t *testing.T) {
+	grey := func(v uint8) color.RGBA { return color.RGBA{R: v, G: v, B: v, A: 0xff} }
+	row := premultipliedRow(grey(10), grey(20), grey(30), grey(40))
+	// the kernels copy the pixel left or right of each pixel, so the ends
+	// read past the border
+	left := Kernel{Width: 3, Height: 1, Weights: []float64{1, 0, 0}}
+	right := Kernel{Width: 3, Height: 1, Weights: []float64{0, 0, 1}}
+
+	tests := []struct {
+		edge      EdgeMode
+		wantLeft  []uint8
+		wantRight []uint8
+	}{
+		{edge: EdgeClamp, wantLeft: []uint8{10, 10, 20, 30}, wantRight: []uint8{20, 30, 40, 40}},
+		{edge: EdgeWrap, wantLeft: []uint8{40, 10, 20, 30}, wantRight: []uint8{20, 30, 40, 10}},
+		{edge: EdgeMirror, wantLeft: []uint8{20, 10, 20, 30}, wantRight: []uint8{20, 30, 40, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.edge.String(), func(t *testing.T) {
+			for _, pass := range []struct {
+				kernel Kernel
+				want   []uint8
+			}{{left, tt.wantLeft}, {right, tt.wantRight}} {
+				got, err := Convolve(row, pass.kernel, tt.edge)
+				if err != nil {
+					t.Fatalf("Convolve() error = %v", err)
+				}
+				for x, want := range pass.want {
+					if c := got.RGBAAt(x, 0); c != grey(want) {
+						t.Errorf("Convolve(%v) pixel %d = %v, want %d", pass.kernel.Weights, x, c, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKernelValidation(t *testing.T) {
+	if _, err := NewKernel([][]float64{{1, 2, 1}, {2, 4, 2}, {1, 2, 1}}); err != nil {
+		t.Errorf("NewKernel() error = %v", err)
+	}
+	for name, rows := range map[string][][]float64{
+		"no rows":          nil,
+		"even rows":        {{1}, {1}},
+		"even columns":     {{1, 1}},
+		"ragged rows":      {{1, 1, 1}, {1}, {1, 1, 1}},
+		"empty single row": {{}},
+	} {
+		if _, err := NewKernel(rows); err == nil {
+			t.Errorf("NewKernel() accepted %s", name)
+		}
+	}
+
+	img := premultipliedRow(color.RGBA{A: 0xff})
+	for name, k := range map[string]Kernel{
+		"even size":          {Width: 2, Height: 1, Weights: []float64{1, 1}},
+		"larger than 15x15":  {Width: 17, Height: 1, Weights: make([]float64, 17)},
+		"mismatched weights": {Width: 3, Height: 3, Weights: []float64{1, 1, 1}},
+	} {
+		if _, err := Convolve(img, k, EdgeClamp); err == nil {
+			t.Errorf("Convolve() accepted a kernel of %s", name)
+		}
+	}
+	if _, err := ConvolveSeparable(img, BoxKernel(100), BoxKernel(100), EdgeClamp); err != nil {
+		t.Errorf("ConvolveSeparable() error = %v for the largest blur", err)
+	}
+	if _, err := ConvolveSeparable(img, BoxKernel(101), []float64{1}, EdgeClamp); err == nil {
+		t.Error("ConvolveSeparable() accepted a kernel wider than 201")
+	}
+	if _, err := ConvolveSeparable(img, []float64{1}, []float64{0.5, 0.5}, EdgeClamp); err == nil {
+		t.Error("ConvolveSeparable() accepted an even kernel")
+	}
+
+	// normalizing scales a kernel to sum to 1 but leaves a zero sum kernel
+	if sum := (Kernel{Width: 3, Height: 1, Weights: []float64{1, 2, 1}}).Normalize().Sum(); math.Abs(sum-1) > 1e-9 {
+		t.Errorf("normalized kernel sums to %g, want 1", sum)
+	}
+	if w := laplacianKernel.Normalize().Weights; w[4] != -4 {
+		t.Errorf("normalized laplacian centre = %g, want it left at -4", w[4])
+	}
+}
+
+func TestGaussianKernel(t *testing.T) {
+	for _, sigma := range []float64{0.1, 1, 2.5, 30} {
+		k := GaussianKernel(sigma)
+		radius := int(math.Max(1, math.Ceil(3*sigma)))
+		if len(k) != 2*radius+1 {
+			t.Errorf("GaussianKernel(%g) has %d weights, want %d", sigma, len(k), 2*radius+1)
+		}
+		sum := 0.0
+		for i, w := range k {
+			sum += w
+			if math.Abs(w-k[len(k)-1-i]) > 1e-12 {
+				t.Errorf("GaussianKernel(%g) is not symmetric at %d", sigma, i)
+			}
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("GaussianKernel(%g) sums to %g, want 1", sigma, sum)
+		}
+	}
+}
+
+// kernels summing to 1 filter alpha along with colour, any other kernel
+// keeps the alpha of each pixel, and colour never exceeds alpha.
+func TestConvolveAlpha(t *testing.T) {
+	half := color.RGBA{R: 100, G: 50, B: 25, A: 0x80}
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+	tests := []struct {
+		name    string
+		convert func(img image.Image) (*image.RGBA, error)
+		src     *image.RGBA
+		want    []color.RGBA
+	}{
+		{
+			name: "blur softens transparent edges",
+			convert: func(img image.Image) (*image.RGBA, error) {
+				return Convolve(img, Kernel{Width: 3, Height: 1, Weights: BoxKernel(1)}, EdgeClamp)
+			},
+			src:  premultipliedRow(white, color.RGBA{}, color.RGBA{}),
+			want: []color.RGBA{{R: 170, G: 170, B: 170, A: 170}, {R: 85, G: 85, B: 85, A: 85}, {}},
+		},
+		{
+			name: "brightening keeps alpha and is capped by it",
+			convert: func(img image.Image) (*image.RGBA, error) {
+				return Convolve(img, Kernel{Width: 1, Height: 1, Weights: []float64{2}}, EdgeClamp)
+			},
+			src:  premultipliedRow(half),
+			want: []color.RGBA{{R: 0x80, G: 100, B: 50, A: 0x80}},
+		},
+		{
+			name: "zero sum kernel keeps alpha",
+			convert: func(img image.Image) (*image.RGBA, error) {
+				return Convolve(img, Kernel{Width: 3, Height: 1, Weights: []float64{-1, 2, -1}}, EdgeClamp)
+			},
+			src:  premultipliedRow(half, white, half),
+			want: []color.RGBA{{A: 0x80}, {R: 0xff, G: 0xff, B: 0xff, A: 0xff}, {A: 0x80}},
+		},
+		{
+			name: "separable blur softens transparent edges",
+			convert: func(img image.Image) (*image.RGBA, error) {
+				return ConvolveSeparable(img, BoxKernel(1), []float64{1}, EdgeClamp)
+			},
+			src:  premultipliedRow(white, color.RGBA{}, color.RGBA{}),
+			want: []color.RGBA{{R: 170, G: 170, B: 170, A: 170}, {R: 85, G: 85, B: 85, A: 85}, {}},
+		},
+		{
+			name: "separable brightening keeps alpha in both passes",
+			convert: func(img image.Image) (*image.RGBA, error) {
+				return ConvolveSeparable(img, []float64{0, 3, 0}, []float64{0, 0.5, 0}, EdgeClamp)
+			},
+			src:  premultipliedRow(half),
+			want: []color.RGBA{{R: 0x40, G: 0x40, B: 38, A: 0x80}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.convert(tt.src)
+			if err != nil {
+				t.Fatalf("convolving error = %v", err)
+			}
+			for x, want := range tt.want {
+				if c := got.RGBAAt(x, 0); c != want {
+					t.Errorf("pixel %d = %v, want %v", x, c, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Encoder writes a processed image in a particular file format.
+type Encoder interface {
+	Encode(io.Writer, image.Image) error
+	// ContentType is the MIME type of the encoded output.
+	ContentType() string
+	// Extension is the file extension of the encoded output, including the
+	// leading dot.
+	Extension() string
+}
+
+// EncoderSpec names a registered output format and its options.
+type EncoderSpec struct {
+	Format string `json:"format" yaml:"format"`
+	Params Params `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// EncoderFactory builds an encoder from its spec parameters.
+type EncoderFactory func(params *ParamReader) (Encoder, error)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]EncoderFactory{}
+)
+
+func init() {
+	RegisterEncoder("jpeg", func(params *ParamReader) (Encoder, error) {
+		quality := params.IntRange("quality", jpeg.DefaultQuality, 1, 100)
+		progressive := params.Bool("progressive", false)
+		return NewJPEGEncoder(quality, progressive), params.Err()
+	})
+	RegisterEncoder("png", func(params *ParamReader) (Encoder, error) {
+		level := png.DefaultCompression
+		switch name := params.String("compression", "default"); name {
+		case "default":
+		case "none":
+			level = png.NoCompression
+		case "fast":
+			level = png.BestSpeed
+		case "best":
+			level = png.BestCompression
+		default:
+			params.Fail("compression", "unknown compression %q, expected default, none, fast or best", name)
+		}
+		if params.Has("colors") {
+			colors := params.IntRange("colors", 256, 2, 256)
+			return NewPalettedPNGEncoder(level, colors), params.Err()
+		}
+		return NewPNGEncoder(level), params.Err()
+	})
+	RegisterEncoder("gif", func(params *ParamReader) (Encoder, error) {
+		colors := params.IntRange("colors", 256, 2, 256)
+		return NewGIFEncoder(colors), params.Err()
+	})
+}
+
+// RegisterEncoder makes an output format available to encoder specs under
+// format, which should match the name image.Decode reports for it.
+// Registering the same format twice panics.
+func RegisterEncoder(format string, factory EncoderFactory) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if factory == nil {
+		panic("imageprocessing: RegisterEncoder factory is nil")
+	}
+	if _, dup := encoders[format]; dup {
+		panic("imageprocessing: RegisterEncoder called twice for " + format)
+	}
+	encoders[format] = factory
+}
+
+// RegisteredEncoders returns the sorted names of every registered format.
+func RegisteredEncoders() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasEncoder reports whether format can be encoded.
+func HasEncoder(format string) bool {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	_, ok := encoders[format]
+	return ok
+}
+
+// NewEncoder builds the encoder described by spec.
+func NewEncoder(spec EncoderSpec) (Encoder, error) {
+	encodersMu.RLock()
+	factory, ok := encoders[spec.Format]
+	encodersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown encoder format %q, expected one of %v", spec.Format, RegisteredEncoders())
+	}
+
+	params := NewParamReader(spec.Params)
+	encoder, err := factory(params)
+	if err != nil {
+		return nil, fmt.Errorf("encoder %q: %w", spec.Format, err)
+	}
+	if err := params.Err(); err != nil {
+		return nil, fmt.Errorf("encoder %q: %w", spec.Format, err)
+	}
+	return encoder, nil
+}
+
+type jpegEncoder struct {
+	quality     int
+	progressive bool
+}
+
+var _ Encoder = jpegEncoder{}
+
+// NewJPEGEncoder returns an encoder writing baseline, or progressive, JPEGs
+// at the given quality between 1 and 100.
+func NewJPEGEncoder(quality int, progressive bool) Encoder {
+	return &jpegEncoder{
+		quality:     quality,
+		progressive: progressive,
+	}
+}
+
+func (e jpegEncoder) Encode(w io.Writer, img image.Image) error {
+	if e.progressive {
+		return encodeProgressiveJPEG(w, img, e.quality)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: e.quality})
+}
+
+func (e jpegEncoder) ContentType() string {
+	return "image/jpeg"
+}
+
+func (e jpegEncoder) Extension() string {
+	return ".jpg"
+}
+
+type pngEncoder struct {
+	encoder png.Encoder
+	// colors is the size of the palette, 0 writes full colour
+	colors int
+}
+
+var _ Encoder = pngEncoder{}
+
+func NewPNGEncoder(level png.CompressionLevel) Encoder {
+	return &pngEncoder{
+		encoder: png.Encoder{CompressionLevel: level},
+	}
+}
+
+// NewPalettedPNGEncoder returns an encoder writing paletted PNGs with at most
+// colors palette entries, chosen as for NewGIFEncoder. Black and white
+// images with a palette of 2 are written at 1 bit per pixel.
+func NewPalettedPNGEncoder(level png.CompressionLevel, colors int) Encoder {
+	return &pngEncoder{
+		encoder: png.Encoder{CompressionLevel: level},
+		colors:  colors,
+	}
+}
+
+func (e pngEncoder) Encode(w io.Writer, img image.Image) error {
+	if e.colors > 0 {
+		return e.encoder.Encode(w, toPaletted(img, e.colors))
+	}
+	return e.encoder.Encode(w, img)
+}
+
+func (e pngEncoder) ContentType() string {
+	return "image/png"
+}
+
+func (e pngEncoder) Extension() string {
+	return ".png"
+}
+
+type gifEncoder struct {
+	colors int
+}
+
+var (
+	_ Encoder          = gifEncoder{}
+	_ AnimationEncoder = gifEncoder{}
+)
+
+// NewGIFEncoder returns an encoder writing GIFs with at most colors palette
+// entries. Greyscale images get an evenly spaced grey palette, anything else
+// is mapped onto the Plan 9 palette, or onto a palette chosen from the
+// colours of the image when colors is below 256.
+func NewGIFEncoder(colors int) Encoder {
+	return &gifEncoder{
+		colors: colors,
+	}
+}
+
+func (e gifEncoder) Encode(w io.Writer, img image.Image) error {
+	return gif.Encode(w, toPaletted(img, e.colors), &gif.Options{NumColors: e.colors})
+}
+
+// EncodeAnimation writes every frame of a, each with its own palette chosen
+// as for Encode. Transparent pixels stay transparent, so frames can be
+// disposed of as they were in the source.
+func (e gifEncoder) EncodeAnimation(w io.Writer, a *Animation) error {
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(a.Frames)),
+		Delay:     a.Delays,
+		Disposal:  a.Disposal,
+		LoopCount: a.LoopCount,
+	}
+	for i, frame := range a.Frames {
+		g.Image[i] = toPaletted(frame, e.colors)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+func (e gifEncoder) ContentType() string {
+	return "image/gif"
+}
+
+func (e gifEncoder) Extension() string {
+	return ".gif"
+}
+
+// toPaletted maps img onto a palette of at most colors entries, dithering
+// where the palette falls short. Greyscale images get an evenly spaced grey
+// palette. Anything else is mapped onto the Plan 9 palette when it may use
+// all of it, or onto a palette chosen from its own colours when it has fewer
+// entries, as the first entries of Plan 9 are nearly all dark. When img is
+// not opaque the last entry is reserved for the pixels that are mostly
+// transparent, as the palettes themselves are opaque.
+func toPaletted(img image.Image, colors int) *image.Paletted {
+	transparent := colors >= 3 && !isOpaque(img)
+	if transparent {
+		colors--
+	}
+
+	var p color.Palette
+	switch {
+	case isGrey(img):
+		p = greyPalette(colors)
+	case colors >= len(palette.Plan9):
+		p = palette.Plan9
+	default:
+		p = medianCutPalette(img, colors)
+	}
+
+	b := img.Bounds()
+	paletted := image.NewPaletted(b, p)
+	draw.FloydSteinberg.Draw(paletted, b, img, b.Min)
+	if !transparent {
+		return paletted
+	}
+
+	paletted.Palette = append(p[:len(p):len(p)], color.RGBA{})
+	index := uint8(len(paletted.Palette) - 1)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < 0x8000 {
+				paletted.Pix[paletted.PixOffset(x, y)] = index
+			}
+		}
+	}
+	return paletted
+}
+
+// isOpaque reports whether every pixel of img is fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func greyPalette(n int) color.Palette {
+	p := make(color.Palette, n)
+	for i := range p {
+		v := uint8(i * 0xff / (n - 1))
+		p[i] = color.RGBA{R: v, G: v, B: v, A: 0xff}
+	}
+	return p
+}
+
+// isGrey reports whether every pixel of img has equal colour channels.
+func isGrey(img image.Image) bool {
+	switch i := img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	case *image.RGBA:
+		b := i.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := i.Pix[i.PixOffset(b.Min.X, y):i.PixOffset(b.Max.X, y)]
+			for x := 0; x < len(row); x += 4 {
+				if row[x] != row[x+1] || row[x] != row[x+2] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			if r != g || r != bl {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,341 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+// colourSource returns an image sweeping through hues left to right and
+// from dark to light top to bottom.
+func colourSource() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 120, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			// six segments of the hue circle, 20 pixels each
+			f := uint8(x % 20 * 255 / 19)
+			var r, g, b uint8
+			switch x / 20 {
+			case 0:
+				r, g, b = 255, f, 0
+			case 1:
+				r, g, b = 255-f, 255, 0
+			case 2:
+				r, g, b = 0, 255, f
+			case 3:
+				r, g, b = 0, 255-f, 255
+			case 4:
+				r, g, b = f, 0, 255
+			default:
+				r, g, b = 255, 0, 255-f
+			}
+			shade := func(v uint8) uint8 { return uint8(int(v) * (y + 30) / 119) }
+			img.SetRGBA(x, y, color.RGBA{R: shade(r), G: shade(g), B: shade(b), A: 0xff})
+		}
+	}
+	return img
+}
+
+// sceneSource returns an image of a sky over grass, the few smooth colour
+// ranges of a typical photo.
+func sceneSource() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 120, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			c := color.RGBA{R: uint8(90 + y), G: uint8(150 + y), B: 235, A: 0xff}
+			if y >= 50 {
+				c = color.RGBA{R: uint8(40 + x/4), G: uint8(110 + (y-50)*2), B: 40, A: 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// meanLuma returns the mean brightness of img, from 0 to 255.
+func meanLuma(img image.Image) float64 {
+	var sum float64
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+	return sum / float64(b.Dx()*b.Dy())
+}
+
+// meanError returns the mean difference of the colour channels of a and b,
+// from 0 to 255.
+func meanError(a, b image.Image) float64 {
+	var sum, n float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := color.NRGBAModel.Convert(a.At(x, y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(x, y)).(color.NRGBA)
+			for _, d := range []int{int(ca.R) - int(cb.R), int(ca.G) - int(cb.G), int(ca.B) - int(cb.B)} {
+				if d < 0 {
+					d = -d
+				}
+				sum += float64(d)
+				n++
+			}
+		}
+	}
+	return sum / n
+}
+
+// halfTransparent returns colourSource with its left half cleared.
+func halfTransparent() *image.RGBA {
+	img := colourSource()
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 60; x++ {
+			img.SetRGBA(x, y, color.RGBA{})
+		}
+	}
+	return img
+}
+
+func TestNewEncoder(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        EncoderSpec
+		contentType string
+		extension   string
+		wantErr     bool
+	}{
+		{name: "jpeg", spec: EncoderSpec{Format: "jpeg", Params: Params{"quality": 80, "progressive": true}}, contentType: "image/jpeg", extension: ".jpg"},
+		{name: "png", spec: EncoderSpec{Format: "png", Params: Params{"compression": "best"}}, contentType: "image/png", extension: ".png"},
+		{name: "gif", spec: EncoderSpec{Format: "gif", Params: Params{"colors": 16}}, contentType: "image/gif", extension: ".gif"},
+		{name: "unknown format", spec: EncoderSpec{Format: "bmp"}, wantErr: true},
+		{name: "quality out of range", spec: EncoderSpec{Format: "jpeg", Params: Params{"quality": 101}}, wantErr: true},
+		{name: "unknown compression", spec: EncoderSpec{Format: "png", Params: Params{"compression": "tiny"}}, wantErr: true},
+		{name: "too few colours", spec: EncoderSpec{Format: "gif", Params: Params{"colors": 1}}, wantErr: true},
+		{name: "unknown parameter", spec: EncoderSpec{Format: "gif", Params: Params{"loops": 1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder, err := NewEncoder(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewEncoder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := encoder.ContentType(); got != tt.contentType {
+				t.Errorf("ContentType() = %q, want %q", got, tt.contentType)
+			}
+			if got := encoder.Extension(); got != tt.extension {
+				t.Errorf("Extension() = %q, want %q", got, tt.extension)
+			}
+
+			var b bytes.Buffer
+			if err := encoder.Encode(&b, colourSource()); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			decoded, format, err := image.Decode(&b)
+			if err != nil {
+				t.Fatalf("decoding output : %v", err)
+			}
+			if format != tt.spec.Format {
+				t.Errorf("decoded format = %q, want %q", format, tt.spec.Format)
+			}
+			if decoded.Bounds() != colourSource().Bounds() {
+				t.Errorf("decoded bounds = %v, want %v", decoded.Bounds(), colourSource().Bounds())
+			}
+		})
+	}
+}
+
+func TestEncodeTransparency(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoder Encoder
+		// colors is the palette size the transparent entry counts against
+		colors int
+		maxErr float64
+	}{
+		{name: "png", encoder: NewPNGEncoder(png.DefaultCompression), maxErr: 0},
+		{name: "gif", encoder: NewGIFEncoder(256), colors: 256, maxErr: 6},
+		{name: "gif 16 colours", encoder: NewGIFEncoder(16), colors: 16, maxErr: 22},
+		{name: "paletted png", encoder: NewPalettedPNGEncoder(png.DefaultCompression, 256), colors: 256, maxErr: 6},
+		{name: "paletted png 16 colours", encoder: NewPalettedPNGEncoder(png.DefaultCompression, 16), colors: 16, maxErr: 22},
+		{name: "paletted png 4 colours", encoder: NewPalettedPNGEncoder(png.DefaultCompression, 4), colors: 4, maxErr: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := halfTransparent()
+			var b bytes.Buffer
+			if err := tt.encoder.Encode(&b, src); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			decoded, _, err := image.Decode(&b)
+			if err != nil {
+				t.Fatalf("decoding output : %v", err)
+			}
+			if paletted, ok := decoded.(*image.Paletted); ok && len(paletted.Palette) > tt.colors {
+				t.Errorf("palette has %d colours, want at most %d", len(paletted.Palette), tt.colors)
+			}
+			for _, p := range []image.Point{{0, 0}, {30, 45}, {59, 89}} {
+				if _, _, _, a := decoded.At(p.X, p.Y).RGBA(); a != 0 {
+					t.Errorf("cleared pixel %v has alpha %#x, want transparent", p, a)
+				}
+			}
+			for _, p := range []image.Point{{60, 0}, {90, 45}, {119, 89}} {
+				if _, _, _, a := decoded.At(p.X, p.Y).RGBA(); a != 0xffff {
+					t.Errorf("opaque pixel %v has alpha %#x, want opaque", p, a)
+				}
+			}
+			opaque := image.Rect(60, 0, 120, 90)
+			if e := meanError(src.SubImage(opaque), decoded); e > tt.maxErr {
+				t.Errorf("mean colour error of the opaque half = %.1f, want at most %.1f", e, tt.maxErr)
+			}
+		})
+	}
+}
+
+func TestEncodeGreyTransparency(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if x >= 4 {
+				src.SetNRGBA(x, y, color.NRGBA{R: 200, G: 200, B: 200, A: 0xff})
+			}
+		}
+	}
+
+	paletted := toPaletted(src, 16)
+	if len(paletted.Palette) > 16 {
+		t.Fatalf("palette has %d colours, want at most 16", len(paletted.Palette))
+	}
+	if _, _, _, a := paletted.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("cleared pixel has alpha %#x, want transparent", a)
+	}
+	if got := color.GrayModel.Convert(paletted.At(6, 6)).(color.Gray).Y; got < 190 || got > 210 {
+		t.Errorf("grey pixel = %d, want about 200", got)
+	}
+}
+
+func TestPalettedColourError(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     image.Image
+		encoder Encoder
+		colors  int
+		maxErr  float64
+	}{
+		{name: "png 4 colours, hues", src: colourSource(), encoder: NewPalettedPNGEncoder(png.DefaultCompression, 4), colors: 4, maxErr: 45},
+		{name: "png 16 colours, hues", src: colourSource(), encoder: NewPalettedPNGEncoder(png.DefaultCompression, 16), colors: 16, maxErr: 26},
+		{name: "png 64 colours, hues", src: colourSource(), encoder: NewPalettedPNGEncoder(png.DefaultCompression, 64), colors: 64, maxErr: 13},
+		{name: "gif 16 colours, hues", src: colourSource(), encoder: NewGIFEncoder(16), colors: 16, maxErr: 26},
+		{name: "gif 256 colours, hues", src: colourSource(), encoder: NewGIFEncoder(256), colors: 256, maxErr: 12},
+		{name: "png 4 colours, scene", src: sceneSource(), encoder: NewPalettedPNGEncoder(png.DefaultCompression, 4), colors: 4, maxErr: 13},
+		{name: "png 16 colours, scene", src: sceneSource(), encoder: NewPalettedPNGEncoder(png.DefaultCompression, 16), colors: 16, maxErr: 4},
+		{name: "gif 16 colours, scene", src: sceneSource(), encoder: NewGIFEncoder(16), colors: 16, maxErr: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			var b bytes.Buffer
+			if err := tt.encoder.Encode(&b, src); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			decoded, _, err := image.Decode(&b)
+			if err != nil {
+				t.Fatalf("decoding output : %v", err)
+			}
+			paletted, ok := decoded.(*image.Paletted)
+			if !ok {
+				t.Fatalf("decoded %T, want a paletted image", decoded)
+			}
+			if len(paletted.Palette) > tt.colors {
+				t.Errorf("palette has %d colours, want at most %d", len(paletted.Palette), tt.colors)
+			}
+			if e := meanError(src, decoded); e > tt.maxErr {
+				t.Errorf("mean colour error = %.1f, want at most %.1f", e, tt.maxErr)
+			}
+			if got, want := meanLuma(decoded), meanLuma(src); got < want-5 || got > want+5 {
+				t.Errorf("mean brightness = %.1f, want about %.1f", got, want)
+			}
+		})
+	}
+}
+
+func TestPalettedBeatsPlan9Prefix(t *testing.T) {
+	for _, src := range []*image.RGBA{colourSource(), sceneSource()} {
+		plan9 := image.NewPaletted(src.Bounds(), palette.Plan9[:16])
+		draw.FloydSteinberg.Draw(plan9, src.Bounds(), src, image.Point{})
+
+		got := toPaletted(src, 16)
+		if e, plan9Err := meanError(src, got), meanError(src, plan9); e > plan9Err*3/4 {
+			t.Errorf("mean colour error = %.1f, want well under the %.1f of the first 16 Plan 9 colours", e, plan9Err)
+		}
+	}
+}
+
+func TestMedianCutPaletteExactColours(t *testing.T) {
+	colours := []color.RGBA{{R: 200, G: 10, B: 10, A: 0xff}, {R: 10, G: 200, B: 10, A: 0xff}, {R: 10, G: 10, B: 200, A: 0xff}}
+	img := image.NewRGBA(image.Rect(0, 0, 30, 10))
+	for x := 0; x < 30; x++ {
+		for y := 0; y < 10; y++ {
+			img.SetRGBA(x, y, colours[x/10])
+		}
+	}
+
+	p := medianCutPalette(img, 8)
+	if len(p) != len(colours) {
+		t.Fatalf("palette = %v, want the %d colours of the image", p, len(colours))
+	}
+	for _, c := range colours {
+		if p[p.Index(c)] != c {
+			t.Errorf("palette %v is missing %v", p, c)
+		}
+	}
+	if e := meanError(img, toPaletted(img, 8)); e != 0 {
+		t.Errorf("mean colour error = %.1f, want 0", e)
+	}
+}
+
+// mostly transparent pixels take no part in choosing a median cut palette
+// and are mapped to the transparent entry after it.
+func TestMedianCutPaletteTransparency(t *testing.T) {
+	colours := []color.NRGBA{{R: 200, G: 10, B: 10, A: 0xff}, {R: 10, G: 200, B: 10, A: 0xff}, {R: 10, G: 10, B: 200, A: 0xff}}
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 10))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 10; y++ {
+			if x < 30 {
+				img.SetNRGBA(x, y, colours[x/10])
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0x40})
+			}
+		}
+	}
+
+	p := medianCutPalette(img, 8)
+	if len(p) != len(colours) {
+		t.Fatalf("palette = %v, want only the %d opaque colours of the image", p, len(colours))
+	}
+
+	for _, colors := range []int{4, 8} {
+		paletted := toPaletted(img, colors)
+		if len(paletted.Palette) > colors {
+			t.Fatalf("palette has %d colours, want at most %d", len(paletted.Palette), colors)
+		}
+		transparent := uint8(len(paletted.Palette) - 1)
+		if _, _, _, a := paletted.Palette[transparent].RGBA(); a != 0 {
+			t.Fatalf("last palette entry %v is not transparent", paletted.Palette[transparent])
+		}
+		if got := paletted.ColorIndexAt(35, 5); got != transparent {
+			t.Errorf("mostly transparent pixel index = %d, want the transparent %d", got, transparent)
+		}
+		for x, c := range colours {
+			if got := paletted.At(x*10+5, 5); got != color.Color(color.RGBA(c)) {
+				t.Errorf("%d colours: opaque pixel = %v, want %v", colors, got, c)
+			}
+		}
+	}
+}
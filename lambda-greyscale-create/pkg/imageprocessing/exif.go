@@ -0,0 +1,333 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Orientation is the value of the EXIF Orientation tag, describing how the
+// stored pixels must be transformed to display the image upright.
+type Orientation int
+
+const (
+	OrientationNormal Orientation = iota + 1
+	OrientationFlipHorizontal
+	OrientationRotate180
+	OrientationFlipVertical
+	OrientationTranspose
+	OrientationRotate90
+	OrientationTransverse
+	OrientationRotate270
+)
+
+const (
+	jpegSOI  = 0xd8
+	jpegSOS  = 0xda
+	jpegEOI  = 0xd9
+	jpegAPP1 = 0xe1
+	jpegAPP2 = 0xe2
+
+	exifTypeByte      = 1
+	exifTypeASCII     = 2
+	exifTypeShort     = 3
+	exifTypeLong      = 4
+	exifTypeRational  = 5
+	exifTypeUndefined = 7
+	exifTypeSShort    = 8
+	exifTypeSLong     = 9
+	exifTypeSRational = 10
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// exifTypeSizes is the size in bytes of one value of each TIFF field type.
+var exifTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// exifEntry is a single tag of an IFD, value holds its raw bytes in the byte
+// order of the Metadata it belongs to.
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// Metadata is the EXIF, XMP and ICC metadata of an encoded image. Decoding
+// drops it, so it is read from the source separately and written back into
+// the encoded output by EmbedMetadata.
+type Metadata struct {
+	order binary.ByteOrder
+	// the entries of IFD0, the Exif IFD and the GPS IFD, without the
+	// pointers linking them, which are rebuilt when written
+	ifd0 []exifEntry
+	exif []exifEntry
+	gps  []exifEntry
+
+	// XMP is the XMP packet and ICC the ICC colour profile, nil when absent
+	XMP []byte
+	ICC []byte
+}
+
+// ReadOrientation returns the EXIF orientation of an encoded image. Images
+// that carry no orientation are OrientationNormal, an error is only returned
+// for malformed metadata.
+func ReadOrientation(data []byte) (Orientation, error) {
+	m, err := ReadMetadata(data)
+	return m.Orientation(), err
+}
+
+// ReadMetadata returns the metadata of an encoded JPEG or PNG image, or nil
+// when it carries none or is in another format.
+func ReadMetadata(data []byte) (*Metadata, error) {
+	var (
+		m   *Metadata
+		err error
+	)
+	switch {
+	case len(data) >= 2 && data[0] == 0xff && data[1] == jpegSOI:
+		m, err = readJPEGMetadata(data)
+	case bytes.HasPrefix(data, pngHeader):
+		m, err = readPNGMetadata(data)
+	}
+	if err != nil || m == nil || (m.empty() && m.XMP == nil && m.ICC == nil) {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Orientation returns the value of the Orientation tag, OrientationNormal
+// when there is none.
+func (m *Metadata) Orientation() Orientation {
+	if m == nil {
+		return OrientationNormal
+	}
+	for _, e := range m.ifd0 {
+		if e.tag != exifTagOrientation || e.typ != exifTypeShort || e.count != 1 {
+			continue
+		}
+		o := Orientation(m.order.Uint16(e.value))
+		if o < OrientationNormal || o > OrientationRotate270 {
+			// out of range values are treated as upright, as viewers do
+			return OrientationNormal
+		}
+		return o
+	}
+	return OrientationNormal
+}
+
+func (m *Metadata) empty() bool {
+	return len(m.ifd0) == 0 && len(m.exif) == 0 && len(m.gps) == 0
+}
+
+func readJPEGMetadata(data []byte) (*Metadata, error) {
+	m := &Metadata{}
+	var iccChunks [][]byte
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("invalid jpeg marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		// fill bytes may pad markers
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("truncated jpeg segment at offset %d", pos)
+		}
+		segment := data[pos+4 : pos+2+length]
+		switch {
+		case marker == jpegAPP1 && bytes.HasPrefix(segment, exifHeader) && m.order == nil:
+			if err := m.parseTIFF(segment[len(exifHeader):]); err != nil {
+				return nil, err
+			}
+		case marker == jpegAPP1 && bytes.HasPrefix(segment, xmpHeader):
+			m.XMP = append([]byte(nil), segment[len(xmpHeader):]...)
+		case marker == jpegAPP2 && bytes.HasPrefix(segment, iccHeader) && len(segment) > len(iccHeader)+2:
+			// profiles too large for one segment are split, each chunk is
+			// prefixed with its 1 based sequence number and the chunk count
+			iccChunks = append(iccChunks, segment[len(iccHeader):])
+		}
+		pos += 2 + length
+	}
+
+	if len(iccChunks) > 0 {
+		sort.SliceStable(iccChunks, func(i, j int) bool { return iccChunks[i][0] < iccChunks[j][0] })
+		var icc []byte
+		for _, chunk := range iccChunks {
+			icc = append(icc, chunk[2:]...)
+		}
+		m.ICC = icc
+	}
+	return m, nil
+}
+
+func readPNGMetadata(data []byte) (*Metadata, error) {
+	m := &Metadata{}
+	for pos := len(pngHeader); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || pos+12+length > len(data) {
+			return nil, fmt.Errorf("truncated png chunk at offset %d", pos)
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		chunk := data[pos+8 : pos+8+length]
+		switch chunkType {
+		case "eXIf":
+			if err := m.parseTIFF(chunk); err != nil {
+				return nil, err
+			}
+		case "iCCP":
+			// profile name, a null separator and the compression method
+			// precede the zlib compressed profile
+			sep := bytes.IndexByte(chunk, 0)
+			if sep < 0 || sep+2 > len(chunk) {
+				return nil, errors.New("invalid png iCCP chunk")
+			}
+			icc, err := inflate(chunk[sep+2:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid png iCCP chunk : %w", err)
+			}
+			m.ICC = icc
+		case "iTXt":
+			if xmp, ok := pngXMP(chunk); ok {
+				m.XMP = xmp
+			}
+		case "IDAT", "IEND":
+			return m, nil
+		}
+		pos += 12 + length
+	}
+	return m, nil
+}
+
+// pngXMP returns the XMP packet held in an iTXt chunk.
+func pngXMP(chunk []byte) ([]byte, bool) {
+	const keyword = "XML:com.adobe.xmp\x00"
+	if !bytes.HasPrefix(chunk, []byte(keyword)) || len(chunk) < len(keyword)+2 {
+		return nil, false
+	}
+	compressed := chunk[len(keyword)] == 1
+	// skip the compression fields, the language tag and translated keyword
+	rest := chunk[len(keyword)+2:]
+	for i := 0; i < 2; i++ {
+		sep := bytes.IndexByte(rest, 0)
+		if sep < 0 {
+			return nil, false
+		}
+		rest = rest[sep+1:]
+	}
+	if compressed {
+		xmp, err := inflate(rest)
+		return xmp, err == nil
+	}
+	return append([]byte(nil), rest...), true
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// parseTIFF reads IFD0 and the Exif and GPS IFDs it points to from a TIFF
+// structure.
+func (m *Metadata) parseTIFF(tiff []byte) error {
+	if len(tiff) < 8 {
+		return errors.New("truncated exif header")
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		m.order = binary.LittleEndian
+	case "MM":
+		m.order = binary.BigEndian
+	default:
+		return errors.New("invalid exif byte order")
+	}
+	if m.order.Uint16(tiff[2:]) != 42 {
+		return errors.New("invalid exif header")
+	}
+
+	ifd0, err := m.parseIFD(tiff, m.order.Uint32(tiff[4:]))
+	if err != nil {
+		return err
+	}
+	for _, e := range ifd0 {
+		var target *[]exifEntry
+		switch e.tag {
+		case exifTagExifIFD:
+			target = &m.exif
+		case exifTagGPSIFD:
+			target = &m.gps
+		default:
+			m.ifd0 = append(m.ifd0, e)
+			continue
+		}
+		if e.typ != exifTypeLong || e.count != 1 {
+			return fmt.Errorf("invalid exif pointer tag %#04x", e.tag)
+		}
+		entries, err := m.parseIFD(tiff, m.order.Uint32(e.value))
+		if err != nil {
+			return err
+		}
+		*target = entries
+	}
+	return nil
+}
+
+func (m *Metadata) parseIFD(tiff []byte, offset uint32) ([]exifEntry, error) {
+	ifd := int(offset)
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, errors.New("invalid exif ifd offset")
+	}
+	count := int(m.order.Uint16(tiff[ifd:]))
+	entries := make([]exifEntry, 0, count)
+	for i := 0; i < count; i++ {
+		pos := ifd + 2 + i*12
+		if pos+12 > len(tiff) {
+			return nil, errors.New("truncated exif ifd")
+		}
+		e := exifEntry{
+			tag:   m.order.Uint16(tiff[pos:]),
+			typ:   m.order.Uint16(tiff[pos+2:]),
+			count: m.order.Uint32(tiff[pos+4:]),
+		}
+		typeSize, ok := exifTypeSizes[e.typ]
+		if !ok {
+			// unknown types can't be sized, so can't be copied
+			continue
+		}
+		size := uint64(typeSize) * uint64(e.count)
+		if size <= 4 {
+			e.value = append([]byte(nil), tiff[pos+8:pos+8+int(size)]...)
+		} else {
+			start := uint64(m.order.Uint32(tiff[pos+8:]))
+			if start+size > uint64(len(tiff)) {
+				return nil, fmt.Errorf("exif tag %#04x points outside the segment", e.tag)
+			}
+			e.value = append([]byte(nil), tiff[start:start+size]...)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
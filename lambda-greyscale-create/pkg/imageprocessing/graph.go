@@ -0,0 +1,303 @@
+package imageprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"reflect"
+)
+
+// GraphSpec is the declarative form of a PipelineGraph. Its actions run
+// first, then the result is kept as Output, when set, and passed on to every
+// branch.
+type GraphSpec struct {
+	Actions  []ActionSpec `json:"actions,omitempty" yaml:"actions,omitempty"`
+	Output   string       `json:"output,omitempty" yaml:"output,omitempty"`
+	Branches []GraphSpec  `json:"branches,omitempty" yaml:"branches,omitempty"`
+}
+
+// OutputSpec names the pipeline producing one output of a graph.
+type OutputSpec struct {
+	Name     string
+	Pipeline PipelineSpec
+}
+
+// MergePipelineSpecs returns the graph producing every output from its
+// pipeline, with the leading actions that pipelines have in common run once
+// and their result shared. Actions are only shared when their names and
+// parameters are the same.
+func MergePipelineSpecs(outputs []OutputSpec) GraphSpec {
+	paths := make([]outputPath, len(outputs))
+	for i, o := range outputs {
+		paths[i] = outputPath{name: o.Name, actions: o.Pipeline.Actions}
+	}
+	return GraphSpec{Branches: mergePaths(paths)}
+}
+
+// outputPath is the actions still to run for an output.
+type outputPath struct {
+	name    string
+	actions []ActionSpec
+}
+
+// mergePaths returns a branch for every group of paths starting with the
+// same action, keeping the order the paths were given in.
+func mergePaths(paths []outputPath) []GraphSpec {
+	var branches []GraphSpec
+	merged := make([]bool, len(paths))
+	for i, p := range paths {
+		if merged[i] {
+			continue
+		}
+		if len(p.actions) == 0 {
+			branches = append(branches, GraphSpec{Output: p.name})
+			continue
+		}
+
+		group := []outputPath{p}
+		for j := i + 1; j < len(paths); j++ {
+			if !merged[j] && len(paths[j].actions) > 0 && sameAction(paths[j].actions[0], p.actions[0]) {
+				group = append(group, paths[j])
+				merged[j] = true
+			}
+		}
+		if len(group) == 1 {
+			branches = append(branches, GraphSpec{Actions: p.actions, Output: p.name})
+			continue
+		}
+
+		shared := commonPrefix(group)
+		rest := make([]outputPath, len(group))
+		for k, g := range group {
+			rest[k] = outputPath{name: g.name, actions: g.actions[shared:]}
+		}
+		branches = append(branches, GraphSpec{Actions: p.actions[:shared], Branches: mergePaths(rest)})
+	}
+	return branches
+}
+
+// commonPrefix returns the number of leading actions every path shares.
+func commonPrefix(paths []outputPath) int {
+	n := 0
+	for {
+		for _, p := range paths {
+			if n >= len(p.actions) || !sameAction(p.actions[n], paths[0].actions[n]) {
+				return n
+			}
+		}
+		n++
+	}
+}
+
+func sameAction(a, b ActionSpec) bool {
+	if a.Name != b.Name {
+		return false
+	}
+	if len(a.Params) == 0 && len(b.Params) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Params, b.Params)
+}
+
+// PipelineGraph runs a tree of pipelines over an image. The result of each
+// pipeline is passed on to every branch below it, so outputs whose
+// pipelines start the same way, such as renditions that are all oriented
+// and greyscaled before being sized, share that work. Every leaf ends in a
+// named output.
+type PipelineGraph struct {
+	pipeline *processorPipeline
+	output   string
+	branches []*PipelineGraph
+	// outputs names every output at or below this node
+	outputs []string
+}
+
+// NewGraphFromSpec builds a PipelineGraph from a spec, reading any
+// resources its actions name from local files.
+func NewGraphFromSpec(spec GraphSpec) (*PipelineGraph, error) {
+	return NewGraphFromSpecWithResources(spec, LocalResources{})
+}
+
+// NewGraphFromSpecWithResources builds a PipelineGraph from a spec, opening
+// the resources its actions name with resources. Every output must be named
+// once, and every branch must lead to an output.
+func NewGraphFromSpecWithResources(spec GraphSpec, resources ResourceOpener) (*PipelineGraph, error) {
+	g, err := buildGraph(spec, resources)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, name := range g.outputs {
+		if seen[name] {
+			return nil, fmt.Errorf("output %q is produced more than once", name)
+		}
+		seen[name] = true
+	}
+	return g, nil
+}
+
+func buildGraph(spec GraphSpec, resources ResourceOpener) (*PipelineGraph, error) {
+	g := &PipelineGraph{output: spec.Output}
+	if spec.Output != "" {
+		g.outputs = []string{spec.Output}
+	}
+	for _, branchSpec := range spec.Branches {
+		branch, err := buildGraph(branchSpec, resources)
+		if err != nil {
+			return nil, err
+		}
+		g.branches = append(g.branches, branch)
+		g.outputs = append(g.outputs, branch.outputs...)
+	}
+	switch {
+	case len(g.outputs) > 0:
+	case len(spec.Actions) > 0:
+		return nil, fmt.Errorf("graph branch starting with %q has no output", spec.Actions[0].Name)
+	default:
+		return nil, errors.New("graph branch has no output")
+	}
+
+	// built after the branches so errors can name the outputs affected
+	pipeline, err := buildPipeline(spec.Actions, resources)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline of outputs %q : %w", g.outputs, err)
+	}
+	g.pipeline = pipeline
+	return g, nil
+}
+
+// Outputs returns the names of every output of the graph.
+func (g *PipelineGraph) Outputs() []string {
+	return append([]string(nil), g.outputs...)
+}
+
+// Pipeline returns the linear pipeline producing output, running the
+// actions on the path to it one after another. It reuses the actions of the
+// graph rather than building them again.
+func (g *PipelineGraph) Pipeline(output string) (ProcessorPipeline, bool) {
+	path := g.path(output)
+	if path == nil {
+		return nil, false
+	}
+	pipeline := &processorPipeline{}
+	for _, node := range path {
+		for i, action := range node.pipeline.imageProcesses {
+			pipeline.addNamedAction(node.pipeline.names[i], action)
+		}
+	}
+	return pipeline, true
+}
+
+// path returns the nodes from g down to the one producing output.
+func (g *PipelineGraph) path(output string) []*PipelineGraph {
+	if output == "" {
+		return nil
+	}
+	if g.output == output {
+		return []*PipelineGraph{g}
+	}
+	for _, branch := range g.branches {
+		if path := branch.path(output); path != nil {
+			return append([]*PipelineGraph{g}, path...)
+		}
+	}
+	return nil
+}
+
+// Transform returns every output of the graph for img, keyed by name.
+func (g *PipelineGraph) Transform(img image.Image) (map[string]image.Image, error) {
+	return g.TransformContext(context.Background(), img)
+}
+
+// TransformContext is Transform stopping with ctx.Err() once ctx is done.
+func (g *PipelineGraph) TransformContext(ctx context.Context, img image.Image) (map[string]image.Image, error) {
+	return g.TransformOutputs(ctx, img, g.outputs)
+}
+
+// TransformOutputs returns the named outputs of the graph for img, only
+// running the branches that lead to them. Observers ctx carries see the
+// actions numbered from the root of the graph, along with the outputs each
+// one feeds.
+func (g *PipelineGraph) TransformOutputs(ctx context.Context, img image.Image, outputs []string) (map[string]image.Image, error) {
+	if img == nil {
+		return nil, errors.New("image should not be nil")
+	}
+	want := make(map[string]bool, len(outputs))
+	for _, name := range outputs {
+		if g.path(name) == nil {
+			return nil, fmt.Errorf("unknown output %q, expected one of %v", name, g.outputs)
+		}
+		want[name] = true
+	}
+
+	results := make(map[string]image.Image, len(want))
+	if err := g.run(ctx, pipelineObservers(ctx), img, want, 0, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// run transforms img through the pipeline of g and on through its branches,
+// keeping the wanted outputs in results. offset is the number of actions
+// run above g.
+func (g *PipelineGraph) run(ctx context.Context, observers []PipelineObserver, img image.Image, want map[string]bool, offset int, results map[string]image.Image) error {
+	var outputs []string
+	for _, name := range g.outputs {
+		if want[name] {
+			outputs = append(outputs, name)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	if len(g.pipeline.imageProcesses) > 0 {
+		nodeCtx := ctx
+		if len(observers) > 0 {
+			branchObservers := make([]PipelineObserver, len(observers))
+			for i, o := range observers {
+				branchObservers[i] = branchObserver{observer: o, outputs: outputs, offset: offset}
+			}
+			nodeCtx = context.WithValue(ctx, observersKey{}, branchObservers)
+		}
+		var err error
+		if img, err = g.pipeline.TransformContext(nodeCtx, img); err != nil {
+			return err
+		}
+	}
+
+	if want[g.output] {
+		results[g.output] = img
+	}
+	for _, branch := range g.branches {
+		if err := branch.run(ctx, observers, img, want, offset+len(g.pipeline.imageProcesses), results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// branchObserver passes on the events of one pipeline of a graph, numbering
+// its actions from the root and naming the outputs they feed.
+type branchObserver struct {
+	observer PipelineObserver
+	outputs  []string
+	offset   int
+}
+
+var _ PipelineObserver = branchObserver{}
+
+func (o branchObserver) BeforeAction(ctx context.Context, event ActionEvent) {
+	o.observer.BeforeAction(ctx, o.event(event))
+}
+
+func (o branchObserver) AfterAction(ctx context.Context, event ActionEvent) {
+	o.observer.AfterAction(ctx, o.event(event))
+}
+
+func (o branchObserver) event(event ActionEvent) ActionEvent {
+	event.Index += o.offset
+	event.Outputs = o.outputs
+	return event
+}
@@ -0,0 +1,221 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// graphOutputs are renditions as a converter would define them, the first
+// three starting with the same actions and the last sharing none.
+var graphOutputs = []OutputSpec{
+	{Name: "full", Pipeline: PipelineSpec{Actions: []ActionSpec{
+		{Name: "greyscale"},
+		{Name: "brightness", Params: Params{"amount": 0.1}},
+	}}},
+	{Name: "medium", Pipeline: PipelineSpec{Actions: []ActionSpec{
+		{Name: "greyscale"},
+		{Name: "brightness", Params: Params{"amount": 0.1}},
+		{Name: "resize", Params: Params{"width": 60}},
+	}}},
+	{Name: "thumbnail", Pipeline: PipelineSpec{Actions: []ActionSpec{
+		{Name: "greyscale"},
+		{Name: "resize", Params: Params{"width": 20, "height": 20}},
+		{Name: "unsharp_mask"},
+	}}},
+	{Name: "blurred", Pipeline: PipelineSpec{Actions: []ActionSpec{
+		{Name: "gaussian_blur", Params: Params{"sigma": 1.5}},
+	}}},
+}
+
+func encodedPNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestMergePipelineSpecs(t *testing.T) {
+	got := MergePipelineSpecs(graphOutputs)
+	want := GraphSpec{Branches: []GraphSpec{
+		{
+			Actions: []ActionSpec{{Name: "greyscale"}},
+			Branches: []GraphSpec{
+				{
+					Actions: []ActionSpec{{Name: "brightness", Params: Params{"amount": 0.1}}},
+					Branches: []GraphSpec{
+						{Output: "full"},
+						{Actions: []ActionSpec{{Name: "resize", Params: Params{"width": 60}}}, Output: "medium"},
+					},
+				},
+				{
+					Actions: []ActionSpec{{Name: "resize", Params: Params{"width": 20, "height": 20}}, {Name: "unsharp_mask"}},
+					Output:  "thumbnail",
+				},
+			},
+		},
+		{Actions: []ActionSpec{{Name: "gaussian_blur", Params: Params{"sigma": 1.5}}}, Output: "blurred"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergePipelineSpecs() = %+v, want %+v", got, want)
+	}
+
+	// actions with different parameters are not shared
+	split := MergePipelineSpecs([]OutputSpec{
+		{Name: "a", Pipeline: PipelineSpec{Actions: []ActionSpec{{Name: "resize", Params: Params{"width": 10}}}}},
+		{Name: "b", Pipeline: PipelineSpec{Actions: []ActionSpec{{Name: "resize", Params: Params{"width": 20}}}}},
+	})
+	if len(split.Branches) != 2 {
+		t.Errorf("MergePipelineSpecs() with different parameters = %+v, want a branch per output", split)
+	}
+}
+
+func TestPipelineGraphMatchesPipelines(t *testing.T) {
+	graph, err := NewGraphFromSpec(MergePipelineSpecs(graphOutputs))
+	if err != nil {
+		t.Fatalf("NewGraphFromSpec() error = %v", err)
+	}
+	if got, want := graph.Outputs(), []string{"full", "medium", "thumbnail", "blurred"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outputs() = %v, want %v", got, want)
+	}
+
+	src := colourSource()
+	got, err := graph.Transform(src)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if len(got) != len(graphOutputs) {
+		t.Fatalf("Transform() returned %d outputs, want %d", len(got), len(graphOutputs))
+	}
+	for _, o := range graphOutputs {
+		pipeline, err := NewPipelineFromSpec(o.Pipeline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alone, err := pipeline.Transform(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encodedPNG(t, got[o.Name]), encodedPNG(t, alone)) {
+			t.Errorf("output %s differs from running its pipeline alone", o.Name)
+		}
+
+		// the linear pipeline of an output gives the same image too
+		linear, ok := graph.Pipeline(o.Name)
+		if !ok {
+			t.Fatalf("Pipeline(%q) not found", o.Name)
+		}
+		fromLinear, err := linear.Transform(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encodedPNG(t, fromLinear), encodedPNG(t, alone)) {
+			t.Errorf("Pipeline(%q) differs from running its pipeline alone", o.Name)
+		}
+	}
+}
+
+func TestPipelineGraphSharedActionsRunOnce(t *testing.T) {
+	graph, err := NewGraphFromSpec(MergePipelineSpecs(graphOutputs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer := &recordingObserver{}
+	ctx := WithPipelineObserver(context.Background(), observer)
+	if _, err := graph.TransformContext(ctx, colourSource()); err != nil {
+		t.Fatalf("TransformContext() error = %v", err)
+	}
+
+	runs := map[string]int{}
+	for _, event := range observer.events {
+		runs[event.Name]++
+	}
+	// greyscale feeds three outputs and brightness two, but each runs once
+	want := map[string]int{"greyscale": 1, "brightness": 1, "resize": 2, "unsharp_mask": 1, "gaussian_blur": 1}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("actions ran %v times, want %v", runs, want)
+	}
+
+	first := observer.events[0]
+	if first.Name != "greyscale" || first.Index != 0 || !reflect.DeepEqual(first.Outputs, []string{"full", "medium", "thumbnail"}) {
+		t.Errorf("first event = %s at %d for %v, want greyscale at 0 for full, medium and thumbnail", first.Name, first.Index, first.Outputs)
+	}
+	for _, event := range observer.events {
+		if event.Name == "unsharp_mask" && (event.Index != 2 || !reflect.DeepEqual(event.Outputs, []string{"thumbnail"})) {
+			t.Errorf("unsharp_mask event at %d for %v, want at 2 for thumbnail", event.Index, event.Outputs)
+		}
+	}
+}
+
+func TestPipelineGraphTransformOutputs(t *testing.T) {
+	graph, err := NewGraphFromSpec(MergePipelineSpecs(graphOutputs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer := &recordingObserver{}
+	ctx := WithPipelineObserver(context.Background(), observer)
+	got, err := graph.TransformOutputs(ctx, colourSource(), []string{"thumbnail"})
+	if err != nil {
+		t.Fatalf("TransformOutputs() error = %v", err)
+	}
+	if len(got) != 1 || got["thumbnail"] == nil {
+		t.Errorf("TransformOutputs() = %v, want the thumbnail only", got)
+	}
+	// branches leading to other outputs are not run
+	if len(observer.events) != 3 {
+		t.Errorf("ran %d actions, want the 3 of the thumbnail", len(observer.events))
+	}
+
+	if _, err := graph.TransformOutputs(context.Background(), colourSource(), []string{"missing"}); err == nil {
+		t.Error("TransformOutputs() of an unknown output error = nil")
+	}
+	if _, err := graph.Transform(nil); err == nil {
+		t.Error("Transform() of a nil image error = nil")
+	}
+}
+
+func TestNewGraphFromSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    GraphSpec
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			spec: GraphSpec{Actions: []ActionSpec{{Name: "greyscale"}}, Branches: []GraphSpec{{Output: "a"}, {Output: "b"}}},
+		},
+		{
+			name:    "output named twice",
+			spec:    GraphSpec{Branches: []GraphSpec{{Output: "a"}, {Actions: []ActionSpec{{Name: "greyscale"}}, Output: "a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "branch without an output",
+			spec:    GraphSpec{Branches: []GraphSpec{{Output: "a"}, {Actions: []ActionSpec{{Name: "greyscale"}}}}},
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			spec:    GraphSpec{},
+			wantErr: true,
+		},
+		{
+			name:    "unknown action",
+			spec:    GraphSpec{Actions: []ActionSpec{{Name: "unknown"}}, Output: "a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGraphFromSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewGraphFromSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
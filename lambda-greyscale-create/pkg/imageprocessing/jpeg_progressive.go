@@ -0,0 +1,548 @@
+package imageprocessing
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+// The standard library only writes baseline JPEGs, so progressive output is
+// produced here. The encoder uses spectral selection only: a DC scan followed
+// by two AC bands per component, each Huffman coded with the standard tables
+// of section K.3 of the spec. Decoders render the DC scan as a coarse preview
+// while the rest of the file downloads.
+
+const jpegBlockSize = 64
+
+// jpegZigzag maps a zig-zag index to its natural order index.
+var jpegZigzag = [jpegBlockSize]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegUnscaledQuant are the section K.1 quantization tables in zig-zag order.
+var jpegUnscaledQuant = [2][jpegBlockSize]byte{
+	// luminance
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	// chrominance
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+type jpegHuffmanSpec struct {
+	// count[i] is the number of codes of length i+1 bits
+	count [16]byte
+	value []byte
+}
+
+// jpegHuffmanSpecs are the section K.3 tables, indexed luminance DC,
+// luminance AC, chrominance DC and chrominance AC.
+var jpegHuffmanSpecs = [4]jpegHuffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// jpegHuffmanLUT maps a value to its code, the top 8 bits hold the code
+// length and the low 24 bits the code itself.
+type jpegHuffmanLUT []uint32
+
+func newJPEGHuffmanLUT(s jpegHuffmanSpec) jpegHuffmanLUT {
+	lut := make(jpegHuffmanLUT, 256)
+	code, k := uint32(0), 0
+	for i := 0; i < len(s.count); i++ {
+		nBits := uint32(i+1) << 24
+		for j := byte(0); j < s.count[i]; j++ {
+			lut[s.value[k]] = nBits | code
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return lut
+}
+
+// jpegDCT holds the scaled cosine basis, jpegDCT[u][x] = C(u)/2 cos((2x+1)uπ/16).
+var jpegDCT = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 1.0
+		if u == 0 {
+			c = 1 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+type jpegComponent struct {
+	id      byte
+	h, v    int
+	table   int
+	blocksW int
+	blocksH int
+	// scanW and scanH are the blocks covering the component itself, which is
+	// all a non-interleaved scan codes; the rest is MCU padding.
+	scanW  int
+	scanH  int
+	coeffs [][jpegBlockSize]int32
+}
+
+func (c *jpegComponent) block(bx, by int) *[jpegBlockSize]int32 {
+	return &c.coeffs[by*c.blocksW+bx]
+}
+
+// encodeProgressiveJPEG writes img as a 4:2:0 progressive JPEG, or a single
+// component one for *image.Gray.
+func encodeProgressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width >= 1<<16 || height >= 1<<16 {
+		return errors.New("jpeg: image is too large to encode")
+	}
+
+	quant := scaledJPEGQuant(quality)
+
+	var components []*jpegComponent
+	var mcusX, mcusY int
+	if gray, ok := img.(*image.Gray); ok {
+		mcusX, mcusY = (width+7)/8, (height+7)/8
+		lum := newJPEGComponent(1, 1, 1, 0, mcusX, mcusY, width, height)
+		plane := jpegPlane(mcusX*8, mcusY*8, width, height, func(x, y int) float64 {
+			return float64(gray.Pix[gray.PixOffset(b.Min.X+x, b.Min.Y+y)])
+		})
+		lum.transform(plane, mcusX*8, &quant[0])
+		components = []*jpegComponent{lum}
+	} else {
+		mcusX, mcusY = (width+15)/16, (height+15)/16
+		rgba := toRGBA(img)
+		planeW, planeH := mcusX*16, mcusY*16
+		ys := jpegPlane(planeW, planeH, width, height, func(x, y int) float64 {
+			p := rgba.Pix[y*rgba.Stride+x*4:]
+			return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		})
+		cbs := jpegPlane(planeW, planeH, width, height, func(x, y int) float64 {
+			p := rgba.Pix[y*rgba.Stride+x*4:]
+			return -0.168736*float64(p[0]) - 0.331264*float64(p[1]) + 0.5*float64(p[2]) + 128
+		})
+		crs := jpegPlane(planeW, planeH, width, height, func(x, y int) float64 {
+			p := rgba.Pix[y*rgba.Stride+x*4:]
+			return 0.5*float64(p[0]) - 0.418688*float64(p[1]) - 0.081312*float64(p[2]) + 128
+		})
+
+		lum := newJPEGComponent(1, 2, 2, 0, mcusX, mcusY, width, height)
+		lum.transform(ys, planeW, &quant[0])
+		cb := newJPEGComponent(2, 1, 1, 1, mcusX, mcusY, (width+1)/2, (height+1)/2)
+		cb.transform(downsampleJPEGPlane(cbs, planeW, planeH), planeW/2, &quant[1])
+		cr := newJPEGComponent(3, 1, 1, 1, mcusX, mcusY, (width+1)/2, (height+1)/2)
+		cr.transform(downsampleJPEGPlane(crs, planeW, planeH), planeW/2, &quant[1])
+		components = []*jpegComponent{lum, cb, cr}
+	}
+
+	var luts [4]jpegHuffmanLUT
+	for i, spec := range jpegHuffmanSpecs {
+		luts[i] = newJPEGHuffmanLUT(spec)
+	}
+
+	e := &jpegWriter{w: bufio.NewWriter(w)}
+	e.writeMarkerHeader(0xd8, 0)
+	e.writeDQT(quant, len(components) > 1)
+	e.writeSOF2(width, height, components)
+	e.writeDHT(len(components) > 1)
+
+	// DC first, interleaved when there is more than one component
+	e.writeSOS(components, 0, 0)
+	preds := make([]int32, len(components))
+	if len(components) == 1 {
+		c := components[0]
+		for by := 0; by < c.scanH; by++ {
+			for bx := 0; bx < c.scanW; bx++ {
+				preds[0] = e.emitDC(luts[0], c.block(bx, by)[0], preds[0])
+			}
+		}
+	} else {
+		for my := 0; my < mcusY; my++ {
+			for mx := 0; mx < mcusX; mx++ {
+				for i, c := range components {
+					for by := 0; by < c.v; by++ {
+						for bx := 0; bx < c.h; bx++ {
+							block := c.block(mx*c.h+bx, my*c.v+by)
+							preds[i] = e.emitDC(luts[c.table*2], block[0], preds[i])
+						}
+					}
+				}
+			}
+		}
+	}
+	e.pad()
+
+	// then the low and high AC bands of each component
+	for _, band := range [][2]int{{1, 5}, {6, 63}} {
+		for _, c := range components {
+			e.writeSOS([]*jpegComponent{c}, band[0], band[1])
+			for by := 0; by < c.scanH; by++ {
+				for bx := 0; bx < c.scanW; bx++ {
+					e.emitAC(luts[c.table*2+1], c.block(bx, by), band[0], band[1])
+				}
+			}
+			e.pad()
+		}
+	}
+
+	e.writeMarkerHeader(0xd9, 0)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func scaledJPEGQuant(quality int) (quant [2][jpegBlockSize]byte) {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	var scale int
+	if quality < 50 {
+		scale = 5000 / quality
+	} else {
+		scale = 200 - quality*2
+	}
+	for i := range quant {
+		for j := range quant[i] {
+			x := (int(jpegUnscaledQuant[i][j])*scale + 50) / 100
+			if x < 1 {
+				x = 1
+			} else if x > 255 {
+				x = 255
+			}
+			quant[i][j] = byte(x)
+		}
+	}
+	return quant
+}
+
+func newJPEGComponent(id byte, h, v, table, mcusX, mcusY, width, height int) *jpegComponent {
+	c := &jpegComponent{
+		id:      id,
+		h:       h,
+		v:       v,
+		table:   table,
+		blocksW: mcusX * h,
+		blocksH: mcusY * v,
+		scanW:   (width + 7) / 8,
+		scanH:   (height + 7) / 8,
+	}
+	c.coeffs = make([][jpegBlockSize]int32, c.blocksW*c.blocksH)
+	return c
+}
+
+// jpegPlane samples a planeW x planeH plane, replicating the last row and
+// column of the width x height image into the padding.
+func jpegPlane(planeW, planeH, width, height int, sample func(x, y int) float64) []float64 {
+	plane := make([]float64, planeW*planeH)
+	parallelRows(context.Background(), planeH, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy := y
+			if sy >= height {
+				sy = height - 1
+			}
+			for x := 0; x < planeW; x++ {
+				sx := x
+				if sx >= width {
+					sx = width - 1
+				}
+				plane[y*planeW+x] = sample(sx, sy)
+			}
+		}
+	})
+	return plane
+}
+
+func downsampleJPEGPlane(plane []float64, planeW, planeH int) []float64 {
+	w, h := planeW/2, planeH/2
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := 2*y*planeW + 2*x
+			out[y*w+x] = (plane[i] + plane[i+1] + plane[i+planeW] + plane[i+planeW+1]) / 4
+		}
+	}
+	return out
+}
+
+// transform runs the forward DCT over every block of the plane and stores
+// the quantized coefficients in zig-zag order.
+func (c *jpegComponent) transform(plane []float64, stride int, quant *[jpegBlockSize]byte) {
+	parallelRows(context.Background(), c.blocksH, func(by0, by1 int) {
+		var tmp [8][8]float64
+		for by := by0; by < by1; by++ {
+			for bx := 0; bx < c.blocksW; bx++ {
+				// rows
+				for y := 0; y < 8; y++ {
+					row := plane[(by*8+y)*stride+bx*8:]
+					for u := 0; u < 8; u++ {
+						var sum float64
+						for x := 0; x < 8; x++ {
+							sum += jpegDCT[u][x] * (row[x] - 128)
+						}
+						tmp[y][u] = sum
+					}
+				}
+				// columns
+				block := c.block(bx, by)
+				for zz := 0; zz < jpegBlockSize; zz++ {
+					natural := jpegZigzag[zz]
+					u, v := natural%8, natural/8
+					var sum float64
+					for y := 0; y < 8; y++ {
+						sum += jpegDCT[v][y] * tmp[y][u]
+					}
+					q := math.Round(sum / float64(quant[zz]))
+					if q > 1023 {
+						q = 1023
+					} else if q < -1023 {
+						q = -1023
+					}
+					block[zz] = int32(q)
+				}
+			}
+		}
+	})
+}
+
+type jpegWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint32
+	err   error
+}
+
+func (e *jpegWriter) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *jpegWriter) writeByte(b byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(b)
+	}
+}
+
+// writeMarkerHeader writes a marker followed by the segment length, markers
+// without a payload such as SOI and EOI pass a length of 0.
+func (e *jpegWriter) writeMarkerHeader(marker byte, length int) {
+	if length == 0 {
+		e.write([]byte{0xff, marker})
+		return
+	}
+	e.write([]byte{0xff, marker, byte(length >> 8), byte(length)})
+}
+
+func (e *jpegWriter) writeDQT(quant [2][jpegBlockSize]byte, chroma bool) {
+	tables := 1
+	if chroma {
+		tables = 2
+	}
+	e.writeMarkerHeader(0xdb, 2+tables*(1+jpegBlockSize))
+	for i := 0; i < tables; i++ {
+		e.writeByte(byte(i))
+		e.write(quant[i][:])
+	}
+}
+
+func (e *jpegWriter) writeSOF2(width, height int, components []*jpegComponent) {
+	e.writeMarkerHeader(0xc2, 8+3*len(components))
+	e.write([]byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(components))})
+	for _, c := range components {
+		e.write([]byte{c.id, byte(c.h<<4 | c.v), byte(c.table)})
+	}
+}
+
+func (e *jpegWriter) writeDHT(chroma bool) {
+	specs := jpegHuffmanSpecs[:2]
+	if chroma {
+		specs = jpegHuffmanSpecs[:]
+	}
+	length := 2
+	for _, s := range specs {
+		length += 1 + 16 + len(s.value)
+	}
+	e.writeMarkerHeader(0xc4, length)
+	for i, s := range specs {
+		// class 0 is DC and 1 is AC, the table id is the component table
+		e.writeByte(byte((i%2)<<4 | i/2))
+		e.write(s.count[:])
+		e.write(s.value)
+	}
+}
+
+func (e *jpegWriter) writeSOS(components []*jpegComponent, ss, se int) {
+	e.writeMarkerHeader(0xda, 6+2*len(components))
+	e.writeByte(byte(len(components)))
+	for _, c := range components {
+		e.write([]byte{c.id, byte(c.table<<4 | c.table)})
+	}
+	e.write([]byte{byte(ss), byte(se), 0})
+}
+
+// emit writes the low nBits of bits, stuffing a zero byte after every 0xff.
+func (e *jpegWriter) emit(bits, nBits uint32) {
+	nBits += e.nBits
+	bits <<= 32 - nBits
+	bits |= e.bits
+	for nBits >= 8 {
+		b := byte(bits >> 24)
+		e.writeByte(b)
+		if b == 0xff {
+			e.writeByte(0x00)
+		}
+		bits <<= 8
+		nBits -= 8
+	}
+	e.bits, e.nBits = bits, nBits
+}
+
+func (e *jpegWriter) emitHuff(lut jpegHuffmanLUT, value int32) {
+	x := lut[value]
+	e.emit(x&(1<<24-1), x>>24)
+}
+
+// emitHuffRLE writes the run length and size category of value followed by
+// its magnitude bits.
+func (e *jpegWriter) emitHuffRLE(lut jpegHuffmanLUT, runLength, value int32) {
+	a, b := value, value
+	if a < 0 {
+		a, b = -value, value-1
+	}
+	var nBits uint32
+	for a > 0 {
+		nBits++
+		a >>= 1
+	}
+	e.emitHuff(lut, runLength<<4|int32(nBits))
+	if nBits > 0 {
+		e.emit(uint32(b)&(1<<nBits-1), nBits)
+	}
+}
+
+func (e *jpegWriter) emitDC(lut jpegHuffmanLUT, dc, pred int32) int32 {
+	e.emitHuffRLE(lut, 0, dc-pred)
+	return dc
+}
+
+// emitAC codes the coefficients ss..se of a block. A trailing run of zeros is
+// an EOB, which in a progressive scan is an end-of-band run of one block.
+func (e *jpegWriter) emitAC(lut jpegHuffmanLUT, block *[jpegBlockSize]int32, ss, se int) {
+	var run int32
+	for k := ss; k <= se; k++ {
+		ac := block[k]
+		if ac == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			e.emitHuff(lut, 0xf0)
+			run -= 16
+		}
+		e.emitHuffRLE(lut, run, ac)
+		run = 0
+	}
+	if run > 0 {
+		e.emitHuff(lut, 0x00)
+	}
+}
+
+// pad fills the final byte of a scan with 1 bits.
+func (e *jpegWriter) pad() {
+	if e.nBits > 0 {
+		n := 8 - e.nBits
+		e.emit(1<<n-1, n)
+	}
+	e.bits, e.nBits = 0, 0
+}
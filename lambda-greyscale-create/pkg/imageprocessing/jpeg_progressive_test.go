@@ -0,0 +1,121 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegMarkers returns the markers of a JPEG stream in order, skipping over
+// segment payloads and entropy coded data.
+func jpegMarkers(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var markers []byte
+	for i := 0; i < len(data); {
+		if data[i] != 0xff || i+1 >= len(data) {
+			t.Fatalf("expected a marker at offset %d", i)
+		}
+		marker := data[i+1]
+		markers = append(markers, marker)
+		i += 2
+		if marker == 0xd8 || marker == 0xd9 {
+			continue
+		}
+		if i+2 > len(data) {
+			t.Fatalf("truncated segment %#x", marker)
+		}
+		i += int(data[i])<<8 | int(data[i+1])
+		if marker != 0xda {
+			continue
+		}
+		// entropy coded data runs up to the next marker that is neither a
+		// stuffed 0xff nor a restart
+		for i+1 < len(data) && (data[i] != 0xff || data[i+1] == 0 || data[i+1] >= 0xd0 && data[i+1] <= 0xd7) {
+			i++
+		}
+	}
+	return markers
+}
+
+func greySource(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x*255/w + y*255/h) / 2)})
+		}
+	}
+	return img
+}
+
+func TestEncodeProgressiveJPEG(t *testing.T) {
+	colour := colourSource()
+	tests := []struct {
+		name  string
+		src   image.Image
+		scans int
+	}{
+		{name: "grey", src: greySource(64, 48), scans: 3},
+		{name: "grey, odd size", src: greySource(37, 13), scans: 3},
+		{name: "colour", src: colour, scans: 7},
+		{name: "colour, odd size", src: colour.SubImage(image.Rect(3, 5, 44, 30)), scans: 7},
+		{name: "single pixel", src: colour.SubImage(image.Rect(10, 10, 11, 11)), scans: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var progressive bytes.Buffer
+			if err := encodeProgressiveJPEG(&progressive, tt.src, 90); err != nil {
+				t.Fatalf("encodeProgressiveJPEG() error = %v", err)
+			}
+
+			var scans, sof2 int
+			for _, m := range jpegMarkers(t, progressive.Bytes()) {
+				switch m {
+				case 0xc0:
+					t.Error("stream has a baseline SOF0 marker")
+				case 0xc2:
+					sof2++
+				case 0xda:
+					scans++
+				}
+			}
+			if sof2 != 1 {
+				t.Errorf("stream has %d SOF2 markers, want 1", sof2)
+			}
+			if scans != tt.scans {
+				t.Errorf("stream has %d scans, want %d", scans, tt.scans)
+			}
+
+			got, err := jpeg.Decode(bytes.NewReader(progressive.Bytes()))
+			if err != nil {
+				t.Fatalf("decoding output : %v", err)
+			}
+			if got.Bounds().Size() != tt.src.Bounds().Size() {
+				t.Fatalf("decoded size = %v, want %v", got.Bounds().Size(), tt.src.Bounds().Size())
+			}
+
+			// the output should be as close to the source as a baseline
+			// JPEG of the same quality
+			var baseline bytes.Buffer
+			if err := jpeg.Encode(&baseline, tt.src, &jpeg.Options{Quality: 90}); err != nil {
+				t.Fatalf("jpeg.Encode() error = %v", err)
+			}
+			want, err := jpeg.Decode(&baseline)
+			if err != nil {
+				t.Fatalf("decoding baseline : %v", err)
+			}
+			src := toRGBA(tt.src)
+			if e, baseErr := meanError(src, got), meanError(src, want); e > baseErr+2 {
+				t.Errorf("mean colour error = %.1f, want about the %.1f of a baseline jpeg", e, baseErr)
+			}
+		})
+	}
+}
+
+func TestEncodeProgressiveJPEGEmpty(t *testing.T) {
+	var b bytes.Buffer
+	if err := encodeProgressiveJPEG(&b, image.NewRGBA(image.Rect(0, 0, 0, 4)), 90); err == nil {
+		t.Error("encodeProgressiveJPEG() of an empty image, want an error")
+	}
+}
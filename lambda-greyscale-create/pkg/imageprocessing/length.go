@@ -0,0 +1,112 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Length is a distance along one axis of an image, either in pixels or as a
+// percentage of the image size along that axis.
+type Length struct {
+	Value   float64
+	Percent bool
+}
+
+// Pixels returns a Length of n pixels.
+func Pixels(n int) Length {
+	return Length{Value: float64(n)}
+}
+
+// Percent returns a Length of p percent of the image size.
+func Percent(p float64) Length {
+	return Length{Value: p, Percent: true}
+}
+
+func (l Length) String() string {
+	if l.Percent {
+		return strconv.FormatFloat(l.Value, 'g', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(l.Value, 'g', -1, 64) + "px"
+}
+
+// IsZero reports whether the length is zero in either unit.
+func (l Length) IsZero() bool {
+	return l.Value == 0
+}
+
+// Resolve returns the length in pixels along an axis of size pixels, rounded
+// to the nearest pixel.
+func (l Length) Resolve(size int) int {
+	if l.Percent {
+		return int(math.Round(l.Value * float64(size) / 100))
+	}
+	return int(math.Round(l.Value))
+}
+
+// ParseLength parses a number of pixels, e.g. "120" or "120px", or a
+// percentage, e.g. "25%".
+func ParseLength(s string) (Length, error) {
+	value := strings.TrimSpace(s)
+	l := Length{}
+	switch {
+	case strings.HasSuffix(value, "%"):
+		l.Percent = true
+		value = strings.TrimSuffix(value, "%")
+	case strings.HasSuffix(value, "px"):
+		value = strings.TrimSuffix(value, "px")
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return Length{}, fmt.Errorf("invalid length %q, expected pixels such as 120 or a percentage such as 25%%", s)
+	}
+	l.Value = v
+	return l, nil
+}
+
+// Length reads a length parameter given as a number of pixels or a string
+// accepted by ParseLength, returning def when it is not set. Pixel lengths
+// must lie within [0, maxPixels] and percentages within [0, 100].
+func (r *ParamReader) Length(name string, def Length, maxPixels int) Length {
+	v, ok := r.lookup(name)
+	if !ok {
+		return def
+	}
+
+	var l Length
+	if s, isString := v.(string); isString {
+		parsed, err := ParseLength(s)
+		if err != nil {
+			r.Fail(name, "%v", err)
+			return def
+		}
+		l = parsed
+	} else {
+		l = Length{Value: r.Float(name, def.Value)}
+	}
+
+	switch {
+	case l.Percent && (l.Value < 0 || l.Value > 100):
+		r.Fail(name, "must be between 0%% and 100%%, got %v", l)
+		return def
+	case !l.Percent && (l.Value < 0 || l.Value > float64(maxPixels)):
+		r.Fail(name, "must be between 0 and %d pixels, got %v", maxPixels, l)
+		return def
+	}
+	return l
+}
+
+// RequiredLength reads a length parameter that must be set and not zero.
+func (r *ParamReader) RequiredLength(name string, maxPixels int) Length {
+	if !r.Has(name) {
+		r.lookup(name)
+		r.Fail(name, "is required")
+		return Length{}
+	}
+	l := r.Length(name, Length{}, maxPixels)
+	if l.IsZero() {
+		r.Fail(name, "must not be zero")
+	}
+	return l
+}
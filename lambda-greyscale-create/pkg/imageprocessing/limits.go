@@ -0,0 +1,61 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// DecodeLimits bound the size of the images an upload may decode to. They
+// are checked against the image header, before any pixels are decoded, so a
+// small file claiming huge dimensions is refused without allocating them.
+type DecodeLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// DefaultDecodeLimits keep a decoded image within a couple of hundred
+// megabytes.
+var DefaultDecodeLimits = DecodeLimits{
+	MaxWidth:  maxResizeDimension,
+	MaxHeight: maxResizeDimension,
+	MaxPixels: 50000000,
+}
+
+// LimitError reports an image beyond one of its DecodeLimits.
+type LimitError struct {
+	// Limit names the limit exceeded, "width", "height" or "pixels".
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("image %s of %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// Check returns a *LimitError when a width x height image is beyond the
+// limits.
+func (l DecodeLimits) Check(width, height int) error {
+	switch pixels := int64(width) * int64(height); {
+	case width > l.MaxWidth:
+		return &LimitError{Limit: "width", Value: int64(width), Max: int64(l.MaxWidth)}
+	case height > l.MaxHeight:
+		return &LimitError{Limit: "height", Value: int64(height), Max: int64(l.MaxHeight)}
+	case pixels > l.MaxPixels:
+		return &LimitError{Limit: "pixels", Value: pixels, Max: l.MaxPixels}
+	}
+	return nil
+}
+
+// CheckDecodeConfig reads the header of an encoded image and checks its size
+// against limits. It returns the header and format, and a *LimitError when
+// the image is too large to decode.
+func CheckDecodeConfig(data []byte, limits DecodeLimits) (image.Config, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return cfg, format, err
+	}
+	return cfg, format, limits.Check(cfg.Width, cfg.Height)
+}
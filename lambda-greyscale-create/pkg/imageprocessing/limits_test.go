@@ -0,0 +1,85 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"runtime"
+	"testing"
+)
+
+// claimingPNG returns a png whose header claims it is w x h, followed by the
+// pixels of a 1x1 image.
+func claimingPNG(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	// the IHDR chunk follows the 8 byte signature, its data starts with the
+	// width and height and is followed by a crc of its type and data
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr, w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+	return data
+}
+
+func TestDecodeLimitsCheck(t *testing.T) {
+	limits := DecodeLimits{MaxWidth: 100, MaxHeight: 50, MaxPixels: 3000}
+	tests := []struct {
+		name      string
+		w, h      int
+		wantLimit string
+	}{
+		{name: "within", w: 60, h: 50},
+		{name: "at the pixel limit", w: 100, h: 30},
+		{name: "too wide", w: 101, h: 1, wantLimit: "width"},
+		{name: "too high", w: 1, h: 51, wantLimit: "height"},
+		{name: "too many pixels", w: 100, h: 31, wantLimit: "pixels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := limits.Check(tt.w, tt.h)
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+				t.Errorf("Check() error = %v, want one for the %s", err, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestCheckDecodeConfig(t *testing.T) {
+	cfg, format, err := CheckDecodeConfig(claimingPNG(t, 1, 1), DefaultDecodeLimits)
+	if err != nil || format != "png" || cfg.Width != 1 {
+		t.Errorf("CheckDecodeConfig() = %+v, %q, %v, want a 1x1 png", cfg, format, err)
+	}
+	if _, _, err := CheckDecodeConfig([]byte("not an image"), DefaultDecodeLimits); err == nil {
+		t.Error("CheckDecodeConfig() accepted a file that is not an image")
+	}
+
+	// a small file claiming to be huge is refused from its header alone,
+	// without allocating the gigabytes its pixels would take
+	data := claimingPNG(t, 30000, 30000)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err = CheckDecodeConfig(data, DefaultDecodeLimits)
+	runtime.ReadMemStats(&after)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "width" || limitErr.Value != 30000 {
+		t.Fatalf("CheckDecodeConfig() error = %v, want one for the width of 30000", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("CheckDecodeConfig() allocated %d bytes, want it to read the header only", allocated)
+	}
+}
@@ -0,0 +1,549 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetadataMode selects which of the source metadata a rendition keeps.
+type MetadataMode string
+
+const (
+	// MetadataKeep keeps all EXIF, XMP and ICC metadata, except for GPS
+	// positions unless the policy sets KeepGPS.
+	MetadataKeep MetadataMode = "keep"
+	// MetadataStrip writes no metadata at all.
+	MetadataStrip MetadataMode = "strip"
+	// MetadataAllowlist keeps only the tags named by the policy.
+	MetadataAllowlist MetadataMode = "allowlist"
+)
+
+// the names accepted in an allowlist besides the tag names, selecting the
+// whole XMP packet, the ICC profile and every GPS tag.
+const (
+	metadataXMP = "XMP"
+	metadataICC = "ICC"
+	metadataGPS = "GPS"
+)
+
+const (
+	exifTagOrientation     = 0x0112
+	exifTagExifIFD         = 0x8769
+	exifTagGPSIFD          = 0x8825
+	exifTagMakerNote       = 0x927c
+	exifTagPixelXDimension = 0xa002
+	exifTagPixelYDimension = 0xa003
+	exifTagInteropIFD      = 0xa005
+)
+
+var ifd0TagNames = map[uint16]string{
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x011a: "XResolution",
+	0x011b: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x8298: "Copyright",
+}
+
+var exifTagNames = map[uint16]string{
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8827: "ISOSpeedRatings",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9204: "ExposureBiasValue",
+	0x9207: "MeteringMode",
+	0x9209: "Flash",
+	0x920a: "FocalLength",
+	0x9286: "UserComment",
+	0xa001: "ColorSpace",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa403: "WhiteBalance",
+	0xa405: "FocalLengthIn35mmFilm",
+	0xa430: "CameraOwnerName",
+	0xa431: "BodySerialNumber",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+}
+
+var gpsTagNames = map[uint16]string{
+	0x00: "GPSVersionID",
+	0x01: "GPSLatitudeRef",
+	0x02: "GPSLatitude",
+	0x03: "GPSLongitudeRef",
+	0x04: "GPSLongitude",
+	0x05: "GPSAltitudeRef",
+	0x06: "GPSAltitude",
+	0x07: "GPSTimeStamp",
+	0x10: "GPSImgDirectionRef",
+	0x11: "GPSImgDirection",
+	0x12: "GPSMapDatum",
+	0x1d: "GPSDateStamp",
+}
+
+// MetadataPolicy decides which of the metadata of an upload is written into
+// a rendition. Tags names the EXIF tags, e.g. "Copyright", kept by the
+// allowlist mode, along with "XMP", "ICC" and "GPS" for the XMP packet, the
+// colour profile and all GPS tags.
+type MetadataPolicy struct {
+	Mode    MetadataMode `json:"mode" yaml:"mode"`
+	Tags    []string     `json:"tags,omitempty" yaml:"tags,omitempty"`
+	KeepGPS bool         `json:"keepGPS,omitempty" yaml:"keepGPS,omitempty"`
+}
+
+// DefaultMetadataPolicy keeps the authorship of an image and when it was
+// taken, and nothing that could identify where.
+var DefaultMetadataPolicy = MetadataPolicy{
+	Mode: MetadataAllowlist,
+	Tags: []string{"Copyright", "Artist", "DateTimeOriginal"},
+}
+
+// Validate checks the mode and that every allowlisted tag is known.
+func (p MetadataPolicy) Validate() error {
+	switch p.Mode {
+	case MetadataKeep, MetadataStrip:
+		if len(p.Tags) > 0 {
+			return fmt.Errorf("metadata tags can only be set with the %s mode", MetadataAllowlist)
+		}
+	case MetadataAllowlist:
+		for _, name := range p.Tags {
+			if !knownMetadataName(name) {
+				return fmt.Errorf("unknown metadata tag %q", name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown metadata mode %q, expected %s, %s or %s", p.Mode, MetadataKeep, MetadataStrip, MetadataAllowlist)
+	}
+	if p.KeepGPS && p.Mode != MetadataKeep {
+		return fmt.Errorf("keepGPS can only be set with the %s mode", MetadataKeep)
+	}
+	return nil
+}
+
+func knownMetadataName(name string) bool {
+	switch name {
+	case metadataXMP, metadataICC, metadataGPS:
+		return true
+	}
+	for _, names := range []map[uint16]string{ifd0TagNames, exifTagNames, gpsTagNames} {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply returns the part of m the policy keeps, or nil when nothing is kept.
+// A kept Orientation tag is reset to upright, as is the tiff:Orientation of a
+// kept XMP packet, renditions are auto-oriented before they are written.
+func (p MetadataPolicy) Apply(m *Metadata) *Metadata {
+	if m == nil || p.Mode == MetadataStrip {
+		return nil
+	}
+
+	kept := &Metadata{order: m.order}
+	switch p.Mode {
+	case MetadataKeep:
+		kept.ifd0 = filterEntries(m.ifd0, func(e exifEntry) bool { return true })
+		// maker notes hold offsets into the source segment that can't be
+		// rewritten, the interop pointer is dropped with the IFD it points to
+		kept.exif = filterEntries(m.exif, func(e exifEntry) bool {
+			return e.tag != exifTagMakerNote && e.tag != exifTagInteropIFD
+		})
+		if p.KeepGPS {
+			kept.gps = filterEntries(m.gps, func(e exifEntry) bool { return true })
+		}
+		// xmp packets repeat the exif position, so drop them with it
+		if p.KeepGPS || !bytes.Contains(m.XMP, []byte("GPSLatitude")) {
+			kept.XMP = m.XMP
+		}
+		kept.ICC = m.ICC
+	case MetadataAllowlist:
+		allowed := map[string]bool{}
+		for _, name := range p.Tags {
+			allowed[name] = true
+		}
+		kept.ifd0 = filterEntries(m.ifd0, func(e exifEntry) bool { return allowed[ifd0TagNames[e.tag]] })
+		kept.exif = filterEntries(m.exif, func(e exifEntry) bool { return allowed[exifTagNames[e.tag]] })
+		kept.gps = filterEntries(m.gps, func(e exifEntry) bool { return allowed[metadataGPS] || allowed[gpsTagNames[e.tag]] })
+		if allowed[metadataXMP] {
+			kept.XMP = m.XMP
+		}
+		if allowed[metadataICC] {
+			kept.ICC = m.ICC
+		}
+	}
+
+	for i, e := range kept.ifd0 {
+		if e.tag == exifTagOrientation {
+			kept.ifd0[i] = kept.withValue(e, uint32(OrientationNormal))
+		}
+	}
+	kept.XMP = uprightXMP(kept.XMP)
+
+	if kept.empty() && kept.XMP == nil && kept.ICC == nil {
+		return nil
+	}
+	return kept
+}
+
+// xmpOrientation matches the tiff:Orientation property of an XMP packet,
+// written either as an attribute or as an element.
+var xmpOrientation = regexp.MustCompile(`(tiff:Orientation\s*=\s*["'])[^"']*(["'])|(<tiff:Orientation>)[^<]*(</tiff:Orientation>)`)
+
+// uprightXMP returns a copy of packet with its tiff:Orientation set to
+// upright, which viewers would otherwise apply a second time.
+func uprightXMP(packet []byte) []byte {
+	if packet == nil {
+		return nil
+	}
+	return xmpOrientation.ReplaceAll(packet, []byte("${1}${3}1${2}${4}"))
+}
+
+func filterEntries(entries []exifEntry, keep func(e exifEntry) bool) []exifEntry {
+	var kept []exifEntry
+	for _, e := range entries {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// withValue returns e holding the single number v, for SHORT and LONG tags.
+func (m *Metadata) withValue(e exifEntry, v uint32) exifEntry {
+	switch {
+	case e.typ == exifTypeShort && v <= 0xffff:
+		e.value = make([]byte, 2)
+		m.order.PutUint16(e.value, uint16(v))
+	case e.typ == exifTypeLong, e.typ == exifTypeShort:
+		e.typ = exifTypeLong
+		e.value = make([]byte, 4)
+		m.order.PutUint32(e.value, v)
+	default:
+		return e
+	}
+	e.count = 1
+	return e
+}
+
+// Fields returns the named EXIF tags of m as text, keyed by tag name. Binary
+// tags are left out.
+func (m *Metadata) Fields() map[string]string {
+	if m == nil || m.empty() {
+		return nil
+	}
+	fields := map[string]string{}
+	add := func(entries []exifEntry, names map[uint16]string) {
+		for _, e := range entries {
+			name, ok := names[e.tag]
+			if !ok {
+				continue
+			}
+			if value, ok := m.format(e); ok && value != "" {
+				fields[name] = value
+			}
+		}
+	}
+	add(m.ifd0, ifd0TagNames)
+	add(m.exif, exifTagNames)
+	add(m.gps, gpsTagNames)
+	return fields
+}
+
+func (m *Metadata) format(e exifEntry) (string, bool) {
+	var values []string
+	switch e.typ {
+	case exifTypeASCII:
+		return strings.TrimSpace(strings.SplitN(string(e.value), "\x00", 2)[0]), true
+	case exifTypeUndefined:
+		// undefined values are only shown when they are text, e.g. the
+		// exif version
+		for _, c := range e.value {
+			if c < 0x20 || c > 0x7e {
+				return "", false
+			}
+		}
+		return string(e.value), true
+	case exifTypeByte:
+		for _, v := range e.value {
+			values = append(values, strconv.Itoa(int(v)))
+		}
+	case exifTypeShort:
+		for i := 0; i+2 <= len(e.value); i += 2 {
+			values = append(values, strconv.Itoa(int(m.order.Uint16(e.value[i:]))))
+		}
+	case exifTypeSShort:
+		for i := 0; i+2 <= len(e.value); i += 2 {
+			values = append(values, strconv.Itoa(int(int16(m.order.Uint16(e.value[i:])))))
+		}
+	case exifTypeLong:
+		for i := 0; i+4 <= len(e.value); i += 4 {
+			values = append(values, strconv.FormatUint(uint64(m.order.Uint32(e.value[i:])), 10))
+		}
+	case exifTypeSLong:
+		for i := 0; i+4 <= len(e.value); i += 4 {
+			values = append(values, strconv.Itoa(int(int32(m.order.Uint32(e.value[i:])))))
+		}
+	case exifTypeRational:
+		for i := 0; i+8 <= len(e.value); i += 8 {
+			values = append(values, fmt.Sprintf("%d/%d", m.order.Uint32(e.value[i:]), m.order.Uint32(e.value[i+4:])))
+		}
+	case exifTypeSRational:
+		for i := 0; i+8 <= len(e.value); i += 8 {
+			values = append(values, fmt.Sprintf("%d/%d", int32(m.order.Uint32(e.value[i:])), int32(m.order.Uint32(e.value[i+4:]))))
+		}
+	default:
+		return "", false
+	}
+	return strings.Join(values, " "), true
+}
+
+// EmbedMetadata writes m into an image encoded as contentType, sized
+// according to the pixel dimensions of the rendition. JPEG and PNG images
+// can carry metadata, other formats are returned as they are.
+func EmbedMetadata(data []byte, contentType string, m *Metadata, size image.Point) ([]byte, error) {
+	if m == nil {
+		return data, nil
+	}
+	switch contentType {
+	case "image/jpeg":
+		return embedJPEGMetadata(data, m, size)
+	case "image/png":
+		return embedPNGMetadata(data, m, size)
+	default:
+		return data, nil
+	}
+}
+
+// maxJPEGSegment is the largest payload of a jpeg marker segment.
+const maxJPEGSegment = 0xffff - 2
+
+func embedJPEGMetadata(data []byte, m *Metadata, size image.Point) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, errors.New("can not embed metadata, not a jpeg image")
+	}
+
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, parts ...[]byte) error {
+		length := 0
+		for _, p := range parts {
+			length += len(p)
+		}
+		if length > maxJPEGSegment {
+			return fmt.Errorf("can not embed metadata, %d bytes is too large for a jpeg segment", length)
+		}
+		segments.Write([]byte{0xff, marker, byte((length + 2) >> 8), byte(length + 2)})
+		for _, p := range parts {
+			segments.Write(p)
+		}
+		return nil
+	}
+
+	// the exif segment must directly follow the start of image
+	if !m.empty() {
+		if err := writeSegment(jpegAPP1, exifHeader, m.tiff(size)); err != nil {
+			return nil, err
+		}
+	}
+	if m.XMP != nil {
+		if err := writeSegment(jpegAPP1, xmpHeader, m.XMP); err != nil {
+			return nil, err
+		}
+	}
+	if m.ICC != nil {
+		chunkSize := maxJPEGSegment - len(iccHeader) - 2
+		chunks := (len(m.ICC) + chunkSize - 1) / chunkSize
+		if chunks > 255 {
+			return nil, errors.New("can not embed metadata, icc profile too large")
+		}
+		for i := 0; i < chunks; i++ {
+			end := (i + 1) * chunkSize
+			if end > len(m.ICC) {
+				end = len(m.ICC)
+			}
+			if err := writeSegment(jpegAPP2, iccHeader, []byte{byte(i + 1), byte(chunks)}, m.ICC[i*chunkSize:end]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := make([]byte, 0, len(data)+segments.Len())
+	out = append(out, data[:2]...)
+	out = append(out, segments.Bytes()...)
+	return append(out, data[2:]...), nil
+}
+
+func embedPNGMetadata(data []byte, m *Metadata, size image.Point) ([]byte, error) {
+	// the header chunk always comes first and holds 13 bytes
+	const ihdrEnd = 8 + 12 + 13
+	if !bytes.HasPrefix(data, pngHeader) || len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("can not embed metadata, not a png image")
+	}
+
+	var chunks bytes.Buffer
+	writeChunk := func(chunkType string, parts ...[]byte) {
+		body := bytes.Join(parts, nil)
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(body)))
+		chunks.Write(length[:])
+
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(chunkType))
+		crc.Write(body)
+		chunks.WriteString(chunkType)
+		chunks.Write(body)
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		chunks.Write(sum[:])
+	}
+
+	// the colour profile must precede the palette and image data
+	if m.ICC != nil {
+		var profile bytes.Buffer
+		zw := zlib.NewWriter(&profile)
+		if _, err := zw.Write(m.ICC); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		writeChunk("iCCP", []byte("ICC Profile\x00\x00"), profile.Bytes())
+	}
+	if !m.empty() {
+		writeChunk("eXIf", m.tiff(size))
+	}
+	if m.XMP != nil {
+		// uncompressed, with no language tag or translated keyword
+		writeChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), m.XMP)
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// tiff returns the EXIF tags of m as a TIFF structure, with the pixel
+// dimensions updated to size.
+func (m *Metadata) tiff(size image.Point) []byte {
+	ifd0 := append([]exifEntry(nil), m.ifd0...)
+	exif := append([]exifEntry(nil), m.exif...)
+	gps := append([]exifEntry(nil), m.gps...)
+	for i, e := range exif {
+		switch e.tag {
+		case exifTagPixelXDimension:
+			exif[i] = m.withValue(e, uint32(size.X))
+		case exifTagPixelYDimension:
+			exif[i] = m.withValue(e, uint32(size.Y))
+		}
+	}
+
+	// the sub IFDs follow IFD0, so their offsets are known once its size is
+	pointer := func(tag uint16, offset int) exifEntry {
+		return m.withValue(exifEntry{tag: tag, typ: exifTypeLong}, uint32(offset))
+	}
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, pointer(exifTagExifIFD, 0))
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, pointer(exifTagGPSIFD, 0))
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exif)
+	for i, e := range ifd0 {
+		switch e.tag {
+		case exifTagExifIFD:
+			ifd0[i] = pointer(e.tag, exifOffset)
+		case exifTagGPSIFD:
+			ifd0[i] = pointer(e.tag, gpsOffset)
+		}
+	}
+
+	buf := make([]byte, 8, gpsOffset+ifdSize(gps))
+	if m.order == binary.LittleEndian {
+		copy(buf, "II")
+	} else {
+		copy(buf, "MM")
+	}
+	m.order.PutUint16(buf[2:], 42)
+	m.order.PutUint32(buf[4:], 8)
+
+	buf = m.appendIFD(buf, ifd0)
+	if len(exif) > 0 {
+		buf = m.appendIFD(buf, exif)
+	}
+	if len(gps) > 0 {
+		buf = m.appendIFD(buf, gps)
+	}
+	return buf
+}
+
+// ifdSize returns the bytes an IFD of entries takes, including the values
+// too large to be stored inline, each padded to a word boundary.
+func ifdSize(entries []exifEntry) int {
+	if len(entries) == 0 {
+		return 0
+	}
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += len(e.value) + len(e.value)%2
+		}
+	}
+	return size
+}
+
+// appendIFD appends an IFD of entries, sorted by tag as TIFF requires,
+// followed by its out of line values.
+func (m *Metadata) appendIFD(buf []byte, entries []exifEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	start := len(buf)
+	dataOffset := start + 2 + 12*len(entries) + 4
+	ifd := make([]byte, dataOffset-start)
+	var data []byte
+
+	m.order.PutUint16(ifd, uint16(len(entries)))
+	for i, e := range entries {
+		entry := ifd[2+12*i:]
+		m.order.PutUint16(entry, e.tag)
+		m.order.PutUint16(entry[2:], e.typ)
+		m.order.PutUint32(entry[4:], e.count)
+		if len(e.value) <= 4 {
+			copy(entry[8:12], e.value)
+			continue
+		}
+		m.order.PutUint32(entry[8:], uint32(dataOffset+len(data)))
+		data = append(data, e.value...)
+		if len(e.value)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+
+	buf = append(buf, ifd...)
+	return append(buf, data...)
+}
@@ -0,0 +1,109 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+const (
+	xmpAttribute = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:tiff="http://ns.adobe.com/tiff/1.0/" tiff:Orientation="6" tiff:Make="Phone"/>` +
+		`</rdf:RDF></x:xmpmeta>`
+	xmpElement = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:tiff="http://ns.adobe.com/tiff/1.0/"><tiff:Orientation>8</tiff:Orientation></rdf:Description>` +
+		`</rdf:RDF></x:xmpmeta>`
+)
+
+// rotatedMetadata returns metadata with an EXIF Orientation of 6 and the
+// XMP packet xmp.
+func rotatedMetadata(xmp string) *Metadata {
+	return &Metadata{
+		order: binary.BigEndian,
+		ifd0: []exifEntry{
+			{tag: exifTagOrientation, typ: exifTypeShort, count: 1, value: []byte{0, 6}},
+		},
+		XMP: []byte(xmp),
+	}
+}
+
+func TestApplyResetsOrientation(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  MetadataPolicy
+		xmp     string
+		wantXMP string
+	}{
+		{
+			name:    "keep, xmp attribute",
+			policy:  MetadataPolicy{Mode: MetadataKeep},
+			xmp:     xmpAttribute,
+			wantXMP: `tiff:Orientation="1" tiff:Make="Phone"`,
+		},
+		{
+			name:    "keep, xmp element",
+			policy:  MetadataPolicy{Mode: MetadataKeep},
+			xmp:     xmpElement,
+			wantXMP: `<tiff:Orientation>1</tiff:Orientation>`,
+		},
+		{
+			name:    "allowlist",
+			policy:  MetadataPolicy{Mode: MetadataAllowlist, Tags: []string{"Orientation", metadataXMP}},
+			xmp:     xmpAttribute,
+			wantXMP: `tiff:Orientation="1"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := rotatedMetadata(tt.xmp)
+			kept := tt.policy.Apply(source)
+			if kept == nil {
+				t.Fatal("Apply() kept nothing")
+			}
+			if o := kept.Orientation(); o != OrientationNormal {
+				t.Errorf("EXIF orientation = %d, want %d", o, OrientationNormal)
+			}
+			if !bytes.Contains(kept.XMP, []byte(tt.wantXMP)) {
+				t.Errorf("XMP = %s, want it to contain %s", kept.XMP, tt.wantXMP)
+			}
+			if string(source.XMP) != tt.xmp || source.Orientation() != OrientationRotate90 {
+				t.Error("Apply() changed the source metadata")
+			}
+		})
+	}
+}
+
+func TestEmbedMetadataUpright(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	kept := MetadataPolicy{Mode: MetadataKeep}.Apply(rotatedMetadata(xmpAttribute))
+	encoded, err := EmbedMetadata(b.Bytes(), "image/jpeg", kept, image.Pt(8, 4))
+	if err != nil {
+		t.Fatalf("EmbedMetadata() error = %v", err)
+	}
+
+	read, err := ReadMetadata(encoded)
+	if err != nil {
+		t.Fatalf("ReadMetadata() error = %v", err)
+	}
+	if o := read.Orientation(); o != OrientationNormal {
+		t.Errorf("written EXIF orientation = %d, want %d", o, OrientationNormal)
+	}
+	if bytes.Contains(read.XMP, []byte(`tiff:Orientation="6"`)) || !bytes.Contains(read.XMP, []byte(`tiff:Orientation="1"`)) {
+		t.Errorf("written XMP = %s, want an upright tiff:Orientation", read.XMP)
+	}
+}
+
+func TestUprightXMPWithoutOrientation(t *testing.T) {
+	packet := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+	if got := uprightXMP(packet); !bytes.Equal(got, packet) {
+		t.Errorf("uprightXMP() = %s, want %s", got, packet)
+	}
+	if got := uprightXMP(nil); got != nil {
+		t.Errorf("uprightXMP(nil) = %s, want nil", got)
+	}
+}
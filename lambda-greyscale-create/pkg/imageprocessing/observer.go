@@ -0,0 +1,119 @@
+package imageprocessing
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ActionEvent describes one action of a pipeline run. BeforeAction is given
+// the index, name and input size, AfterAction every field.
+type ActionEvent struct {
+	// Index is the position of the action in the pipeline, Name the name it
+	// was registered under, or its type for actions added directly.
+	Index int
+	Name  string
+	// Outputs names the outputs the result of the action feeds when it runs
+	// in a PipelineGraph, where Index counts from the root of the graph.
+	Outputs []string
+
+	InputSize  image.Point
+	OutputSize image.Point
+	Duration   time.Duration
+	// Allocs and AllocBytes are the heap allocations made while the action
+	// ran, left at zero unless ctx asks for them with
+	// WithAllocationTracking. They come from the process wide runtime
+	// statistics, so anything running alongside the pipeline is counted too.
+	Allocs     uint64
+	AllocBytes uint64
+	Err        error
+}
+
+// PipelineObserver is told about every action a pipeline runs. Observers
+// are called from the goroutine running the pipeline.
+type PipelineObserver interface {
+	BeforeAction(ctx context.Context, event ActionEvent)
+	AfterAction(ctx context.Context, event ActionEvent)
+}
+
+type observersKey struct{}
+
+type allocationTrackingKey struct{}
+
+// WithPipelineObserver returns a copy of ctx under which pipelines report
+// every action they run to observer, as well as to any observers ctx already
+// carries.
+func WithPipelineObserver(ctx context.Context, observer PipelineObserver) context.Context {
+	parent := pipelineObservers(ctx)
+	observers := make([]PipelineObserver, len(parent), len(parent)+1)
+	copy(observers, parent)
+	return context.WithValue(ctx, observersKey{}, append(observers, observer))
+}
+
+func pipelineObservers(ctx context.Context) []PipelineObserver {
+	observers, _ := ctx.Value(observersKey{}).([]PipelineObserver)
+	return observers
+}
+
+// WithAllocationTracking returns a copy of ctx under which observed
+// pipelines fill in the Allocs and AllocBytes of every ActionEvent. Reading
+// the allocation statistics stops the world before and after each action, so
+// it is left off unless asked for.
+func WithAllocationTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allocationTrackingKey{}, true)
+}
+
+// AllocationTracking reports whether ctx asks for allocation tracking, see
+// WithAllocationTracking.
+func AllocationTracking(ctx context.Context) bool {
+	tracking, _ := ctx.Value(allocationTrackingKey{}).(bool)
+	return tracking
+}
+
+// transformObserved runs action as the pipeline step event describes,
+// telling observers about it before and after.
+func transformObserved(ctx context.Context, observers []PipelineObserver, event ActionEvent, action ImageAction, img image.Image) (image.Image, error) {
+	event.InputSize = img.Bounds().Size()
+	for _, o := range observers {
+		o.BeforeAction(ctx, event)
+	}
+
+	tracking := AllocationTracking(ctx)
+	var before, after runtime.MemStats
+	if tracking {
+		runtime.ReadMemStats(&before)
+	}
+	start := time.Now()
+	out, err := ActionWithContext(action).TransformContext(ctx, img)
+	event.Duration = time.Since(start)
+	if tracking {
+		runtime.ReadMemStats(&after)
+		event.Allocs = after.Mallocs - before.Mallocs
+		event.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	}
+	if out != nil {
+		event.OutputSize = out.Bounds().Size()
+	}
+	event.Err = err
+	for _, o := range observers {
+		o.AfterAction(ctx, event)
+	}
+	return out, err
+}
+
+// actionName names an action added without a spec after its type, so
+// actionResize is "resize".
+func actionName(action ImageAction) string {
+	t := reflect.TypeOf(action)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return fmt.Sprintf("%T", action)
+	}
+	return strings.ToLower(strings.TrimPrefix(t.Name(), "action"))
+}
@@ -0,0 +1,108 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// recordingObserver keeps every event it is given after an action.
+type recordingObserver struct {
+	events []ActionEvent
+}
+
+func (o *recordingObserver) BeforeAction(ctx context.Context, event ActionEvent) {}
+
+func (o *recordingObserver) AfterAction(ctx context.Context, event ActionEvent) {
+	o.events = append(o.events, event)
+}
+
+func TestPipelineObserverAllocations(t *testing.T) {
+	tests := []struct {
+		name       string
+		track      bool
+		wantAllocs bool
+	}{
+		{name: "untracked", track: false, wantAllocs: false},
+		{name: "tracked", track: true, wantAllocs: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer := &recordingObserver{}
+			ctx := WithPipelineObserver(context.Background(), observer)
+			if tt.track {
+				ctx = WithAllocationTracking(ctx)
+			}
+			if got := AllocationTracking(ctx); got != tt.track {
+				t.Errorf("AllocationTracking() = %v, want %v", got, tt.track)
+			}
+
+			pipeline, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{
+				{Name: "greyscale"},
+				{Name: "resize", Params: Params{"width": 20, "height": 20}},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := PipelineWithContext(pipeline).TransformContext(ctx, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+				t.Fatalf("TransformContext() error = %v", err)
+			}
+			if len(observer.events) != 2 {
+				t.Fatalf("observed %d actions, want 2", len(observer.events))
+			}
+			for _, event := range observer.events {
+				if got := event.Allocs > 0 && event.AllocBytes > 0; got != tt.wantAllocs {
+					t.Errorf("action %s allocs = %d, %d bytes, want them filled in %v", event.Name, event.Allocs, event.AllocBytes, tt.wantAllocs)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineMergesLookupTables(t *testing.T) {
+	specs := []ActionSpec{
+		{Name: "brightness", Params: Params{"amount": 0.2}},
+		{Name: "contrast", Params: Params{"amount": 0.3}},
+		{Name: "gamma", Params: Params{"gamma": 1.5}},
+		{Name: "greyscale"},
+		{Name: "levels", Params: Params{"black": 20, "white": 230}},
+	}
+	pipeline, err := NewPipelineFromSpec(PipelineSpec{Actions: specs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []image.Image{colourSource(), halfTransparent()} {
+		observer := &recordingObserver{}
+		ctx := WithPipelineObserver(context.Background(), observer)
+		got, err := PipelineWithContext(pipeline).TransformContext(ctx, src)
+		if err != nil {
+			t.Fatalf("TransformContext() error = %v", err)
+		}
+		var names []string
+		for _, event := range observer.events {
+			names = append(names, event.Name)
+		}
+		if want := []string{"brightness+contrast+gamma", "greyscale", "levels"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("ran %v, want %v", names, want)
+		}
+
+		// the merged table gives the image running each action in turn
+		// would
+		want := image.Image(src)
+		for _, spec := range specs {
+			action, err := NewAction(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, err = action.Transform(want); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(toRGBA(got).Pix, toRGBA(want).Pix) {
+			t.Errorf("merged pipeline differs from running %d actions in turn, mean error %.2f", len(specs), meanError(got, want))
+		}
+	}
+}
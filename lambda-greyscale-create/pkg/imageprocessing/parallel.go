@@ -0,0 +1,70 @@
+package imageprocessing
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// minRowsPerBand stops small images being split into bands so thin that
+	// scheduling them costs more than processing them.
+	minRowsPerBand = 16
+	// maxRowsPerBand keeps bands short enough that cancellation is noticed
+	// soon after it happens.
+	maxRowsPerBand = 64
+)
+
+// parallelRows splits the rows [0, height) into contiguous bands and runs fn
+// over each band on a bounded pool of workers, one per available CPU. Once
+// ctx is done the bands not yet started are skipped, so callers must check
+// ctx.Err() before using what fn produced.
+func parallelRows(ctx context.Context, height int, fn func(y0, y1 int)) {
+	if height <= 0 {
+		return
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	bandSize := (height + workers - 1) / workers
+	if bandSize < minRowsPerBand {
+		bandSize = minRowsPerBand
+	}
+	if bandSize > maxRowsPerBand {
+		bandSize = maxRowsPerBand
+	}
+	bands := (height + bandSize - 1) / bandSize
+	if workers > bands {
+		workers = bands
+	}
+
+	// workers take the next band until every band is taken or ctx is done
+	next := int64(-1)
+	work := func() {
+		for ctx.Err() == nil {
+			band := int(atomic.AddInt64(&next, 1))
+			if band >= bands {
+				return
+			}
+			y0, y1 := band*bandSize, (band+1)*bandSize
+			if y1 > height {
+				y1 = height
+			}
+			fn(y0, y1)
+		}
+	}
+	if workers <= 1 {
+		work()
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	wg.Wait()
+}
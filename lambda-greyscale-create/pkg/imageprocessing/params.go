@@ -0,0 +1,225 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Params are the parameters of a single action in a PipelineSpec, as decoded
+// from JSON or YAML.
+type Params map[string]interface{}
+
+// ParamError reports a parameter that is missing, unknown or has a bad value.
+type ParamError struct {
+	Param string
+	Err   error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("parameter %q: %v", e.Param, e.Err)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// ParamReader reads typed values out of Params for an ActionFactory. It keeps
+// the first error it encounters so factories can read every parameter and
+// check Err once, and it tracks which parameters were read so that unknown
+// ones can be rejected.
+type ParamReader struct {
+	params    Params
+	resources ResourceOpener
+	read      map[string]bool
+	err       error
+}
+
+// NewParamReader returns a reader of params whose resources are local
+// files.
+func NewParamReader(params Params) *ParamReader {
+	return NewParamReaderWithResources(params, LocalResources{})
+}
+
+// NewParamReaderWithResources returns a reader of params that opens the
+// resources they name with resources.
+func NewParamReaderWithResources(params Params, resources ResourceOpener) *ParamReader {
+	return &ParamReader{
+		params:    params,
+		resources: resources,
+		read:      map[string]bool{},
+	}
+}
+
+// Err returns the first error encountered, or an error naming the first
+// parameter that was never read.
+func (r *ParamReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	var unknown []string
+	for name := range r.params {
+		if !r.read[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &ParamError{Param: unknown[0], Err: fmt.Errorf("unknown parameter")}
+	}
+	return nil
+}
+
+// Fail records an error against a parameter, for validation that the typed
+// readers cannot do themselves.
+func (r *ParamReader) Fail(name string, format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = &ParamError{Param: name, Err: fmt.Errorf(format, args...)}
+	}
+}
+
+// Has reports whether the parameter was given.
+func (r *ParamReader) Has(name string) bool {
+	_, ok := r.params[name]
+	return ok
+}
+
+func (r *ParamReader) lookup(name string) (interface{}, bool) {
+	r.read[name] = true
+	v, ok := r.params[name]
+	return v, ok && v != nil
+}
+
+// Int reads an integer parameter, returning def when it is not set.
+func (r *ParamReader) Int(name string, def int) int {
+	v, ok := r.lookup(name)
+	if !ok {
+		return def
+	}
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case uint64:
+		return int(n)
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < math.MaxInt32 {
+			return int(n)
+		}
+	}
+	r.Fail(name, "expected an integer, got %v", v)
+	return def
+}
+
+// RequiredInt reads an integer parameter that must be set.
+func (r *ParamReader) RequiredInt(name string) int {
+	if !r.Has(name) {
+		r.lookup(name)
+		r.Fail(name, "is required")
+		return 0
+	}
+	return r.Int(name, 0)
+}
+
+// Float reads a numeric parameter, returning def when it is not set.
+func (r *ParamReader) Float(name string, def float64) float64 {
+	v, ok := r.lookup(name)
+	if !ok {
+		return def
+	}
+	if n, ok := numberValue(v); ok {
+		return n
+	}
+	r.Fail(name, "expected a number, got %v", v)
+	return def
+}
+
+// numberValue converts the numeric types produced by the JSON and YAML
+// decoders to a finite float64.
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		if !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// String reads a string parameter, returning def when it is not set.
+func (r *ParamReader) String(name string, def string) string {
+	v, ok := r.lookup(name)
+	if !ok {
+		return def
+	}
+	s, isString := v.(string)
+	if !isString {
+		r.Fail(name, "expected a string, got %v", v)
+		return def
+	}
+	return s
+}
+
+// Bool reads a boolean parameter, returning def when it is not set.
+func (r *ParamReader) Bool(name string, def bool) bool {
+	v, ok := r.lookup(name)
+	if !ok {
+		return def
+	}
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		if parsed, err := strconv.ParseBool(b); err == nil {
+			return parsed
+		}
+	}
+	r.Fail(name, "expected a boolean, got %v", v)
+	return def
+}
+
+// IntRange reads an integer parameter and checks it lies within [min, max].
+func (r *ParamReader) IntRange(name string, def, min, max int) int {
+	if !r.Has(name) {
+		return r.Int(name, def)
+	}
+	return r.checkIntRange(name, r.Int(name, def), def, min, max)
+}
+
+// RequiredIntRange reads an integer parameter that must be set and lie within
+// [min, max].
+func (r *ParamReader) RequiredIntRange(name string, min, max int) int {
+	if !r.Has(name) {
+		return r.RequiredInt(name)
+	}
+	return r.checkIntRange(name, r.Int(name, 0), 0, min, max)
+}
+
+func (r *ParamReader) checkIntRange(name string, v, def, min, max int) int {
+	if v < min || v > max {
+		r.Fail(name, "must be between %d and %d, got %d", min, max, v)
+		return def
+	}
+	return v
+}
+
+// FloatRange reads a numeric parameter and checks it lies within [min, max].
+func (r *ParamReader) FloatRange(name string, def, min, max float64) float64 {
+	if !r.Has(name) {
+		return r.Float(name, def)
+	}
+	v := r.Float(name, def)
+	if v < min || v > max {
+		r.Fail(name, "must be between %g and %g, got %g", min, max, v)
+		return def
+	}
+	return v
+}
@@ -1,6 +1,7 @@
 package imageprocessing
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"image"
@@ -12,10 +13,12 @@ type ProcessorPipeline interface {
 	Transform(image.Image) (image.Image, error)
 }
 
-var _ ProcessorPipeline = &processorPipeline{}
+var _ ContextProcessorPipeline = &processorPipeline{}
 
 type processorPipeline struct {
 	imageProcesses []ImageAction
+	// names holds the name of each action, reported to observers
+	names []string
 }
 
 func NewProcessorPipeline() ProcessorPipeline {
@@ -28,17 +31,48 @@ func (p *processorPipeline) AddAction(action ImageAction) {
 	if action == nil {
 		return
 	}
+	p.addNamedAction(actionName(action), action)
+}
+
+// addNamedAction adds action under name, the name observers see it by.
+func (p *processorPipeline) addNamedAction(name string, action ImageAction) {
+	if action == nil {
+		return
+	}
+	// consecutive lookup tables compose into one, saving a pass over the image
+	if next, ok := action.(*actionLUT); ok && len(p.imageProcesses) > 0 {
+		last := len(p.imageProcesses) - 1
+		if lut, ok := p.imageProcesses[last].(*actionLUT); ok {
+			p.imageProcesses[last] = lut.then(*next)
+			p.names[last] += "+" + name
+			return
+		}
+	}
 	p.imageProcesses = append(p.imageProcesses, action)
+	p.names = append(p.names, name)
 }
 
 func (p processorPipeline) Transform(image image.Image) (image.Image, error) {
+	return p.TransformContext(context.Background(), image)
+}
+
+// TransformContext runs the actions in order, stopping with ctx.Err() once
+// ctx is done. Each action is reported to the observers ctx carries, see
+// WithPipelineObserver.
+func (p processorPipeline) TransformContext(ctx context.Context, image image.Image) (image.Image, error) {
 	if image == nil {
 		return nil, errors.New("image should not be nil")
 	}
+	observers := pipelineObservers(ctx)
 	currentImage := image
-	for _, processor := range p.imageProcesses {
+	for i, processor := range p.imageProcesses {
 		var err error
-		currentImage, err = processor.Transform(currentImage)
+		if len(observers) > 0 {
+			event := ActionEvent{Index: i, Name: p.names[i]}
+			currentImage, err = transformObserved(ctx, observers, event, processor, currentImage)
+		} else {
+			currentImage, err = ActionWithContext(processor).TransformContext(ctx, currentImage)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to transform image: %w", err)
 		}
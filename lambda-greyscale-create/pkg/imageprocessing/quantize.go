@@ -0,0 +1,187 @@
+package imageprocessing
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// quantizeBits is the precision each channel is counted at when building a
+// palette, 5 bits gives 32768 colour cells.
+const quantizeBits = 5
+
+// colorCell is the pixels of an image falling in one cell of the colour
+// histogram, with the sums of their channels to average them.
+type colorCell struct {
+	// key holds the channels of the cell at quantizeBits each
+	key   [3]uint8
+	count uint64
+	sum   [3]uint64
+}
+
+// colorBox is a set of cells that becomes one palette entry.
+type colorBox struct {
+	cells []colorCell
+	count uint64
+}
+
+// medianCutPalette returns a palette of at most n colours chosen for img by
+// median cut: the colours of img are split in two at the median of their
+// widest channel until there are n groups, each of which gives the average
+// of its pixels. Pixels that are mostly transparent are left out.
+func medianCutPalette(img image.Image, n int) color.Palette {
+	boxes := []colorBox{newColorBox(colorHistogram(img))}
+	if len(boxes[0].cells) == 0 {
+		return color.Palette{color.RGBA{A: 0xff}}
+	}
+
+	for len(boxes) < n {
+		i := boxToSplit(boxes)
+		if i < 0 {
+			break
+		}
+		a, b := boxes[i].split()
+		boxes[i] = a
+		boxes = append(boxes, b)
+	}
+
+	p := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		p[i] = box.average()
+	}
+	return p
+}
+
+// colorHistogram counts the pixels of img by colour cell.
+func colorHistogram(img image.Image) []colorCell {
+	const shift = 8 - quantizeBits
+	cells := map[uint32]*colorCell{}
+	add := func(r, g, b uint8) {
+		key := uint32(r>>shift)<<(2*quantizeBits) | uint32(g>>shift)<<quantizeBits | uint32(b>>shift)
+		cell, ok := cells[key]
+		if !ok {
+			cell = &colorCell{key: [3]uint8{r >> shift, g >> shift, b >> shift}}
+			cells[key] = cell
+		}
+		cell.count++
+		cell.sum[0] += uint64(r)
+		cell.sum[1] += uint64(g)
+		cell.sum[2] += uint64(b)
+	}
+
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := rgba.Pix[rgba.PixOffset(b.Min.X, y):rgba.PixOffset(b.Max.X, y)]
+			for x := 0; x < len(row); x += 4 {
+				if a := row[x+3]; a >= 0x80 {
+					c := color.NRGBAModel.Convert(color.RGBA{R: row[x], G: row[x+1], B: row[x+2], A: a}).(color.NRGBA)
+					add(c.R, c.G, c.B)
+				}
+			}
+		}
+	} else {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A >= 0x80 {
+					add(c.R, c.G, c.B)
+				}
+			}
+		}
+	}
+
+	histogram := make([]colorCell, 0, len(cells))
+	for _, cell := range cells {
+		histogram = append(histogram, *cell)
+	}
+	// map order is random, sort so the palette is the same on every run
+	sort.Slice(histogram, func(i, j int) bool {
+		a, b := histogram[i].key, histogram[j].key
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	return histogram
+}
+
+func newColorBox(cells []colorCell) colorBox {
+	box := colorBox{cells: cells}
+	for _, cell := range cells {
+		box.count += cell.count
+	}
+	return box
+}
+
+// boxToSplit returns the index of the box covering the most pixels over the
+// widest range of a channel, or -1 when every box holds a single cell.
+func boxToSplit(boxes []colorBox) int {
+	best, bestScore := -1, uint64(0)
+	for i, box := range boxes {
+		if len(box.cells) < 2 {
+			continue
+		}
+		_, width := box.widestChannel()
+		if score := box.count * uint64(width+1); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// widestChannel returns the channel whose values spread the most across the
+// box, along with that spread.
+func (box colorBox) widestChannel() (int, uint8) {
+	var channel int
+	var widest uint8
+	for c := 0; c < 3; c++ {
+		min, max := box.cells[0].key[c], box.cells[0].key[c]
+		for _, cell := range box.cells[1:] {
+			if v := cell.key[c]; v < min {
+				min = v
+			} else if v > max {
+				max = v
+			}
+		}
+		if max-min > widest {
+			channel, widest = c, max-min
+		}
+	}
+	return channel, widest
+}
+
+// split divides the box at the median pixel of its widest channel, leaving
+// at least one cell on each side.
+func (box colorBox) split() (colorBox, colorBox) {
+	channel, _ := box.widestChannel()
+	sort.SliceStable(box.cells, func(i, j int) bool {
+		return box.cells[i].key[channel] < box.cells[j].key[channel]
+	})
+
+	at, seen := 1, box.cells[0].count
+	for at < len(box.cells)-1 && seen < box.count/2 {
+		seen += box.cells[at].count
+		at++
+	}
+	return newColorBox(box.cells[:at]), newColorBox(box.cells[at:])
+}
+
+// average returns the mean colour of the pixels of the box.
+func (box colorBox) average() color.Color {
+	var sum [3]uint64
+	for _, cell := range box.cells {
+		for c := range sum {
+			sum[c] += cell.sum[c]
+		}
+	}
+	return color.RGBA{
+		R: uint8((sum[0] + box.count/2) / box.count),
+		G: uint8((sum[1] + box.count/2) / box.count),
+		B: uint8((sum[2] + box.count/2) / box.count),
+		A: 0xff,
+	}
+}
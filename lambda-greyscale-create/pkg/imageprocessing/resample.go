@@ -0,0 +1,252 @@
+package imageprocessing
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ResampleFilter selects the kernel used when an image is scaled.
+type ResampleFilter int
+
+const (
+	FilterNearest ResampleFilter = iota
+	FilterBilinear
+	FilterCatmullRom
+	FilterLanczos
+)
+
+var resampleFilterNames = map[ResampleFilter]string{
+	FilterNearest:    "nearest",
+	FilterBilinear:   "bilinear",
+	FilterCatmullRom: "catmullrom",
+	FilterLanczos:    "lanczos",
+}
+
+func (f ResampleFilter) String() string {
+	if name, ok := resampleFilterNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("ResampleFilter(%d)", int(f))
+}
+
+// ParseResampleFilter returns the filter with the given name.
+func ParseResampleFilter(name string) (ResampleFilter, error) {
+	for f, n := range resampleFilterNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown resample filter %q, expected nearest, bilinear, catmullrom or lanczos", name)
+}
+
+// support is the radius of the kernel in source pixels when not downscaling.
+func (f ResampleFilter) support() float64 {
+	switch f {
+	case FilterBilinear:
+		return 1
+	case FilterCatmullRom:
+		return 2
+	case FilterLanczos:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func (f ResampleFilter) kernel(x float64) float64 {
+	x = math.Abs(x)
+	switch f {
+	case FilterBilinear:
+		if x < 1 {
+			return 1 - x
+		}
+	case FilterCatmullRom:
+		if x < 1 {
+			return (3*x*x*x - 5*x*x + 2) / 2
+		}
+		if x < 2 {
+			return (-x*x*x + 5*x*x - 8*x + 4) / 2
+		}
+	case FilterLanczos:
+		if x < 3 {
+			return sinc(x) * sinc(x/3)
+		}
+	}
+	return 0
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// toRGBA returns img as a zero-origin *image.RGBA, copying it only when it is
+// not already in that form.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// pixelWeights is the contribution of a run of source pixels, starting at
+// start, to a single destination pixel.
+type pixelWeights struct {
+	start   int
+	weights []float32
+}
+
+func computeWeights(dstSize, srcSize int, filter ResampleFilter) []pixelWeights {
+	scale := float64(srcSize) / float64(dstSize)
+	filterScale := math.Max(scale, 1)
+	support := filter.support() * filterScale
+
+	out := make([]pixelWeights, dstSize)
+	for i := range out {
+		center := (float64(i) + 0.5) * scale
+		left := int(math.Floor(center - support))
+		if left < 0 {
+			left = 0
+		}
+		right := int(math.Ceil(center + support))
+		if right > srcSize {
+			right = srcSize
+		}
+
+		weights := make([]float32, 0, right-left)
+		var sum float64
+		for j := left; j < right; j++ {
+			w := filter.kernel((float64(j) + 0.5 - center) / filterScale)
+			weights = append(weights, float32(w))
+			sum += w
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] = float32(float64(weights[j]) / sum)
+			}
+		}
+		out[i] = pixelWeights{start: left, weights: weights}
+	}
+	return out
+}
+
+// resample scales src to w x h. Filtering is done on premultiplied values so
+// transparent edges do not bleed colour. It returns ctx.Err() once ctx is
+// done.
+func resample(ctx context.Context, src *image.RGBA, w, h int, filter ResampleFilter) (*image.RGBA, error) {
+	var dst *image.RGBA
+	if filter == FilterNearest {
+		dst = resampleNearest(ctx, src, w, h)
+	} else {
+		dst = resampleFiltered(ctx, src, w, h, filter)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func resampleFiltered(ctx context.Context, src *image.RGBA, w, h int, filter ResampleFilter) *image.RGBA {
+	b := src.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+
+	// horizontal pass into a float buffer of w x srcH
+	xWeights := computeWeights(w, srcW, filter)
+	tmp := make([]float32, w*srcH*4)
+	parallelRows(ctx, srcH, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			out := tmp[y*w*4:]
+			for x, pw := range xWeights {
+				var r, g, bl, a float32
+				for k, weight := range pw.weights {
+					i := (pw.start + k) * 4
+					r += float32(row[i]) * weight
+					g += float32(row[i+1]) * weight
+					bl += float32(row[i+2]) * weight
+					a += float32(row[i+3]) * weight
+				}
+				out[x*4] = r
+				out[x*4+1] = g
+				out[x*4+2] = bl
+				out[x*4+3] = a
+			}
+		}
+	})
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// vertical pass from the float buffer into the destination
+	yWeights := computeWeights(h, srcH, filter)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			pw := yWeights[y]
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < w; x++ {
+				var r, g, bl, a float32
+				for k, weight := range pw.weights {
+					i := ((pw.start+k)*w + x) * 4
+					r += tmp[i] * weight
+					g += tmp[i+1] * weight
+					bl += tmp[i+2] * weight
+					a += tmp[i+3] * weight
+				}
+				alpha := clampUint8(a)
+				out[x*4] = clampUint8Max(r, alpha)
+				out[x*4+1] = clampUint8Max(g, alpha)
+				out[x*4+2] = clampUint8Max(bl, alpha)
+				out[x*4+3] = alpha
+			}
+		}
+	})
+	return dst
+}
+
+func resampleNearest(ctx context.Context, src *image.RGBA, w, h int) *image.RGBA {
+	b := src.Bounds()
+	xScale := float64(b.Dx()) / float64(w)
+	yScale := float64(b.Dy()) / float64(h)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy := int((float64(y) + 0.5) * yScale)
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+sy):]
+			out := dst.Pix[y*dst.Stride:]
+			for x := 0; x < w; x++ {
+				sx := int((float64(x) + 0.5) * xScale)
+				copy(out[x*4:x*4+4], row[sx*4:sx*4+4])
+			}
+		}
+	})
+	return dst
+}
+
+func clampUint8(v float32) uint8 {
+	return clampUint8Max(v, 0xff)
+}
+
+// clampUint8Max rounds v and clamps it to [0, max]; colour channels are
+// clamped to alpha so ringing kernels cannot produce invalid premultiplied
+// pixels.
+func clampUint8Max(v float32, max uint8) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= float32(max) {
+		return max
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,76 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// maxResourceSize bounds the size of a resource read for an action
+// parameter.
+const maxResourceSize = 16 << 20
+
+// ResourceOpener opens the files that action parameters refer to by name,
+// such as watermark images and fonts.
+type ResourceOpener interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// LocalResources opens resources from the local filesystem, resolving
+// relative names against Dir.
+type LocalResources struct {
+	Dir string
+}
+
+var _ ResourceOpener = LocalResources{}
+
+func (l LocalResources) Open(name string) (io.ReadCloser, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(l.Dir, name)
+	}
+	return os.Open(name)
+}
+
+// Resource reads the contents of the required resource named by a string
+// parameter.
+func (r *ParamReader) Resource(name string) []byte {
+	if !r.Has(name) {
+		r.lookup(name)
+		r.Fail(name, "is required")
+		return nil
+	}
+	resource := r.String(name, "")
+	if r.err != nil {
+		return nil
+	}
+	if resource == "" {
+		r.Fail(name, "must not be empty")
+		return nil
+	}
+
+	data, err := readResource(r.resources, resource)
+	if err != nil {
+		r.Fail(name, "%v", err)
+		return nil
+	}
+	return data
+}
+
+func readResource(resources ResourceOpener, name string) ([]byte, error) {
+	f, err := resources.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s : %w", name, err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxResourceSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s : %w", name, err)
+	}
+	if len(data) > maxResourceSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxResourceSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,106 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mapResources opens resources from memory, failing with os.ErrNotExist for
+// names it doesn't hold.
+type mapResources map[string][]byte
+
+func (m mapResources) Open(name string) (io.ReadCloser, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("open %s : %w", name, os.ErrNotExist)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// sizedResources opens every name as a resource of its size in bytes,
+// without holding them in memory.
+type sizedResources map[string]int64
+
+func (m sizedResources) Open(name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(io.LimitReader(zeroReader{}, m[name])), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestReadResource(t *testing.T) {
+	sized := sizedResources{"limit": maxResourceSize, "over": maxResourceSize + 1}
+	if data, err := readResource(sized, "limit"); err != nil || len(data) != maxResourceSize {
+		t.Errorf("readResource() of %d bytes = %d bytes, %v, want it read whole", maxResourceSize, len(data), err)
+	}
+	if _, err := readResource(sized, "over"); err == nil {
+		t.Errorf("readResource() accepted a resource over %d bytes", maxResourceSize)
+	}
+
+	if _, err := readResource(mapResources{}, "missing.png"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readResource() error = %v, want it to wrap os.ErrNotExist", err)
+	}
+}
+
+func TestLocalResources(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "mark.png"), []byte("mark"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// relative names are read from Dir, absolute ones as they are
+	for _, name := range []string{"mark.png", filepath.Join(dir, "mark.png")} {
+		data, err := readResource(LocalResources{Dir: dir}, name)
+		if err != nil || string(data) != "mark" {
+			t.Errorf("readResource(%q) = %q, %v, want the file", name, data, err)
+		}
+	}
+	if _, err := readResource(LocalResources{Dir: dir}, "missing.png"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readResource() error = %v, want it to wrap os.ErrNotExist", err)
+	}
+}
+
+func TestParamResource(t *testing.T) {
+	resources := mapResources{"mark.png": []byte("mark")}
+	tests := []struct {
+		name    string
+		params  Params
+		want    string
+		wantErr bool
+	}{
+		{name: "found", params: Params{"image": "mark.png"}, want: "mark"},
+		{name: "missing", params: Params{"image": "other.png"}, wantErr: true},
+		{name: "not set", params: Params{}, wantErr: true},
+		{name: "empty", params: Params{"image": ""}, wantErr: true},
+		{name: "not a string", params: Params{"image": 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := NewParamReaderWithResources(tt.params, resources)
+			data := params.Resource("image")
+			err := params.Err()
+			if tt.wantErr {
+				var paramErr *ParamError
+				if !errors.As(err, &paramErr) || paramErr.Param != "image" {
+					t.Errorf("Resource() error = %v, want one for parameter %q", err, "image")
+				}
+				return
+			}
+			if err != nil || string(data) != tt.want {
+				t.Errorf("Resource() = %q, %v, want %q", data, err, tt.want)
+			}
+		})
+	}
+}
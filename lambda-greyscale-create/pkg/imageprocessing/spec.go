@@ -0,0 +1,165 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ActionSpec names a registered action and the parameters to build it with.
+type ActionSpec struct {
+	Name   string `json:"name" yaml:"name"`
+	Params Params `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// PipelineSpec is the declarative form of a ProcessorPipeline, the actions
+// are applied in order.
+type PipelineSpec struct {
+	Actions []ActionSpec `json:"actions" yaml:"actions"`
+}
+
+// ActionFactory builds an action from its spec parameters. Factories should
+// validate every parameter so that bad specs fail when they are loaded rather
+// than when an image is processed.
+type ActionFactory func(params *ParamReader) (ImageAction, error)
+
+// ActionError reports which action of a spec could not be built.
+type ActionError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("action %d (%q): %v", e.Index, e.Name, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	actionsMu sync.RWMutex
+	actions   = map[string]ActionFactory{}
+)
+
+// RegisterAction makes an action available to pipeline specs under name.
+// Registering the same name twice panics.
+func RegisterAction(name string, factory ActionFactory) {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	if factory == nil {
+		panic("imageprocessing: RegisterAction factory is nil")
+	}
+	if _, dup := actions[name]; dup {
+		panic("imageprocessing: RegisterAction called twice for " + name)
+	}
+	actions[name] = factory
+}
+
+// RegisteredActions returns the sorted names of every registered action.
+func RegisteredActions() []string {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewAction builds a single registered action from its spec, reading any
+// resources it names from local files.
+func NewAction(spec ActionSpec) (ImageAction, error) {
+	return NewActionWithResources(spec, LocalResources{})
+}
+
+// NewActionWithResources builds a single registered action from its spec,
+// opening any resources it names with resources.
+func NewActionWithResources(spec ActionSpec, resources ResourceOpener) (ImageAction, error) {
+	actionsMu.RLock()
+	factory, ok := actions[spec.Name]
+	actionsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown action, expected one of %v", RegisteredActions())
+	}
+
+	params := NewParamReaderWithResources(spec.Params, resources)
+	action, err := factory(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.Err(); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// NewPipelineFromSpec builds a ProcessorPipeline from a spec, failing on the
+// first action that is unknown or has bad parameters.
+func NewPipelineFromSpec(spec PipelineSpec) (ProcessorPipeline, error) {
+	return NewPipelineFromSpecWithResources(spec, LocalResources{})
+}
+
+// NewPipelineFromSpecWithResources builds a ProcessorPipeline from a spec as
+// NewPipelineFromSpec does, opening the resources its actions name with
+// resources.
+func NewPipelineFromSpecWithResources(spec PipelineSpec, resources ResourceOpener) (ProcessorPipeline, error) {
+	if len(spec.Actions) == 0 {
+		return nil, errors.New("pipeline spec has no actions")
+	}
+	return buildPipeline(spec.Actions, resources)
+}
+
+// buildPipeline builds the pipeline running actions, which may be empty.
+func buildPipeline(actions []ActionSpec, resources ResourceOpener) (*processorPipeline, error) {
+	pipeline := &processorPipeline{}
+	for i, actionSpec := range actions {
+		action, err := NewActionWithResources(actionSpec, resources)
+		if err != nil {
+			return nil, &ActionError{Index: i, Name: actionSpec.Name, Err: err}
+		}
+		pipeline.addNamedAction(actionSpec.Name, action)
+	}
+	return pipeline, nil
+}
+
+// ParsePipelineSpec decodes a JSON or YAML spec and checks that it builds.
+func ParsePipelineSpec(data []byte) (PipelineSpec, error) {
+	var spec PipelineSpec
+	if err := UnmarshalSpec(data, &spec); err != nil {
+		return PipelineSpec{}, err
+	}
+	if _, err := NewPipelineFromSpec(spec); err != nil {
+		return PipelineSpec{}, err
+	}
+	return spec, nil
+}
+
+// UnmarshalSpec decodes JSON or YAML into v, rejecting fields v does not
+// define. Documents starting with '{' or '[' are read as JSON so that syntax
+// errors are reported against the format that was actually written.
+func UnmarshalSpec(data []byte, v interface{}) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(v); err != nil {
+			return fmt.Errorf("invalid json spec : %w", err)
+		}
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(trimmed))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid yaml spec : %w", err)
+	}
+	return nil
+}
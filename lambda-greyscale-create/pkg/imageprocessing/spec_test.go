@@ -0,0 +1,103 @@
+package imageprocessing
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestParsePipelineSpec(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantAction string
+		wantParam  string
+		wantErr    bool
+	}{
+		{
+			name: "json",
+			data: `{"actions": [{"name": "resize", "params": {"width": 10}}, {"name": "greyscale"}]}`,
+		},
+		{
+			name: "yaml",
+			data: "actions:\n  - name: thumbnail\n    params: {width: 10, height: 10, filter: nearest}\n",
+		},
+		{
+			name:    "no actions",
+			data:    `{"actions": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			data:    `{"actions": [{"name": "greyscale"}], "colour": true}`,
+			wantErr: true,
+		},
+		{
+			name:       "unknown action",
+			data:       `{"actions": [{"name": "greyscale"}, {"name": "sparkle"}]}`,
+			wantAction: "sparkle",
+			wantErr:    true,
+		},
+		{
+			name:       "unknown parameter",
+			data:       `{"actions": [{"name": "resize", "params": {"width": 10, "depth": 3}}]}`,
+			wantAction: "resize",
+			wantParam:  "depth",
+			wantErr:    true,
+		},
+		{
+			name:       "wrong type",
+			data:       `{"actions": [{"name": "resize", "params": {"width": "wide"}}]}`,
+			wantAction: "resize",
+			wantParam:  "width",
+			wantErr:    true,
+		},
+		{
+			name:       "fill without height",
+			data:       `{"actions": [{"name": "resize", "params": {"width": 10, "mode": "fill"}}]}`,
+			wantAction: "resize",
+			wantParam:  "mode",
+			wantErr:    true,
+		},
+		{
+			name:       "unknown filter",
+			data:       `{"actions": [{"name": "thumbnail", "params": {"width": 10, "height": 10, "filter": "blurry"}}]}`,
+			wantAction: "thumbnail",
+			wantParam:  "filter",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePipelineSpec([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePipelineSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var actionErr *ActionError
+			if tt.wantAction != "" && (!errors.As(err, &actionErr) || actionErr.Name != tt.wantAction) {
+				t.Errorf("ParsePipelineSpec() error = %v, want one for action %q", err, tt.wantAction)
+			}
+			var paramErr *ParamError
+			if tt.wantParam != "" && (!errors.As(err, &paramErr) || paramErr.Param != tt.wantParam) {
+				t.Errorf("ParsePipelineSpec() error = %v, want one for parameter %q", err, tt.wantParam)
+			}
+		})
+	}
+}
+
+func TestNewPipelineFromSpec(t *testing.T) {
+	pipeline, err := NewPipelineFromSpec(PipelineSpec{Actions: []ActionSpec{
+		{Name: "resize", Params: Params{"width": 20, "height": 20, "mode": "exact"}},
+		{Name: "greyscale"},
+	}})
+	if err != nil {
+		t.Fatalf("NewPipelineFromSpec() error = %v", err)
+	}
+	got, err := pipeline.Transform(image.NewRGBA(image.Rect(0, 0, 40, 30)))
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if got.Bounds() != image.Rect(0, 0, 20, 20) {
+		t.Errorf("Transform() bounds = %v, want 20x20", got.Bounds())
+	}
+}
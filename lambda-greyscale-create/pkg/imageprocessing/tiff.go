@@ -0,0 +1,134 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+
+	"golang.org/x/image/tiff"
+)
+
+// maxTIFFPages bounds the pages read from a multi-page TIFF.
+const maxTIFFPages = 100
+
+// TIFFPageCount returns the number of pages of a TIFF, one per image file
+// directory.
+func TIFFPageCount(data []byte) (int, error) {
+	_, offsets, err := tiffPageOffsets(data)
+	return len(offsets), err
+}
+
+// TIFFOrientation returns the Orientation tag of the first page of a TIFF,
+// OrientationNormal when it has none. TIFFs keep the tag in the image file
+// directory of every page rather than in EXIF, so ReadMetadata doesn't see
+// it.
+func TIFFOrientation(data []byte) (Orientation, error) {
+	order, err := tiffByteOrder(data)
+	if err != nil {
+		return OrientationNormal, err
+	}
+	return tiffOrientation(data, order, order.Uint32(data[4:]))
+}
+
+// DecodeTIFFPages decodes every page of a TIFF in order, each turned upright
+// by its own Orientation tag as scanned pages can differ. The size of each
+// page, and of all pages together, is checked against limits before it is
+// decoded. The tiff decoder only reads the first page, so each page is
+// decoded from a copy of data whose header points at that page instead.
+func DecodeTIFFPages(data []byte, limits DecodeLimits) ([]image.Image, error) {
+	order, offsets, err := tiffPageOffsets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]image.Image, len(offsets))
+	total := int64(0)
+	page := make([]byte, len(data))
+	copy(page, data)
+	for i, offset := range offsets {
+		order.PutUint32(page[4:], offset)
+		cfg, err := tiff.DecodeConfig(bytes.NewReader(page))
+		if err != nil {
+			return nil, fmt.Errorf("page %d : %w", i+1, err)
+		}
+		if err := limits.Check(cfg.Width, cfg.Height); err != nil {
+			return nil, fmt.Errorf("page %d : %w", i+1, err)
+		}
+		total += int64(cfg.Width) * int64(cfg.Height)
+		if total > limits.MaxPixels {
+			return nil, fmt.Errorf("pages 1 to %d : %w", i+1, &LimitError{Limit: "pixels", Value: total, Max: limits.MaxPixels})
+		}
+		orientation, err := tiffOrientation(data, order, offset)
+		if err != nil {
+			return nil, fmt.Errorf("page %d : %w", i+1, err)
+		}
+		if pages[i], err = tiff.Decode(bytes.NewReader(page)); err != nil {
+			return nil, fmt.Errorf("page %d : %w", i+1, err)
+		}
+		pages[i] = AutoOrient(pages[i], orientation)
+	}
+	return pages, nil
+}
+
+// tiffOrientation returns the Orientation tag of the image file directory at
+// offset.
+func tiffOrientation(data []byte, order binary.ByteOrder, offset uint32) (Orientation, error) {
+	m := &Metadata{order: order}
+	entries, err := m.parseIFD(data, offset)
+	if err != nil {
+		return OrientationNormal, err
+	}
+	m.ifd0 = entries
+	return m.Orientation(), nil
+}
+
+// tiffPageOffsets returns the byte order of a TIFF and the offset of each of
+// its image file directories, following the chain from the header.
+func tiffPageOffsets(data []byte) (binary.ByteOrder, []uint32, error) {
+	order, err := tiffByteOrder(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var offsets []uint32
+	seen := map[uint32]bool{}
+	for offset := order.Uint32(data[4:]); offset != 0; {
+		if seen[offset] {
+			return nil, nil, errors.New("tiff: image file directories form a loop")
+		}
+		if len(offsets) == maxTIFFPages {
+			return nil, nil, fmt.Errorf("tiff: more than %d pages", maxTIFFPages)
+		}
+		ifd := uint64(offset)
+		if ifd+2 > uint64(len(data)) {
+			return nil, nil, errors.New("tiff: image file directory offset out of range")
+		}
+		next := ifd + 2 + 12*uint64(order.Uint16(data[ifd:]))
+		if next+4 > uint64(len(data)) {
+			return nil, nil, errors.New("tiff: truncated image file directory")
+		}
+		seen[offset] = true
+		offsets = append(offsets, offset)
+		offset = order.Uint32(data[next:])
+	}
+	if len(offsets) == 0 {
+		return nil, nil, errors.New("tiff: no image file directories")
+	}
+	return order, offsets, nil
+}
+
+// tiffByteOrder returns the byte order given by the header of a TIFF.
+func tiffByteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 8 {
+		return nil, errors.New("tiff: truncated header")
+	}
+	switch string(data[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian, nil
+	case "MM\x00*":
+		return binary.BigEndian, nil
+	}
+	return nil, errors.New("tiff: not a tiff image")
+}
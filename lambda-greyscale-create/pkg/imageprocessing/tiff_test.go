@@ -0,0 +1,223 @@
+package imageprocessing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// The fixtures under testdata/formats are gradient.bmp and gradient.tiff, a
+// single-page 10x6 deflate TIFF, pages.tiff, an uncompressed grey TIFF of
+// three pages of 8x6, 6x4 and 4x2 filled with the greys 60, 120 and 180, and
+// lossless.webp and lossy.webp taken from the golang.org/x/image test data.
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "formats", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantFormat string
+		wantSize   image.Point
+	}{
+		{name: "gradient.bmp", wantFormat: "bmp", wantSize: image.Pt(12, 8)},
+		{name: "gradient.tiff", wantFormat: "tiff", wantSize: image.Pt(10, 6)},
+		{name: "pages.tiff", wantFormat: "tiff", wantSize: image.Pt(8, 6)},
+		{name: "lossless.webp", wantFormat: "webp", wantSize: image.Pt(75, 100)},
+		{name: "lossy.webp", wantFormat: "webp", wantSize: image.Pt(150, 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, format, err := image.Decode(bytes.NewReader(readFixture(t, tt.name)))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %q, want %q", format, tt.wantFormat)
+			}
+			if size := img.Bounds().Size(); size != tt.wantSize {
+				t.Errorf("size = %v, want %v", size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestTIFFPageCount(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		wantPages int
+		wantErr   bool
+	}{
+		{name: "single page", data: readFixture(t, "gradient.tiff"), wantPages: 1},
+		{name: "multi-page", data: readFixture(t, "pages.tiff"), wantPages: 3},
+		{name: "not a tiff", data: readFixture(t, "gradient.bmp"), wantErr: true},
+		{name: "truncated", data: readFixture(t, "pages.tiff")[:20], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := TIFFPageCount(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TIFFPageCount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && pages != tt.wantPages {
+				t.Errorf("TIFFPageCount() = %d, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestDecodeTIFFPages(t *testing.T) {
+	pages, err := DecodeTIFFPages(readFixture(t, "pages.tiff"), DefaultDecodeLimits)
+	if err != nil {
+		t.Fatalf("DecodeTIFFPages() error = %v", err)
+	}
+	want := []struct {
+		size image.Point
+		grey uint8
+	}{
+		{size: image.Pt(8, 6), grey: 60},
+		{size: image.Pt(6, 4), grey: 120},
+		{size: image.Pt(4, 2), grey: 180},
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("decoded %d pages, want %d", len(pages), len(want))
+	}
+	for i, page := range pages {
+		if size := page.Bounds().Size(); size != want[i].size {
+			t.Errorf("page %d size = %v, want %v", i+1, size, want[i].size)
+		}
+		if got := color.GrayModel.Convert(page.At(0, 0)).(color.Gray).Y; got != want[i].grey {
+			t.Errorf("page %d grey = %d, want %d", i+1, got, want[i].grey)
+		}
+	}
+
+	single, err := DecodeTIFFPages(readFixture(t, "gradient.tiff"), DefaultDecodeLimits)
+	if err != nil {
+		t.Fatalf("DecodeTIFFPages() error = %v", err)
+	}
+	if len(single) != 1 || single[0].Bounds().Size() != image.Pt(10, 6) {
+		t.Errorf("single page tiff decoded to %d pages, want one of 10x6", len(single))
+	}
+}
+
+// orientedTIFF returns an uncompressed grey TIFF with a 3x2 page for each
+// of orientations, tagged with it. The top left pixel of every page is white
+// and the rest black.
+func orientedTIFF(orientations ...Orientation) []byte {
+	const w, h = 3, 2
+	order := binary.LittleEndian
+	data := []byte("II*\x00\x00\x00\x00\x00")
+	next := 4
+	for _, orientation := range orientations {
+		pixels := len(data)
+		data = append(data, 0xff, 0, 0, 0, 0, 0)
+		order.PutUint32(data[next:], uint32(len(data)))
+		entries := [][3]uint32{
+			{256, exifTypeShort, w},
+			{257, exifTypeShort, h},
+			{258, exifTypeShort, 8},
+			{259, exifTypeShort, 1},
+			{262, exifTypeShort, 1},
+			{273, exifTypeLong, uint32(pixels)},
+			{exifTagOrientation, exifTypeShort, uint32(orientation)},
+			{277, exifTypeShort, 1},
+			{278, exifTypeShort, h},
+			{279, exifTypeLong, w * h},
+		}
+		count := make([]byte, 2)
+		order.PutUint16(count, uint16(len(entries)))
+		data = append(data, count...)
+		for _, e := range entries {
+			entry := make([]byte, 12)
+			order.PutUint16(entry, uint16(e[0]))
+			order.PutUint16(entry[2:], uint16(e[1]))
+			order.PutUint32(entry[4:], 1)
+			if e[1] == exifTypeShort {
+				order.PutUint16(entry[8:], uint16(e[2]))
+			} else {
+				order.PutUint32(entry[8:], e[2])
+			}
+			data = append(data, entry...)
+		}
+		next = len(data)
+		data = append(data, 0, 0, 0, 0)
+	}
+	return data
+}
+
+func TestTIFFOrientation(t *testing.T) {
+	data := orientedTIFF(OrientationRotate90, OrientationNormal, OrientationRotate180)
+	if got, err := TIFFOrientation(data); err != nil || got != OrientationRotate90 {
+		t.Errorf("TIFFOrientation() = %d, %v, want %d", got, err, OrientationRotate90)
+	}
+	if got, err := TIFFOrientation(readFixture(t, "gradient.tiff")); err != nil || got != OrientationNormal {
+		t.Errorf("TIFFOrientation() of an untagged tiff = %d, %v, want %d", got, err, OrientationNormal)
+	}
+	if _, err := TIFFOrientation(readFixture(t, "gradient.bmp")); err == nil {
+		t.Error("TIFFOrientation() accepted a bmp")
+	}
+
+	// every page is turned upright by its own tag
+	pages, err := DecodeTIFFPages(data, DefaultDecodeLimits)
+	if err != nil {
+		t.Fatalf("DecodeTIFFPages() error = %v", err)
+	}
+	want := []struct {
+		size  image.Point
+		white image.Point
+	}{
+		// the top left corner of a page turned a quarter clockwise is its
+		// top right corner
+		{size: image.Pt(2, 3), white: image.Pt(1, 0)},
+		{size: image.Pt(3, 2), white: image.Pt(0, 0)},
+		{size: image.Pt(3, 2), white: image.Pt(2, 1)},
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("decoded %d pages, want %d", len(pages), len(want))
+	}
+	for i, page := range pages {
+		if size := page.Bounds().Size(); size != want[i].size {
+			t.Errorf("page %d size = %v, want %v", i+1, size, want[i].size)
+		}
+		white := page.Bounds().Min.Add(want[i].white)
+		if got := color.GrayModel.Convert(page.At(white.X, white.Y)).(color.Gray).Y; got != 0xff {
+			t.Errorf("page %d pixel %v = %d, want the white corner", i+1, want[i].white, got)
+		}
+	}
+}
+
+func TestDecodeTIFFPagesLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits DecodeLimits
+	}{
+		// every page fits but the first two together do not
+		{name: "pixels of all pages", limits: DecodeLimits{MaxWidth: 100, MaxHeight: 100, MaxPixels: 60}},
+		{name: "width of a page", limits: DecodeLimits{MaxWidth: 7, MaxHeight: 100, MaxPixels: 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeTIFFPages(readFixture(t, "pages.tiff"), tt.limits)
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Errorf("DecodeTIFFPages() error = %v, want a limit error", err)
+			}
+		})
+	}
+}
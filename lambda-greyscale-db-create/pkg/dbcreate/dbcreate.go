@@ -0,0 +1,106 @@
+// Package dbcreate is the db create lambda, it stores the images of image
+// converted messages in the ImageTable.
+package dbcreate
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"time"
+)
+
+const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Handler stores the images of image converted messages delivered through
+// the ImageQueue.
+type Handler struct {
+	images    greyscale.ImageRepository
+	publisher greyscale.Publisher
+}
+
+// NewHandler returns a Handler storing images under a random ImageConverter
+// key.
+func NewHandler(images greyscale.ImageRepository, publisher greyscale.Publisher) *Handler {
+	return &Handler{
+		images:    images,
+		publisher: publisher,
+	}
+}
+
+// Handle stores the image of every message in an sqs event.
+func (h *Handler) Handle(ctx context.Context, sqsEvent events.SQSEvent) {
+	logger := logrus.WithFields(logrus.Fields{"action": "converter"})
+	logger.Info("lambda greyscale db function called")
+
+	// handle all records from sqs event
+	var images []greyscale.GreyImage
+	for _, message := range sqsEvent.Records {
+		logger.Infof("received message %s for event source %s", message.MessageId, message.EventSource)
+		logger.Infof("sqs message received : %s", message.Body)
+
+		// parse sns message, as sns topic is wrapped as sqs message
+		// invalid messages are rejected rather than stored as empty records
+		event, err := greyscale.DecodeQueuedImageMessage(message.Body)
+		if err != nil {
+			greyscale.HandleError(ctx, err, h.publisher)
+			continue
+		}
+		logger.Infof("received image message : %s", event.GreyImage)
+
+		// add parsed image to array of images
+		images = append(images, event.GreyImage)
+	}
+
+	// ensure images are not nil
+	if len(images) == 0 {
+		greyscale.HandleError(ctx, errors.New("images can not be nil"), h.publisher)
+		return
+	}
+
+	// handle every image
+	for _, image := range images {
+		// create random key for db
+		image.ImageConverter = randString(10)
+		image.Renditions = withoutProcessing(image.Renditions)
+
+		// add image to dynamodb
+		logger.Infof("adding image to dynamodb : %s", image)
+		if err := h.images.Put(ctx, image); err != nil {
+			greyscale.HandleError(ctx, err, h.publisher)
+			continue
+		}
+	}
+}
+
+// withoutProcessing returns a copy of renditions without their processing
+// summaries, which are only published for diagnosing slow conversions.
+func withoutProcessing(renditions []greyscale.Rendition) []greyscale.Rendition {
+	if renditions == nil {
+		return nil
+	}
+	stored := make([]greyscale.Rendition, len(renditions))
+	for i, r := range renditions {
+		r.Processing = nil
+		stored[i] = r
+	}
+	return stored
+}
+
+// build random string helper func
+func stringWithCharset(length int, charset string) string {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[seededRand.Intn(len(charset))]
+	}
+	return string(b)
+}
+
+// build random string wrapper func
+func randString(length int) string {
+	return stringWithCharset(length, charset)
+}
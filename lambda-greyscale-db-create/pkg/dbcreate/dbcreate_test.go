@@ -0,0 +1,164 @@
+package dbcreate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const errorTopic = "ErrorTopic"
+
+func TestMain(m *testing.M) {
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func testImage(key string) greyscale.GreyImage {
+	return greyscale.GreyImage{
+		SourceBucket:  "greyscale",
+		SourceKey:     key,
+		SourceURL:     "https://greyscale/" + key,
+		ConvertBucket: "greyscale-convert",
+		ConvertKey:    greyscale.ConvertKey(key, ".png"),
+		ConvertURL:    "https://greyscale-convert/" + greyscale.ConvertKey(key, ".png"),
+		ImageType:     "image/png",
+		Renditions: []greyscale.Rendition{{
+			Name:       "full",
+			ConvertKey: greyscale.ConvertKey(key, ".png"),
+			ConvertURL: "https://greyscale-convert/" + greyscale.ConvertKey(key, ".png"),
+		}},
+	}
+}
+
+// queued wraps message in the envelope sns delivers it to sqs in.
+func queued(t *testing.T, message greyscale.Message) events.SQSMessage {
+	attributes := map[string]map[string]string{}
+	for name, attribute := range message.Attributes {
+		attributes[name] = map[string]string{"Type": attribute.DataType, "Value": attribute.Value}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"Type":              "Notification",
+		"Message":           message.Body,
+		"MessageAttributes": attributes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.SQSMessage{MessageId: "1", EventSource: "aws:sqs", Body: string(body)}
+}
+
+func queuedImage(t *testing.T, image greyscale.GreyImage) events.SQSMessage {
+	message, err := greyscale.EncodeImageMessage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queued(t, message)
+}
+
+func TestHandle(t *testing.T) {
+	missingKey := testImage("b.png")
+	missingKey.ConvertKey = ""
+	missingKeyBody, _ := json.Marshal(greyscale.NewImageConverted(missingKey))
+
+	tests := []struct {
+		name       string
+		records    func(t *testing.T) []events.SQSMessage
+		errors     map[string]error
+		wantStored []string
+		wantErrors int
+	}{
+		{
+			name: "stores the image",
+			records: func(t *testing.T) []events.SQSMessage {
+				return []events.SQSMessage{queuedImage(t, testImage("a.png"))}
+			},
+			wantStored: []string{"a.png"},
+		},
+		{
+			name: "stores every image",
+			records: func(t *testing.T) []events.SQSMessage {
+				return []events.SQSMessage{queuedImage(t, testImage("a.png")), queuedImage(t, testImage("b.png"))}
+			},
+			wantStored: []string{"a.png", "b.png"},
+		},
+		{
+			name: "version 0 message",
+			records: func(t *testing.T) []events.SQSMessage {
+				image := testImage("a.png")
+				image.Renditions = nil
+				body, _ := json.Marshal(image)
+				return []events.SQSMessage{queued(t, greyscale.Message{Body: string(body)})}
+			},
+			wantStored: []string{"a.png"},
+		},
+		{
+			name: "invalid message is skipped",
+			records: func(t *testing.T) []events.SQSMessage {
+				return []events.SQSMessage{
+					queuedImage(t, testImage("a.png")),
+					queued(t, greyscale.Message{Body: string(missingKeyBody)}),
+				}
+			},
+			wantStored: []string{"a.png"},
+			wantErrors: 1,
+		},
+		{
+			name: "not an sns notification",
+			records: func(t *testing.T) []events.SQSMessage {
+				return []events.SQSMessage{{MessageId: "1", Body: "not json"}}
+			},
+			// the bad record, then the empty batch
+			wantErrors: 2,
+		},
+		{
+			name: "no records",
+			records: func(t *testing.T) []events.SQSMessage {
+				return nil
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "put fails",
+			records: func(t *testing.T) []events.SQSMessage {
+				return []events.SQSMessage{queuedImage(t, testImage("a.png"))}
+			},
+			errors:     map[string]error{"Put": errors.New("put failed")},
+			wantErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := greyscaletest.NewImageRepository()
+			repository.Errors = tt.errors
+			publisher := greyscaletest.NewPublisher()
+
+			h := NewHandler(repository, publisher)
+			h.Handle(context.Background(), events.SQSEvent{Records: tt.records(t)})
+
+			stored := repository.Scan(func(greyscale.GreyImage) bool { return true })
+			if len(stored) != len(tt.wantStored) {
+				t.Fatalf("stored %d images, want %v", len(stored), tt.wantStored)
+			}
+			for i, image := range stored {
+				if image.SourceKey != tt.wantStored[i] {
+					t.Errorf("stored image %d is %s, want %s", i, image.SourceKey, tt.wantStored[i])
+				}
+				if len(image.ImageConverter) != 10 {
+					t.Errorf("stored %s under key %q, want a random 10 character key", image.SourceKey, image.ImageConverter)
+				}
+			}
+			if got := len(publisher.Messages(errorTopic)); got != tt.wantErrors {
+				t.Errorf("published %d errors, want %d", got, tt.wantErrors)
+			}
+		})
+	}
+}
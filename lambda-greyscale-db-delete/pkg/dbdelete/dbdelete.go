@@ -0,0 +1,55 @@
+// Package dbdelete is the db delete lambda, it removes the image records of
+// converted images deleted from the convert bucket.
+package dbdelete
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler removes the image records of converted images deleted from the
+// convert bucket.
+type Handler struct {
+	images    greyscale.ImageRepository
+	publisher greyscale.Publisher
+}
+
+// NewHandler returns a Handler.
+func NewHandler(images greyscale.ImageRepository, publisher greyscale.Publisher) *Handler {
+	return &Handler{
+		images:    images,
+		publisher: publisher,
+	}
+}
+
+// Handle processes every object deleted in an s3 event.
+func (h *Handler) Handle(ctx context.Context, event events.S3Event) {
+	logger := logrus.WithFields(logrus.Fields{"action": "converter"})
+	logger.Info("lambda greyscale db function called")
+
+	// handle all records from s3 event
+	for _, e := range event.Records {
+		logger.Infof("received event %s for event source %s", e.EventName, e.EventSource)
+
+		imageKey := e.S3.Object.Key
+
+		images, err := h.images.FindByConvertKey(ctx, imageKey)
+		if err != nil {
+			greyscale.HandleError(ctx, err, h.publisher)
+			continue
+		}
+
+		for _, image := range images {
+			logger.Infof("found image url %s", image.ConvertURL)
+
+			err := h.images.Delete(ctx, image.ImageConverter)
+			if err != nil {
+				greyscale.HandleError(ctx, err, h.publisher)
+				continue
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package dbdelete
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const errorTopic = "ErrorTopic"
+
+func TestMain(m *testing.M) {
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func deletedEvent(keys ...string) events.S3Event {
+	var event events.S3Event
+	for _, key := range keys {
+		event.Records = append(event.Records, events.S3EventRecord{
+			EventName: "ObjectRemoved:Delete",
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "greyscale-convert"},
+				Object: events.S3Object{Key: key},
+			},
+		})
+	}
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	images := []greyscale.GreyImage{
+		{ImageConverter: "one", SourceKey: "a.png", ConvertKey: "converted-a.png.png", ConvertURL: "https://convert/converted-a.png.png"},
+		{ImageConverter: "two", SourceKey: "a.png", ConvertKey: "converted-a.png.png", ConvertURL: "https://convert/converted-a.png.png"},
+		{ImageConverter: "three", SourceKey: "b.png", ConvertKey: "converted-b.png.png", ConvertURL: "https://convert/converted-b.png.png"},
+	}
+
+	tests := []struct {
+		name       string
+		event      events.S3Event
+		errors     map[string]error
+		wantLeft   []string
+		wantErrors int
+	}{
+		{
+			name:     "removes every record of the key",
+			event:    deletedEvent("converted-a.png.png"),
+			wantLeft: []string{"three"},
+		},
+		{
+			name:     "rendition without a record",
+			event:    deletedEvent("converted/a.png/web.jpg"),
+			wantLeft: []string{"one", "two", "three"},
+		},
+		{
+			name:     "every record",
+			event:    deletedEvent("converted-a.png.png", "converted-b.png.png"),
+			wantLeft: []string{},
+		},
+		{
+			name:       "find fails",
+			event:      deletedEvent("converted-a.png.png", "converted-b.png.png"),
+			errors:     map[string]error{"FindByConvertKey": errors.New("scan failed")},
+			wantLeft:   []string{"one", "two", "three"},
+			wantErrors: 2,
+		},
+		{
+			name:       "delete fails",
+			event:      deletedEvent("converted-a.png.png"),
+			errors:     map[string]error{"Delete": errors.New("delete failed")},
+			wantLeft:   []string{"one", "two", "three"},
+			wantErrors: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := greyscaletest.NewImageRepository()
+			for _, image := range images {
+				repository.Items[image.ImageConverter] = image
+			}
+			repository.Errors = tt.errors
+			publisher := greyscaletest.NewPublisher()
+
+			h := NewHandler(repository, publisher)
+			h.Handle(context.Background(), tt.event)
+
+			if len(repository.Items) != len(tt.wantLeft) {
+				t.Errorf("%d records left, want %v", len(repository.Items), tt.wantLeft)
+			}
+			for _, key := range tt.wantLeft {
+				if _, ok := repository.Items[key]; !ok {
+					t.Errorf("record %s was removed", key)
+				}
+			}
+			if got := len(publisher.Messages(errorTopic)); got != tt.wantErrors {
+				t.Errorf("published %d errors, want %d", got, tt.wantErrors)
+			}
+		})
+	}
+}
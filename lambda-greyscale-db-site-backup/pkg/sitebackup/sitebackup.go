@@ -0,0 +1,64 @@
+// Package sitebackup is the site backup lambda, it copies the website to its
+// backup bucket.
+package sitebackup
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler copies every object written to the website bucket to its backup
+// bucket.
+type Handler struct {
+	store     greyscale.ObjectStore
+	publisher greyscale.Publisher
+}
+
+// NewHandler returns a Handler.
+func NewHandler(store greyscale.ObjectStore, publisher greyscale.Publisher) *Handler {
+	return &Handler{
+		store:     store,
+		publisher: publisher,
+	}
+}
+
+// Handle copies every object created in an s3 event.
+func (h *Handler) Handle(ctx context.Context, e events.S3Event) {
+	logger := logrus.WithFields(logrus.Fields{"action": "backup"})
+	logger.Info("lambda greyscale site backup function called")
+
+	for _, record := range e.Records {
+		websiteSourceBucket := record.S3.Bucket.Name
+		websiteKey := record.S3.Object.Key
+		websiteDestinationBucket := greyscale.BackupBucket(websiteSourceBucket)
+
+		website, _, err := h.store.Get(ctx, websiteSourceBucket, websiteKey)
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrap(err, "error getting image from bucket"), h.publisher)
+			return
+		}
+
+		// convert image to buffer
+		websiteBufferCopy := &bytes.Buffer{}
+		_, err = io.Copy(websiteBufferCopy, website)
+		website.Close()
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrap(err, "error creating buffer copy"), h.publisher)
+			return
+		}
+
+		err = h.store.Put(ctx, websiteDestinationBucket, websiteKey, websiteBufferCopy, "image/png")
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrap(err, "error putting image in bucket"), h.publisher)
+			return
+		}
+
+		logger.Infof("finished updating website for event %s", record.EventName)
+	}
+}
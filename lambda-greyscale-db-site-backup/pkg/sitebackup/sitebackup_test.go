@@ -0,0 +1,103 @@
+package sitebackup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	websiteBucket = "greyscale-website"
+	errorTopic    = "ErrorTopic"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func createdEvent(keys ...string) events.S3Event {
+	var event events.S3Event
+	for _, key := range keys {
+		event.Records = append(event.Records, events.S3EventRecord{
+			EventName: "ObjectCreated:Put",
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: websiteBucket},
+				Object: events.S3Object{Key: key},
+			},
+		})
+	}
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      events.S3Event
+		errors     map[string]error
+		wantBackup []string
+		wantErrors int
+	}{
+		{
+			name:       "copies the website",
+			event:      createdEvent("index.html"),
+			wantBackup: []string{"index.html"},
+		},
+		{
+			name:       "copies every record",
+			event:      createdEvent("index.html", "about.html"),
+			wantBackup: []string{"about.html", "index.html"},
+		},
+		{
+			name:       "missing object",
+			event:      createdEvent("missing.html"),
+			wantErrors: 1,
+		},
+		{
+			name:       "put fails",
+			event:      createdEvent("index.html"),
+			errors:     map[string]error{"Put": errors.New("put failed")},
+			wantErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := greyscaletest.NewObjectStore()
+			for _, key := range []string{"index.html", "about.html"} {
+				store.PutObject(websiteBucket, key, greyscaletest.Object{Body: []byte("<html>" + key + "</html>")})
+			}
+			store.Errors = tt.errors
+			publisher := greyscaletest.NewPublisher()
+
+			h := NewHandler(store, publisher)
+			h.Handle(context.Background(), tt.event)
+
+			backup := greyscale.BackupBucket(websiteBucket)
+			keys := store.Keys(backup)
+			if len(keys) != len(tt.wantBackup) {
+				t.Fatalf("backed up %v, want %v", keys, tt.wantBackup)
+			}
+			for i, key := range keys {
+				if key != tt.wantBackup[i] {
+					t.Fatalf("backed up %v, want %v", keys, tt.wantBackup)
+				}
+				copied, _ := store.Object(backup, key)
+				original, _ := store.Object(websiteBucket, key)
+				if string(copied.Body) != string(original.Body) {
+					t.Errorf("backup of %s = %q, want %q", key, copied.Body, original.Body)
+				}
+			}
+			if got := len(publisher.Messages(errorTopic)); got != tt.wantErrors {
+				t.Errorf("published %d errors, want %d", got, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -4,6 +4,10 @@ go 1.15
 
 require (
 	github.com/aws/aws-lambda-go v1.20.0
-	github.com/aws/aws-sdk-go v1.36.1
+	github.com/aws/aws-sdk-go v1.36.4
+	github.com/ciaranRoche/lambda-image-processor/pkg/greyscale v0.0.0
+	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.7.0
 )
+
+replace github.com/ciaranRoche/lambda-image-processor/pkg/greyscale => ../pkg/greyscale
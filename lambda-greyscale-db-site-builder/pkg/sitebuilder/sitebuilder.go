@@ -0,0 +1,100 @@
+// Package sitebuilder is the site builder lambda, it renders the website
+// from the ImageTable.
+package sitebuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WebsiteBucket and WebsiteKey locate the rendered website.
+	WebsiteBucket = "greyscale-website"
+	WebsiteKey    = "index.html"
+
+	snsTopic = "arn:aws:sns:eu-west-1:442832839294:websiteUpdated"
+)
+
+// Handler rebuilds the website from every converted image whenever the
+// ImageTable changes.
+type Handler struct {
+	images    greyscale.ImageRepository
+	store     greyscale.ObjectStore
+	publisher greyscale.Publisher
+	// template is the path of the index.gohtml template
+	template string
+}
+
+// NewHandler returns a Handler rendering the website with the template at
+// templatePath.
+func NewHandler(images greyscale.ImageRepository, store greyscale.ObjectStore, publisher greyscale.Publisher, templatePath string) *Handler {
+	return &Handler{
+		images:    images,
+		store:     store,
+		publisher: publisher,
+		template:  templatePath,
+	}
+}
+
+// Handle rebuilds the website once per record in a dynamodb stream event.
+func (h *Handler) Handle(ctx context.Context, e events.DynamoDBEvent) {
+	logger := logrus.WithFields(logrus.Fields{"action": "builder"})
+	logger.Info("lambda greyscale site builder function called")
+
+	for _, record := range e.Records {
+		logger.Infof("processing %s for event ID %s", record.EventName, record.EventID)
+
+		converted, err := h.images.ListConverted(ctx)
+		if err != nil {
+			greyscale.HandleError(ctx, err, h.publisher)
+			return
+		}
+
+		var images []string
+		for _, image := range converted {
+			logger.Infof("found image url %s", image.ConvertURL)
+
+			images = append(images, image.ConvertURL)
+		}
+
+		logger.Infof("found %d images", len(images))
+
+		buf := bytes.NewBufferString("")
+
+		tpl, err := template.ParseFiles(h.template)
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrapf(err, "error parsing template"), h.publisher)
+			return
+		}
+
+		err = tpl.Execute(buf, images)
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrapf(err, "unable to parse html template"), h.publisher)
+			return
+		}
+
+		err = h.store.Put(ctx, WebsiteBucket, WebsiteKey, bytes.NewReader(buf.Bytes()), "text/html")
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrapf(err, "error putting html in bucket"), h.publisher)
+			return
+		}
+
+		// public sns message
+		snsMessage := fmt.Sprintf("website updated with '%d' images", len(images))
+		logger.Infof("sending message : %s", snsMessage)
+		err = h.publisher.Publish(ctx, snsTopic, greyscale.Message{Body: snsMessage})
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrapf(err, "error publishing sns"), h.publisher)
+			return
+		}
+
+		logger.Infof("finished updating website for event %s", record.EventID)
+	}
+}
@@ -0,0 +1,134 @@
+package sitebuilder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	errorTopic = "ErrorTopic"
+	// templatePath is the template the lambda is deployed with
+	templatePath = "../../index.gohtml"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func streamEvent(ids ...string) events.DynamoDBEvent {
+	var event events.DynamoDBEvent
+	for _, id := range ids {
+		event.Records = append(event.Records, events.DynamoDBEventRecord{EventID: id, EventName: "INSERT"})
+	}
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	images := []greyscale.GreyImage{
+		{ImageConverter: "one", SourceKey: "a.png", ConvertURL: "https://greyscale-convert/converted-a.png.png"},
+		{ImageConverter: "two", SourceKey: "b.png", ConvertURL: "https://greyscale-convert/converted-b.png.png"},
+		// not converted yet
+		{ImageConverter: "three", SourceKey: "c.png"},
+	}
+
+	tests := []struct {
+		name         string
+		event        events.DynamoDBEvent
+		template     string
+		storeErrors  map[string]error
+		imageErrors  map[string]error
+		publishErr   error
+		wantSite     bool
+		wantUpdated  int
+		wantErrors   int
+		wantContains []string
+	}{
+		{
+			name:         "renders every converted image",
+			event:        streamEvent("1"),
+			wantSite:     true,
+			wantUpdated:  1,
+			wantContains: []string{images[0].ConvertURL, images[1].ConvertURL},
+		},
+		{
+			name:        "renders once per record",
+			event:       streamEvent("1", "2"),
+			wantSite:    true,
+			wantUpdated: 2,
+		},
+		{
+			name:        "list fails",
+			event:       streamEvent("1"),
+			imageErrors: map[string]error{"ListConverted": errors.New("scan failed")},
+			wantErrors:  1,
+		},
+		{
+			name:       "missing template",
+			event:      streamEvent("1"),
+			template:   "missing.gohtml",
+			wantErrors: 1,
+		},
+		{
+			name:        "put fails",
+			event:       streamEvent("1"),
+			storeErrors: map[string]error{"Put": errors.New("put failed")},
+			wantErrors:  1,
+		},
+		{
+			name:       "publish fails",
+			event:      streamEvent("1"),
+			publishErr: errors.New("publish failed"),
+			wantSite:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := greyscaletest.NewImageRepository()
+			for _, image := range images {
+				repository.Items[image.ImageConverter] = image
+			}
+			repository.Errors = tt.imageErrors
+			store := greyscaletest.NewObjectStore()
+			store.Errors = tt.storeErrors
+			publisher := greyscaletest.NewPublisher()
+			publisher.Err = tt.publishErr
+			template := tt.template
+			if template == "" {
+				template = templatePath
+			}
+
+			h := NewHandler(repository, store, publisher, template)
+			h.Handle(context.Background(), tt.event)
+
+			site, ok := store.Object(WebsiteBucket, WebsiteKey)
+			if ok != tt.wantSite {
+				t.Fatalf("website written = %v, want %v", ok, tt.wantSite)
+			}
+			if ok && site.Info.ContentType != "text/html" {
+				t.Errorf("website content type = %q, want text/html", site.Info.ContentType)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(string(site.Body), want) {
+					t.Errorf("website does not show %s", want)
+				}
+			}
+			if got := len(publisher.Messages(snsTopic)); got != tt.wantUpdated {
+				t.Errorf("published %d website updates, want %d", got, tt.wantUpdated)
+			}
+			if got := len(publisher.Messages(errorTopic)); got != tt.wantErrors {
+				t.Errorf("published %d errors, want %d", got, tt.wantErrors)
+			}
+		})
+	}
+}
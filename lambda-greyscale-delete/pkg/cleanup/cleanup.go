@@ -0,0 +1,81 @@
+// Package cleanup is the delete lambda, it removes the renditions of images
+// deleted from the greyscale bucket.
+package cleanup
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler removes every rendition of an image deleted from the greyscale
+// bucket from the convert bucket.
+type Handler struct {
+	store     greyscale.ObjectStore
+	publisher greyscale.Publisher
+}
+
+// NewHandler returns a Handler.
+func NewHandler(store greyscale.ObjectStore, publisher greyscale.Publisher) *Handler {
+	return &Handler{
+		store:     store,
+		publisher: publisher,
+	}
+}
+
+// Handle processes every object deleted in an s3 event.
+func (h *Handler) Handle(ctx context.Context, event events.S3Event) {
+	logger := logrus.WithFields(logrus.Fields{"action": "converter"})
+
+	for _, e := range event.Records {
+		h.handleDeletedObject(ctx, e, logger)
+	}
+
+	logger.Infof("lambda function finished, processed '%d' events", len(event.Records))
+}
+
+func (h *Handler) handleDeletedObject(ctx context.Context, object events.S3EventRecord, logger *logrus.Entry) {
+	imageSourceBucket := object.S3.Bucket.Name
+	imageSourceKey := object.S3.Object.Key
+
+	imageDestinationBucket := greyscale.ConvertBucket(imageSourceBucket)
+
+	// the primary rendition is "converted-<key>" followed by the extension of
+	// the format it was written in
+	primaryKeys, err := h.store.List(ctx, imageDestinationBucket, greyscale.ConvertKeyPrefix(imageSourceKey))
+	if err != nil {
+		greyscale.HandleError(ctx, errors.Wrapf(err, "error listing objects in bucket"), h.publisher)
+		return
+	}
+	var imageDestinationKeys []string
+	for _, key := range primaryKeys {
+		if greyscale.IsConvertKey(key, imageSourceKey) {
+			imageDestinationKeys = append(imageDestinationKeys, key)
+		}
+	}
+
+	// the other renditions are grouped under a per image prefix
+	renditionKeys, err := h.store.List(ctx, imageDestinationBucket, greyscale.RenditionPrefix(imageSourceKey))
+	if err != nil {
+		greyscale.HandleError(ctx, errors.Wrapf(err, "error listing renditions in bucket"), h.publisher)
+		return
+	}
+	for _, key := range renditionKeys {
+		if greyscale.IsRenditionKey(key, imageSourceKey) {
+			imageDestinationKeys = append(imageDestinationKeys, key)
+		}
+	}
+
+	// remove items from converted bucket
+	for _, key := range imageDestinationKeys {
+		err := h.store.Delete(ctx, imageDestinationBucket, key)
+		if err != nil {
+			greyscale.HandleError(ctx, errors.Wrapf(err, "error deleting object %s from bucket", key), h.publisher)
+			continue
+		}
+		logger.Infof("successfully removed %s from bucket %s", key, imageDestinationBucket)
+	}
+}
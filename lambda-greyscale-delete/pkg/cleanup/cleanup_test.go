@@ -0,0 +1,160 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bucket     = "greyscale"
+	errorTopic = "ErrorTopic"
+)
+
+var convertBucket = greyscale.ConvertBucket(bucket)
+
+func TestMain(m *testing.M) {
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func deletedEvent(keys ...string) events.S3Event {
+	var event events.S3Event
+	for _, key := range keys {
+		event.Records = append(event.Records, events.S3EventRecord{
+			EventName: "ObjectRemoved:Delete",
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: bucket},
+				Object: events.S3Object{Key: key},
+			},
+		})
+	}
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	// the renditions of a.png, alongside those of uploads with similar keys
+	converted := []string{
+		"converted-a.png.jpg",
+		"converted-a.png.png",
+		"converted/a.png/thumbnail.jpg",
+		"converted/a.png/web.jpg",
+		"converted-a.jpg.jpg",
+		"converted/a.jpg/web.jpg",
+		"converted-a.png.bak.jpg",
+		"converted/a.png.bak/web.jpg",
+	}
+	others := []string{
+		"converted-a.jpg.jpg",
+		"converted/a.jpg/web.jpg",
+		"converted-a.png.bak.jpg",
+		"converted/a.png.bak/web.jpg",
+	}
+
+	tests := []struct {
+		name       string
+		event      events.S3Event
+		errors     map[string]error
+		wantKeys   []string
+		wantErrors int
+	}{
+		{
+			name:     "removes only the renditions of the deleted upload",
+			event:    deletedEvent("a.png"),
+			wantKeys: others,
+		},
+		{
+			name:     "upload without renditions",
+			event:    deletedEvent("b.png"),
+			wantKeys: converted,
+		},
+		{
+			name:     "every record",
+			event:    deletedEvent("a.png", "a.jpg"),
+			wantKeys: others[2:],
+		},
+		{
+			name:       "list fails",
+			event:      deletedEvent("a.png"),
+			errors:     map[string]error{"List": errors.New("list failed")},
+			wantKeys:   converted,
+			wantErrors: 1,
+		},
+		{
+			name:       "delete fails",
+			event:      deletedEvent("a.png"),
+			errors:     map[string]error{"Delete": errors.New("delete failed")},
+			wantKeys:   converted,
+			wantErrors: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := greyscaletest.NewObjectStore()
+			for _, key := range converted {
+				store.PutObject(convertBucket, key, greyscaletest.Object{Body: []byte(key)})
+			}
+			store.Errors = tt.errors
+			publisher := greyscaletest.NewPublisher()
+
+			h := NewHandler(store, publisher)
+			h.Handle(context.Background(), tt.event)
+
+			if got := store.Keys(convertBucket); !sameKeys(got, tt.wantKeys) {
+				t.Errorf("keys left = %v, want %v", got, tt.wantKeys)
+			}
+			if got := len(publisher.Messages(errorTopic)); got != tt.wantErrors {
+				t.Errorf("published %d errors, want %d", got, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestHandleNestedSourceKeys(t *testing.T) {
+	// a and a/b.jpg are both uploads, the renditions of a/b.jpg sit one
+	// level below those of a
+	store := greyscaletest.NewObjectStore()
+	for _, key := range []string{
+		"converted-a.jpg",
+		"converted/a/web.jpg",
+		"converted-a/b.jpg.jpg",
+		"converted/a/b.jpg/web.jpg",
+		// the legacy primary of a.tiff, from before the whole key was kept
+		"converted-a.tiff",
+	} {
+		store.PutObject(convertBucket, key, greyscaletest.Object{Body: []byte(key)})
+	}
+	publisher := greyscaletest.NewPublisher()
+
+	h := NewHandler(store, publisher)
+	h.Handle(context.Background(), deletedEvent("a"))
+
+	want := []string{"converted-a/b.jpg.jpg", "converted/a/b.jpg/web.jpg", "converted-a.tiff"}
+	if got := store.Keys(convertBucket); !sameKeys(got, want) {
+		t.Errorf("keys left = %v, want %v", got, want)
+	}
+	if got := len(publisher.Messages(errorTopic)); got != 0 {
+		t.Errorf("published %d errors, want 0", got)
+	}
+}
+
+// sameKeys compares keys with want regardless of order.
+func sameKeys(keys, want []string) bool {
+	set := func(keys []string) map[string]bool {
+		m := map[string]bool{}
+		for _, key := range keys {
+			m[key] = true
+		}
+		return m
+	}
+	return reflect.DeepEqual(set(keys), set(want))
+}
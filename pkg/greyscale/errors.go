@@ -0,0 +1,46 @@
+package greyscale
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorTopicEnv names the env variable holding the ErrorTopic arn.
+const ErrorTopicEnv = "ERRORSNS"
+
+// HandleError logs err and publishes it to the ErrorTopic. Failing to publish
+// is only logged so that one bad record doesn't stop a lambda.
+func HandleError(ctx context.Context, err error, publisher Publisher) {
+	log := logrus.WithFields(logrus.Fields{"action": "error"})
+	log.Error(err)
+
+	// publish error message to sns topic
+	err = publisher.Publish(ctx, os.Getenv(ErrorTopicEnv), Message{
+		Body: fmt.Sprintf("error : %v", err),
+	})
+	if err != nil {
+		// fail gracefully
+		log.Errorf("error publishing sns : %v", err)
+	}
+}
+
+// HandleRejected logs a rejected upload and publishes its image rejected
+// message to the ErrorTopic. Failing to publish is only logged, as for
+// HandleError.
+func HandleRejected(ctx context.Context, event ImageRejected, publisher Publisher) {
+	log := logrus.WithFields(logrus.Fields{"action": "rejected", "reason": event.Reason})
+	log.Warnf("rejected %s from bucket %s : %s", event.SourceKey, event.SourceBucket, event.Detail)
+
+	message, err := EncodeRejectedMessage(event)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := publisher.Publish(ctx, os.Getenv(ErrorTopicEnv), message); err != nil {
+		// fail gracefully
+		log.Errorf("error publishing sns : %v", err)
+	}
+}
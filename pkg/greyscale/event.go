@@ -0,0 +1,224 @@
+package greyscale
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// ImageTopicEnv names the env variable holding the ImageTopic arn.
+	ImageTopicEnv = "TOPICSNS"
+
+	// ImageConvertedEventType is the eventType of the message published to
+	// the ImageTopic once every rendition of an upload is stored.
+	ImageConvertedEventType = "image.converted"
+
+	// ImageConvertedSchemaVersion is the schemaVersion published by this
+	// version of the create lambda. Version 0 is the unversioned message
+	// published before eventType and schemaVersion existed, it has the same
+	// image fields and is still accepted by DecodeImageMessage.
+	ImageConvertedSchemaVersion = 1
+
+	// ImageRejectedEventType is the eventType of the message published to
+	// the ErrorTopic for an upload the create lambda refused to process.
+	ImageRejectedEventType = "image.rejected"
+
+	// ImageRejectedSchemaVersion is the schemaVersion of image rejected
+	// messages.
+	ImageRejectedSchemaVersion = 1
+
+	// EventTypeAttribute and SchemaVersionAttribute name the sns message
+	// attributes carrying the eventType and schemaVersion of a message, so
+	// that subscriptions can filter on them without parsing the body.
+	EventTypeAttribute     = "eventType"
+	SchemaVersionAttribute = "schemaVersion"
+)
+
+// ImageConverted is the message published to the ImageTopic for a converted
+// image. The GreyImage fields are inlined in the json so that a version 0
+// message decodes into the same struct.
+type ImageConverted struct {
+	EventType     string `json:"eventType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	GreyImage
+}
+
+// NewImageConverted returns the current version of the message for image.
+func NewImageConverted(image GreyImage) ImageConverted {
+	return ImageConverted{
+		EventType:     ImageConvertedEventType,
+		SchemaVersion: ImageConvertedSchemaVersion,
+		GreyImage:     image,
+	}
+}
+
+// Validate reports the first missing or invalid field of the message, so a
+// broken message is rejected instead of being stored as an empty record.
+func (e ImageConverted) Validate() error {
+	if e.EventType != ImageConvertedEventType {
+		return fmt.Errorf("invalid eventType %q, expected %q", e.EventType, ImageConvertedEventType)
+	}
+	if e.SchemaVersion < 0 || e.SchemaVersion > ImageConvertedSchemaVersion {
+		return fmt.Errorf("unsupported schemaVersion %d, expected at most %d", e.SchemaVersion, ImageConvertedSchemaVersion)
+	}
+
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"sourceBucket", e.SourceBucket},
+		{"sourceKey", e.SourceKey},
+		{"sourceURL", e.SourceURL},
+		{"convertBucket", e.ConvertBucket},
+		{"convertKey", e.ConvertKey},
+		{"convertURL", e.ConvertURL},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			return fmt.Errorf("missing %s", field.name)
+		}
+	}
+
+	for i, rendition := range e.Renditions {
+		if rendition.Name == "" || rendition.ConvertKey == "" || rendition.ConvertURL == "" {
+			return fmt.Errorf("rendition %d is missing its name, convertKey or convertURL", i)
+		}
+	}
+	return nil
+}
+
+// EncodeImageMessage returns the ImageTopic message for a converted image,
+// with its eventType and schemaVersion attributes.
+func EncodeImageMessage(image GreyImage) (Message, error) {
+	event := NewImageConverted(image)
+	if err := event.Validate(); err != nil {
+		return Message{}, fmt.Errorf("error, invalid image message : %w", err)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Message{}, fmt.Errorf("error, invalid json : %w", err)
+	}
+
+	return Message{
+		Body: string(body),
+		Attributes: map[string]MessageAttribute{
+			EventTypeAttribute:     {DataType: "String", Value: event.EventType},
+			SchemaVersionAttribute: {DataType: "Number", Value: strconv.Itoa(event.SchemaVersion)},
+		},
+	}, nil
+}
+
+// DecodeImageMessage parses and validates an ImageTopic message. A version 0
+// message, which has no eventType, is upgraded to the current version.
+func DecodeImageMessage(message string) (ImageConverted, error) {
+	var event ImageConverted
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		return ImageConverted{}, fmt.Errorf("error unmarshalling image message : %w", err)
+	}
+
+	if event.SchemaVersion == 0 && event.EventType == "" {
+		event.EventType = ImageConvertedEventType
+	}
+	if err := event.Validate(); err != nil {
+		return ImageConverted{}, fmt.Errorf("error, invalid image message : %w", err)
+	}
+
+	event.SchemaVersion = ImageConvertedSchemaVersion
+	return event, nil
+}
+
+// The reasons an upload is rejected for.
+const (
+	// RejectedObjectTooLarge is an upload of more bytes than allowed.
+	RejectedObjectTooLarge = "object_too_large"
+	// RejectedImageTooLarge is an image whose width, height or pixel count,
+	// read from its header, is more than allowed.
+	RejectedImageTooLarge = "image_too_large"
+	// RejectedAnimationTooLarge is an animation with more frames, or more
+	// pixels across its frames, than allowed.
+	RejectedAnimationTooLarge = "animation_too_large"
+)
+
+// ImageRejected is the message published to the ErrorTopic for an upload
+// that was not processed because it is beyond the limits of the create
+// lambda. Limit and Value are the limit exceeded and the value of the
+// upload, when the reason has them.
+type ImageRejected struct {
+	EventType     string `json:"eventType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	SourceBucket  string `json:"sourceBucket"`
+	SourceKey     string `json:"sourceKey"`
+	Reason        string `json:"reason"`
+	Detail        string `json:"detail"`
+	Limit         int64  `json:"limit,omitempty"`
+	Value         int64  `json:"value,omitempty"`
+}
+
+// NewImageRejected returns the current version of the message for an upload
+// rejected for reason.
+func NewImageRejected(bucket, key, reason, detail string) ImageRejected {
+	return ImageRejected{
+		EventType:     ImageRejectedEventType,
+		SchemaVersion: ImageRejectedSchemaVersion,
+		SourceBucket:  bucket,
+		SourceKey:     key,
+		Reason:        reason,
+		Detail:        detail,
+	}
+}
+
+// EncodeRejectedMessage returns the ErrorTopic message for a rejected upload,
+// with its eventType and schemaVersion attributes.
+func EncodeRejectedMessage(event ImageRejected) (Message, error) {
+	if event.SourceBucket == "" || event.SourceKey == "" || event.Reason == "" {
+		return Message{}, errors.New("error, invalid rejected message : missing sourceBucket, sourceKey or reason")
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Message{}, fmt.Errorf("error, invalid json : %w", err)
+	}
+
+	return Message{
+		Body: string(body),
+		Attributes: map[string]MessageAttribute{
+			EventTypeAttribute:     {DataType: "String", Value: event.EventType},
+			SchemaVersionAttribute: {DataType: "Number", Value: strconv.Itoa(event.SchemaVersion)},
+		},
+	}, nil
+}
+
+// snsNotification is the envelope sns wraps a message in when delivering it
+// to an sqs queue.
+type snsNotification struct {
+	Message           *string
+	MessageAttributes map[string]snsAttribute
+}
+
+type snsAttribute struct {
+	Type  string
+	Value string
+}
+
+// DecodeQueuedImageMessage parses an ImageTopic message delivered through an
+// sqs queue subscribed to the topic. The eventType attribute, when present,
+// must name an image converted message.
+func DecodeQueuedImageMessage(body string) (ImageConverted, error) {
+	var notification snsNotification
+	if err := json.Unmarshal([]byte(body), &notification); err != nil {
+		return ImageConverted{}, fmt.Errorf("error unmarshalling sqs message : %w", err)
+	}
+
+	// ensure sns message is not nil
+	if notification.Message == nil {
+		return ImageConverted{}, errors.New("sns message can not be nil")
+	}
+
+	if attribute, ok := notification.MessageAttributes[EventTypeAttribute]; ok && attribute.Value != ImageConvertedEventType {
+		return ImageConverted{}, fmt.Errorf("unexpected eventType attribute %q, expected %q", attribute.Value, ImageConvertedEventType)
+	}
+	return DecodeImageMessage(*notification.Message)
+}
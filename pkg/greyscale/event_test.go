@@ -0,0 +1,217 @@
+package greyscale
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testImage() GreyImage {
+	return GreyImage{
+		SourceBucket:  "greyscale",
+		SourceKey:     "photo.png",
+		SourceURL:     "https://greyscale.s3-eu-west-1.amazonaws.com/photo.png",
+		ConvertBucket: "greyscale-convert",
+		ConvertKey:    "converted-photo.png.jpg",
+		ConvertURL:    "https://greyscale-convert.s3-eu-west-1.amazonaws.com/converted-photo.png.jpg",
+		ImageType:     "image/jpeg",
+		Renditions: []Rendition{
+			{
+				Name:       "web",
+				ConvertKey: "converted/photo.png/web.jpg",
+				ConvertURL: "https://greyscale-convert.s3-eu-west-1.amazonaws.com/converted/photo.png/web.jpg",
+			},
+		},
+	}
+}
+
+// queued wraps message in the envelope sns delivers to sqs, with an optional
+// eventType attribute.
+func queued(t *testing.T, message *string, eventType string) string {
+	t.Helper()
+	notification := snsNotification{Message: message}
+	if eventType != "" {
+		notification.MessageAttributes = map[string]snsAttribute{
+			EventTypeAttribute: {Type: "String", Value: eventType},
+		}
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatalf("marshalling notification : %v", err)
+	}
+	return string(body)
+}
+
+func TestImageMessageRoundTrip(t *testing.T) {
+	message, err := EncodeImageMessage(testImage())
+	if err != nil {
+		t.Fatalf("EncodeImageMessage() error = %v", err)
+	}
+
+	got, err := DecodeImageMessage(message.Body)
+	if err != nil {
+		t.Fatalf("DecodeImageMessage() error = %v", err)
+	}
+	if want := NewImageConverted(testImage()); !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeImageMessage() = %+v, want %+v", got, want)
+	}
+
+	for name, want := range map[string]string{EventTypeAttribute: ImageConvertedEventType, SchemaVersionAttribute: "1"} {
+		attribute, ok := message.Attributes[name]
+		if !ok {
+			t.Errorf("attributes are missing %s", name)
+			continue
+		}
+		if attribute.Value != want {
+			t.Errorf("attribute %s = %q, want %q", name, attribute.Value, want)
+		}
+	}
+	if got := message.Attributes[SchemaVersionAttribute].DataType; got != "Number" {
+		t.Errorf("schemaVersion data type = %q, want Number", got)
+	}
+}
+
+func TestDecodeImageMessage(t *testing.T) {
+	v0, err := json.Marshal(testImage())
+	if err != nil {
+		t.Fatalf("marshalling image : %v", err)
+	}
+	encode := func(edit func(m map[string]interface{})) string {
+		current, err := EncodeImageMessage(testImage())
+		if err != nil {
+			t.Fatalf("EncodeImageMessage() error = %v", err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(current.Body), &m); err != nil {
+			t.Fatalf("unmarshalling message : %v", err)
+		}
+		edit(m)
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("marshalling message : %v", err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		wantErr string
+	}{
+		{name: "version 0 is upgraded", message: string(v0)},
+		{name: "not json", message: "{", wantErr: "unmarshalling"},
+		{
+			name:    "missing convertKey",
+			message: encode(func(m map[string]interface{}) { delete(m, "convertKey") }),
+			wantErr: "missing convertKey",
+		},
+		{
+			name:    "missing sourceBucket",
+			message: encode(func(m map[string]interface{}) { m["sourceBucket"] = "" }),
+			wantErr: "missing sourceBucket",
+		},
+		{
+			name:    "other event type",
+			message: encode(func(m map[string]interface{}) { m["eventType"] = "image.rejected" }),
+			wantErr: "invalid eventType",
+		},
+		{
+			name:    "newer schema version",
+			message: encode(func(m map[string]interface{}) { m["schemaVersion"] = ImageConvertedSchemaVersion + 1 }),
+			wantErr: "unsupported schemaVersion",
+		},
+		{
+			name: "rendition without a key",
+			message: encode(func(m map[string]interface{}) {
+				m["renditions"] = []map[string]string{{"name": "web"}}
+			}),
+			wantErr: "rendition 0",
+		},
+		{
+			name:    "version 0 missing a field",
+			message: `{"sourceBucket": "greyscale", "sourceKey": "photo.png"}`,
+			wantErr: "missing sourceURL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeImageMessage(tt.message)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DecodeImageMessage() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeImageMessage() error = %v", err)
+			}
+			if want := NewImageConverted(testImage()); !reflect.DeepEqual(got, want) {
+				t.Errorf("DecodeImageMessage() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeImageMessageValidates(t *testing.T) {
+	image := testImage()
+	image.ConvertURL = ""
+	if _, err := EncodeImageMessage(image); err == nil || !strings.Contains(err.Error(), "missing convertURL") {
+		t.Errorf("EncodeImageMessage() error = %v, want one for the missing convertURL", err)
+	}
+}
+
+func TestDecodeQueuedImageMessage(t *testing.T) {
+	message, err := EncodeImageMessage(testImage())
+	if err != nil {
+		t.Fatalf("EncodeImageMessage() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "with attributes", body: queued(t, &message.Body, ImageConvertedEventType)},
+		{name: "without attributes", body: queued(t, &message.Body, "")},
+		{name: "other event type", body: queued(t, &message.Body, "image.rejected"), wantErr: true},
+		{name: "no message", body: queued(t, nil, ImageConvertedEventType), wantErr: true},
+		{name: "not json", body: "{", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeQueuedImageMessage(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeQueuedImageMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, NewImageConverted(testImage())) {
+				t.Errorf("DecodeQueuedImageMessage() = %+v, want %+v", got, NewImageConverted(testImage()))
+			}
+		})
+	}
+}
+
+func TestEncodeRejectedMessage(t *testing.T) {
+	event := NewImageRejected("greyscale", "huge.png", RejectedImageTooLarge, "image width of 20000 exceeds the limit of 10000")
+	event.Limit, event.Value = 10000, 20000
+	message, err := EncodeRejectedMessage(event)
+	if err != nil {
+		t.Fatalf("EncodeRejectedMessage() error = %v", err)
+	}
+
+	var got ImageRejected
+	if err := json.Unmarshal([]byte(message.Body), &got); err != nil {
+		t.Fatalf("unmarshalling message : %v", err)
+	}
+	if !reflect.DeepEqual(got, event) {
+		t.Errorf("message = %+v, want %+v", got, event)
+	}
+	if got := message.Attributes[EventTypeAttribute].Value; got != ImageRejectedEventType {
+		t.Errorf("eventType attribute = %q, want %q", got, ImageRejectedEventType)
+	}
+
+	event.Reason = ""
+	if _, err := EncodeRejectedMessage(event); err == nil {
+		t.Error("EncodeRejectedMessage() accepted a message without a reason")
+	}
+}
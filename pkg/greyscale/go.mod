@@ -0,0 +1,8 @@
+module github.com/ciaranRoche/lambda-image-processor/pkg/greyscale
+
+go 1.15
+
+require (
+	github.com/aws/aws-sdk-go v1.36.4
+	github.com/sirupsen/logrus v1.7.0
+)
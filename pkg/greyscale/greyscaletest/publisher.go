@@ -0,0 +1,64 @@
+package greyscaletest
+
+import (
+	"context"
+
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+// PublishedMessage is a message published to a Publisher.
+type PublishedMessage struct {
+	Topic      string                                `json:"topic"`
+	Body       string                                `json:"body"`
+	Attributes map[string]greyscale.MessageAttribute `json:"attributes,omitempty"`
+}
+
+// Publisher is an in memory sns, keeping every message published and passing
+// it to the subscribers of its topic.
+type Publisher struct {
+	Published   []PublishedMessage
+	Subscribers map[string][]func(greyscale.Message)
+	// Err, when set, fails every publish.
+	Err error
+}
+
+var _ greyscale.Publisher = &Publisher{}
+
+// NewPublisher returns a Publisher without messages or subscribers.
+func NewPublisher() *Publisher {
+	return &Publisher{
+		Published:   []PublishedMessage{},
+		Subscribers: map[string][]func(greyscale.Message){},
+	}
+}
+
+// Subscribe passes every message later published to topic to fn.
+func (p *Publisher) Subscribe(topic string, fn func(greyscale.Message)) {
+	p.Subscribers[topic] = append(p.Subscribers[topic], fn)
+}
+
+func (p *Publisher) Publish(ctx context.Context, topic string, message greyscale.Message) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	p.Published = append(p.Published, PublishedMessage{
+		Topic:      topic,
+		Body:       message.Body,
+		Attributes: message.Attributes,
+	})
+	for _, fn := range p.Subscribers[topic] {
+		fn(message)
+	}
+	return nil
+}
+
+// Messages returns the messages published to topic, in order.
+func (p *Publisher) Messages(topic string) []PublishedMessage {
+	var messages []PublishedMessage
+	for _, message := range p.Published {
+		if message.Topic == topic {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,97 @@
+package greyscaletest
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+// The dynamodb stream event names of the changes an ImageRepository reports.
+const (
+	RecordInserted = "INSERT"
+	RecordModified = "MODIFY"
+	RecordRemoved  = "REMOVE"
+)
+
+// ImageRepository is an in memory ImageTable.
+type ImageRepository struct {
+	// Items holds every image, keyed by ImageConverter.
+	Items map[string]greyscale.GreyImage
+	// OnChange, when set, is called with the stream record every change
+	// would have raised.
+	OnChange func(eventName string, image greyscale.GreyImage)
+	// Errors fails the methods named by its keys, e.g. "Put", with their
+	// value instead of running them.
+	Errors map[string]error
+}
+
+var _ greyscale.ImageRepository = &ImageRepository{}
+
+// NewImageRepository returns an empty ImageRepository.
+func NewImageRepository() *ImageRepository {
+	return &ImageRepository{Items: map[string]greyscale.GreyImage{}}
+}
+
+func (r *ImageRepository) Put(ctx context.Context, image greyscale.GreyImage) error {
+	if err := r.Errors["Put"]; err != nil {
+		return err
+	}
+	eventName := RecordInserted
+	if _, ok := r.Items[image.ImageConverter]; ok {
+		eventName = RecordModified
+	}
+	r.Items[image.ImageConverter] = image
+	if r.OnChange != nil {
+		r.OnChange(eventName, image)
+	}
+	return nil
+}
+
+func (r *ImageRepository) FindByConvertKey(ctx context.Context, key string) ([]greyscale.GreyImage, error) {
+	if err := r.Errors["FindByConvertKey"]; err != nil {
+		return nil, err
+	}
+	return r.Scan(func(image greyscale.GreyImage) bool {
+		return image.ConvertKey == key
+	}), nil
+}
+
+func (r *ImageRepository) ListConverted(ctx context.Context) ([]greyscale.GreyImage, error) {
+	if err := r.Errors["ListConverted"]; err != nil {
+		return nil, err
+	}
+	return r.Scan(func(image greyscale.GreyImage) bool {
+		return image.ConvertURL != ""
+	}), nil
+}
+
+func (r *ImageRepository) Delete(ctx context.Context, imageConverter string) error {
+	if err := r.Errors["Delete"]; err != nil {
+		return err
+	}
+	image, ok := r.Items[imageConverter]
+	if !ok {
+		return nil
+	}
+	delete(r.Items, imageConverter)
+	if r.OnChange != nil {
+		r.OnChange(RecordRemoved, image)
+	}
+	return nil
+}
+
+// Scan returns the matching images ordered by source key, so they come back
+// the same way on every run.
+func (r *ImageRepository) Scan(match func(greyscale.GreyImage) bool) []greyscale.GreyImage {
+	images := []greyscale.GreyImage{}
+	for _, image := range r.Items {
+		if match(image) {
+			images = append(images, image)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].SourceKey < images[j].SourceKey
+	})
+	return images
+}
@@ -0,0 +1,162 @@
+// Package greyscaletest provides in memory fakes of the greyscale
+// interfaces, for the tests of the lambdas and for the simulator.
+package greyscaletest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+)
+
+// The s3 event names of the changes an ObjectStore reports.
+const (
+	ObjectCreated = "ObjectCreated:Put"
+	ObjectRemoved = "ObjectRemoved:Delete"
+)
+
+// Object is an object held by an ObjectStore.
+type Object struct {
+	Body []byte
+	Info greyscale.ObjectInfo
+	Tags map[string]string
+}
+
+// ObjectStore is an in memory s3.
+type ObjectStore struct {
+	// Buckets holds the objects of every bucket, keyed by bucket then key.
+	Buckets map[string]map[string]Object
+	// OnChange, when set, is called with the s3 event every put and delete
+	// would have raised.
+	OnChange func(eventName, bucket, key string, size int64)
+	// Errors fails the methods named by its keys, e.g. "Put", with their
+	// value instead of running them.
+	Errors map[string]error
+}
+
+var _ greyscale.ObjectStore = &ObjectStore{}
+
+// NewObjectStore returns an empty ObjectStore.
+func NewObjectStore() *ObjectStore {
+	return &ObjectStore{Buckets: map[string]map[string]Object{}}
+}
+
+func (s *ObjectStore) lookup(bucket, key string) (Object, error) {
+	obj, ok := s.Buckets[bucket][key]
+	if !ok {
+		return Object{}, fmt.Errorf("no object %s in bucket %s", key, bucket)
+	}
+	return obj, nil
+}
+
+func (s *ObjectStore) Head(ctx context.Context, bucket, key string) (greyscale.ObjectInfo, error) {
+	if err := s.Errors["Head"]; err != nil {
+		return greyscale.ObjectInfo{}, err
+	}
+	obj, err := s.lookup(bucket, key)
+	if err != nil {
+		return greyscale.ObjectInfo{}, err
+	}
+	return obj.Info, nil
+}
+
+func (s *ObjectStore) Get(ctx context.Context, bucket, key string) (io.ReadCloser, greyscale.ObjectInfo, error) {
+	if err := s.Errors["Get"]; err != nil {
+		return nil, greyscale.ObjectInfo{}, err
+	}
+	obj, err := s.lookup(bucket, key)
+	if err != nil {
+		return nil, greyscale.ObjectInfo{}, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.Body)), obj.Info, nil
+}
+
+func (s *ObjectStore) Tags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	if err := s.Errors["Tags"]; err != nil {
+		return nil, err
+	}
+	obj, err := s.lookup(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(obj.Tags))
+	for name, value := range obj.Tags {
+		tags[name] = value
+	}
+	return tags, nil
+}
+
+func (s *ObjectStore) Put(ctx context.Context, bucket, key string, body io.Reader, contentType string) error {
+	if err := s.Errors["Put"]; err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("error reading %s : %w", key, err)
+	}
+	s.PutObject(bucket, key, Object{Body: data, Info: greyscale.ObjectInfo{ContentType: contentType}})
+	return nil
+}
+
+// PutObject stores obj with its metadata and tags, which the ObjectStore
+// interface has no way to set. The content length is set from its body.
+func (s *ObjectStore) PutObject(bucket, key string, obj Object) {
+	obj.Info.ContentLength = int64(len(obj.Body))
+	if s.Buckets[bucket] == nil {
+		s.Buckets[bucket] = map[string]Object{}
+	}
+	s.Buckets[bucket][key] = obj
+	if s.OnChange != nil {
+		s.OnChange(ObjectCreated, bucket, key, obj.Info.ContentLength)
+	}
+}
+
+// Object returns the object stored under key.
+func (s *ObjectStore) Object(bucket, key string) (Object, bool) {
+	obj, ok := s.Buckets[bucket][key]
+	return obj, ok
+}
+
+// Keys returns the keys of every object of bucket, in order.
+func (s *ObjectStore) Keys(bucket string) []string {
+	keys, _ := s.list(bucket, "")
+	return keys
+}
+
+func (s *ObjectStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	if err := s.Errors["List"]; err != nil {
+		return nil, err
+	}
+	return s.list(bucket, prefix)
+}
+
+func (s *ObjectStore) list(bucket, prefix string) ([]string, error) {
+	var keys []string
+	for key := range s.Buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *ObjectStore) Delete(ctx context.Context, bucket, key string) error {
+	if err := s.Errors["Delete"]; err != nil {
+		return err
+	}
+	// like s3, deleting a missing object succeeds and raises no event
+	if _, ok := s.Buckets[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.Buckets[bucket], key)
+	if s.OnChange != nil {
+		s.OnChange(ObjectRemoved, bucket, key, 0)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Package greyscale holds the types and naming rules shared by the greyscale
+// lambdas, so that every lambda agrees on what an image record looks like and
+// where its objects live.
+package greyscale
+
+import "fmt"
+
+const (
+	// Region is the region every greyscale bucket lives in.
+	Region = "eu-west-1"
+
+	// ImageTable is the dynamodb table holding a GreyImage per conversion.
+	ImageTable = "Image"
+)
+
+// GreyImage is a converted upload. It is published to the ImageTopic by the
+// create lambda and stored in the ImageTable by the db create lambda, which
+// assigns the ImageConverter key.
+type GreyImage struct {
+	ImageConverter string      `json:"imageConverter,omitempty"`
+	SourceBucket   string      `json:"sourceBucket"`
+	SourceKey      string      `json:"sourceKey"`
+	SourceURL      string      `json:"sourceURL"`
+	ConvertBucket  string      `json:"convertBucket"`
+	ConvertKey     string      `json:"convertKey"`
+	ConvertURL     string      `json:"convertURL"`
+	ImageType      string      `json:"imageType"`
+	Renditions     []Rendition `json:"renditions,omitempty"`
+	// Metadata holds the EXIF fields of the upload kept by the primary
+	// rendition, keyed by tag name, e.g. "Copyright".
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Rendition is one of the named outputs produced from an upload, the primary
+// rendition is also described by the ConvertKey and ConvertURL of its
+// GreyImage.
+type Rendition struct {
+	Name       string `json:"name"`
+	ConvertKey string `json:"convertKey"`
+	ConvertURL string `json:"convertURL"`
+	// Processing describes the pipeline run that produced the rendition. It
+	// is published for diagnosing slow conversions, the db create lambda
+	// drops it before storing the image.
+	Processing *ProcessingSummary `json:"processing,omitempty"`
+}
+
+// ProcessingSummary describes the pipeline run that produced a rendition.
+// For animations every figure is the total across all frames. The
+// allocation figures are only set when the create lambda tracks them.
+type ProcessingSummary struct {
+	DurationMs float64         `json:"durationMs"`
+	AllocBytes uint64          `json:"allocBytes,omitempty"`
+	Actions    []ActionSummary `json:"actions"`
+}
+
+func (s *ProcessingSummary) String() string {
+	if s == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%d actions in %.3fms", len(s.Actions), s.DurationMs)
+}
+
+// ActionSummary describes one action of a pipeline run.
+type ActionSummary struct {
+	Name         string  `json:"name"`
+	DurationMs   float64 `json:"durationMs"`
+	InputWidth   int     `json:"inputWidth"`
+	InputHeight  int     `json:"inputHeight"`
+	OutputWidth  int     `json:"outputWidth"`
+	OutputHeight int     `json:"outputHeight"`
+	Allocs       uint64  `json:"allocs,omitempty"`
+	AllocBytes   uint64  `json:"allocBytes,omitempty"`
+	// Shared is set for actions whose result was shared with other
+	// renditions, their figures are counted in the summary of each.
+	Shared bool `json:"shared,omitempty"`
+}
@@ -0,0 +1,84 @@
+package greyscale
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConvertBucket returns the bucket converted images of sourceBucket are
+// written to.
+func ConvertBucket(sourceBucket string) string {
+	return fmt.Sprintf("%s-convert", sourceBucket)
+}
+
+// BackupBucket returns the bucket backups of bucket are written to.
+func BackupBucket(bucket string) string {
+	return fmt.Sprintf("%s-backup", bucket)
+}
+
+// ConvertExtensions are the extensions of the formats the create lambda
+// encodes renditions in.
+var ConvertExtensions = []string{".jpg", ".png", ".gif"}
+
+// ConvertKey returns the key of the primary rendition of sourceKey. It keeps
+// the whole source key, extension included, so uploads differing only in
+// their extension are not converted to the same key, and adds the extension
+// of the format the rendition was encoded in.
+func ConvertKey(sourceKey, extension string) string {
+	return convertKeyBase(sourceKey) + extension
+}
+
+// IsConvertKey reports whether key is the primary rendition of sourceKey, in
+// any format.
+func IsConvertKey(key, sourceKey string) bool {
+	base := convertKeyBase(sourceKey)
+	if !strings.HasPrefix(key, base) {
+		return false
+	}
+	// a longer source key sharing the prefix, such as photo.png for photo,
+	// leaves more than one of the extensions behind
+	extension := strings.TrimPrefix(key, base)
+	for _, e := range ConvertExtensions {
+		if extension == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertKeyPrefix returns a prefix shared by every primary rendition key of
+// sourceKey. Keys listed under it still need checking with IsConvertKey, as
+// the prefix also matches longer source keys.
+func ConvertKeyPrefix(sourceKey string) string {
+	return convertKeyBase(sourceKey)
+}
+
+func convertKeyBase(sourceKey string) string {
+	return fmt.Sprintf("converted-%s", sourceKey)
+}
+
+// RenditionKey returns the key of a named, non primary, rendition of
+// sourceKey.
+func RenditionKey(sourceKey, name, extension string) string {
+	return fmt.Sprintf("%s%s%s", RenditionPrefix(sourceKey), name, extension)
+}
+
+// RenditionPrefix returns the prefix every non primary rendition of sourceKey
+// is stored under. Keys listed under it still need checking with
+// IsRenditionKey, as the prefix also matches nested source keys.
+func RenditionPrefix(sourceKey string) string {
+	return fmt.Sprintf("converted/%s/", sourceKey)
+}
+
+// IsRenditionKey reports whether key is a non primary rendition of sourceKey.
+func IsRenditionKey(key, sourceKey string) bool {
+	prefix := RenditionPrefix(sourceKey)
+	// the renditions of a nested source key such as <key>/photo.jpg share
+	// the prefix, one level further down
+	return strings.HasPrefix(key, prefix) && !strings.Contains(strings.TrimPrefix(key, prefix), "/")
+}
+
+// ImageURL returns the public url of an object.
+func ImageURL(bucket, region, key string) string {
+	return fmt.Sprintf("https://%s.s3-%s.amazonaws.com/%s", bucket, region, key)
+}
@@ -0,0 +1,70 @@
+package greyscale
+
+import "testing"
+
+func TestIsConvertKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		sourceKey string
+		want      bool
+	}{
+		{name: "jpeg", key: "converted-photo.png.jpg", sourceKey: "photo.png", want: true},
+		{name: "same format", key: "converted-photo.png.png", sourceKey: "photo.png", want: true},
+		{name: "no source extension", key: "converted-a.gif", sourceKey: "a", want: true},
+		{name: "other source", key: "converted-photo.jpg.jpg", sourceKey: "photo.png"},
+		{name: "longer source key", key: "converted-a.jpg.jpg", sourceKey: "a"},
+		{name: "longer source key in an encoder format", key: "converted-a.jpg", sourceKey: "a.jpg"},
+		// converted-a.tiff could only be the legacy primary of a.tiff, as
+		// nothing is encoded as tiff
+		{name: "source extension that is not an encoder format", key: "converted-a.tiff", sourceKey: "a"},
+		{name: "nested source key", key: "converted-a/b.jpg", sourceKey: "a"},
+		{name: "no extension", key: "converted-a", sourceKey: "a"},
+		{name: "rendition", key: "converted/a/web.jpg", sourceKey: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConvertKey(tt.key, tt.sourceKey); got != tt.want {
+				t.Errorf("IsConvertKey(%q, %q) = %v, want %v", tt.key, tt.sourceKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertKeyRoundTrip(t *testing.T) {
+	for _, sourceKey := range []string{"a", "a.jpg", "photo.png", "dir/photo.jpeg"} {
+		for _, extension := range ConvertExtensions {
+			key := ConvertKey(sourceKey, extension)
+			if !IsConvertKey(key, sourceKey) {
+				t.Errorf("IsConvertKey(%q, %q) = false, want true", key, sourceKey)
+			}
+			for _, other := range []string{"a", "a.jpg", "photo.png", "dir/photo.jpeg"} {
+				if other != sourceKey && IsConvertKey(key, other) {
+					t.Errorf("IsConvertKey(%q, %q) = true, want false", key, other)
+				}
+			}
+		}
+	}
+}
+
+func TestIsRenditionKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		sourceKey string
+		want      bool
+	}{
+		{name: "rendition", key: "converted/a/web.jpg", sourceKey: "a", want: true},
+		{name: "rendition of a nested source key", key: "converted/a/b.jpg/web.jpg", sourceKey: "a"},
+		{name: "nested source rendition", key: "converted/a/b.jpg/web.jpg", sourceKey: "a/b.jpg", want: true},
+		{name: "longer source key", key: "converted/ab/web.jpg", sourceKey: "a"},
+		{name: "primary", key: "converted-a.jpg", sourceKey: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRenditionKey(tt.key, tt.sourceKey); got != tt.want {
+				t.Errorf("IsRenditionKey(%q, %q) = %v, want %v", tt.key, tt.sourceKey, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package greyscale
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// Message is a notification published to a topic.
+type Message struct {
+	Body       string
+	Attributes map[string]MessageAttribute
+}
+
+// MessageAttribute is a typed attribute of a Message, DataType is one of the
+// sns data types, e.g. "String" or "Number".
+type MessageAttribute struct {
+	DataType string
+	Value    string
+}
+
+// Publisher publishes messages to a topic.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, message Message) error
+}
+
+type snsPublisher struct {
+	svc *sns.SNS
+}
+
+// NewSNSPublisher returns a Publisher backed by sns, topics are topic arns.
+func NewSNSPublisher(sess *session.Session) Publisher {
+	return &snsPublisher{svc: sns.New(sess)}
+}
+
+func (p *snsPublisher) Publish(ctx context.Context, topic string, message Message) error {
+	input := &sns.PublishInput{
+		Message:  aws.String(message.Body),
+		TopicArn: aws.String(topic),
+	}
+	if len(message.Attributes) > 0 {
+		input.MessageAttributes = make(map[string]*sns.MessageAttributeValue, len(message.Attributes))
+		for name, attribute := range message.Attributes {
+			input.MessageAttributes[name] = &sns.MessageAttributeValue{
+				DataType:    aws.String(attribute.DataType),
+				StringValue: aws.String(attribute.Value),
+			}
+		}
+	}
+
+	if _, err := p.svc.PublishWithContext(ctx, input); err != nil {
+		return fmt.Errorf("error publishing sns : %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package greyscale
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// ImageRepository stores the GreyImage records of converted images.
+type ImageRepository interface {
+	// Put stores image under its ImageConverter key.
+	Put(ctx context.Context, image GreyImage) error
+	// FindByConvertKey returns the images whose primary rendition is key.
+	FindByConvertKey(ctx context.Context, key string) ([]GreyImage, error)
+	// ListConverted returns every image with a converted url.
+	ListConverted(ctx context.Context) ([]GreyImage, error)
+	// Delete removes the image stored under imageConverter.
+	Delete(ctx context.Context, imageConverter string) error
+}
+
+type dynamoImageRepository struct {
+	svc   *dynamodb.DynamoDB
+	table string
+}
+
+// NewDynamoImageRepository returns an ImageRepository backed by the dynamodb
+// table, normally ImageTable.
+func NewDynamoImageRepository(sess *session.Session, table string) ImageRepository {
+	return &dynamoImageRepository{
+		svc:   dynamodb.New(sess),
+		table: table,
+	}
+}
+
+func (r *dynamoImageRepository) Put(ctx context.Context, image GreyImage) error {
+	// parse image as dynamodb attribute
+	item, err := dynamodbattribute.MarshalMap(image)
+	if err != nil {
+		return fmt.Errorf("could not marshal image : %w", err)
+	}
+
+	_, err = r.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(r.table),
+	})
+	if err != nil {
+		return fmt.Errorf("failure to insert item to dynamoDB : %w", err)
+	}
+	return nil
+}
+
+func (r *dynamoImageRepository) FindByConvertKey(ctx context.Context, key string) ([]GreyImage, error) {
+	return r.scan(ctx, expression.Name("convertKey").Equal(expression.Value(key)))
+}
+
+func (r *dynamoImageRepository) ListConverted(ctx context.Context) ([]GreyImage, error) {
+	return r.scan(ctx, expression.Name("convertURL").AttributeExists())
+}
+
+func (r *dynamoImageRepository) scan(ctx context.Context, filter expression.ConditionBuilder) ([]GreyImage, error) {
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building dynamodb expression : %w", err)
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	err = r.svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		TableName:                 aws.String(r.table),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting items from dynamodb : %w", err)
+	}
+
+	images := make([]GreyImage, 0, len(items))
+	for _, item := range items {
+		var image GreyImage
+		if err := dynamodbattribute.UnmarshalMap(item, &image); err != nil {
+			return nil, fmt.Errorf("error unmarshalling image : %w", err)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (r *dynamoImageRepository) Delete(ctx context.Context, imageConverter string) error {
+	_, err := r.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"imageConverter": {
+				S: aws.String(imageConverter),
+			},
+		},
+		TableName: aws.String(r.table),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting item from DB : %w", err)
+	}
+	return nil
+}
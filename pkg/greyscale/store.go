@@ -0,0 +1,136 @@
+package greyscale
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	ContentType   string
+	ContentLength int64
+	// Metadata holds the user metadata (x-amz-meta-*) of the object, keyed by
+	// the canonical http header form of its name, e.g. "Pipeline".
+	Metadata map[string]string
+}
+
+// ObjectStore reads and writes the objects of the greyscale buckets.
+type ObjectStore interface {
+	// Head returns the description of an object without reading it.
+	Head(ctx context.Context, bucket, key string) (ObjectInfo, error)
+	// Get returns the body of an object, which the caller must close.
+	Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error)
+	// Tags returns the tags set on an object.
+	Tags(ctx context.Context, bucket, key string) (map[string]string, error)
+	// Put stores body as the object, replacing any existing one.
+	Put(ctx context.Context, bucket, key string, body io.Reader, contentType string) error
+	// List returns the keys of every object under prefix.
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
+	// Delete removes an object.
+	Delete(ctx context.Context, bucket, key string) error
+}
+
+type s3ObjectStore struct {
+	svc      *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewS3ObjectStore returns an ObjectStore backed by s3.
+func NewS3ObjectStore(sess *session.Session) ObjectStore {
+	return &s3ObjectStore{
+		svc:      s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+	}
+}
+
+func (s *s3ObjectStore) Head(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	head, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("error getting metadata of %s from bucket %s : %w", key, bucket, err)
+	}
+	return ObjectInfo{
+		ContentType:   aws.StringValue(head.ContentType),
+		ContentLength: aws.Int64Value(head.ContentLength),
+		Metadata:      aws.StringValueMap(head.Metadata),
+	}, nil
+}
+
+func (s *s3ObjectStore) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	obj, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("error getting %s from bucket %s : %w", key, bucket, err)
+	}
+	return obj.Body, ObjectInfo{
+		ContentType:   aws.StringValue(obj.ContentType),
+		ContentLength: aws.Int64Value(obj.ContentLength),
+		Metadata:      aws.StringValueMap(obj.Metadata),
+	}, nil
+}
+
+func (s *s3ObjectStore) Tags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	tagging, err := s.svc.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting tags of %s from bucket %s : %w", key, bucket, err)
+	}
+	tags := make(map[string]string, len(tagging.TagSet))
+	for _, tag := range tagging.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+func (s *s3ObjectStore) Put(ctx context.Context, bucket, key string, body io.Reader, contentType string) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("error putting %s in bucket %s : %w", key, bucket, err)
+	}
+	return nil
+}
+
+func (s *s3ObjectStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s in bucket %s : %w", prefix, bucket, err)
+	}
+	return keys, nil
+}
+
+func (s *s3ObjectStore) Delete(ctx context.Context, bucket, key string) error {
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting %s from bucket %s : %w", key, bucket, err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+module github.com/ciaranRoche/lambda-image-processor/simulator
+
+go 1.15
+
+require (
+	github.com/aws/aws-lambda-go v1.20.0
+	github.com/ciaranRoche/greyscale v0.0.0
+	github.com/ciaranRoche/greyscale-db v0.0.0
+	github.com/ciaranRoche/greyscale-db-delete v0.0.0
+	github.com/ciaranRoche/greyscale-db-event v0.0.0
+	github.com/ciaranRoche/greyscale-event-delete v0.0.0
+	github.com/ciaranRoche/greyscale-site-backup v0.0.0
+	github.com/ciaranRoche/lambda-image-processor/pkg/greyscale v0.0.0
+	github.com/sirupsen/logrus v1.7.0
+)
+
+replace (
+	github.com/ciaranRoche/greyscale => ../lambda-greyscale-create
+	github.com/ciaranRoche/greyscale-db => ../lambda-greyscale-db-create
+	github.com/ciaranRoche/greyscale-db-delete => ../lambda-greyscale-db-delete
+	github.com/ciaranRoche/greyscale-db-event => ../lambda-greyscale-db-site-builder
+	github.com/ciaranRoche/greyscale-event-delete => ../lambda-greyscale-delete
+	github.com/ciaranRoche/greyscale-site-backup => ../lambda-greyscale-db-site-backup
+	github.com/ciaranRoche/lambda-image-processor/pkg/greyscale => ../pkg/greyscale
+)
@@ -0,0 +1,207 @@
+// Command simulator runs the whole greyscale event chain locally. Every file
+// in the input directory is uploaded to an in memory greyscale bucket, which
+// triggers the create lambda and, through in memory sns, sqs and dynamodb
+// streams, every lambda after it. The buckets, the ImageTable and the
+// published messages are then written to the output directory.
+//
+// With -watch the input directory is polled, new or changed files are
+// uploaded again and removed files are deleted, triggering the delete
+// lambdas.
+//
+// A file may be given upload metadata and tags, e.g. to select a pipeline,
+// with a "<file>.meta.json" file next to it:
+//
+//	{"metadata": {"pipeline": "thumbs"}, "tags": {"quality": "80"}}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const metaSuffix = ".meta.json"
+
+// sidecar is the upload metadata and tags of a file.
+type sidecar struct {
+	Metadata map[string]string `json:"metadata"`
+	Tags     map[string]string `json:"tags"`
+}
+
+func main() {
+	in := flag.String("in", "greyscale", "directory acting as the greyscale bucket")
+	out := flag.String("out", "out", "directory the buckets, table and messages are written to")
+	bucket := flag.String("bucket", "greyscale", "name of the greyscale bucket")
+	templatePath := flag.String("template", filepath.Join("..", "lambda-greyscale-db-site-builder", "index.gohtml"), "site builder template")
+	watch := flag.Bool("watch", false, "keep polling the input directory for changes")
+	interval := flag.Duration("interval", time.Second, "polling interval with -watch")
+	flag.Parse()
+
+	logger := logrus.WithFields(logrus.Fields{"action": "simulator"})
+
+	if err := checkDirs(*in, *out); err != nil {
+		logger.Fatal(err)
+	}
+	// errors are published to the in memory ErrorTopic and logged
+	os.Setenv(greyscale.ErrorTopicEnv, errorTopic)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	s, err := newSimulator(ctx, *bucket, *templatePath)
+	if err != nil {
+		logger.Fatalf("error loading processing config : %v", err)
+	}
+	seen := map[string]time.Time{}
+	for {
+		changed, err := s.sync(*in, seen)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if changed {
+			if err := s.run(ctx); err != nil {
+				break
+			}
+			if err := s.write(*out); err != nil {
+				logger.Fatal(err)
+			}
+			logger.Infof("wrote results to %s", *out)
+		}
+
+		if !*watch {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(*interval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+}
+
+// checkDirs ensures in exists and that writing out can't remove it.
+func checkDirs(in, out string) error {
+	if info, err := os.Stat(in); err != nil || !info.IsDir() {
+		return fmt.Errorf("input %s is not a directory", in)
+	}
+	absIn, err := filepath.Abs(in)
+	if err != nil {
+		return err
+	}
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(absOut, absIn); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("input %s can not be inside output %s", in, out)
+	}
+	return nil
+}
+
+// sync uploads the files of dir that are new or changed since the last sync
+// and deletes the objects of files that were removed, seen holds the
+// modification time of every uploaded file. It reports whether anything
+// changed.
+func (s *simulator) sync(dir string, seen map[string]time.Time) (bool, error) {
+	ctx := context.Background()
+	found := map[string]bool{}
+	changed := false
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || strings.HasSuffix(path, metaSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		found[key] = true
+
+		modified := info.ModTime()
+		if meta, err := os.Stat(path + metaSuffix); err == nil && meta.ModTime().After(modified) {
+			modified = meta.ModTime()
+		}
+		if last, ok := seen[key]; ok && !modified.After(last) {
+			return nil
+		}
+		seen[key] = modified
+		changed = true
+		return s.upload(path, key)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error reading %s : %w", dir, err)
+	}
+
+	for key := range seen {
+		if found[key] {
+			continue
+		}
+		delete(seen, key)
+		changed = true
+		if err := s.store.Delete(ctx, s.bucket, key); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+func (s *simulator) upload(path, key string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s : %w", path, err)
+	}
+
+	var meta sidecar
+	if raw, err := ioutil.ReadFile(path + metaSuffix); err == nil {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return fmt.Errorf("error reading %s : %w", path+metaSuffix, err)
+		}
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	// s3 returns metadata names in their canonical header form
+	metadata := make(map[string]string, len(meta.Metadata))
+	for name, value := range meta.Metadata {
+		metadata[http.CanonicalHeaderKey(name)] = value
+	}
+
+	logrus.WithFields(logrus.Fields{"action": "simulator"}).Infof("uploading %s to bucket %s", key, s.bucket)
+	s.store.PutObject(s.bucket, key, greyscaletest.Object{
+		Body: data,
+		Info: greyscale.ObjectInfo{
+			ContentType: contentType,
+			Metadata:    metadata,
+		},
+		Tags: meta.Tags,
+	})
+	return nil
+}
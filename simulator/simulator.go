@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ciaranRoche/greyscale-db-delete/pkg/dbdelete"
+	"github.com/ciaranRoche/greyscale-db-event/pkg/sitebuilder"
+	"github.com/ciaranRoche/greyscale-db/pkg/dbcreate"
+	"github.com/ciaranRoche/greyscale-event-delete/pkg/cleanup"
+	"github.com/ciaranRoche/greyscale-site-backup/pkg/sitebackup"
+	"github.com/ciaranRoche/greyscale/pkg/converter"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale"
+	"github.com/ciaranRoche/lambda-image-processor/pkg/greyscale/greyscaletest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	imageTopic = "ImageTopic"
+	errorTopic = "ErrorTopic"
+)
+
+// simulator wires the six lambda handlers to in memory stand-ins of s3, sns,
+// sqs and the ImageTable stream, triggering them as the deployed event chain
+// would. Triggered handlers are queued and run one at a time by run, so a
+// handler never runs inside another.
+type simulator struct {
+	bucket string
+
+	store  *greyscaletest.ObjectStore
+	topics *greyscaletest.Publisher
+	table  *greyscaletest.ImageRepository
+
+	converter   *converter.Handler
+	cleanup     *cleanup.Handler
+	dbcreate    *dbcreate.Handler
+	dbdelete    *dbdelete.Handler
+	sitebuilder *sitebuilder.Handler
+	sitebackup  *sitebackup.Handler
+
+	pending []func(ctx context.Context)
+	ids     int
+}
+
+func newSimulator(ctx context.Context, bucket, templatePath string) (*simulator, error) {
+	s := &simulator{bucket: bucket}
+	s.store = greyscaletest.NewObjectStore()
+	s.store.OnChange = s.objectEvent
+	s.topics = greyscaletest.NewPublisher()
+	s.table = greyscaletest.NewImageRepository()
+	s.table.OnChange = s.streamEvent
+
+	var err error
+	s.converter, err = converter.NewHandler(ctx, s.store, s, imageTopic)
+	if err != nil {
+		return nil, err
+	}
+	s.cleanup = cleanup.NewHandler(s.store, s)
+	s.dbcreate = dbcreate.NewHandler(s.table, s)
+	s.dbdelete = dbdelete.NewHandler(s.table, s)
+	s.sitebuilder = sitebuilder.NewHandler(s.table, s.store, s, templatePath)
+	s.sitebackup = sitebackup.NewHandler(s.store, s)
+
+	// the ImageQueue is subscribed to the ImageTopic
+	s.topics.Subscribe(imageTopic, s.queueMessage)
+	s.topics.Subscribe(errorTopic, func(message greyscale.Message) {
+		logrus.WithFields(logrus.Fields{"action": "simulator", "topic": errorTopic}).Warn(message.Body)
+	})
+	return s, nil
+}
+
+// Publish publishes message to the in memory topics, logging those no lambda
+// is subscribed to.
+func (s *simulator) Publish(ctx context.Context, topic string, message greyscale.Message) error {
+	if len(s.topics.Subscribers[topic]) == 0 {
+		logrus.WithFields(logrus.Fields{"action": "simulator", "topic": topic}).Infof("published : %s", message.Body)
+	}
+	return s.topics.Publish(ctx, topic, message)
+}
+
+func (s *simulator) schedule(fn func(ctx context.Context)) {
+	s.pending = append(s.pending, fn)
+}
+
+// run runs triggered handlers until none are left.
+func (s *simulator) run(ctx context.Context) error {
+	for len(s.pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fn := s.pending[0]
+		s.pending = s.pending[1:]
+		fn(ctx)
+	}
+	return nil
+}
+
+func (s *simulator) nextID() string {
+	s.ids++
+	return strconv.Itoa(s.ids)
+}
+
+// objectEvent triggers the lambdas subscribed to s3 events of a bucket.
+func (s *simulator) objectEvent(eventName, bucket, key string, size int64) {
+	event := events.S3Event{Records: []events.S3EventRecord{{
+		EventSource: "aws:s3",
+		EventName:   eventName,
+		EventTime:   time.Now(),
+		S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: bucket},
+			Object: events.S3Object{Key: key, Size: size},
+		},
+	}}}
+
+	switch {
+	case bucket == s.bucket && eventName == greyscaletest.ObjectCreated:
+		s.schedule(func(ctx context.Context) { s.converter.Handle(ctx, event) })
+	case bucket == s.bucket && eventName == greyscaletest.ObjectRemoved:
+		s.schedule(func(ctx context.Context) { s.cleanup.Handle(ctx, event) })
+	case bucket == greyscale.ConvertBucket(s.bucket) && eventName == greyscaletest.ObjectRemoved:
+		s.schedule(func(ctx context.Context) { s.dbdelete.Handle(ctx, event) })
+	case bucket == sitebuilder.WebsiteBucket && eventName == greyscaletest.ObjectCreated:
+		s.schedule(func(ctx context.Context) { s.sitebackup.Handle(ctx, event) })
+	}
+}
+
+// queueMessage delivers an ImageTopic message to the db create lambda
+// wrapped in the envelope sns uses for sqs subscriptions.
+func (s *simulator) queueMessage(message greyscale.Message) {
+	attributes := map[string]map[string]string{}
+	for name, attribute := range message.Attributes {
+		attributes[name] = map[string]string{"Type": attribute.DataType, "Value": attribute.Value}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"Type":              "Notification",
+		"MessageId":         s.nextID(),
+		"TopicArn":          imageTopic,
+		"Message":           message.Body,
+		"MessageAttributes": attributes,
+		"Timestamp":         time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"action": "simulator"}).Errorf("error wrapping sns message : %v", err)
+		return
+	}
+
+	event := events.SQSEvent{Records: []events.SQSMessage{{
+		MessageId:   s.nextID(),
+		EventSource: "aws:sqs",
+		Body:        string(body),
+	}}}
+	s.schedule(func(ctx context.Context) { s.dbcreate.Handle(ctx, event) })
+}
+
+// streamEvent triggers the site builder with an ImageTable stream record.
+func (s *simulator) streamEvent(eventName string, image greyscale.GreyImage) {
+	event := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{{
+		EventID:     s.nextID(),
+		EventName:   eventName,
+		EventSource: "aws:dynamodb",
+		Change: events.DynamoDBStreamRecord{
+			Keys: map[string]events.DynamoDBAttributeValue{
+				"imageConverter": events.NewStringAttribute(image.ImageConverter),
+			},
+		},
+	}}}
+	s.schedule(func(ctx context.Context) { s.sitebuilder.Handle(ctx, event) })
+}
+
+// write replaces the contents of dir with every bucket, the ImageTable and
+// the published messages:
+//
+//	dir/s3/<bucket>/<key>
+//	dir/dynamodb/Image.json
+//	dir/sns/messages.json
+func (s *simulator) write(dir string) error {
+	for _, sub := range []string{"s3", "dynamodb", "sns"} {
+		if err := os.RemoveAll(filepath.Join(dir, sub)); err != nil {
+			return fmt.Errorf("error clearing %s : %w", sub, err)
+		}
+	}
+
+	for bucket, objects := range s.store.Buckets {
+		for key, obj := range objects {
+			path := filepath.Join(dir, "s3", bucket, filepath.FromSlash(key))
+			if err := writeFile(path, obj.Body); err != nil {
+				return err
+			}
+		}
+	}
+
+	images := s.table.Scan(func(greyscale.GreyImage) bool { return true })
+	table, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling table : %w", err)
+	}
+	if err := writeFile(filepath.Join(dir, "dynamodb", greyscale.ImageTable+".json"), table); err != nil {
+		return err
+	}
+
+	messages, err := json.MarshalIndent(s.topics.Published, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling messages : %w", err)
+	}
+	return writeFile(filepath.Join(dir, "sns", "messages.json"), messages)
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating %s : %w", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s : %w", path, err)
+	}
+	return nil
+}